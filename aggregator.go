@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AggregatorSwapEvent is a trade executed by the Soroswap Aggregator, which
+// splits it across several AMMs. Distribution lists the legs: the protocol
+// each went through, its token path and its share of the trade.
+type AggregatorSwapEvent struct {
+	Type string `json:"type"`
+	// ContractID is the aggregator contract.
+	ContractID     string          `json:"contract_id"`
+	TokenIn        string          `json:"token_in"`
+	TokenOut       string          `json:"token_out"`
+	AmountIn       Amount          `json:"amount_in"`
+	AmountOut      Amount          `json:"amount_out"`
+	To             string          `json:"to"`
+	Distribution   []AggregatorLeg `json:"distribution"`
+	TxHash         string          `json:"tx_hash"`
+	Timestamp      time.Time       `json:"timestamp"`
+	OperationIndex int64           `json:"operation_index"`
+	EventIndex     int64           `json:"event_index"`
+	LedgerSequence int64           `json:"ledger_sequence"`
+}
+
+// AggregatorLeg is the part of an aggregated trade routed through one
+// protocol. Parts is the leg's share of the trade, out of the sum of all
+// legs' parts. AmountIn and AmountOut are optional.
+type AggregatorLeg struct {
+	ProtocolID string   `json:"protocol_id"`
+	Path       []string `json:"path"`
+	Parts      int64    `json:"parts"`
+	AmountIn   Amount   `json:"amount_in,omitempty"`
+	AmountOut  Amount   `json:"amount_out,omitempty"`
+}
+
+const (
+	insertAggregatorSwapQuery = `
+        INSERT INTO aggregator_swaps (
+            aggregator, token_in, token_out, amount_in, amount_out, recipient, leg_count,
+            ledger_sequence, tx_hash, operation_index, event_index, swapped_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, aggregator, event_index) DO NOTHING
+        RETURNING id
+    `
+
+	insertAggregatorLegQuery = `
+        INSERT INTO aggregator_swap_legs (
+            swap_id, leg_index, protocol_id, path, parts, amount_in, amount_out
+        ) VALUES (?, ?, ?, ?, ?, ?, ?)
+    `
+)
+
+func init() {
+	registerHandlerQuery(insertAggregatorSwapQuery, insertAggregatorLegQuery)
+	registerRetentionTable(retentionTable{
+		Name:   "aggregator_swaps",
+		Table:  "aggregator_swaps",
+		Column: "swapped_at",
+		Prune:  pruneAggregatorSwaps,
+	})
+	registerLedgerTable(ledgerTable{Table: "aggregator_swaps", Delete: rollbackAggregatorSwaps})
+}
+
+// normalize canonicalizes the event's addresses and checks its legs.
+// Amounts are canonicalized beforehand.
+func (e *AggregatorSwapEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.TokenIn, &e.TokenOut, &e.To); err != nil {
+		return err
+	}
+	if e.ContractID == "" || e.TokenIn == "" || e.TokenOut == "" {
+		return fmt.Errorf("contract_id, token_in and token_out are required")
+	}
+	if e.AmountIn == "" || e.AmountOut == "" {
+		return fmt.Errorf("amount_in and amount_out are required")
+	}
+	if len(e.Distribution) == 0 {
+		return fmt.Errorf("distribution needs at least one leg")
+	}
+	for i := range e.Distribution {
+		leg := &e.Distribution[i]
+		leg.ProtocolID = strings.ToLower(strings.TrimSpace(leg.ProtocolID))
+		if leg.ProtocolID == "" {
+			return fmt.Errorf("leg %d: protocol_id is required", i)
+		}
+		if len(leg.Path) < 2 {
+			return fmt.Errorf("leg %d: path needs at least two tokens, got %d", i, len(leg.Path))
+		}
+		for j := range leg.Path {
+			if err := normalizeAddresses(&leg.Path[j]); err != nil {
+				return err
+			}
+			if leg.Path[j] == "" {
+				return fmt.Errorf("leg %d: path token %d is empty", i, j)
+			}
+		}
+		if leg.Parts < 0 {
+			return fmt.Errorf("leg %d: parts must not be negative, got %d", i, leg.Parts)
+		}
+	}
+	return nil
+}
+
+// amountFields returns pointers to every amount in the event.
+func (e *AggregatorSwapEvent) amountFields() []*Amount {
+	fields := []*Amount{&e.AmountIn, &e.AmountOut}
+	for i := range e.Distribution {
+		fields = append(fields, &e.Distribution[i].AmountIn, &e.Distribution[i].AmountOut)
+	}
+	return fields
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleAggregatorSwap(ctx context.Context, event AggregatorSwapEvent) error {
+	swappedAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("aggregator swap at ledger %d: %w", event.LedgerSequence, err)
+	}
+
+	// aggregator_swaps is not derived from other events, so replays leave
+	// it.
+	if replayTables(ctx) != nil {
+		return nil
+	}
+
+	tx, done, err := s.beginEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer done() // Will be ignored if transaction is committed
+
+	var swapID int64
+	err = s.stmts.queryRow(ctx, tx, insertAggregatorSwapQuery,
+		event.ContractID,
+		event.TokenIn,
+		event.TokenOut,
+		event.AmountIn,
+		event.AmountOut,
+		nullableString(event.To),
+		len(event.Distribution),
+		nullableLedger(event.LedgerSequence),
+		nullableString(event.TxHash),
+		event.OperationIndex,
+		event.EventIndex,
+		swappedAt.Value,
+	).Scan(&swapID)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.recordOutcome(ctx, "aggregator_swap", outcomeDuplicate, "")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert aggregator swap: %v", err)
+	}
+
+	for i, leg := range event.Distribution {
+		if _, err := s.stmts.exec(ctx, tx, insertAggregatorLegQuery,
+			swapID, i, leg.ProtocolID, strings.Join(leg.Path, ","), leg.Parts,
+			nullableString(string(leg.AmountIn)), nullableString(string(leg.AmountOut)),
+		); err != nil {
+			return fmt.Errorf("failed to insert aggregator leg %d: %v", i, err)
+		}
+	}
+
+	if err := s.commitEvent(ctx, tx, true); err != nil {
+		return fmt.Errorf("failed to commit aggregator swap: %v", err)
+	}
+	logger.Debug("Recorded aggregator swap", "event_type", "aggregator_swap", "from", event.TokenIn,
+		"to", event.TokenOut, "legs", len(event.Distribution), "ledger", event.LedgerSequence)
+	s.recordOutcome(ctx, "aggregator_swap", outcomeInserted, "")
+	return nil
+}
+
+// pruneAggregatorSwaps deletes the oldest aggregator swaps with their legs.
+func pruneAggregatorSwaps(ctx context.Context, tx *sql.Tx, b backend, cutoff time.Time, chunk int) (int64, error) {
+	const oldest = `SELECT id FROM aggregator_swaps WHERE swapped_at < ? ORDER BY id LIMIT ?`
+	if _, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM aggregator_swap_legs WHERE swap_id IN ("+oldest+")"), cutoff, chunk); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM aggregator_swaps WHERE id IN ("+oldest+")"), cutoff, chunk)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// rollbackAggregatorSwaps deletes the aggregator swaps above ledger with
+// their legs.
+func rollbackAggregatorSwaps(ctx context.Context, tx *sql.Tx, b backend, ledger int64) (int64, error) {
+	const above = `SELECT id FROM aggregator_swaps WHERE ledger_sequence > ?`
+	if _, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM aggregator_swap_legs WHERE swap_id IN ("+above+")"), ledger); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM aggregator_swaps WHERE ledger_sequence > ?"), ledger)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+)
+
+// Alert rule types.
+const (
+	// alertReserveDropPct fires when either reserve falls by more than
+	// threshold percent in a single sync.
+	alertReserveDropPct = "reserve_drop_pct"
+	// alertReserveBelow fires when a token's reserve crosses below value.
+	alertReserveBelow = "reserve_below"
+)
+
+// alertRule is one configured entry of the alerts list.
+type alertRule struct {
+	ID   string
+	Type string
+	// Threshold is the percentage for reserve_drop_pct.
+	Threshold *big.Rat
+	// Token restricts reserve_below to one token; empty means both sides.
+	Token string
+	// Value is the reserve floor for reserve_below.
+	Value *big.Int
+}
+
+// Alert is a triggered rule, as stored in the alerts table.
+type Alert struct {
+	RuleID      string `json:"rule_id"`
+	RuleType    string `json:"rule_type"`
+	PairAddress string `json:"pair_address"`
+	Token       string `json:"token"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+	Ledger      int64  `json:"ledger"`
+}
+
+const insertAlertQuery = `
+        INSERT INTO alerts (
+            rule_id, rule_type, pair_address, token, before_value, after_value,
+            ledger_sequence, triggered_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (rule_id, pair_address, ledger_sequence) DO NOTHING
+    `
+
+func init() {
+	registerHandlerQuery(insertAlertQuery)
+	registerRetentionTable(retentionTable{Name: "alerts", Table: "alerts", Column: "triggered_at"})
+	registerLedgerTable(ledgerTable{Table: "alerts"})
+}
+
+// parseAlertRules reads the alerts config list, e.g.
+//
+//	alerts:
+//	  - {type: reserve_drop_pct, threshold: 50}
+//	  - {type: reserve_below, token: "C...", value: "1000000"}
+func parseAlertRules(cfg *Config) ([]alertRule, error) {
+	raw := cfg.Alerts
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config alerts: expected a list, got %T", raw)
+	}
+
+	rules := make([]alertRule, 0, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config alerts[%d]: expected a map, got %T", i, item)
+		}
+		var r alertRule
+		var err error
+		if r.Type, err = configString(m, "type", ""); err != nil {
+			return nil, fmt.Errorf("config alerts[%d]: %v", i, err)
+		}
+		if r.ID, err = configString(m, "id", fmt.Sprintf("%s#%d", r.Type, i)); err != nil {
+			return nil, fmt.Errorf("config alerts[%d]: %v", i, err)
+		}
+
+		switch r.Type {
+		case alertReserveDropPct:
+			pct, err := configFloat(m, "threshold", 0)
+			if err != nil || pct <= 0 || pct > 100 {
+				return nil, fmt.Errorf("config alerts[%d]: threshold must be a percentage in (0, 100]", i)
+			}
+			r.Threshold = new(big.Rat).SetFloat64(pct)
+		case alertReserveBelow:
+			token, err := configString(m, "token", "")
+			if err != nil {
+				return nil, fmt.Errorf("config alerts[%d]: %v", i, err)
+			}
+			if r.Token, err = normalizeAddress(token); err != nil {
+				return nil, fmt.Errorf("config alerts[%d]: %v", i, err)
+			}
+			value := fmt.Sprint(m["value"])
+			v, ok := new(big.Int).SetString(value, 10)
+			if !ok || v.Sign() <= 0 {
+				return nil, fmt.Errorf("config alerts[%d]: value must be a positive integer, got %q", i, value)
+			}
+			r.Value = v
+		default:
+			return nil, fmt.Errorf("config alerts[%d]: unknown rule type %q", i, r.Type)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// evaluateAlerts checks every rule against the reserve change made by a
+// sync and records triggered alerts inside the sync's transaction. The
+// first sync of a pair has nothing meaningful to compare against and never
+// fires.
+func (s *SaveSoroswapPairsToSQLite) evaluateAlerts(ctx context.Context, tx *sql.Tx, event SyncEvent, prev *pairState, at interface{}) error {
+	if len(s.alertRules) == 0 || !prev.LastSyncLedger.Valid {
+		return nil
+	}
+
+	sides := []struct {
+		token         string
+		before, after string
+	}{
+		{prev.Token0, prev.Reserve0, string(event.NewReserve0)},
+		{prev.Token1, prev.Reserve1, string(event.NewReserve1)},
+	}
+	for _, rule := range s.alertRules {
+		for _, side := range sides {
+			before, ok1 := new(big.Int).SetString(side.before, 10)
+			after, ok2 := new(big.Int).SetString(side.after, 10)
+			if !ok1 || !ok2 || !rule.fires(side.token, before, after) {
+				continue
+			}
+
+			alert := Alert{
+				RuleID:      rule.ID,
+				RuleType:    rule.Type,
+				PairAddress: event.ContractID,
+				Token:       side.token,
+				Before:      side.before,
+				After:       side.after,
+				Ledger:      event.LedgerSequence,
+			}
+			result, err := s.stmts.exec(ctx, tx, insertAlertQuery,
+				alert.RuleID, alert.RuleType, alert.PairAddress, alert.Token,
+				alert.Before, alert.After, alert.Ledger, at)
+			if err != nil {
+				return fmt.Errorf("failed to record alert %s: %v", rule.ID, err)
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				logger.Info("Alert fired", "rule", rule.ID, "pair", alert.PairAddress, "token", alert.Token,
+					"before", alert.Before, "after", alert.After, "ledger", alert.Ledger)
+				if err := s.queueWebhook(ctx, tx, webhookAlert, alert.PairAddress, alert); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fires reports whether the rule triggers for one side of a reserve change.
+func (r alertRule) fires(token string, before, after *big.Int) bool {
+	switch r.Type {
+	case alertReserveDropPct:
+		if before.Sign() <= 0 || after.Cmp(before) >= 0 {
+			return false
+		}
+		// (before - after) / before * 100 > threshold
+		drop := new(big.Rat).SetFrac(new(big.Int).Sub(before, after), before)
+		drop.Mul(drop, big.NewRat(100, 1))
+		return drop.Cmp(r.Threshold) > 0
+	case alertReserveBelow:
+		if r.Token != "" && r.Token != token {
+			return false
+		}
+		// Only the crossing fires, not every sync while below.
+		return before.Cmp(r.Value) >= 0 && after.Cmp(r.Value) < 0
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Relations between two pairs trading the same tokens.
+const (
+	// aliasDuplicate pairs list the tokens in the same order.
+	aliasDuplicate = "duplicate"
+	// aliasReversed pairs list them in opposite order.
+	aliasReversed = "reversed"
+)
+
+const (
+	// sameTokensQuery finds the other pairs of a token set on the pair's
+	// network, oldest first. Placeholders have no tokens yet.
+	sameTokensQuery = `
+        SELECT pair_address, tokens_flipped FROM soroswap_pairs
+        WHERE token_a = ? AND token_b = ? AND pair_address <> ?
+          AND COALESCE(network, '') = ? AND NOT placeholder
+        ORDER BY created_at, pair_address
+    `
+
+	insertPairAliasQuery = `
+        INSERT INTO pair_aliases (pair_address, alias_of, relation, ledger_sequence, detected_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address, alias_of) DO NOTHING
+    `
+
+	pairAliasesQuery = `
+        SELECT pair_address, alias_of, relation, ledger_sequence, detected_at FROM pair_aliases
+        WHERE ? IN (pair_address, alias_of)
+        ORDER BY detected_at, pair_address, alias_of
+    `
+)
+
+func init() {
+	registerHandlerQuery(sameTokensQuery)
+	registerHandlerQuery(insertPairAliasQuery)
+	registerLedgerTable(ledgerTable{Table: "pair_aliases"})
+	registerPairTable(pairTable{
+		Name:  "pair_aliases",
+		Count: "SELECT COUNT(*) FROM pair_aliases WHERE ? IN (pair_address, alias_of)",
+		// A pair has at most a few aliases, so they go in one chunk.
+		Delete: func(ctx context.Context, tx *sql.Tx, b backend, pair string, _ int) (int64, error) {
+			result, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM pair_aliases WHERE ? IN (pair_address, alias_of)"), pair)
+			if err != nil {
+				return 0, err
+			}
+			return result.RowsAffected()
+		},
+	})
+}
+
+// PairAlias records that PairAddress trades the same tokens as the older
+// pair AliasOf.
+type PairAlias struct {
+	PairAddress string `json:"pair_address"`
+	AliasOf     string `json:"alias_of"`
+	// Relation is "duplicate" when both list the tokens in the same order
+	// and "reversed" when not.
+	Relation   string    `json:"relation"`
+	Ledger     int64     `json:"ledger_sequence,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// recordPairAliases links a new pair to the pairs already stored for its
+// token set.
+func (s *SaveSoroswapPairsToSQLite) recordPairAliases(ctx context.Context, tx *sql.Tx, event NewPairEvent) error {
+	tokenA, tokenB, flipped := canonicalTokens(event.Token0, event.Token1)
+	rows, err := s.stmts.query(ctx, tx, sameTokensQuery, tokenA, tokenB, event.PairAddress, s.network)
+	if err != nil {
+		return fmt.Errorf("failed to query pairs of %s/%s: %v", tokenA, tokenB, err)
+	}
+	var aliases []PairAlias
+	for rows.Next() {
+		var other string
+		var otherFlipped bool
+		if err := rows.Scan(&other, &otherFlipped); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pair of %s/%s: %v", tokenA, tokenB, err)
+		}
+		relation := aliasDuplicate
+		if otherFlipped != flipped {
+			relation = aliasReversed
+		}
+		aliases = append(aliases, PairAlias{PairAddress: event.PairAddress, AliasOf: other, Relation: relation})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read pairs of %s/%s: %v", tokenA, tokenB, err)
+	}
+
+	for _, a := range aliases {
+		if _, err := s.stmts.exec(ctx, tx, insertPairAliasQuery,
+			a.PairAddress, a.AliasOf, a.Relation, nullableLedger(event.LedgerSequence), time.Now().UTC()); err != nil {
+			return fmt.Errorf("failed to record alias of %s: %v", a.PairAddress, err)
+		}
+		logger.Warn("Pair trades the same tokens as an existing pair", "pair", a.PairAddress,
+			"alias_of", a.AliasOf, "relation", a.Relation, "token_a", tokenA, "token_b", tokenB)
+	}
+	return nil
+}
+
+// PairAliases returns the recorded aliases involving pair, on either side.
+func (s *SaveSoroswapPairsToSQLite) PairAliases(ctx context.Context, pair string) ([]PairAlias, error) {
+	addr, err := normalizeAddress(pair)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(pairAliasesQuery), addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aliases of %s: %v", addr, err)
+	}
+	defer rows.Close()
+
+	aliases := []PairAlias{}
+	for rows.Next() {
+		var a PairAlias
+		var ledger sql.NullInt64
+		if err := rows.Scan(&a.PairAddress, &a.AliasOf, &a.Relation, &ledger, &a.DetectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alias: %v", err)
+		}
+		a.Ledger = ledger.Int64
+		aliases = append(aliases, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read aliases: %v", err)
+	}
+	return aliases, nil
+}
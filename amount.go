@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Amount is a token amount. It decodes from a JSON string or a JSON number,
+// since some processor versions emit amounts as numbers, and is stored as
+// the canonical integer string either way. Decoding keeps the input text;
+// canonicalAmount validates and canonicalizes it.
+type Amount string
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*a = ""
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*a = Amount(s)
+	default:
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("amount must be a string or number, got %s", data)
+		}
+		*a = Amount(n)
+	}
+	return nil
+}
+
+// Value stores an Amount as its string form.
+func (a Amount) Value() (driver.Value, error) {
+	return string(a), nil
+}
+
+// maxExactFloat is 2^53, beyond which a number written with a fraction or
+// exponent has most likely been through a float64 and lost digits.
+var maxExactFloat = new(big.Rat).SetInt64(1 << 53)
+
+// amountPattern is the accepted amount syntax: decimal digits with an
+// optional fraction and exponent.
+var amountPattern = regexp.MustCompile(`^\d+(\.\d+)?([eE][+-]?\d+)?$`)
+
+// canonicalAmount turns an amount into its canonical integer string: no
+// sign, no leading zeros. Plain integers of any size are exact. Decimal or
+// scientific forms are accepted when they are within tolerance of an
+// integer and small enough that no precision was lost getting here. An
+// empty amount stays empty.
+func canonicalAmount(a Amount, tolerance *big.Rat) (Amount, error) {
+	s := strings.TrimSpace(string(a))
+	if s == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(s, "-") {
+		return "", fmt.Errorf("invalid amount %q: negative", s)
+	}
+	// big.Rat would also take fractions, hex and digit separators.
+	if !amountPattern.MatchString(s) {
+		return "", fmt.Errorf("invalid amount %q", s)
+	}
+	if v, ok := new(big.Int).SetString(s, 10); ok {
+		return Amount(v.String()), nil
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return "", fmt.Errorf("invalid amount %q", s)
+	}
+	if r.Cmp(maxExactFloat) > 0 {
+		return "", fmt.Errorf("invalid amount %q: too large to be exact in decimal or scientific notation", s)
+	}
+	// Round half up; amounts are non-negative here.
+	rounded := new(big.Int).Quo(
+		new(big.Int).Add(new(big.Int).Mul(r.Num(), big.NewInt(2)), r.Denom()),
+		new(big.Int).Mul(r.Denom(), big.NewInt(2)))
+	diff := new(big.Rat).Sub(r, new(big.Rat).SetInt(rounded))
+	if diff.Abs(diff).Cmp(tolerance) > 0 {
+		return "", fmt.Errorf("invalid amount %q: fractional part exceeds tolerance %s", s, tolerance.FloatString(6))
+	}
+	return Amount(rounded.String()), nil
+}
+
+// maxReserve is the largest reserve a pair can hold, since pair contracts
+// keep reserves as i128.
+var maxReserve = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 127), big.NewInt(1))
+
+// checkReserve validates a canonicalized reserve before it is written: it
+// must be present and a non-negative integer within i128, so the reserve
+// columns only ever hold numbers downstream tools can parse.
+func checkReserve(field string, a Amount) error {
+	if a == "" {
+		return fmt.Errorf("missing %s", field)
+	}
+	v, ok := new(big.Int).SetString(string(a), 10)
+	if !ok || v.Sign() < 0 {
+		return fmt.Errorf("%s %q is not a non-negative integer", field, a)
+	}
+	if v.Cmp(maxReserve) > 0 {
+		return fmt.Errorf("%s %s exceeds the i128 range of pair reserves", field, a)
+	}
+	return nil
+}
+
+// canonicalAmounts canonicalizes each amount field in place.
+func (s *SaveSoroswapPairsToSQLite) canonicalAmounts(fields ...*Amount) error {
+	tolerance := s.amountTolerance
+	if tolerance == nil {
+		tolerance = new(big.Rat)
+	}
+	for _, f := range fields {
+		c, err := canonicalAmount(*f, tolerance)
+		if err != nil {
+			return err
+		}
+		*f = c
+	}
+	return nil
+}
+
+// parseAmountTolerance reads amount_fraction_tolerance, the largest
+// fractional part rounded away from a numeric amount (default 0).
+func parseAmountTolerance(cfg *Config) (*big.Rat, error) {
+	r := new(big.Rat)
+	switch v := cfg.AmountFractionTolerance.(type) {
+	case nil:
+	case string:
+		if _, ok := r.SetString(v); !ok {
+			return nil, fmt.Errorf("config amount_fraction_tolerance: invalid number %q", v)
+		}
+	case float64:
+		r.SetFloat64(v)
+	case int:
+		r.SetInt64(int64(v))
+	default:
+		return nil, fmt.Errorf("config amount_fraction_tolerance: expected number, got %T", v)
+	}
+	if r.Sign() < 0 || r.Cmp(big.NewRat(1, 2)) >= 0 {
+		return nil, fmt.Errorf("config amount_fraction_tolerance must be in [0, 0.5)")
+	}
+	return r, nil
+}
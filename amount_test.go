@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestAmountDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		json      string
+		tolerance *big.Rat
+		want      Amount
+		// wantErr is "decode" or "canonical" for the step that fails.
+		wantErr string
+	}{
+		{name: "string", json: `"123"`, want: "123"},
+		{name: "string with leading zeros", json: `"007"`, want: "7"},
+		{name: "string beyond int64", json: `"170141183460469231731687303715884105727"`, want: "170141183460469231731687303715884105727"},
+		{name: "integer", json: `123`, want: "123"},
+		{name: "integer beyond float64", json: `123456789012345678901234567890`, want: "123456789012345678901234567890"},
+		{name: "float with .0", json: `123.0`, want: "123"},
+		{name: "scientific", json: `1.23e2`, want: "123"},
+		{name: "scientific string", json: `"1E3"`, want: "1000"},
+		{name: "scientific too large to be exact", json: `1e30`, wantErr: "canonical"},
+		{name: "fractional", json: `123.5`, wantErr: "canonical"},
+		{name: "fractional within tolerance", json: `123.004`, tolerance: big.NewRat(1, 100), want: "123"},
+		{name: "fractional beyond tolerance", json: `123.4`, tolerance: big.NewRat(1, 100), wantErr: "canonical"},
+		{name: "null", json: `null`, want: ""},
+		{name: "negative", json: `"-5"`, wantErr: "canonical"},
+		{name: "hex", json: `"0x10"`, wantErr: "canonical"},
+		{name: "digit separators", json: `"1_000"`, wantErr: "canonical"},
+		{name: "ratio", json: `"1/2"`, wantErr: "canonical"},
+		{name: "leading dot", json: `".5"`, wantErr: "canonical"},
+		{name: "plus sign", json: `"+5"`, wantErr: "canonical"},
+		{name: "boolean", json: `true`, wantErr: "decode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a Amount
+			if err := json.Unmarshal([]byte(tt.json), &a); err != nil {
+				if tt.wantErr != "decode" {
+					t.Fatalf("decode %s: %v", tt.json, err)
+				}
+				return
+			}
+			if tt.wantErr == "decode" {
+				t.Fatalf("decode %s = %q, want an error", tt.json, a)
+			}
+			tolerance := tt.tolerance
+			if tolerance == nil {
+				tolerance = new(big.Rat)
+			}
+			got, err := canonicalAmount(a, tolerance)
+			switch {
+			case tt.wantErr == "canonical" && err == nil:
+				t.Errorf("canonicalAmount(%q) = %q, want an error", a, got)
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("canonicalAmount(%q): %v", a, err)
+			case tt.wantErr == "" && got != tt.want:
+				t.Errorf("canonicalAmount(%q) = %q, want %q", a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumericReservesStored(t *testing.T) {
+	s := newTestConsumer(t, nil)
+	process(t, s,
+		newPairEvent(testPair, 10),
+		syncEvent(testPair, "", "", 20).with(event{"new_reserve_0": json.Number("1000.0"), "new_reserve_1": json.Number("25")}),
+	)
+	if p := getPair(t, s, testPair); p.Reserve0 != "1000" || p.Reserve1 != "25" {
+		t.Errorf("reserves = %s/%s, want 1000/25", p.Reserve0, p.Reserve1)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAnalyticsTables are exported when analytics_tables is not set.
+// Tables that do not exist in the database are skipped.
+var defaultAnalyticsTables = []string{"soroswap_pairs", "pair_stats_daily", "tokens"}
+
+// analyticsExport configures ExportAnalyticsDB and its scheduled mode.
+type analyticsExport struct {
+	tables   []string
+	path     string
+	interval time.Duration
+}
+
+func parseAnalyticsExport(cfg *Config) (analyticsExport, error) {
+	ae := analyticsExport{
+		tables:   cfg.AnalyticsTables,
+		path:     cfg.AnalyticsExportPath,
+		interval: time.Duration(cfg.AnalyticsExportIntervalHours * float64(time.Hour)),
+	}
+	if len(ae.tables) == 0 {
+		ae.tables = defaultAnalyticsTables
+	}
+	if ae.interval > 0 && ae.path == "" {
+		return ae, fmt.Errorf("config analytics_export_interval_hours requires analytics_export_path")
+	}
+	return ae, nil
+}
+
+// ExportAnalyticsDB writes a small SQLite file holding the latest pair state
+// and the other analytics_tables, for jobs that cannot download the full
+// database. The file is attached to a live connection and filled in one
+// transaction while writes are held, so it is a consistent snapshot, then
+// renamed into place so readers never see a partial export. Its export_meta
+// table records the max ledger it covers.
+func (s *SaveSoroswapPairsToSQLite) ExportAnalyticsDB(ctx context.Context, path string) error {
+	if _, ok := s.backend.(*sqliteBackend); !ok {
+		return fmt.Errorf("analytics export requires the sqlite3 driver, not %s", s.backend.Name())
+	}
+	if path == "" {
+		return errors.New("analytics export path is empty")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale export %s: %v", tmp, err)
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.exportAnalytics(ctx, tmp)
+	unlock()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move export into place: %v", err)
+	}
+	logger.Info("Analytics export written", "path", path)
+	return nil
+}
+
+// exportAnalytics fills a fresh file at path. ATTACH applies to a single
+// connection, so every statement runs on one pinned connection.
+func (s *SaveSoroswapPairsToSQLite) exportAnalytics(ctx context.Context, path string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS analytics", path); err != nil {
+		return fmt.Errorf("failed to attach export database: %v", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "DETACH DATABASE analytics"); err != nil {
+			logger.Error("Failed to detach export database", "error", err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	var exported []string
+	for _, name := range s.analytics.tables {
+		// The export keeps the database's table names, prefix included.
+		table := s.backend.Table(name)
+		// A table partitioned by month is a view; its template has the
+		// columns, and the export gets one table.
+		schema := table
+		if s.partitions.partitioned(name) {
+			schema = partitionTemplate(s.backend, name)
+		}
+		var create string
+		err := tx.QueryRowContext(ctx,
+			"SELECT sql FROM main.sqlite_master WHERE type = 'table' AND name = ?", schema).Scan(&create)
+		if err == sql.ErrNoRows {
+			logger.Warn("Analytics export: skipping missing table", "table", table)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read schema of %s: %v", table, err)
+		}
+		// Qualifying the name the stored statement creates creates it in
+		// the attached file.
+		create = createTableName.ReplaceAllString(create, "${1}analytics."+table)
+		if _, err := tx.ExecContext(ctx, create); err != nil {
+			return fmt.Errorf("failed to create %s in export: %v", table, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"INSERT INTO analytics.%[1]s SELECT * FROM main.%[1]s", table)); err != nil {
+			return fmt.Errorf("failed to copy %s: %v", table, err)
+		}
+		exported = append(exported, table)
+	}
+
+	var maxLedger sql.NullInt64
+	if err := tx.QueryRowContext(ctx, s.backend.Rebind(
+		"SELECT MAX(last_sync_ledger) FROM main.soroswap_pairs")).Scan(&maxLedger); err != nil {
+		return fmt.Errorf("failed to read max ledger: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE analytics.export_meta (
+            key TEXT NOT NULL PRIMARY KEY,
+            value TEXT NOT NULL
+        )`); err != nil {
+		return fmt.Errorf("failed to create export_meta: %v", err)
+	}
+	ledger := ""
+	if maxLedger.Valid {
+		ledger = strconv.FormatInt(maxLedger.Int64, 10)
+	}
+	for _, kv := range [][2]string{
+		{"max_ledger", ledger},
+		{"exported_at", time.Now().UTC().Format(time.RFC3339)},
+		{"tables", strings.Join(exported, ",")},
+		{"plugin_version", s.version},
+	} {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO analytics.export_meta (key, value) VALUES (?, ?)", kv[0], kv[1]); err != nil {
+			return fmt.Errorf("failed to write export_meta: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit export: %v", err)
+	}
+	logger.Info("Exported analytics tables", "tables", exported, "max_ledger", ledger)
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportAnalyticsDBDefaultTables(t *testing.T) {
+	dir := t.TempDir()
+	// The export is attached to the database, so it needs one on disk.
+	s := newTestConsumer(t, map[string]interface{}{"db_path": filepath.Join(dir, "pairs.sqlite"), "stats_rollups": true})
+	process(t, s, newPairEvent(testPair, 10), syncEvent(testPair, "100", "5", 20), swapEvent(testPair, "aa01", 21))
+
+	path := filepath.Join(dir, "analytics.sqlite")
+	if err := s.ExportAnalyticsDB(context.Background(), path); err != nil {
+		t.Fatalf("ExportAnalyticsDB: %v", err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"soroswap_pairs", "pair_stats_daily"} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil || n != 1 {
+			t.Errorf("exported %s rows = %d (err %v), want 1", table, n, err)
+		}
+	}
+	var tables string
+	if err := db.QueryRow("SELECT value FROM export_meta WHERE key = 'tables'").Scan(&tables); err != nil {
+		t.Fatalf("read export_meta: %v", err)
+	}
+	if !strings.Contains(tables, "pair_stats_daily") {
+		t.Errorf("export_meta tables = %q, want pair_stats_daily among them", tables)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// apiHandler serves the read-only query API:
+//
+//	GET /pairs                          ListPairs (?token=, ?limit=, ?cursor=)
+//	GET /pairs/{address}                GetPair
+//	GET /pairs/{address}/history        GetPairHistory (HistoryOptions as query parameters)
+//	GET /pairs/{address}/volume         GetPairVolume
+//	GET /pairs/{address}/fees           GetPairFees
+//	GET /pairs/{address}/positions      GetPairPositions
+//	GET /providers/{address}/positions  GetProviderPositions
+//	GET /factories/{address}            GetFactoryState
+//	GET /tokens/{address}               GetToken
+func (s *SaveSoroswapPairsToSQLite) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pairs", func(w http.ResponseWriter, r *http.Request) {
+		q := apiQuery{values: r.URL.Query()}
+		opts := PairListOptions{
+			Token:  q.address("token"),
+			Limit:  int(q.int("limit")),
+			Cursor: q.values.Get("cursor"),
+		}
+		if q.err != nil {
+			writeAPIError(w, q.err)
+			return
+		}
+		page, err := s.ListPairs(r.Context(), opts)
+		writeAPIResult(w, page, err)
+	})
+	mux.HandleFunc("GET /pairs/{address}", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		pair, err := s.GetPair(r.Context(), addr)
+		writeAPIResult(w, pair, err)
+	})
+	mux.HandleFunc("GET /pairs/{address}/history", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		q := apiQuery{values: r.URL.Query()}
+		opts := HistoryOptions{
+			FromLedger: q.int("from_ledger"),
+			ToLedger:   q.int("to_ledger"),
+			FromTime:   q.time("from_time"),
+			ToTime:     q.time("to_time"),
+			Limit:      int(q.int("limit")),
+			Step:       int(q.int("step")),
+			Bucket:     q.duration("bucket"),
+			Cursor:     q.values.Get("cursor"),
+		}
+		if q.err != nil {
+			writeAPIError(w, q.err)
+			return
+		}
+		page, err := s.GetPairHistory(r.Context(), addr, opts)
+		writeAPIResult(w, page, err)
+	})
+	mux.HandleFunc("GET /pairs/{address}/volume", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		volume, err := s.GetPairVolume(r.Context(), addr)
+		writeAPIResult(w, volume, err)
+	})
+	mux.HandleFunc("GET /pairs/{address}/fees", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		fees, err := s.GetPairFees(r.Context(), addr)
+		writeAPIResult(w, fees, err)
+	})
+	mux.HandleFunc("GET /pairs/{address}/positions", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		positions, err := s.GetPairPositions(r.Context(), addr)
+		writeAPIResult(w, positions, err)
+	})
+	mux.HandleFunc("GET /providers/{address}/positions", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		positions, err := s.GetProviderPositions(r.Context(), addr)
+		writeAPIResult(w, positions, err)
+	})
+	mux.HandleFunc("GET /factories/{address}", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		state, err := s.GetFactoryState(r.Context(), addr)
+		writeAPIResult(w, state, err)
+	})
+	mux.HandleFunc("GET /tokens/{address}", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := apiAddress(r.PathValue("address"))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		token, err := s.GetToken(r.Context(), addr)
+		writeAPIResult(w, token, err)
+	})
+	return mux
+}
+
+// errBadRequest marks errors caused by the request rather than the server.
+var errBadRequest = errors.New("bad request")
+
+// apiAddress validates an address taken from the request.
+func apiAddress(raw string) (string, error) {
+	addr, err := normalizeAddress(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	if addr == "" {
+		return "", fmt.Errorf("%w: address is required", errBadRequest)
+	}
+	return addr, nil
+}
+
+// apiQuery parses query parameters, keeping the first error. Missing
+// parameters parse as zero values.
+type apiQuery struct {
+	values url.Values
+	err    error
+}
+
+func (q *apiQuery) parse(name string, parse func(string) error) {
+	v := q.values.Get(name)
+	if v == "" || q.err != nil {
+		return
+	}
+	if err := parse(v); err != nil {
+		q.err = fmt.Errorf("%w: invalid %s %q", errBadRequest, name, v)
+	}
+}
+
+func (q *apiQuery) int(name string) (n int64) {
+	q.parse(name, func(v string) (err error) {
+		n, err = strconv.ParseInt(v, 10, 64)
+		return err
+	})
+	return n
+}
+
+func (q *apiQuery) time(name string) (t time.Time) {
+	q.parse(name, func(v string) (err error) {
+		t, err = time.Parse(time.RFC3339, v)
+		return err
+	})
+	return t
+}
+
+func (q *apiQuery) duration(name string) (d time.Duration) {
+	q.parse(name, func(v string) (err error) {
+		d, err = time.ParseDuration(v)
+		return err
+	})
+	return d
+}
+
+func (q *apiQuery) address(name string) (addr string) {
+	q.parse(name, func(v string) (err error) {
+		addr, err = normalizeAddress(v)
+		return err
+	})
+	return addr
+}
+
+func writeAPIResult(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeAPIError maps read API errors to HTTP statuses. Internal errors are
+// logged rather than returned to the client.
+func writeAPIError(w http.ResponseWriter, err error) {
+	status, msg := http.StatusInternalServerError, "internal error"
+	switch {
+	case errors.Is(err, ErrPairNotFound), errors.Is(err, ErrTokenNotFound), errors.Is(err, ErrFactoryNotFound):
+		status, msg = http.StatusNotFound, err.Error()
+	case errors.Is(err, errBadRequest), errors.Is(err, ErrInvalidCursor):
+		status, msg = http.StatusBadRequest, err.Error()
+	default:
+		logger.Error("API request failed", "error", err)
+	}
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// backend hides the SQL differences between the supported databases so the
+// event handlers can be shared. Queries are written once using ? placeholders
+// and the portable column type markers below, then rewritten by the backend.
+//
+// Both SQLite (3.24+) and Postgres understand INSERT ... ON CONFLICT, so the
+// upsert and conflict policies are expressed identically on both.
+type backend interface {
+	// Name returns the driver name used in logs and errors.
+	Name() string
+	// Open opens a connection pool and applies backend specific settings.
+	Open(ctx context.Context) (*sql.DB, error)
+	// OpenReader opens the read-only pool queries and exports run on,
+	// after Open. Backends that read and write on one pool return db.
+	OpenReader(ctx context.Context, db *sql.DB) (*sql.DB, error)
+	// Rebind rewrites ? placeholders into the backend's native form and
+	// applies the table prefix.
+	Rebind(query string) string
+	// Placeholders rewrites ? placeholders like Rebind but leaves table
+	// names alone, for operator-supplied statements.
+	Placeholders(query string) string
+	// DDL rewrites the portable type markers used in schema statements and
+	// applies the table prefix.
+	DDL(stmt string) string
+	// Table returns the name of one of the plugin's tables in the
+	// database, for statements built outside Rebind and DDL.
+	Table(name string) string
+	// EpochSeconds returns an expression converting a timestamp column to
+	// Unix seconds.
+	EpochSeconds(column string) string
+	// ISOTimestamp returns an expression formatting a timestamp column as
+	// an ISO 8601 UTC string.
+	ISOTimestamp(column string) string
+	// QueryPlan explains query, reporting whether it scans a full table.
+	QueryPlan(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, bool, error)
+	// ColumnExists reports whether table already has the named column.
+	// Tables passed to it and DropNotNull are unprefixed.
+	ColumnExists(ctx context.Context, db *sql.DB, table, column string) (bool, error)
+	// DropNotNull makes the named columns of table nullable. It does
+	// nothing for columns that already are, or when table does not exist.
+	DropNotNull(ctx context.Context, db *sql.DB, table string, columns ...string) error
+	// LockSchema serializes schema migrations between processes sharing the
+	// database. The returned func releases the lock.
+	LockSchema(ctx context.Context, db *sql.DB) (func(), error)
+	// CheckWritable reports why the database cannot take writes right
+	// now, or nil if it can.
+	CheckWritable(ctx context.Context, db *sql.DB) error
+	// Vacuum reclaims the space of deleted rows of the given unprefixed
+	// tables. It must not run inside a transaction.
+	Vacuum(ctx context.Context, db *sql.DB, tables ...string) error
+}
+
+// Portable column type markers used in schema statements, mapped to native
+// types by each backend's DDL.
+//
+//	{{timestamp}}  a point in time
+//	{{serial_pk}}  an auto-incrementing integer primary key
+//	{{blob}}       raw bytes
+//	{{decimal}}    a number for aggregating, not exact in SQLite
+var (
+	sqliteDDL = strings.NewReplacer(
+		"{{timestamp}}", "TIMESTAMP",
+		"{{serial_pk}}", "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"{{blob}}", "BLOB",
+		"{{decimal}}", "REAL",
+	)
+	postgresDDL = strings.NewReplacer(
+		"{{timestamp}}", "TIMESTAMPTZ",
+		"{{serial_pk}}", "BIGSERIAL PRIMARY KEY",
+		"{{blob}}", "BYTEA",
+		"{{decimal}}", "NUMERIC",
+	)
+)
+
+// schemaLockKey is the Postgres advisory lock held while migrating the
+// schema ("soroswap" in ASCII).
+const schemaLockKey = 0x736f726f73776170
+
+// newBackend selects the storage backend from the plugin configuration.
+// SQLite is the default; Postgres requires a dsn. db_driver is accepted as
+// an alias of driver.
+func newBackend(cfg *Config) (backend, error) {
+	names, err := parseTableNames(cfg)
+	if err != nil {
+		return nil, err
+	}
+	driver, alias := cfg.Driver, cfg.DBDriver
+	if driver == "" {
+		driver = alias
+	} else if alias != "" && !strings.EqualFold(alias, driver) {
+		return nil, fmt.Errorf("config driver %q and db_driver %q disagree", driver, alias)
+	}
+	switch strings.ToLower(driver) {
+	case "", "sqlite", "sqlite3":
+		b := &sqliteBackend{
+			tableNames: names,
+			path:       cfg.DBPath,
+			createDirs: cfg.CreateDirs,
+			dirMode:    cfg.DirMode,
+			fileMode:   cfg.FileMode,
+			key:        parseSQLiteKey(cfg),
+		}
+		if b.pragmas, err = parseSQLitePragmas(cfg); err != nil {
+			return nil, err
+		}
+		b.pragmas.key = b.key
+		if b.pool, b.readPool, err = parsePools(cfg, defaultSQLiteMaxOpenConns); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "postgres", "postgresql":
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("driver %q requires a dsn", driver)
+		}
+		b := &postgresBackend{tableNames: names, dsn: cfg.DSN}
+		if b.pool, b.readPool, err = parsePools(cfg, defaultPostgresMaxOpenConns); err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", driver)
+	}
+}
+
+// sqliteBackend stores pairs in a local SQLite file.
+type sqliteBackend struct {
+	tableNames
+	path string
+	// createDirs creates missing parent directories with dirMode.
+	createDirs bool
+	dirMode    os.FileMode
+	// fileMode, when set, is applied to a newly created database file.
+	fileMode os.FileMode
+	pragmas  sqlitePragmas
+	// key is the SQLCipher key of an encrypted database, empty for plain
+	// SQLite.
+	key *sqliteKey
+	// litefs is set when the file lives on a LiteFS mount, where only the
+	// primary takes writes.
+	litefs bool
+	// pool limits the write pool and readPool the query pool.
+	pool     poolLimits
+	readPool poolLimits
+	// dsn is what Open opened, for OpenReader to open the same database.
+	dsn string
+}
+
+func (b *sqliteBackend) Name() string { return "sqlite3" }
+
+func (b *sqliteBackend) Open(ctx context.Context) (*sql.DB, error) {
+	file, memory := sqliteFilePath(b.path)
+	existed := true
+	if !memory {
+		var err error
+		if existed, err = prepareDBPath(file, b.createDirs, b.dirMode); err != nil {
+			return nil, err
+		}
+	}
+
+	pragmas := b.pragmas
+	// New databases free pages incrementally, which only works when set
+	// before the first table is created.
+	pragmas.incrementalVacuum = !existed && !memory
+	b.dsn = b.path
+	if memory {
+		b.dsn = sharedMemoryDSN(b.path)
+	}
+	connector := newSQLiteConnector(b.dsn, pragmas)
+	db := sql.OpenDB(connector)
+	b.pool.apply(db)
+	if memory {
+		if err := connector.keepOpen(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open in-memory SQLite database: %v", err)
+		}
+	}
+
+	// Verify connection
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		if b.key.get() != "" {
+			return nil, fmt.Errorf("failed to ping SQLite (is sqlite_key right?): %v", err)
+		}
+		return nil, fmt.Errorf("failed to ping SQLite: %v", err)
+	}
+	if b.key.get() != "" {
+		if err := checkSQLCipher(ctx, db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	// SQLite falls back to another journal mode when the requested one is
+	// unavailable, e.g. WAL on file systems without shared memory.
+	var journalMode string
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read SQLite journal mode: %v", err)
+	}
+	if !memory && !strings.EqualFold(journalMode, b.pragmas.journalMode) {
+		logger.Warn("SQLite journal mode differs from the configured one", "journal_mode", journalMode, "configured", b.pragmas.journalMode)
+	}
+
+	if !existed && b.fileMode != 0 {
+		if err := os.Chmod(file, b.fileMode); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to set mode %s on database file %s: %v", b.fileMode, file, err)
+		}
+	}
+	return db, nil
+}
+
+// OpenReader opens a second pool on the database for queries, so they
+// neither wait for the single write connection nor hold it. Its
+// connections are query_only.
+func (b *sqliteBackend) OpenReader(ctx context.Context, _ *sql.DB) (*sql.DB, error) {
+	pragmas := b.pragmas
+	pragmas.queryOnly = true
+	db := sql.OpenDB(newSQLiteConnector(b.dsn, pragmas))
+	b.readPool.apply(db)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite read pool: %v", err)
+	}
+	return db, nil
+}
+
+func (b *sqliteBackend) Rebind(query string) string { return b.qualify(query) }
+
+func (b *sqliteBackend) Placeholders(query string) string { return query }
+
+func (b *sqliteBackend) DDL(stmt string) string { return sqliteDDL.Replace(b.qualify(stmt)) }
+
+func (b *sqliteBackend) EpochSeconds(column string) string {
+	return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", column)
+}
+
+func (b *sqliteBackend) ISOTimestamp(column string) string {
+	return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', %s)", column)
+}
+
+func (b *sqliteBackend) ColumnExists(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	table = b.Table(table)
+	var n int
+	err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	return n > 0, err
+}
+
+// LockSchema is a no-op: SQLite's own file lock already serializes the
+// migration statements.
+func (b *sqliteBackend) LockSchema(ctx context.Context, db *sql.DB) (func(), error) {
+	return func() {}, nil
+}
+
+// CheckWritable checks the database file, its write-ahead log and their
+// directory are still writable, without taking SQLite's write lock.
+func (b *sqliteBackend) CheckWritable(ctx context.Context, db *sql.DB) error {
+	file, memory := sqliteFilePath(b.path)
+	if memory {
+		return nil
+	}
+	if b.litefs {
+		if primary, ok := litefsReplica(filepath.Dir(file)); ok {
+			return fmt.Errorf("this node is a LiteFS replica; writes go to the primary %s", primary)
+		}
+	}
+	for _, path := range []string{file, file + "-wal"} {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if errors.Is(err, fs.ErrNotExist) && path != file {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", path, err)
+		}
+		f.Close()
+	}
+	return probeDirWritable(filepath.Dir(file))
+}
+
+// Vacuum frees the pages of deleted rows incrementally when the database
+// uses auto_vacuum = INCREMENTAL, and otherwise rebuilds the whole file,
+// which SQLite can only vacuum as a whole.
+func (b *sqliteBackend) Vacuum(ctx context.Context, db *sql.DB, tables ...string) error {
+	var mode int
+	if err := db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+	if mode == 2 {
+		return incrementalVacuum(ctx, db)
+	}
+	_, err := db.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+// incrementalVacuum frees every free page. The pragma frees one page per
+// step, so its result rows are drained rather than executed once.
+func incrementalVacuum(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}) error {
+	rows, err := q.QueryContext(ctx, "PRAGMA incremental_vacuum")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// DropNotNull rebuilds table, since SQLite cannot alter a column's
+// constraints: a copy is created from the stored CREATE TABLE statement
+// without the NOT NULL constraints, filled, and renamed over the original.
+// Indexes go with the old table and must be recreated by the caller.
+func (b *sqliteBackend) DropNotNull(ctx context.Context, db *sql.DB, table string, columns ...string) error {
+	table = b.Table(table)
+	var notNull int
+	for _, column := range columns {
+		var n int
+		if err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ? AND \"notnull\" = 1", table, column,
+		).Scan(&n); err != nil {
+			return err
+		}
+		notNull += n
+	}
+	if notNull == 0 {
+		return nil
+	}
+
+	var create string
+	if err := db.QueryRowContext(ctx,
+		"SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&create); err != nil {
+		return err
+	}
+	rebuilt := table + "_rebuild"
+	prefix := regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?"?` + regexp.QuoteMeta(table) + `"?`)
+	if !prefix.MatchString(create) {
+		return fmt.Errorf("unexpected definition of %s: %s", table, create)
+	}
+	create = prefix.ReplaceAllLiteralString(create, "CREATE TABLE "+rebuilt)
+	for _, column := range columns {
+		notNull := regexp.MustCompile(`(?i)(\b` + regexp.QuoteMeta(column) + `\s+\w+)\s+NOT\s+NULL`)
+		create = notNull.ReplaceAllString(create, "$1")
+	}
+
+	// Views referencing the table would make the rename fail while it is
+	// missing; legacy renames skip that check. The pragma is per
+	// connection, so pin one.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA legacy_alter_table = ON"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA legacy_alter_table = OFF")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+	for _, stmt := range []string{
+		create,
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", rebuilt, table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuilt, table),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to rebuild %s: %v", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *sqliteBackend) QueryPlan(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, bool, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var plan []string
+	fullScan := false
+	// Views, such as those over monthly partitions, run as co-routines
+	// whose output is then scanned; only scans of tables count.
+	coroutines := make(map[string]bool)
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return nil, false, err
+		}
+		plan = append(plan, detail)
+		if name, ok := strings.CutPrefix(detail, "CO-ROUTINE "); ok {
+			coroutines[name] = true
+		}
+		// "SCAN t" (or "SCAN TABLE t" before 3.36) without an index is a
+		// full table scan; "SCAN t USING INDEX" walks an index instead.
+		if strings.HasPrefix(detail, "SCAN ") && !strings.Contains(detail, " USING ") &&
+			!coroutines[strings.TrimPrefix(detail, "SCAN ")] {
+			fullScan = true
+		}
+	}
+	return plan, fullScan, rows.Err()
+}
+
+// postgresBackend stores pairs in a Postgres database.
+type postgresBackend struct {
+	tableNames
+	dsn      string
+	pool     poolLimits
+	readPool poolLimits
+}
+
+func (b *postgresBackend) Name() string { return "postgres" }
+
+func (b *postgresBackend) Open(ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open("postgres", b.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres: %v", err)
+	}
+
+	// Verify connection
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping Postgres: %v", err)
+	}
+
+	// Postgres has no file locking to work around, but every handler runs in
+	// its own short transaction so a small pool is plenty.
+	b.pool.apply(db)
+	return db, nil
+}
+
+// OpenReader opens a second pool for queries, so long exports do not take
+// the connections writes need. Its sessions are read only.
+func (b *postgresBackend) OpenReader(ctx context.Context, _ *sql.DB) (*sql.DB, error) {
+	connector, err := pq.NewConnector(b.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres read pool: %v", err)
+	}
+	db := sql.OpenDB(readOnlyConnector{connector})
+	b.readPool.apply(db)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open Postgres read pool: %v", err)
+	}
+	return db, nil
+}
+
+// readOnlyConnector makes every session it opens read only.
+type readOnlyConnector struct {
+	driver.Connector
+}
+
+func (c readOnlyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("Postgres driver cannot execute statements on a connection")
+	}
+	if _, err := execer.ExecContext(ctx, "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY", nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to make session read only: %v", err)
+	}
+	return conn, nil
+}
+
+func (b *postgresBackend) Rebind(query string) string {
+	return b.Placeholders(b.qualify(query))
+}
+
+// Placeholders rewrites ? placeholders to $1, $2, ... skipping quoted
+// literals.
+func (b *postgresBackend) Placeholders(query string) string {
+	var sb strings.Builder
+	sb.Grow(len(query) + 8)
+	n := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+			sb.WriteByte(c)
+		case c == '?' && !inQuote:
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func (b *postgresBackend) DDL(stmt string) string { return postgresDDL.Replace(b.qualify(stmt)) }
+
+func (b *postgresBackend) EpochSeconds(column string) string {
+	return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM %s) AS BIGINT)", column)
+}
+
+func (b *postgresBackend) ISOTimestamp(column string) string {
+	return fmt.Sprintf(`to_char(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`, column)
+}
+
+func (b *postgresBackend) ColumnExists(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	table = b.Table(table)
+	var n int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`,
+		table, column).Scan(&n)
+	return n > 0, err
+}
+
+// LockSchema takes a session advisory lock on a dedicated connection, so
+// writers starting together do not race on CREATE TABLE IF NOT EXISTS,
+// which Postgres does not make atomic.
+func (b *postgresBackend) LockSchema(ctx context.Context, db *sql.DB) (func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection for schema lock: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", int64(schemaLockKey)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to take schema lock: %v", err)
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", int64(schemaLockKey))
+		conn.Close()
+	}, nil
+}
+
+// CheckWritable fails on read-only servers, such as hot standbys.
+func (b *postgresBackend) CheckWritable(ctx context.Context, db *sql.DB) error {
+	var readOnly string
+	if err := db.QueryRowContext(ctx, "SHOW transaction_read_only").Scan(&readOnly); err != nil {
+		return fmt.Errorf("failed to check transaction_read_only: %v", err)
+	}
+	if readOnly == "on" {
+		return fmt.Errorf("database is read-only")
+	}
+	return nil
+}
+
+// Vacuum marks the space of the tables' deleted rows reusable and refreshes
+// their statistics. It does not shrink the files, which would need VACUUM
+// FULL and an exclusive lock.
+func (b *postgresBackend) Vacuum(ctx context.Context, db *sql.DB, tables ...string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = b.Table(t)
+	}
+	_, err := db.ExecContext(ctx, "VACUUM (ANALYZE) "+strings.Join(names, ", "))
+	return err
+}
+
+func (b *postgresBackend) DropNotNull(ctx context.Context, db *sql.DB, table string, columns ...string) error {
+	table = b.Table(table)
+	for _, column := range columns {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(
+			"ALTER TABLE IF EXISTS %s ALTER COLUMN %s DROP NOT NULL", table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *postgresBackend) QueryPlan(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, bool, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var plan []string
+	fullScan := false
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, false, err
+		}
+		plan = append(plan, strings.TrimSpace(line))
+		if strings.Contains(line, "Seq Scan on") {
+			fullScan = true
+		}
+	}
+	return plan, fullScan, rows.Err()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBackendSchema(t *testing.T) {
+	for name, config := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestConsumer(t, config)
+			ctx := context.Background()
+			version, err := s.SchemaVersion(ctx)
+			if err != nil {
+				t.Fatalf("SchemaVersion: %v", err)
+			}
+			if want := migrations[len(migrations)-1].version; version != want {
+				t.Errorf("schema version = %d, want %d", version, want)
+			}
+			for table, column := range map[string]string{
+				"soroswap_pairs":       "pair_address",
+				"pair_reserve_history": "synced_at",
+				"soroswap_swaps":       "swapped_at",
+				"schema_migrations":    "version",
+			} {
+				if ok, err := s.backend.ColumnExists(ctx, s.db, table, column); err != nil || !ok {
+					t.Errorf("%s.%s missing (err %v)", table, column, err)
+				}
+			}
+
+			// Initializing again finds the schema current.
+			s2 := newTestConsumer(t, config)
+			if _, err := s2.SchemaVersion(ctx); err != nil {
+				t.Fatalf("SchemaVersion after reopen: %v", err)
+			}
+		})
+	}
+}
+
+func TestBackendSyncLedgerGuard(t *testing.T) {
+	tests := []struct {
+		name string
+		// syncs are (reserve_0, ledger) pairs, applied in order.
+		syncs []struct {
+			reserve string
+			ledger  int64
+		}
+		wantReserve string
+		wantLedger  int64
+		wantStale   int64
+	}{
+		{
+			name: "newer ledgers apply",
+			syncs: []struct {
+				reserve string
+				ledger  int64
+			}{{"100", 20}, {"200", 21}},
+			wantReserve: "200", wantLedger: 21,
+		},
+		{
+			name: "older ledger is skipped",
+			syncs: []struct {
+				reserve string
+				ledger  int64
+			}{{"200", 21}, {"100", 20}},
+			wantReserve: "200", wantLedger: 21, wantStale: 1,
+		},
+		{
+			name: "same ledger applies",
+			syncs: []struct {
+				reserve string
+				ledger  int64
+			}{{"100", 20}, {"150", 20}},
+			wantReserve: "150", wantLedger: 20,
+		},
+	}
+	for name, config := range testBackends(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				s := newTestConsumer(t, config)
+				process(t, s, newPairEvent(testPair, 10))
+				for _, sync := range tt.syncs {
+					process(t, s, syncEvent(testPair, sync.reserve, "5", sync.ledger))
+				}
+				p := getPair(t, s, testPair)
+				if p.Reserve0 != tt.wantReserve {
+					t.Errorf("reserve_0 = %s, want %s", p.Reserve0, tt.wantReserve)
+				}
+				if p.LastSyncLedger == nil || *p.LastSyncLedger != tt.wantLedger {
+					t.Errorf("last_sync_ledger = %v, want %d", p.LastSyncLedger, tt.wantLedger)
+				}
+				if got := s.Stats().Outcomes[outcomeSkippedStale]; got != tt.wantStale {
+					t.Errorf("skipped stale = %d, want %d", got, tt.wantStale)
+				}
+			})
+		}
+	}
+}
+
+func TestBackendConflictPolicy(t *testing.T) {
+	for name, config := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestConsumer(t, config)
+			process(t, s, newPairEvent(testPair, 10), syncEvent(testPair, "100", "5", 20))
+
+			// A redelivered new_pair neither fails nor resets the reserves.
+			process(t, s, newPairEvent(testPair, 10).with(event{"token_0": testTokenC}))
+			p := getPair(t, s, testPair)
+			if p.Token0 != testTokenA || p.Reserve0 != "100" {
+				t.Errorf("pair after duplicate new_pair = %s/%s, want %s/100", p.Token0, p.Reserve0, testTokenA)
+			}
+			if got := s.Stats().Outcomes[outcomeDuplicate]; got != 1 {
+				t.Errorf("duplicates = %d, want 1", got)
+			}
+
+			// A redelivered swap is stored once.
+			process(t, s, swapEvent(testPair, "aa01", 21), swapEvent(testPair, "aa01", 21))
+			if n := countRows(t, s, "soroswap_swaps"); n != 1 {
+				t.Errorf("swaps = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestBackendRebind(t *testing.T) {
+	tests := []struct {
+		driver string
+		prefix string
+		query  string
+		want   string
+	}{
+		{"sqlite3", "", "SELECT * FROM soroswap_pairs WHERE pair_address = ?", "SELECT * FROM soroswap_pairs WHERE pair_address = ?"},
+		{"sqlite3", "mn_", "SELECT * FROM soroswap_pairs WHERE pair_address = ?", "SELECT * FROM mn_soroswap_pairs WHERE pair_address = ?"},
+		{"postgres", "", "UPDATE soroswap_pairs SET reserve_0 = ? WHERE pair_address = ?", "UPDATE soroswap_pairs SET reserve_0 = $1 WHERE pair_address = $2"},
+		{"postgres", "mn_", "SELECT 1 FROM pair_reserve_history WHERE id = ?", "SELECT 1 FROM mn_pair_reserve_history WHERE id = $1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver+"/"+tt.prefix, func(t *testing.T) {
+			config := map[string]interface{}{"driver": tt.driver, "table_prefix": tt.prefix}
+			if tt.driver == "postgres" {
+				config["dsn"] = "postgres://localhost/unused"
+			} else {
+				config["db_path"] = ":memory:"
+			}
+			if tt.prefix == "" {
+				delete(config, "table_prefix")
+			}
+			b, err := newBackend(decodeTestConfig(t, config))
+			if err != nil {
+				t.Fatalf("newBackend: %v", err)
+			}
+			if got := strings.TrimSpace(b.Rebind(tt.query)); got != tt.want {
+				t.Errorf("Rebind = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
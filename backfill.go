@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// creationLedger is one pair_address→ledger mapping entry.
+type creationLedger struct {
+	PairAddress string `json:"pair_address"`
+	Ledger      int64  `json:"ledger"`
+}
+
+// BackfillCreationLedgers fills created_at_ledger for historical rows from a
+// pair_address→ledger mapping, given either as NDJSON objects
+// ({"pair_address": "...", "ledger": 123}) or as CSV with an optional
+// pair_address,ledger header. Rows that already have a creation ledger are
+// left alone. It returns the number of rows updated.
+func (s *SaveSoroswapPairsToSQLite) BackfillCreationLedgers(ctx context.Context, mapping io.Reader) (int64, error) {
+	entries, err := readCreationLedgers(mapping)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	stmt, err := tx.PrepareContext(ctx, s.backend.Rebind(`
+        UPDATE soroswap_pairs SET created_at_ledger = ?
+        WHERE pair_address = ? AND created_at_ledger IS NULL
+    `))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	var updated int64
+	for _, e := range entries {
+		addr, err := normalizeAddress(e.PairAddress)
+		if err != nil {
+			return 0, err
+		}
+		result, err := stmt.ExecContext(ctx, e.Ledger, addr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to backfill pair %s: %v", addr, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get rows affected: %v", err)
+		}
+		updated += n
+	}
+
+	if err := s.commit(tx); err != nil {
+		return 0, fmt.Errorf("failed to commit backfill: %v", err)
+	}
+	logger.Info("Backfilled creation ledgers", "applied", updated, "entries", len(entries))
+	return updated, nil
+}
+
+// readCreationLedgers parses NDJSON or CSV, sniffing the format from the
+// first non-blank byte.
+func readCreationLedgers(r io.Reader) ([]creationLedger, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mapping: %v", err)
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			break
+		}
+		br.ReadByte()
+	}
+
+	if b, _ := br.Peek(1); b[0] == '{' {
+		return readCreationLedgersNDJSON(br)
+	}
+	return readCreationLedgersCSV(br)
+}
+
+func readCreationLedgersNDJSON(r io.Reader) ([]creationLedger, error) {
+	var entries []creationLedger
+	dec := json.NewDecoder(r)
+	for {
+		var e creationLedger
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid mapping entry %d: %v", len(entries)+1, err)
+		}
+		if e.PairAddress == "" || e.Ledger <= 0 {
+			return nil, fmt.Errorf("invalid mapping entry %d: pair_address and a positive ledger are required", len(entries)+1)
+		}
+		entries = append(entries, e)
+	}
+}
+
+func readCreationLedgersCSV(r io.Reader) ([]creationLedger, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping CSV: %v", err)
+	}
+	if len(records) > 0 && strings.EqualFold(records[0][0], "pair_address") {
+		records = records[1:]
+	}
+
+	entries := make([]creationLedger, 0, len(records))
+	for i, rec := range records {
+		ledger, err := strconv.ParseInt(strings.TrimSpace(rec[1]), 10, 64)
+		if err != nil || ledger <= 0 {
+			return nil, fmt.Errorf("invalid mapping row %d: bad ledger %q", i+1, rec[1])
+		}
+		entries = append(entries, creationLedger{PairAddress: rec[0], Ledger: ledger})
+	}
+	return entries, nil
+}
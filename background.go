@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// startBackground runs fn every interval until Close. Each run gets a
+// context cancelled on Close; errors are logged, not fatal.
+func (s *SaveSoroswapPairsToSQLite) startBackground(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	if interval <= 0 {
+		return
+	}
+	s.bgWG.Add(1)
+	go func() {
+		defer s.bgWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.bgCtx.Done():
+				return
+			case <-ticker.C:
+				if err := fn(s.bgCtx); err != nil && s.bgCtx.Err() == nil {
+					logger.Error("Background job failed", "job", name, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBackground cancels background jobs and waits for them to return.
+func (s *SaveSoroswapPairsToSQLite) stopBackground() {
+	if s.bgCancel != nil {
+		s.bgCancel()
+	}
+	s.bgWG.Wait()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrBackpressure is returned when max_pending_events is reached and
+// overflow_policy is reject. The error is retryable: the pipeline's own
+// retry and backoff should redeliver the message later.
+var ErrBackpressure = errors.New("too many pending events")
+
+// retryableError marks an error as temporary for pipelines that check.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string   { return e.err.Error() }
+func (e *retryableError) Unwrap() error   { return e.err }
+func (e *retryableError) Temporary() bool { return true }
+
+// commitLatencyWindow is how long the commit latency average holds without
+// new commits. Once it has passed, storage counts as keeping up again, so
+// rejecting everything cannot keep the average high forever.
+const commitLatencyWindow = 5 * time.Second
+
+// flowControl applies the optional rate limit, pending-event bound and
+// commit latency bound in front of the write path. All are no-ops when
+// unconfigured.
+type flowControl struct {
+	limiter    *rateLimiter
+	queue      *semaphore.Weighted
+	maxPending int64
+	reject     bool
+	// maxCommitLatency holds admission back while commits are slower than
+	// this on average.
+	maxCommitLatency time.Duration
+
+	commitMu      sync.Mutex
+	commitLatency time.Duration
+	lastCommit    time.Time
+
+	depth     atomic.Int64
+	throttled atomic.Int64
+	rejected  atomic.Int64
+	slowed    atomic.Int64
+}
+
+func newFlowControl(cfg *Config) (*flowControl, error) {
+	fc := &flowControl{maxCommitLatency: cfg.MaxCommitLatency}
+	if cfg.MaxEventsPerSecond > 0 {
+		fc.limiter = newRateLimiter(cfg.MaxEventsPerSecond)
+	}
+	if cfg.MaxPendingEvents > 0 {
+		fc.maxPending = int64(cfg.MaxPendingEvents)
+		fc.queue = semaphore.NewWeighted(fc.maxPending)
+	}
+	if fc.maxCommitLatency < 0 {
+		return nil, fmt.Errorf("config max_commit_latency must not be negative, got %s", fc.maxCommitLatency)
+	}
+	switch cfg.OverflowPolicy {
+	case "block":
+	case "reject":
+		fc.reject = true
+	default:
+		return nil, fmt.Errorf("config overflow_policy: unknown policy %q (want block or reject)", cfg.OverflowPolicy)
+	}
+	return fc, nil
+}
+
+// admit waits until n events may enter the write path, counting them as
+// pending until the returned release func is called. Array payloads pass
+// their element count so each event is limited exactly once.
+func (fc *flowControl) admit(ctx context.Context, n int) (func(), error) {
+	fc.depth.Add(int64(n))
+	if avg, lag := fc.commitLag(); lag > 0 {
+		if fc.reject {
+			fc.depth.Add(-int64(n))
+			fc.rejected.Add(1)
+			return nil, &retryableError{fmt.Errorf("%w: commit latency %s is over max_commit_latency %s",
+				ErrBackpressure, avg.Round(time.Millisecond), fc.maxCommitLatency)}
+		}
+		fc.slowed.Add(1)
+		select {
+		case <-ctx.Done():
+			fc.depth.Add(-int64(n))
+			return nil, ctx.Err()
+		case <-time.After(lag):
+		}
+	}
+	weight := int64(n)
+	if fc.queue != nil {
+		// A batch larger than the whole queue takes the whole queue.
+		if weight > fc.maxPending {
+			weight = fc.maxPending
+		}
+		if fc.reject {
+			if !fc.queue.TryAcquire(weight) {
+				fc.depth.Add(-int64(n))
+				fc.rejected.Add(1)
+				return nil, &retryableError{fmt.Errorf("%w: max_pending_events is %d",
+					ErrBackpressure, fc.maxPending)}
+			}
+		} else if err := fc.queue.Acquire(ctx, weight); err != nil {
+			fc.depth.Add(-int64(n))
+			return nil, err
+		}
+	}
+	release := func() {
+		fc.depth.Add(-int64(n))
+		if fc.queue != nil {
+			fc.queue.Release(weight)
+		}
+	}
+
+	if fc.limiter != nil {
+		throttled, err := fc.limiter.wait(ctx, n)
+		if throttled {
+			fc.throttled.Add(1)
+		}
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// observeCommit adds a commit's duration to the moving average behind
+// max_commit_latency.
+func (fc *flowControl) observeCommit(d time.Duration) {
+	if fc == nil || fc.maxCommitLatency == 0 {
+		return
+	}
+	fc.commitMu.Lock()
+	defer fc.commitMu.Unlock()
+	now := time.Now()
+	if now.Sub(fc.lastCommit) > commitLatencyWindow {
+		fc.commitLatency = d
+	} else {
+		fc.commitLatency = (4*fc.commitLatency + d) / 5
+	}
+	fc.lastCommit = now
+}
+
+// commitLag returns the average commit latency and how far it is over
+// max_commit_latency, 0 when storage keeps up. Blocked admissions wait out
+// the excess, slowing intake to what commits sustain.
+func (fc *flowControl) commitLag() (avg, lag time.Duration) {
+	if fc.maxCommitLatency == 0 {
+		return 0, 0
+	}
+	fc.commitMu.Lock()
+	defer fc.commitMu.Unlock()
+	if time.Since(fc.lastCommit) > commitLatencyWindow {
+		return 0, 0
+	}
+	return fc.commitLatency, fc.commitLatency - fc.maxCommitLatency
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second, with a
+// burst of one second's worth of tokens. Requests larger than the bucket go
+// into debt and wait for it to be repaid.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes n tokens, sleeping until they are available. It reports
+// whether the caller had to wait.
+func (l *rateLimiter) wait(ctx context.Context, n int) (bool, error) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return false, nil
+	}
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		// Give the unused tokens back.
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return true, ctx.Err()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// defaultBackupKeep is how many scheduled backups are kept when
+// backup_keep is not set.
+const defaultBackupKeep = 7
+
+// backupSchedule configures the scheduled online backups.
+type backupSchedule struct {
+	dir      string
+	interval time.Duration
+	// keep is how many backups rotation leaves in dir, 0 for all.
+	keep int
+}
+
+func parseBackup(cfg *Config) (backupSchedule, error) {
+	bs := backupSchedule{dir: cfg.BackupDir, interval: cfg.BackupInterval, keep: cfg.BackupKeep}
+	if bs.interval > 0 && bs.dir == "" {
+		return bs, fmt.Errorf("config backup_interval requires backup_dir")
+	}
+	if bs.keep < 0 {
+		return bs, fmt.Errorf("config backup_keep must not be negative, got %d", bs.keep)
+	}
+	return bs, nil
+}
+
+// Backup writes a consistent copy of the SQLite database to path using
+// SQLite's online backup API, which is safe while events are written. The
+// copy is made in one step, so it is a snapshot of a single point in time;
+// in WAL mode it does not hold up writers. It is written next to path and
+// renamed into place once complete.
+func (s *SaveSoroswapPairsToSQLite) Backup(ctx context.Context, path string) error {
+	sb, ok := s.backend.(*sqliteBackend)
+	if !ok {
+		return fmt.Errorf("backups require the sqlite3 driver, not %s", s.backend.Name())
+	}
+	if path == "" {
+		return errors.New("backup path is empty")
+	}
+	if _, memory := sqliteFilePath(sb.path); memory {
+		return errors.New("cannot back up an in-memory database")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale backup %s: %v", tmp, err)
+	}
+	// Checkpoints leave the WAL in place until the backup is done.
+	s.backupsRunning.Add(1)
+	defer s.backupsRunning.Add(-1)
+	start := time.Now()
+	if err := s.backupTo(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if sb.fileMode != 0 {
+		if err := os.Chmod(tmp, sb.fileMode); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to set mode %s on backup: %v", sb.fileMode, err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move backup into place: %v", err)
+	}
+	logger.Info("Backup written", "path", path, "duration", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// backupTo copies the database into a new file at path.
+func (s *SaveSoroswapPairsToSQLite) backupTo(ctx context.Context, path string) error {
+	src, err := s.readDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %v", err)
+	}
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		destDB.Close()
+		return fmt.Errorf("failed to open backup file: %v", err)
+	}
+	// SQLCipher only copies between databases with the same key.
+	if key := s.backend.(*sqliteBackend).key.get(); key != "" {
+		if _, err := dest.ExecContext(ctx, keyPragma("key", key)); err != nil {
+			dest.Close()
+			destDB.Close()
+			return fmt.Errorf("failed to set SQLCipher key on backup file: %v", err)
+		}
+	}
+	err = dest.Raw(func(destConn interface{}) error {
+		return src.Raw(func(srcConn interface{}) error {
+			return copyDatabase(ctx, destConn.(*sqlite3.SQLiteConn), srcConn.(*sqlite3.SQLiteConn))
+		})
+	})
+	dest.Close()
+	if cerr := destDB.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close backup file: %v", cerr)
+	}
+	return err
+}
+
+// copyDatabase copies every page of src's main database in one step,
+// retrying while the source is busy.
+func copyDatabase(ctx context.Context, dest, src *sqlite3.SQLiteConn) error {
+	backup, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return fmt.Errorf("failed to start backup: %v", err)
+	}
+	for {
+		done, err := backup.Step(-1)
+		if err != nil {
+			backup.Close()
+			return fmt.Errorf("backup failed: %v", err)
+		}
+		if done {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			backup.Close()
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if err := backup.Close(); err != nil {
+		return fmt.Errorf("failed to finish backup: %v", err)
+	}
+	return nil
+}
+
+// backupScheduled writes a timestamped backup to backup_dir, named after the
+// database file, and removes the oldest beyond backup_keep.
+func (s *SaveSoroswapPairsToSQLite) backupScheduled(ctx context.Context) error {
+	if err := os.MkdirAll(s.backup.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", s.backup.dir, err)
+	}
+	stem, ext := s.backupName()
+	name := stem + "-" + time.Now().UTC().Format("20060102T150405Z") + ext
+	if err := s.Backup(ctx, filepath.Join(s.backup.dir, name)); err != nil {
+		return err
+	}
+	return s.rotateBackups()
+}
+
+// backupName splits the database file name into the stem and extension
+// backups are named with.
+func (s *SaveSoroswapPairsToSQLite) backupName() (stem, ext string) {
+	file, _ := sqliteFilePath(s.dbPath)
+	base := filepath.Base(file)
+	ext = filepath.Ext(base)
+	if ext == "" {
+		ext = ".sqlite"
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)), ext
+}
+
+// rotateBackups removes all but the newest backup_keep backups. The
+// timestamps in the names sort in time order.
+func (s *SaveSoroswapPairsToSQLite) rotateBackups() error {
+	if s.backup.keep == 0 {
+		return nil
+	}
+	stem, ext := s.backupName()
+	backups, err := filepath.Glob(filepath.Join(s.backup.dir, stem+"-????????T??????Z"+ext))
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > s.backup.keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("failed to remove old backup: %v", err)
+		}
+		logger.Info("Removed old backup", "path", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
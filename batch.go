@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// eventBatch commits the transactions of many events at once. Each event
+// runs inside a savepoint of the shared transaction, so a failing event
+// still rolls back alone. The batch is only touched with the write lock
+// held.
+type eventBatch struct {
+	// size is the number of events per commit; 0 commits every event.
+	size int
+	// interval bounds how long a partial batch stays uncommitted.
+	interval time.Duration
+	// byLedger commits a batch per ledger instead of every size events.
+	byLedger bool
+
+	tx      *sql.Tx
+	events  int
+	inEvent bool
+	// ledger is the ledger of the open batch when batching by ledger.
+	ledger int64
+	// lastEvent is when the open batch last took an event.
+	lastEvent time.Time
+	// releases end the admission of the messages in the open batch. Their
+	// events count as pending until it commits, so max_pending_events
+	// bounds what a batch holds.
+	releases []func()
+	// attempts are the events in the open batch, applied again one by one
+	// when it fails to commit.
+	attempts []*eventAttempt
+	// retrying is set while they are, which commits every event alone.
+	retrying bool
+}
+
+func parseEventBatch(cfg *Config) (*eventBatch, error) {
+	size, interval, byLedger := cfg.BatchSize, cfg.BatchInterval, cfg.BatchByLedger
+	if size < 0 {
+		return nil, fmt.Errorf("config batch_size must not be negative, got %d", size)
+	}
+	if byLedger && size > 1 {
+		return nil, fmt.Errorf("config batch_by_ledger and batch_size cannot both be set")
+	}
+	b := &eventBatch{size: size, interval: interval, byLedger: byLedger}
+	if b.enabled() && interval <= 0 {
+		return nil, fmt.Errorf("config batch_interval must be positive, got %s", interval)
+	}
+	return b, nil
+}
+
+// enabled reports whether the configuration groups event transactions.
+func (b *eventBatch) enabled() bool {
+	return b.size > 1 || b.byLedger
+}
+
+// batching reports whether event transactions are grouped. Dry runs roll
+// back every event, so they never batch.
+func (s *SaveSoroswapPairsToSQLite) batching() bool {
+	return s.batch != nil && s.batch.enabled() && !s.batch.retrying && !s.dryRun
+}
+
+// startLedger commits the open batch when msg starts a new ledger, so each
+// ledger's events commit together. Events without a ledger join the open
+// batch.
+func (s *SaveSoroswapPairsToSQLite) startLedger(ctx context.Context, msg pluginapi.Message) {
+	if !s.batching() || !s.batch.byLedger {
+		return
+	}
+	ledger := messageLedger(msg)
+	if ledger == 0 || ledger == s.batch.ledger {
+		return
+	}
+	// The events of the previous ledger are already acknowledged; failing
+	// to commit them is not this event's fault.
+	if err := s.flushBatch(ctx); err != nil {
+		logger.Error("Failed to commit ledger batch", "ledger", s.batch.ledger, "error", err)
+	}
+	s.batch.ledger = ledger
+}
+
+// batchTx returns the open batch transaction, beginning one if needed. It
+// is not bound to the caller's context, which would roll it back when the
+// message that opened it completes.
+func (s *SaveSoroswapPairsToSQLite) batchTx() (*sql.Tx, error) {
+	if s.batch.tx == nil {
+		tx, err := s.db.BeginTx(context.Background(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin batch transaction: %v", err)
+		}
+		s.batch.tx = tx
+	}
+	return s.batch.tx, nil
+}
+
+// beginEvent starts the transaction for one event's writes and runs the
+// event's before sql_hooks in it. The returned func undoes them unless
+// commitEvent was called first; handlers defer it like tx.Rollback.
+func (s *SaveSoroswapPairsToSQLite) beginEvent(ctx context.Context) (*sql.Tx, func(), error) {
+	tx, done, err := s.openEvent(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.runSQLHooks(ctx, tx, false); err != nil {
+		done()
+		return nil, nil, err
+	}
+	return tx, done, nil
+}
+
+// openEvent starts the transaction of beginEvent, or its savepoint in the
+// open batch.
+func (s *SaveSoroswapPairsToSQLite) openEvent(ctx context.Context) (*sql.Tx, func(), error) {
+	if !s.batching() {
+		// SQLite has one writer; parallel workers taking turns here keeps
+		// their deferred transactions from failing to upgrade to a write.
+		unlock := func() {}
+		if _, ok := s.backend.(*sqliteBackend); ok && s.pool != nil {
+			s.eventTxMu.Lock()
+			unlock = s.eventTxMu.Unlock
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			unlock()
+			return nil, nil, fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		return tx, func() {
+			tx.Rollback()
+			unlock()
+		}, nil
+	}
+
+	tx, err := s.batchTx()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT event"); err != nil {
+		return nil, nil, fmt.Errorf("failed to begin event savepoint: %v", err)
+	}
+	s.batch.inEvent = true
+	return tx, func() {
+		if !s.batch.inEvent {
+			return
+		}
+		s.batch.inEvent = false
+		if _, err := tx.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT event"); err != nil {
+			logger.Error("Failed to roll back event savepoint", "error", err)
+			return
+		}
+		tx.ExecContext(context.Background(), "RELEASE SAVEPOINT event")
+	}, nil
+}
+
+// commitEvent completes an event started with beginEvent, running its
+// after sql_hooks when the event changed a row, and recording it in
+// processed_events when dedup_events is on. When batching, the event is
+// kept in the batch, which is committed once full.
+func (s *SaveSoroswapPairsToSQLite) commitEvent(ctx context.Context, tx *sql.Tx, changed bool) error {
+	if changed {
+		if err := s.runSQLHooks(ctx, tx, true); err != nil {
+			return err
+		}
+	}
+	if err := s.markProcessed(ctx, tx); err != nil {
+		return err
+	}
+	if !s.batching() {
+		return s.commit(tx)
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT event"); err != nil {
+		return fmt.Errorf("failed to release event savepoint: %v", err)
+	}
+	s.batch.inEvent = false
+	s.batch.events++
+	if attempt, _ := ctx.Value(eventAttemptKey{}).(*eventAttempt); attempt != nil && !attempt.batched {
+		attempt.batched = true
+		s.batch.attempts = append(s.batch.attempts, attempt)
+	}
+	s.batch.lastEvent = time.Now()
+	if !s.batch.byLedger && s.batch.events >= s.batch.size {
+		return s.flushBatch(ctx)
+	}
+	return nil
+}
+
+// inEventTx runs fn in an event transaction, whose writes count as a
+// change.
+func (s *SaveSoroswapPairsToSQLite) inEventTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, done, err := s.beginEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return s.commitEvent(ctx, tx, true)
+}
+
+// flushBatch commits the open batch, if any, then forwards its events and
+// persists the counters buffered while it was open. The write lock must be
+// held. When the commit fails, the batch's events are applied again one by
+// one, see retryBatch.
+func (s *SaveSoroswapPairsToSQLite) flushBatch(ctx context.Context) error {
+	if s.batch == nil || s.batch.tx == nil {
+		return nil
+	}
+	tx, events, attempts := s.batch.tx, s.batch.events, s.batch.attempts
+	s.batch.tx, s.batch.events, s.batch.attempts = nil, 0, nil
+	defer s.releaseBatch()
+	if err := s.commit(tx); err != nil {
+		s.dropForwarded()
+		if s.health != nil {
+			s.health.recordFailure(err)
+		}
+		if len(attempts) < events {
+			// Events joined outside processWithRetry cannot be applied again.
+			return fmt.Errorf("failed to commit batch of %d events: %v", events, err)
+		}
+		logger.Error("Failed to commit batch, applying its events one by one", "events", events, "error", err)
+		s.retryBatch(attempts)
+	} else {
+		s.flushForwarded(ctx)
+	}
+	return s.flushCounters(ctx)
+}
+
+// batchRetryKey marks the context of an event applied again by
+// retryBatch.
+type batchRetryKey struct{}
+
+// retryBatch applies the events of a batch that failed to commit again,
+// each in a transaction of its own, since they were acknowledged when they
+// joined it. Their outcomes were counted then; events failing now are
+// dead-lettered and recounted as failed. The write lock must be held.
+func (s *SaveSoroswapPairsToSQLite) retryBatch(attempts []*eventAttempt) {
+	s.batch.retrying = true
+	defer func() { s.batch.retrying = false }()
+	for _, attempt := range attempts {
+		// The message that opened the batch may be long done.
+		ctx := context.WithValue(context.WithoutCancel(attempt.ctx), batchRetryKey{}, true)
+		eventType, err := s.processWithRetry(ctx, attempt.msg)
+		attempt.reapplied = true
+		if err == nil {
+			continue
+		}
+		logger.Error("Batched event failed again on its own", "event_type", eventType, "error", err)
+		if replayTables(ctx) == nil {
+			s.ingestErrors.record(eventType, attempt.msg, err)
+			s.counters.add(eventType, EventCounters{Processed: -1, Failed: 1})
+			s.deadLetter(ctx, eventType, attempt.msg, err)
+		}
+	}
+}
+
+// holdAdmission keeps a message's admission until the open batch commits,
+// or ends it now when no batch is open. The write lock must be held.
+func (s *SaveSoroswapPairsToSQLite) holdAdmission(release func()) {
+	if s.batching() && s.batch.tx != nil {
+		s.batch.releases = append(s.batch.releases, release)
+		return
+	}
+	release()
+}
+
+// releaseBatch ends the admissions held by the batch just committed or
+// lost.
+func (s *SaveSoroswapPairsToSQLite) releaseBatch() {
+	releases := s.batch.releases
+	s.batch.releases = nil
+	for _, release := range releases {
+		release()
+	}
+}
+
+// flushBatchOnTimer commits a partial batch that has waited an interval.
+// A ledger's batch is left open while its events keep coming.
+func (s *SaveSoroswapPairsToSQLite) flushBatchOnTimer(ctx context.Context) error {
+	unlock, err := s.lockExclusive(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if s.batch.byLedger && time.Since(s.batch.lastEvent) < s.batch.interval {
+		return nil
+	}
+	return s.flushBatch(ctx)
+}
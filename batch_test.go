@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// flush commits the open batch of s.
+func flush(t *testing.T, s *SaveSoroswapPairsToSQLite) {
+	t.Helper()
+	unlock, err := s.lockWrites(context.Background())
+	if err != nil {
+		t.Fatalf("lockWrites: %v", err)
+	}
+	unlock()
+}
+
+func TestBatchCommitsEverySize(t *testing.T) {
+	s := newTestConsumer(t, map[string]interface{}{"batch_size": 3})
+	process(t, s, newPairEvent(testPair, 10), syncEvent(testPair, "100", "5", 20))
+	if s.batch.tx == nil || s.batch.events != 2 {
+		t.Fatalf("open batch = %v with %d events, want 2 events", s.batch.tx, s.batch.events)
+	}
+	process(t, s, syncEvent(testPair, "200", "5", 21))
+	if s.batch.tx != nil || s.batch.events != 0 {
+		t.Fatalf("batch still open with %d events after reaching batch_size", s.batch.events)
+	}
+	if p := getPair(t, s, testPair); p.Reserve0 != "200" {
+		t.Errorf("reserve_0 = %s, want 200", p.Reserve0)
+	}
+}
+
+func TestBatchSavepoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"batch_size", map[string]interface{}{"batch_size": 10, "strict_mode": true}},
+		{"batch_by_ledger", map[string]interface{}{"batch_by_ledger": true, "strict_mode": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, tt.config)
+			process(t, s, newPairEvent(testPair, 20), syncEvent(testPair, "100", "5", 20))
+			// An unknown pair fails in strict mode, rolling back its
+			// savepoint only.
+			err := s.Process(context.Background(), syncEvent(testPair2, "7", "7", 20).message(t))
+			if !errors.Is(err, errUnknownPair) {
+				t.Fatalf("Process unknown pair = %v, want %v", err, errUnknownPair)
+			}
+			process(t, s, syncEvent(testPair, "300", "5", 20))
+			flush(t, s)
+
+			if p := getPair(t, s, testPair); p.Reserve0 != "300" {
+				t.Errorf("reserve_0 = %s, want 300", p.Reserve0)
+			}
+			if n := countRows(t, s, "soroswap_pairs"); n != 1 {
+				t.Errorf("pairs = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestBatchCommitFailureReappliesEvents(t *testing.T) {
+	s := newTestConsumer(t, map[string]interface{}{
+		// Deferred foreign keys are checked at commit, where the batch of
+		// a sync with reserve 666 fails.
+		"db_path":    "file::memory:?_foreign_keys=1",
+		"batch_size": 3,
+		"sql_hooks": map[string]interface{}{"sync": map[string]interface{}{"after": []interface{}{
+			"INSERT INTO commit_guard (parent) SELECT 1 WHERE :new_reserve_0 = '666'",
+		}}},
+	})
+	for _, stmt := range []string{
+		"CREATE TABLE guard_parent (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE commit_guard (parent INTEGER REFERENCES guard_parent(id) DEFERRABLE INITIALLY DEFERRED)",
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("create guard: %v", err)
+		}
+	}
+	process(t, s, newPairEvent(testPair, 10))
+	flush(t, s)
+
+	process(t, s,
+		syncEvent(testPair, "100", "5", 20),
+		syncEvent(testPair, "666", "5", 21),
+		syncEvent(testPair, "300", "5", 22),
+	)
+
+	// The good syncs are applied on their own, the bad one dead-lettered.
+	if p := getPair(t, s, testPair); p.Reserve0 != "300" {
+		t.Errorf("reserve_0 = %s, want 300", p.Reserve0)
+	}
+	if n := countRows(t, s, "dead_letter_events"); n != 1 {
+		t.Errorf("dead letters = %d, want 1", n)
+	}
+	// Outcomes were counted once, when the events joined the batch.
+	if got := s.Stats().Outcomes[outcomeUpdated]; got != 3 {
+		t.Errorf("updated outcomes = %d, want 3", got)
+	}
+	if got := s.Stats().Lifetime["sync"]; got.Processed != 2 || got.Failed != 1 {
+		t.Errorf("sync counters = %+v, want 2 processed, 1 failed", got)
+	}
+}
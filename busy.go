@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/withObsrvr/pluginapi"
+)
+
+// busyRetry retries events that failed because another connection held
+// the SQLite database locked for longer than busy_timeout, or because a
+// transaction's snapshot went stale under a concurrent writer, which
+// SQLite reports without waiting at all.
+type busyRetry struct {
+	// attempts is how many times an event is retried, 0 for never.
+	attempts int
+	backoff  time.Duration
+	retried  atomic.Int64
+}
+
+func parseBusyRetry(cfg *Config) (*busyRetry, error) {
+	attempts, backoff := cfg.BusyRetries, cfg.BusyRetryBackoff
+	if attempts < 0 {
+		return nil, fmt.Errorf("config busy_retries must not be negative, got %d", attempts)
+	}
+	if attempts > 0 && backoff <= 0 {
+		return nil, fmt.Errorf("config busy_retry_backoff must be positive, got %s", backoff)
+	}
+	return &busyRetry{attempts: attempts, backoff: backoff}, nil
+}
+
+// isBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED.
+// Most errors are wrapped with %v, so the message is checked as well.
+func isBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// eventAttemptKey carries an *eventAttempt through a handler's context.
+type eventAttemptKey struct{}
+
+// eventAttempt is what earlier attempts at the same event left behind.
+type eventAttempt struct {
+	// archived is set once the raw event is stored, so retries do not
+	// archive it again.
+	archived bool
+	// batched is set once the event joined the open batch, which keeps
+	// the message to apply it again should the batch fail to commit.
+	batched bool
+	// reapplied is set once retryBatch applied the event again, forwarding
+	// it to the event sinks.
+	reapplied bool
+	// ctx and msg are what processWithRetry was called with.
+	ctx context.Context
+	msg pluginapi.Message
+}
+
+// processWithRetry processes a message, retrying with exponential backoff
+// while it fails on a locked database. Each attempt rolls back completely,
+// so a retry starts from a clean transaction.
+func (s *SaveSoroswapPairsToSQLite) processWithRetry(ctx context.Context, msg pluginapi.Message) (string, error) {
+	attempt := &eventAttempt{ctx: ctx, msg: msg}
+	ctx = context.WithValue(ctx, eventAttemptKey{}, attempt)
+	backoff := s.busy.backoff
+	for retry := 0; ; retry++ {
+		eventType, err := s.processMessage(ctx, msg)
+		if !isBusy(err) || retry >= s.busy.attempts || !s.resetBusyBatch(attempt) {
+			return eventType, err
+		}
+		s.busy.retried.Add(1)
+		logger.Warn("Event hit a locked database, retrying", "event_type", eventType,
+			"backoff", backoff, "retry", retry+1, "retries", s.busy.attempts, "error", err)
+		select {
+		case <-ctx.Done():
+			return eventType, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// resetBusyBatch prepares the open batch for a retry, reporting whether
+// the event can be retried. A transaction that already wrote holds the
+// write lock and cannot be the one that was busy, so a batch can only hit
+// a lock before its first write; it is rolled back to get a fresh
+// snapshot, taking the raw event archived in it along.
+func (s *SaveSoroswapPairsToSQLite) resetBusyBatch(attempt *eventAttempt) bool {
+	if !s.batching() || s.batch.tx == nil {
+		return true
+	}
+	if s.batch.events > 0 {
+		return false
+	}
+	s.batch.tx.Rollback()
+	s.batch.tx = nil
+	s.releaseBatch()
+	attempt.archived = false
+	return true
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// candleResolution is one configured candle size, e.g. "5m".
+type candleResolution struct {
+	Name     string
+	Duration time.Duration
+}
+
+// parseCandleResolutions reads candle_intervals, a list of Go durations or
+// whole days such as "1d". Candles are off when it is unset.
+func parseCandleResolutions(cfg *Config) ([]candleResolution, error) {
+	var out []candleResolution
+	for _, name := range cfg.CandleIntervals {
+		var d time.Duration
+		var err error
+		if days, ok := strings.CutSuffix(name, "d"); ok {
+			n, err := strconv.Atoi(days)
+			if err != nil {
+				return nil, fmt.Errorf("config candle_intervals: invalid interval %q", name)
+			}
+			d = time.Duration(n) * 24 * time.Hour
+		} else if d, err = time.ParseDuration(name); err != nil {
+			return nil, fmt.Errorf("config candle_intervals: invalid interval %q", name)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("config candle_intervals: interval %q is shorter than 1m", name)
+		}
+		out = append(out, candleResolution{Name: name, Duration: d})
+	}
+	return out, nil
+}
+
+const (
+	loadCandleQuery = `
+        SELECT open, high, low, close, open_ledger, close_ledger,
+            volume_0, volume_1, swap_count
+        FROM pair_candles
+        WHERE pair_address = ? AND resolution = ? AND bucket_start = ?
+    `
+
+	upsertCandleQuery = `
+        INSERT INTO pair_candles (
+            pair_address, resolution, bucket_start, open, high, low, close,
+            open_ledger, close_ledger, volume_0, volume_1, swap_count
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address, resolution, bucket_start) DO UPDATE SET
+            open = excluded.open,
+            high = excluded.high,
+            low = excluded.low,
+            close = excluded.close,
+            open_ledger = excluded.open_ledger,
+            close_ledger = excluded.close_ledger,
+            volume_0 = excluded.volume_0,
+            volume_1 = excluded.volume_1,
+            swap_count = excluded.swap_count
+    `
+)
+
+func init() {
+	registerHandlerQuery(loadCandleQuery, upsertCandleQuery)
+	registerRetentionTable(retentionTable{
+		Name:   "candles",
+		Table:  "pair_candles",
+		Column: "bucket_start",
+		Key:    "pair_address, resolution, bucket_start",
+	})
+	registerPairTable(pairTable{
+		Name:   "pair_candles",
+		Count:  "SELECT COUNT(*) FROM pair_candles WHERE pair_address = ?",
+		Delete: deleteByKey("pair_candles", "resolution", "bucket_start"),
+	})
+}
+
+// candleUpdate is what one event contributes to a pair's candles: a price
+// from a sync, or traded volume from a swap.
+type candleUpdate struct {
+	At     time.Time
+	Ledger int64
+	// Price is token_1 per token_0, nil for events that carry no price.
+	Price *float64
+	// Volume0/Volume1 are token amounts traded, nil for non-swaps.
+	Volume0 *big.Int
+	Volume1 *big.Int
+}
+
+// syncPrice returns the token_1 per token_0 price implied by reserves, nil
+// when reserve_0 is zero or unparsable.
+func syncPrice(reserve0, reserve1 string) *float64 {
+	r0, ok0 := new(big.Int).SetString(reserve0, 10)
+	r1, ok1 := new(big.Int).SetString(reserve1, 10)
+	if !ok0 || !ok1 || r0.Sign() == 0 {
+		return nil
+	}
+	p, _ := new(big.Rat).SetFrac(r1, r0).Float64()
+	return &p
+}
+
+// updateCandles folds u into the pair's candle for every configured
+// resolution. Open and close follow ledger order, so events arriving out of
+// order within a bucket still give the right open and close.
+func (s *SaveSoroswapPairsToSQLite) updateCandles(ctx context.Context, tx *sql.Tx, pair string, u candleUpdate) error {
+	for _, res := range s.candleResolutions {
+		bucket := u.At.UTC().Truncate(res.Duration)
+
+		var open, high, low, closePrice sql.NullFloat64
+		var openLedger, closeLedger sql.NullInt64
+		volume0, volume1 := "0", "0"
+		var swaps int64
+		err := s.stmts.queryRow(ctx, tx, loadCandleQuery, pair, res.Name, bucket).Scan(
+			&open, &high, &low, &closePrice, &openLedger, &closeLedger, &volume0, &volume1, &swaps)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to load %s candle for %s: %v", res.Name, pair, err)
+		}
+
+		if u.Price != nil {
+			p := *u.Price
+			ledger := sql.NullInt64{Int64: u.Ledger, Valid: u.Ledger > 0}
+			if !open.Valid {
+				open, high, low, closePrice = valid(p), valid(p), valid(p), valid(p)
+				openLedger, closeLedger = ledger, ledger
+			} else {
+				if p > high.Float64 {
+					high = valid(p)
+				}
+				if p < low.Float64 {
+					low = valid(p)
+				}
+				if ledger.Valid && openLedger.Valid && ledger.Int64 < openLedger.Int64 {
+					open, openLedger = valid(p), ledger
+				}
+				if !ledger.Valid || !closeLedger.Valid || ledger.Int64 >= closeLedger.Int64 {
+					closePrice, closeLedger = valid(p), ledger
+				}
+			}
+		}
+		if u.Volume0 != nil {
+			volume0 = addAmounts(volume0, u.Volume0)
+			volume1 = addAmounts(volume1, u.Volume1)
+			swaps++
+		}
+
+		if _, err := s.stmts.exec(ctx, tx, upsertCandleQuery,
+			pair, res.Name, bucket, open, high, low, closePrice,
+			openLedger, closeLedger, volume0, volume1, swaps,
+		); err != nil {
+			return fmt.Errorf("failed to update %s candle for %s: %v", res.Name, pair, err)
+		}
+	}
+	return nil
+}
+
+func valid(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+// addAmounts adds v to the decimal amount total.
+func addAmounts(total string, v *big.Int) string {
+	t, ok := new(big.Int).SetString(total, 10)
+	if !ok {
+		t = new(big.Int)
+	}
+	return t.Add(t, v).String()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+const (
+	selectCheckpointQuery = `
+        SELECT last_ledger, cursor, updated_at FROM consumer_checkpoint WHERE id = 1
+    `
+
+	upsertCheckpointQuery = `
+        INSERT INTO consumer_checkpoint (id, last_ledger, cursor, updated_at)
+        VALUES (1, ?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET
+            last_ledger = excluded.last_ledger,
+            cursor = excluded.cursor,
+            updated_at = excluded.updated_at
+    `
+)
+
+// Checkpoint is how far live processing has got: the highest ledger of a
+// handled event and the cursor of the latest message that carried one.
+// Failed events count as handled, since they are dead-lettered.
+type Checkpoint struct {
+	LastLedger int64      `json:"last_ledger"`
+	Cursor     string     `json:"cursor,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// checkpointTracker keeps the checkpoint in memory. It is persisted to
+// consumer_checkpoint with the lifetime counters, so once a batch commits
+// and never ahead of the events it covers.
+type checkpointTracker struct {
+	mu    sync.Mutex
+	cp    Checkpoint
+	dirty bool
+}
+
+// record advances the checkpoint past one handled event.
+func (c *checkpointTracker) record(ledger int64, cursor string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ledger > c.cp.LastLedger {
+		c.cp.LastLedger = ledger
+	}
+	if cursor != "" {
+		c.cp.Cursor = cursor
+	}
+	now := time.Now().UTC()
+	c.cp.UpdatedAt = &now
+	c.dirty = true
+}
+
+// rewind moves the checkpoint back to ledger after a rollback. The cursor
+// points past it and is dropped.
+func (c *checkpointTracker) rewind(ledger int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cp.LastLedger <= ledger {
+		return
+	}
+	c.cp.LastLedger = ledger
+	c.cp.Cursor = ""
+	now := time.Now().UTC()
+	c.cp.UpdatedAt = &now
+	c.dirty = true
+}
+
+func (c *checkpointTracker) get() Checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cp
+}
+
+// Checkpoint returns the current checkpoint, including events not yet
+// persisted. A restarted pipeline resumes after LastLedger or Cursor;
+// comparing LastLedger with the network's latest ledger gives the lag.
+func (s *SaveSoroswapPairsToSQLite) Checkpoint() Checkpoint {
+	return s.checkpoint.get()
+}
+
+// recordCheckpoint advances the checkpoint for a live event. Replays and
+// dead-letter retries carry old events and leave it alone.
+func (s *SaveSoroswapPairsToSQLite) recordCheckpoint(ctx context.Context, msg pluginapi.Message) {
+	if s.dryRun || replayTables(ctx) != nil {
+		return
+	}
+	if retry, _ := ctx.Value(deadLetterRetryKey{}).(bool); retry {
+		return
+	}
+	s.checkpoint.record(messageLedger(msg), messageCursor(msg.Metadata))
+}
+
+// messageLedger returns the ledger of an event as processMessage reads it:
+// from the payload, falling back to the message metadata.
+func messageLedger(msg pluginapi.Message) int64 {
+	if payload, ok := msg.Payload.([]byte); ok {
+		var e struct {
+			LedgerSequence int64 `json:"ledger_sequence"`
+		}
+		if json.Unmarshal(payload, &e) == nil && e.LedgerSequence > 0 {
+			return e.LedgerSequence
+		}
+	}
+	ledger, _ := metadataInt64(msg.Metadata, "ledger_sequence")
+	return ledger
+}
+
+// messageCursor returns the source's cursor for a message, if it set one.
+func messageCursor(metadata map[string]interface{}) string {
+	switch v := metadata["cursor"].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// loadCheckpoint reads the persisted checkpoint.
+func (s *SaveSoroswapPairsToSQLite) loadCheckpoint(ctx context.Context) error {
+	var cp Checkpoint
+	var cursor sql.NullString
+	var updatedAt time.Time
+	err := s.db.QueryRowContext(ctx, s.backend.Rebind(selectCheckpointQuery)).Scan(&cp.LastLedger, &cursor, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %v", err)
+	}
+	cp.Cursor = cursor.String
+	cp.UpdatedAt = &updatedAt
+	s.checkpoint.mu.Lock()
+	s.checkpoint.cp = cp
+	s.checkpoint.mu.Unlock()
+	return nil
+}
+
+// flushCheckpoint persists the checkpoint if it moved since the last flush.
+func (s *SaveSoroswapPairsToSQLite) flushCheckpoint(ctx context.Context) error {
+	c := &s.checkpoint
+	c.mu.Lock()
+	cp, dirty := c.cp, c.dirty
+	c.dirty = false
+	c.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.backend.Rebind(upsertCheckpointQuery),
+		cp.LastLedger, nullableString(cp.Cursor), *cp.UpdatedAt); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return fmt.Errorf("failed to persist checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mime"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/tinylib/msgp/msgp"
+	"github.com/withObsrvr/pluginapi"
+)
+
+// payloadCodec converts payloads of some content types to the JSON the
+// handlers decode. Codecs register themselves from init; a message's
+// content-type metadata picks one, so upstream processors need not agree
+// on an encoding.
+type payloadCodec struct {
+	Name string
+	// ContentTypes are the media types the codec decodes, lower case and
+	// without parameters.
+	ContentTypes []string
+	// Decode converts a payload to one JSON event or a JSON array of them.
+	// params are the parameters of the message's content type.
+	Decode func(payload []byte, params map[string]string) ([]byte, error)
+}
+
+var (
+	payloadCodecs     []payloadCodec
+	payloadCodecTypes = map[string]payloadCodec{}
+)
+
+// registerPayloadCodec adds a codec. Content types must be unique across
+// codecs.
+func registerPayloadCodec(c payloadCodec) {
+	payloadCodecs = append(payloadCodecs, c)
+	for _, t := range c.ContentTypes {
+		if _, dup := payloadCodecTypes[t]; dup {
+			panic(fmt.Sprintf("payload codec %s: content type %s already registered", c.Name, t))
+		}
+		payloadCodecTypes[t] = c
+	}
+}
+
+func init() {
+	registerPayloadCodec(payloadCodec{
+		Name:         "json",
+		ContentTypes: []string{"application/json", "text/json"},
+		Decode: func(payload []byte, _ map[string]string) ([]byte, error) {
+			return payload, nil
+		},
+	})
+	registerPayloadCodec(payloadCodec{
+		Name:         "msgpack",
+		ContentTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		Decode: func(payload []byte, _ map[string]string) ([]byte, error) {
+			var out bytes.Buffer
+			if _, err := msgp.UnmarshalAsJSON(&out, payload); err != nil {
+				return nil, err
+			}
+			return out.Bytes(), nil
+		},
+	})
+	registerPayloadCodec(payloadCodec{
+		Name:         "cbor",
+		ContentTypes: []string{"application/cbor"},
+		Decode:       decodeCBOR,
+	})
+}
+
+// cborDecoder decodes maps with string keys, which encoding/json can write.
+var cborDecoder, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+// decodeCBOR converts a CBOR payload to JSON. Bignums become JSON numbers,
+// which amounts accept like decimal strings.
+func decodeCBOR(payload []byte, _ map[string]string) ([]byte, error) {
+	var v interface{}
+	if err := cborDecoder.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(cborToJSON(v))
+}
+
+// cborToJSON replaces the bignums in a decoded CBOR value, which
+// encoding/json cannot write as numbers.
+func cborToJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case big.Int:
+		return json.Number(x.String())
+	case map[string]interface{}:
+		for k, elem := range x {
+			x[k] = cborToJSON(elem)
+		}
+	case []interface{}:
+		for i, elem := range x {
+			x[i] = cborToJSON(elem)
+		}
+	}
+	return v
+}
+
+// payloadCodecFor returns the codec of a message's content-type metadata,
+// or of fallback when it has none. The content type's parameters are
+// returned with it.
+func payloadCodecFor(msg pluginapi.Message, fallback string) (payloadCodec, map[string]string, error) {
+	contentType, _ := msg.Metadata["content-type"].(string)
+	if contentType == "" {
+		contentType = fallback
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return payloadCodec{}, nil, fmt.Errorf("invalid content-type %q: %v", contentType, err)
+	}
+	c, ok := payloadCodecTypes[mediaType]
+	if !ok {
+		return payloadCodec{}, nil, fmt.Errorf("unsupported content-type %q (supported: %s)", contentType, strings.Join(payloadContentTypes(), ", "))
+	}
+	return c, params, nil
+}
+
+// parsePayloadContentType reads payload_content_type, the content type
+// assumed for messages without content-type metadata.
+func parsePayloadContentType(cfg *Config) (string, error) {
+	if _, _, err := payloadCodecFor(pluginapi.Message{}, cfg.PayloadContentType); err != nil {
+		return "", fmt.Errorf("config payload_content_type: %v", err)
+	}
+	return cfg.PayloadContentType, nil
+}
+
+// payloadContentTypes lists the content types of every codec.
+func payloadContentTypes() []string {
+	var types []string
+	for _, c := range payloadCodecs {
+		types = append(types, c.ContentTypes...)
+	}
+	return types
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config declares every configuration key with its type. Initialize decodes
+// the config map into it once, before anything else, so a misspelt key, a
+// value of the wrong type or an empty path fails with a clear message
+// instead of falling back to a default, and every setting is then read from
+// the decoded Config. Defaults live in defaultConfig; a pointer field is
+// nil when unset, for settings whose default depends on other settings. A
+// key with the path or nonempty option must not be empty when set.
+type Config struct {
+	// Storage
+	CreateDirs  bool        `config:"create_dirs"`
+	DBDriver    string      `config:"db_driver"`
+	DBPath      string      `config:"db_path,path"`
+	DirMode     os.FileMode `config:"dir_mode"`
+	Driver      string      `config:"driver"`
+	DSN         string      `config:"dsn"`
+	FileMode    os.FileMode `config:"file_mode"`
+	Indexes     interface{} `config:"indexes"`
+	TablePrefix string      `config:"table_prefix"`
+
+	// Connection pools
+	ConnMaxLifetime     time.Duration `config:"conn_max_lifetime"`
+	MaxIdleConns        *int          `config:"max_idle_conns"`
+	MaxOpenConns        *int          `config:"max_open_conns"`
+	ReadConnMaxLifetime time.Duration `config:"read_conn_max_lifetime"`
+	ReadMaxIdleConns    *int          `config:"read_max_idle_conns"`
+	ReadMaxOpenConns    int           `config:"read_max_open_conns"`
+
+	// SQLite connections
+	SQLiteBusyTimeout       time.Duration `config:"sqlite_busy_timeout"`
+	SQLiteCacheSize         *int          `config:"sqlite_cache_size"`
+	SQLiteJournalMode       string        `config:"sqlite_journal_mode"`
+	SQLiteKey               string        `config:"sqlite_key,nonempty"`
+	SQLiteMmapSize          *int          `config:"sqlite_mmap_size"`
+	SQLiteSynchronous       string        `config:"sqlite_synchronous"`
+	SQLiteWALAutoCheckpoint *int          `config:"sqlite_wal_autocheckpoint"`
+	BusyRetries             int           `config:"busy_retries"`
+	BusyRetryBackoff        time.Duration `config:"busy_retry_backoff"`
+
+	// Event processing
+	BatchByLedger              bool          `config:"batch_by_ledger"`
+	BatchInterval              time.Duration `config:"batch_interval"`
+	BatchSize                  int           `config:"batch_size"`
+	ArchiveRawEvents           bool          `config:"archive_raw_events"`
+	Backfill                   bool          `config:"backfill"`
+	CounterFlushEvery          int           `config:"counter_flush_every"`
+	CreateMissingPairs         bool          `config:"create_missing_pairs"`
+	PendingSyncs               bool          `config:"pending_syncs"`
+	MinReserve                 interface{}   `config:"min_reserve"`
+	DedupEvents                bool          `config:"dedup_events"`
+	DownstreamEnrich           bool          `config:"downstream_enrich"`
+	DryRun                     bool          `config:"dry_run"`
+	DryRunReport               bool          `config:"dry_run_report"`
+	PairAllowlist              []string      `config:"pair_allowlist"`
+	PairDenylist               []string      `config:"pair_denylist"`
+	QueryPlanCheckInterval     time.Duration `config:"query_plan_check_interval"`
+	ReserveHistory             bool          `config:"reserve_history"`
+	SQLHooks                   interface{}   `config:"sql_hooks"`
+	VolumeStats                bool          `config:"volume_stats"`
+	VolumeStatsRefreshInterval time.Duration `config:"volume_stats_refresh_interval"`
+	Network                    string        `config:"network"`
+	PayloadContentType         string        `config:"payload_content_type"`
+	ShutdownTimeout            time.Duration `config:"shutdown_timeout"`
+	StrictMode                 bool          `config:"strict_mode"`
+	TokenAllowlist             []string      `config:"token_allowlist"`
+	TokenDenylist              []string      `config:"token_denylist"`
+	Workers                    int           `config:"workers"`
+
+	// HTTP and gRPC endpoints
+	AdminAddr   string `config:"admin_addr"`
+	APIAddr     string `config:"api_addr"`
+	GraphQLAddr string `config:"graphql_addr"`
+	GRPCAddr    string `config:"grpc_addr"`
+	HealthAddr  string `config:"health_addr"`
+	MetricsAddr string `config:"metrics_addr"`
+
+	// Event validation
+	FutureSkewPolicy        string        `config:"future_skew_policy"`
+	MaxFutureSkew           time.Duration `config:"max_future_skew"`
+	MinEventTime            time.Time     `config:"min_event_time"`
+	MinEventTimePolicy      string        `config:"min_event_time_policy"`
+	ZeroTimestampPolicy     string        `config:"zero_timestamp_policy"`
+	AmountFractionTolerance interface{}   `config:"amount_fraction_tolerance"`
+
+	// Flow control
+	MaxEventsPerSecond float64       `config:"max_events_per_second"`
+	MaxPendingEvents   int           `config:"max_pending_events"`
+	OverflowPolicy     string        `config:"overflow_policy"`
+	MaxCommitLatency   time.Duration `config:"max_commit_latency"`
+
+	// Health
+	FailingThreshold   int           `config:"failing_threshold"`
+	HealthCheckTimeout time.Duration `config:"health_check_timeout"`
+	HealthMaxEventAge  time.Duration `config:"health_max_event_age"`
+
+	// Logging and tracing
+	LogFormat          string            `config:"log_format"`
+	LogLevel           string            `config:"log_level"`
+	TracingEndpoint    string            `config:"tracing_endpoint"`
+	TracingExporter    string            `config:"tracing_exporter"`
+	TracingHeaders     map[string]string `config:"tracing_headers"`
+	TracingInsecure    bool              `config:"tracing_insecure"`
+	TracingSampleRatio float64           `config:"tracing_sample_ratio"`
+	TracingServiceName string            `config:"tracing_service_name"`
+
+	// Alerts and webhooks
+	Alerts              interface{}       `config:"alerts"`
+	WebhookHeaders      map[string]string `config:"webhook_headers"`
+	WebhookMaxAttempts  int               `config:"webhook_max_attempts"`
+	WebhookMaxBackoff   time.Duration     `config:"webhook_max_backoff"`
+	WebhookRetryBackoff time.Duration     `config:"webhook_retry_backoff"`
+	WebhookTimeout      time.Duration     `config:"webhook_timeout"`
+	WebhookURL          string            `config:"webhook_url"`
+
+	// Forwarding
+	KafkaBrokers       []string      `config:"kafka_brokers"`
+	KafkaEnrich        bool          `config:"kafka_enrich"`
+	KafkaMaxAttempts   int           `config:"kafka_max_attempts"`
+	KafkaPassword      string        `config:"kafka_password"`
+	KafkaSASLMechanism string        `config:"kafka_sasl_mechanism"`
+	KafkaTimeout       time.Duration `config:"kafka_timeout"`
+	KafkaTLS           bool          `config:"kafka_tls"`
+	KafkaTopic         string        `config:"kafka_topic"`
+	KafkaUsername      string        `config:"kafka_username"`
+	NATSCredsFile      string        `config:"nats_creds_file,path"`
+	NATSEnrich         bool          `config:"nats_enrich"`
+	NATSJetStream      bool          `config:"nats_jetstream"`
+	NATSSubjectPrefix  string        `config:"nats_subject_prefix"`
+	NATSTimeout        time.Duration `config:"nats_timeout"`
+	NATSToken          string        `config:"nats_token"`
+	NATSURL            string        `config:"nats_url"`
+
+	// Derived data
+	CandleIntervals        []string      `config:"candle_intervals"`
+	CreateViews            bool          `config:"create_views"`
+	StalePairLedgers       int           `config:"stale_pair_ledgers"`
+	StatsRollups           bool          `config:"stats_rollups"`
+	ViewColumns            []string      `config:"view_columns"`
+	TokenEnrichBatch       int           `config:"token_enrich_batch"`
+	TokenEnrichInterval    time.Duration `config:"token_enrich_interval"`
+	TokenRetryAfter        time.Duration `config:"token_retry_after"`
+	TokenRPCSourceAccount  string        `config:"token_rpc_source_account"`
+	TokenRPCTimeout        time.Duration `config:"token_rpc_timeout"`
+	TokenRPCURL            string        `config:"token_rpc_url"`
+	ReflectorContract      string        `config:"reflector_contract"`
+	ReflectorDivergencePct float64       `config:"reflector_divergence_pct"`
+	ReflectorInterval      time.Duration `config:"reflector_interval"`
+	ReflectorRPCURL        string        `config:"reflector_rpc_url"`
+	USDReferenceTokens     []string      `config:"usd_reference_tokens"`
+	USDStablecoins         []string      `config:"usd_stablecoins"`
+
+	// Retention and maintenance
+	ReserveHistoryPruneInterval time.Duration     `config:"reserve_history_prune_interval"`
+	ReserveHistoryRetention     time.Duration     `config:"reserve_history_retention"`
+	Retention                   map[string]string `config:"retention"`
+	RetentionPruneInterval      *time.Duration    `config:"retention_prune_interval"`
+	RetentionVacuum             bool              `config:"retention_vacuum"`
+	RetentionVacuumInterval     time.Duration     `config:"retention_vacuum_interval"`
+	PartitionByMonth            bool              `config:"partition_by_month"`
+	MaintenanceTimezone         string            `config:"maintenance_timezone"`
+	MaintenanceVacuum           string            `config:"maintenance_vacuum"`
+	MaintenanceWindow           string            `config:"maintenance_window"`
+	BackupDir                   string            `config:"backup_dir,path"`
+	BackupInterval              time.Duration     `config:"backup_interval"`
+	BackupKeep                  int               `config:"backup_keep"`
+
+	// Replication
+	Replication                   string        `config:"replication"`
+	ReplicationS3AccessKeyID      string        `config:"replication_s3_access_key_id"`
+	ReplicationS3Endpoint         string        `config:"replication_s3_endpoint"`
+	ReplicationS3Insecure         bool          `config:"replication_s3_insecure"`
+	ReplicationS3Interval         time.Duration `config:"replication_s3_interval"`
+	ReplicationS3Path             string        `config:"replication_s3_path"`
+	ReplicationS3Region           string        `config:"replication_s3_region"`
+	ReplicationS3SecretAccessKey  string        `config:"replication_s3_secret_access_key"`
+	ReplicationS3SnapshotInterval time.Duration `config:"replication_s3_snapshot_interval"`
+	WALCheckpointInterval         time.Duration `config:"wal_checkpoint_interval"`
+	WALCheckpointMode             string        `config:"wal_checkpoint_mode"`
+
+	// Exports
+	AnalyticsExportIntervalHours float64           `config:"analytics_export_interval_hours"`
+	AnalyticsExportPath          string            `config:"analytics_export_path,path"`
+	AnalyticsTables              []string          `config:"analytics_tables"`
+	CSVExportColumns             map[string]string `config:"csv_export_columns"`
+	CSVExportDir                 string            `config:"csv_export_dir,path"`
+	CSVExportFrom                time.Time         `config:"csv_export_from"`
+	CSVExportOnStart             bool              `config:"csv_export_on_start"`
+	CSVExportTables              []string          `config:"csv_export_tables"`
+	CSVExportTo                  time.Time         `config:"csv_export_to"`
+	ParquetExportInterval        time.Duration     `config:"parquet_export_interval"`
+	ParquetExportPath            string            `config:"parquet_export_path,path"`
+	ParquetExportTables          []string          `config:"parquet_export_tables"`
+	ParquetS3AccessKeyID         string            `config:"parquet_s3_access_key_id"`
+	ParquetS3Endpoint            string            `config:"parquet_s3_endpoint"`
+	ParquetS3Insecure            bool              `config:"parquet_s3_insecure"`
+	ParquetS3Region              string            `config:"parquet_s3_region"`
+	ParquetS3SecretAccessKey     string            `config:"parquet_s3_secret_access_key"`
+}
+
+// defaultConfig returns the settings used for unset keys.
+func defaultConfig() Config {
+	return Config{
+		CreateDirs: true,
+		DBPath:     "soroswap_pairs.sqlite",
+		DirMode:    0o755,
+
+		ReadMaxOpenConns: defaultReadOpenConns,
+
+		SQLiteBusyTimeout: 5 * time.Second,
+		SQLiteJournalMode: "WAL",
+		SQLiteSynchronous: "NORMAL",
+		BusyRetries:       5,
+		BusyRetryBackoff:  100 * time.Millisecond,
+
+		BatchInterval:              time.Second,
+		CounterFlushEvery:          defaultCounterFlushEvery,
+		DownstreamEnrich:           true,
+		DryRunReport:               true,
+		QueryPlanCheckInterval:     time.Hour,
+		VolumeStats:                true,
+		VolumeStatsRefreshInterval: 5 * time.Minute,
+		PayloadContentType:         "application/json",
+		ShutdownTimeout:            defaultShutdownTimeout,
+
+		FutureSkewPolicy:    string(timestampFlag),
+		MaxFutureSkew:       defaultMaxFutureSkew,
+		MinEventTimePolicy:  string(timestampFlag),
+		ZeroTimestampPolicy: string(timestampReject),
+
+		OverflowPolicy: "block",
+
+		FailingThreshold:   10,
+		HealthCheckTimeout: 2 * time.Second,
+
+		LogFormat:          "text",
+		LogLevel:           "info",
+		TracingSampleRatio: 1,
+		TracingServiceName: "soroswap-pairs-consumer",
+
+		WebhookMaxAttempts:  10,
+		WebhookMaxBackoff:   10 * time.Minute,
+		WebhookRetryBackoff: time.Second,
+		WebhookTimeout:      10 * time.Second,
+
+		KafkaMaxAttempts:  3,
+		KafkaTimeout:      10 * time.Second,
+		NATSJetStream:     true,
+		NATSSubjectPrefix: "soroswap.pairs",
+		NATSTimeout:       10 * time.Second,
+
+		CreateViews:            true,
+		StalePairLedgers:       defaultStaleLedgers,
+		TokenEnrichBatch:       50,
+		TokenEnrichInterval:    time.Minute,
+		TokenRetryAfter:        time.Hour,
+		TokenRPCSourceAccount:  zeroAccount,
+		TokenRPCTimeout:        10 * time.Second,
+		ReflectorDivergencePct: 5,
+		ReflectorInterval:      5 * time.Minute,
+
+		ReserveHistoryPruneInterval: time.Hour,
+		RetentionVacuumInterval:     24 * time.Hour,
+		MaintenanceTimezone:         "UTC",
+		MaintenanceVacuum:           "incremental",
+		BackupKeep:                  defaultBackupKeep,
+
+		ReplicationS3Endpoint:         "s3.amazonaws.com",
+		ReplicationS3Interval:         defaultShipInterval,
+		ReplicationS3SnapshotInterval: defaultSnapshotInterval,
+		WALCheckpointMode:             "PASSIVE",
+
+		ParquetS3Endpoint: "s3.amazonaws.com",
+	}
+}
+
+// decodeConfig decodes config over defaultConfig, reporting every problem
+// at once.
+func decodeConfig(config map[string]interface{}) (Config, error) {
+	cfg := defaultConfig()
+	var errs []error
+	v := reflect.ValueOf(&cfg).Elem()
+	known := make(map[string]bool, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		key, opt, _ := strings.Cut(v.Type().Field(i).Tag.Get("config"), ",")
+		known[key] = true
+		if config[key] == nil {
+			continue
+		}
+		if err := decodeConfigField(config, key, v.Field(i).Addr().Interface()); err != nil {
+			errs = append(errs, err)
+		} else if (opt == "path" || opt == "nonempty") && v.Field(i).String() == "" {
+			errs = append(errs, fmt.Errorf("config %s must not be empty", key))
+		}
+	}
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if similar := similarConfigKey(key, known); similar != "" {
+			errs = append(errs, fmt.Errorf("config %s: unknown key, did you mean %s?", key, similar))
+		} else {
+			errs = append(errs, fmt.Errorf("config %s: unknown key", key))
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// decodeConfigField reads key into the Config field ptr points to, with
+// the helper for its type.
+func decodeConfigField(config map[string]interface{}, key string, ptr interface{}) error {
+	var err error
+	switch p := ptr.(type) {
+	case *string:
+		*p, err = configString(config, key, "")
+	case *[]string:
+		*p, err = configStrings(config, key)
+	case *map[string]string:
+		*p, err = configStringMap(config, key)
+	case *time.Duration:
+		*p, err = configDuration(config, key, 0)
+	case **time.Duration:
+		var d time.Duration
+		d, err = configDuration(config, key, 0)
+		*p = &d
+	case *time.Time:
+		*p, err = configTime(config, key)
+	case *float64:
+		*p, err = configFloat(config, key, 0)
+	case *int:
+		*p, err = configInt(config, key, 0)
+	case **int:
+		var n int
+		n, err = configInt(config, key, 0)
+		*p = &n
+	case *bool:
+		*p, err = configBool(config, key, false)
+	case *os.FileMode:
+		*p, err = configFileMode(config, key, 0)
+	case *interface{}:
+		// Structured values are checked by their own parsers.
+		*p = config[key]
+	default:
+		panic(fmt.Sprintf("config %s: unsupported field type %T", key, ptr))
+	}
+	return err
+}
+
+// similarConfigKey returns the known key closest to a misspelt one, or ""
+// when none is within two edits.
+func similarConfigKey(key string, known map[string]bool) string {
+	keys := make([]string, 0, len(known))
+	for k := range known {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	normalized := strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	best, bestDist := "", 3
+	for _, k := range keys {
+		if d := editDistance(normalized, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// configString returns the string value of key, or def when unset.
+func configString(config map[string]interface{}, key, def string) (string, error) {
+	v, ok := config[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("config %s: expected string, got %T", key, v)
+	}
+	return s, nil
+}
+
+// configStrings reads a list of strings, nil when unset.
+func configStrings(config map[string]interface{}, key string) ([]string, error) {
+	switch v := config[key].(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		out := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("config %s: expected list of strings, got %T item", key, item)
+			}
+			out[i] = s
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("config %s: expected list of strings, got %T", key, v)
+	}
+}
+
+// configStringMap reads a map of strings, nil when unset.
+func configStringMap(config map[string]interface{}, key string) (map[string]string, error) {
+	switch v := config[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]string:
+		return v, nil
+	case map[string]interface{}:
+		out := make(map[string]string, len(v))
+		for k, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("config %s.%s: expected string, got %T", key, k, item)
+			}
+			out[k] = s
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("config %s: expected map of strings, got %T", key, v)
+	}
+}
+
+// configDuration parses key as a Go duration string such as "5m".
+func configDuration(config map[string]interface{}, key string, def time.Duration) (time.Duration, error) {
+	s, err := configString(config, key, "")
+	if err != nil || s == "" {
+		return def, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("config %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// configTime parses key as an RFC3339 timestamp.
+func configTime(config map[string]interface{}, key string) (time.Time, error) {
+	s, err := configString(config, key, "")
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("config %s: %v", key, err)
+	}
+	return t, nil
+}
+
+// configFloat reads a number, accepting the int and float64 values produced
+// by the different config decoders.
+func configFloat(config map[string]interface{}, key string, def float64) (float64, error) {
+	switch v := config[key].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("config %s: expected number, got %T", key, v)
+	}
+}
+
+// configInt reads a whole number.
+func configInt(config map[string]interface{}, key string, def int) (int, error) {
+	f, err := configFloat(config, key, float64(def))
+	if err != nil {
+		return 0, err
+	}
+	if f != float64(int(f)) {
+		return 0, fmt.Errorf("config %s: expected whole number, got %v", key, f)
+	}
+	return int(f), nil
+}
+
+// configFileMode reads a permission mode given either as an octal string
+// ("0750") or as a number (YAML decodes 0750 to 488).
+func configFileMode(config map[string]interface{}, key string, def os.FileMode) (os.FileMode, error) {
+	switch v := config[key].(type) {
+	case nil:
+		return def, nil
+	case string:
+		m, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("config %s: invalid octal mode %q", key, v)
+		}
+		return os.FileMode(m).Perm(), nil
+	default:
+		n, err := configInt(config, key, 0)
+		if err != nil {
+			return 0, err
+		}
+		return os.FileMode(n).Perm(), nil
+	}
+}
+
+// configBool reads a boolean.
+func configBool(config map[string]interface{}, key string, def bool) (bool, error) {
+	switch v := config[key].(type) {
+	case nil:
+		return def, nil
+	case bool:
+		return v, nil
+	default:
+		return false, fmt.Errorf("config %s: expected boolean, got %T", key, v)
+	}
+}
+
+// configEnvRef matches ${NAME} and ${NAME:-default} references, and the
+// $${ escape for a literal ${.
+var configEnvRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// expandConfigEnv returns a copy of config with environment variable
+// references in string values, including those nested in lists and maps,
+// replaced by the variables' values. A reference to an unset variable
+// without a default is an error, so a missing secret does not become an
+// empty value.
+func expandConfigEnv(config map[string]interface{}) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []error
+	out := make(map[string]interface{}, len(config))
+	for _, key := range keys {
+		out[key] = expandConfigValue(key, config[key], &errs)
+	}
+	return out, errors.Join(errs...)
+}
+
+func expandConfigValue(key string, v interface{}, errs *[]error) interface{} {
+	switch v := v.(type) {
+	case string:
+		s, err := expandEnv(v)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("config %s: %v", key, err))
+		}
+		return s
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = expandConfigValue(fmt.Sprintf("%s[%d]", key, i), item, errs)
+		}
+		return out
+	case []string:
+		out := make([]string, len(v))
+		for i, item := range v {
+			out[i] = expandConfigValue(fmt.Sprintf("%s[%d]", key, i), item, errs).(string)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = expandConfigValue(key+"."+k, item, errs)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for k, item := range v {
+			out[k] = expandConfigValue(key+"."+k, item, errs).(string)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// expandEnv replaces the environment variable references in s.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	out := configEnvRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		m := configEnvRef.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && (v != "" || m[2] == "") {
+			return v
+		}
+		if m[2] != "" {
+			return strings.TrimPrefix(m[2], ":-")
+		}
+		missing = append(missing, m[1])
+		return ""
+	})
+	switch len(missing) {
+	case 0:
+		return out, nil
+	case 1:
+		return "", fmt.Errorf("environment variable %s is not set", missing[0])
+	default:
+		return "", fmt.Errorf("environment variables %s are not set", strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		check   func(t *testing.T, cfg Config)
+		wantErr []string
+	}{
+		{
+			name:   "defaults",
+			config: map[string]interface{}{},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.DBPath != "soroswap_pairs.sqlite" || !cfg.CreateDirs || cfg.BatchInterval != time.Second || cfg.MaxOpenConns != nil {
+					t.Errorf("defaults = db_path %q, create_dirs %v, batch_interval %s, max_open_conns %v",
+						cfg.DBPath, cfg.CreateDirs, cfg.BatchInterval, cfg.MaxOpenConns)
+				}
+			},
+		},
+		{
+			name: "set values replace defaults",
+			config: map[string]interface{}{
+				"create_dirs":              false,
+				"batch_interval":           "250ms",
+				"max_open_conns":           0,
+				"retention_prune_interval": "10m",
+				"dir_mode":                 "0750",
+				"pair_allowlist":           []interface{}{"a", "b"},
+			},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.CreateDirs || cfg.BatchInterval != 250*time.Millisecond || cfg.DirMode != 0o750 || len(cfg.PairAllowlist) != 2 {
+					t.Errorf("decoded = create_dirs %v, batch_interval %s, dir_mode %o, pair_allowlist %v",
+						cfg.CreateDirs, cfg.BatchInterval, cfg.DirMode, cfg.PairAllowlist)
+				}
+				if cfg.MaxOpenConns == nil || *cfg.MaxOpenConns != 0 {
+					t.Errorf("max_open_conns = %v, want 0", cfg.MaxOpenConns)
+				}
+				if cfg.RetentionPruneInterval == nil || *cfg.RetentionPruneInterval != 10*time.Minute {
+					t.Errorf("retention_prune_interval = %v, want 10m", cfg.RetentionPruneInterval)
+				}
+			},
+		},
+		{
+			name:    "unknown key",
+			config:  map[string]interface{}{"batch_sise": 10, "no_such_setting": true},
+			wantErr: []string{"batch_sise: unknown key, did you mean batch_size?", "no_such_setting: unknown key"},
+		},
+		{
+			name:    "wrong types",
+			config:  map[string]interface{}{"batch_size": "10", "create_views": "yes", "batch_interval": "soon"},
+			wantErr: []string{"batch_size: expected number", "create_views: expected boolean", "batch_interval: time: invalid duration"},
+		},
+		{
+			name:    "empty path",
+			config:  map[string]interface{}{"db_path": "", "backup_dir": ""},
+			wantErr: []string{"db_path must not be empty", "backup_dir must not be empty"},
+		},
+		{
+			name:    "empty sqlite_key",
+			config:  map[string]interface{}{"sqlite_key": ""},
+			wantErr: []string{"sqlite_key must not be empty"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := decodeConfig(tt.config)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("decodeConfig: %v", err)
+				}
+				tt.check(t, cfg)
+				return
+			}
+			if err == nil {
+				t.Fatalf("decodeConfig = nil, want errors %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("decodeConfig error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInitializeUsesDecodedDefaults(t *testing.T) {
+	s := newTestConsumer(t, nil)
+	if !s.volumeStats || !s.dryRunReport || !s.downstream.enrich {
+		t.Errorf("volume_stats %v, dry_run_report %v, downstream_enrich %v; want the defaults, true",
+			s.volumeStats, s.dryRunReport, s.downstream.enrich)
+	}
+	if s.busy.attempts != 5 || s.shutdown.timeout != defaultShutdownTimeout {
+		t.Errorf("busy_retries %d, shutdown_timeout %s; want 5 and %s", s.busy.attempts, s.shutdown.timeout, defaultShutdownTimeout)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default connection pool sizes. SQLite takes one writer at a time, so its
+// write pool holds a single connection and queries get a pool of their own
+// instead of queueing behind the writer or failing with SQLITE_BUSY.
+const (
+	defaultSQLiteMaxOpenConns   = 1
+	defaultPostgresMaxOpenConns = 4
+	defaultReadOpenConns        = 4
+	defaultMaxIdleConns         = 2
+)
+
+// poolLimits are the database/sql limits of a connection pool.
+type poolLimits struct {
+	// maxOpen is unlimited when 0.
+	maxOpen int
+	maxIdle int
+	// maxLifetime is unlimited when 0.
+	maxLifetime time.Duration
+}
+
+// parsePoolLimits checks the limits of the pool whose keys start with
+// prefix: maxOpen, maxIdle (default 2, at most maxOpen) and maxLifetime.
+func parsePoolLimits(prefix string, maxOpen int, maxIdle *int, maxLifetime time.Duration) (poolLimits, error) {
+	l := poolLimits{maxOpen: maxOpen, maxIdle: defaultMaxIdleConns, maxLifetime: maxLifetime}
+	if l.maxOpen < 0 {
+		return l, fmt.Errorf("config %smax_open_conns must not be negative, got %d", prefix, l.maxOpen)
+	}
+	if maxIdle != nil {
+		l.maxIdle = *maxIdle
+	} else if l.maxOpen > 0 && l.maxOpen < l.maxIdle {
+		l.maxIdle = l.maxOpen
+	}
+	if l.maxIdle < 0 {
+		return l, fmt.Errorf("config %smax_idle_conns must not be negative, got %d", prefix, l.maxIdle)
+	}
+	if l.maxOpen > 0 && l.maxIdle > l.maxOpen {
+		return l, fmt.Errorf("config %smax_idle_conns (%d) must not exceed %smax_open_conns (%d)", prefix, l.maxIdle, prefix, l.maxOpen)
+	}
+	if l.maxLifetime < 0 {
+		return l, fmt.Errorf("config %sconn_max_lifetime must not be negative, got %s", prefix, l.maxLifetime)
+	}
+	return l, nil
+}
+
+// parsePools checks the write pool, whose size defaults to defOpen, and
+// the read pool.
+func parsePools(cfg *Config, defOpen int) (pool, readPool poolLimits, err error) {
+	if cfg.MaxOpenConns != nil {
+		defOpen = *cfg.MaxOpenConns
+	}
+	if pool, err = parsePoolLimits("", defOpen, cfg.MaxIdleConns, cfg.ConnMaxLifetime); err != nil {
+		return pool, readPool, err
+	}
+	readPool, err = parsePoolLimits("read_", cfg.ReadMaxOpenConns, cfg.ReadMaxIdleConns, cfg.ReadConnMaxLifetime)
+	return pool, readPool, err
+}
+
+// apply sets the limits on db.
+func (l poolLimits) apply(db *sql.DB) {
+	db.SetMaxOpenConns(l.maxOpen)
+	db.SetMaxIdleConns(l.maxIdle)
+	db.SetConnMaxLifetime(l.maxLifetime)
+}
+
+// closeDB closes the read pool and the write pool.
+func (s *SaveSoroswapPairsToSQLite) closeDB() error {
+	var errs []error
+	if s.readDB != nil && s.readDB != s.db {
+		errs = append(errs, s.readDB.Close())
+	}
+	errs = append(errs, s.db.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultCounterFlushEvery = 100
+
+// EventCounters are the lifetime counts kept per event type in
+// consumer_counters.
+type EventCounters struct {
+	Processed    int64 `json:"processed"`
+	Failed       int64 `json:"failed"`
+	SkippedStale int64 `json:"skipped_stale"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+func (c *EventCounters) add(d EventCounters) {
+	c.Processed += d.Processed
+	c.Failed += d.Failed
+	c.SkippedStale += d.SkippedStale
+	c.DeadLettered += d.DeadLettered
+}
+
+// persistentCounters keeps lifetime counters across restarts. Increments
+// are buffered in memory and flushed to consumer_counters every flushEvery
+// events and on Close, so counting never adds a write per event.
+type persistentCounters struct {
+	mu         sync.Mutex
+	stored     map[string]EventCounters
+	pending    map[string]EventCounters
+	events     int
+	flushEvery int
+}
+
+func newPersistentCounters(flushEvery int) *persistentCounters {
+	if flushEvery <= 0 {
+		flushEvery = defaultCounterFlushEvery
+	}
+	return &persistentCounters{
+		stored:     make(map[string]EventCounters),
+		pending:    make(map[string]EventCounters),
+		flushEvery: flushEvery,
+	}
+}
+
+// add buffers an increment, reporting whether a flush is due.
+func (c *persistentCounters) add(eventType string, d EventCounters) bool {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pending[eventType]
+	p.add(d)
+	c.pending[eventType] = p
+	c.events++
+	return c.events >= c.flushEvery
+}
+
+// lifetime returns stored plus buffered counts.
+func (c *persistentCounters) lifetime() map[string]EventCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]EventCounters, len(c.stored))
+	for k, v := range c.stored {
+		out[k] = v
+	}
+	for k, d := range c.pending {
+		v := out[k]
+		v.add(d)
+		out[k] = v
+	}
+	return out
+}
+
+// loadCounters reads the persisted lifetime counters.
+func (s *SaveSoroswapPairsToSQLite) loadCounters(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind(`
+        SELECT event_type, processed, failed, skipped_stale, dead_lettered
+        FROM consumer_counters
+    `))
+	if err != nil {
+		return fmt.Errorf("failed to load counters: %v", err)
+	}
+	defer rows.Close()
+
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	for rows.Next() {
+		var t string
+		var c EventCounters
+		if err := rows.Scan(&t, &c.Processed, &c.Failed, &c.SkippedStale, &c.DeadLettered); err != nil {
+			return fmt.Errorf("failed to scan counters: %v", err)
+		}
+		s.counters.stored[t] = c
+	}
+	return rows.Err()
+}
+
+// flushCounters writes buffered increments in one transaction, after the
+// checkpoint and the error counts. Increments made while the flush runs stay buffered; a failed
+// flush puts its deltas back so nothing is lost. Dry runs never persist
+// counters.
+func (s *SaveSoroswapPairsToSQLite) flushCounters(ctx context.Context) error {
+	if s.dryRun {
+		return nil
+	}
+	if err := s.flushCheckpoint(ctx); err != nil {
+		return err
+	}
+	if err := s.flushIngestErrors(ctx); err != nil {
+		return err
+	}
+
+	c := s.counters
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]EventCounters)
+	c.events = 0
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := s.writeCounters(ctx, pending)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t, d := range pending {
+		if err != nil {
+			p := c.pending[t]
+			p.add(d)
+			c.pending[t] = p
+			continue
+		}
+		v := c.stored[t]
+		v.add(d)
+		c.stored[t] = v
+	}
+	if err != nil {
+		return err
+	}
+	for t, v := range c.stored {
+		logger.Info("Lifetime event counters", "event_type", t, "processed", v.Processed, "failed", v.Failed,
+			"skipped_stale", v.SkippedStale, "dead_lettered", v.DeadLettered)
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) writeCounters(ctx context.Context, deltas map[string]EventCounters) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	stmt, err := tx.PrepareContext(ctx, s.backend.Rebind(`
+        INSERT INTO consumer_counters (
+            event_type, processed, failed, skipped_stale, dead_lettered, updated_at
+        ) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (event_type) DO UPDATE SET
+            processed = consumer_counters.processed + excluded.processed,
+            failed = consumer_counters.failed + excluded.failed,
+            skipped_stale = consumer_counters.skipped_stale + excluded.skipped_stale,
+            dead_lettered = consumer_counters.dead_lettered + excluded.dead_lettered,
+            updated_at = excluded.updated_at
+    `))
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for t, d := range deltas {
+		if _, err := stmt.ExecContext(ctx, t, d.Processed, d.Failed, d.SkippedStale, d.DeadLettered, now); err != nil {
+			return fmt.Errorf("failed to flush counters for %s: %v", t, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit counters: %v", err)
+	}
+	return nil
+}
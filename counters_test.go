@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCountersSurviveRestart(t *testing.T) {
+	tests := []struct {
+		name       string
+		flushEvery int
+	}{
+		{"flushed on close", 100},
+		{"flushed every event", 1},
+		{"flushed every few events", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{
+				"db_path":             filepath.Join(t.TempDir(), "pairs.sqlite"),
+				"counter_flush_every": tt.flushEvery,
+			}
+			ledger, created := int64(20), false
+			run := func(syncs, stale int) map[string]EventCounters {
+				s := openTestConsumer(t, config)
+				defer s.Close()
+				if !created {
+					process(t, s, newPairEvent(testPair, 10))
+					created = true
+				}
+				for i := 0; i < syncs; i++ {
+					ledger++
+					process(t, s, syncEvent(testPair, fmt.Sprint(ledger), "5", ledger))
+				}
+				for i := 0; i < stale; i++ {
+					process(t, s, syncEvent(testPair, "1", "5", 11))
+				}
+				return s.Stats().Lifetime
+			}
+
+			if got := run(4, 1)["sync"]; got.Processed != 5 || got.SkippedStale != 1 {
+				t.Errorf("first run sync counters = %+v, want 5 processed, 1 stale", got)
+			}
+			if got := run(2, 2)["sync"]; got.Processed != 9 || got.SkippedStale != 3 {
+				t.Errorf("second run sync counters = %+v, want 9 processed, 3 stale", got)
+			}
+			lifetime := run(0, 0)
+			if got := lifetime["sync"]; got.Processed != 9 || got.SkippedStale != 3 {
+				t.Errorf("restarted sync counters = %+v, want 9 processed, 3 stale", got)
+			}
+			if got := lifetime["new_pair"]; got.Processed != 1 {
+				t.Errorf("restarted new_pair counters = %+v, want 1 processed", got)
+			}
+		})
+	}
+}
+
+func TestCountersConcurrent(t *testing.T) {
+	config := map[string]interface{}{
+		"db_path":             filepath.Join(t.TempDir(), "pairs.sqlite"),
+		"counter_flush_every": 7,
+	}
+	s := openTestConsumer(t, config)
+	const workers, perWorker = 4, 25
+	for w := 0; w < workers; w++ {
+		process(t, s, newPairEvent(testContract(byte(100+w)), 10))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(pair string) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				msg := syncEvent(pair, fmt.Sprint(i+1), "5", int64(20+i)).message(t)
+				if err := s.Process(context.Background(), msg); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(testContract(byte(100 + w)))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Process: %v", err)
+	}
+	s.Close()
+
+	s = newTestConsumer(t, config)
+	if got := s.Stats().Lifetime["sync"].Processed; got != workers*perWorker {
+		t.Errorf("lifetime syncs = %d, want %d", got, workers*perWorker)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportTable is a table the CSV and Parquet exports can write, by the name
+// used in their config and the export endpoint.
+type exportTable struct {
+	Table string
+	// Time is the column the date range applies to.
+	Time  string
+	Order string
+}
+
+var exportTables = map[string]exportTable{
+	"pairs":   {Table: "soroswap_pairs", Time: "created_at", Order: "pair_address"},
+	"swaps":   {Table: "soroswap_swaps", Time: "swapped_at", Order: "swapped_at, id"},
+	"history": {Table: "pair_reserve_history", Time: "synced_at", Order: "synced_at, id"},
+}
+
+func exportTableNames() []string {
+	names := make([]string, 0, len(exportTables))
+	for name := range exportTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CSVExportOptions selects what ExportCSV writes.
+type CSVExportOptions struct {
+	// Tables are the tables to export (pairs, swaps, history); all when
+	// empty.
+	Tables []string
+	// Columns limits a table's columns, in the given order; all columns
+	// when a table has no entry.
+	Columns map[string][]string
+	// From and To bound the rows by the table's time column (pairs by
+	// created_at, swaps by swapped_at, history by synced_at). To is
+	// exclusive; zero values leave the range open.
+	From, To time.Time
+}
+
+// csvExport configures the export run at startup.
+type csvExport struct {
+	dir     string
+	onStart bool
+	opts    CSVExportOptions
+}
+
+func parseCSVExport(cfg *Config) (csvExport, error) {
+	ce := csvExport{dir: cfg.CSVExportDir, onStart: cfg.CSVExportOnStart}
+	if ce.onStart && ce.dir == "" {
+		return ce, fmt.Errorf("config csv_export_on_start requires csv_export_dir")
+	}
+	ce.opts.Tables = cfg.CSVExportTables
+	if len(cfg.CSVExportColumns) > 0 {
+		ce.opts.Columns = make(map[string][]string, len(cfg.CSVExportColumns))
+		for table, list := range cfg.CSVExportColumns {
+			ce.opts.Columns[table] = splitColumns(list)
+		}
+	}
+	ce.opts.From, ce.opts.To = cfg.CSVExportFrom, cfg.CSVExportTo
+	if err := ce.opts.validate(); err != nil {
+		return ce, fmt.Errorf("config csv_export: %v", err)
+	}
+	return ce, nil
+}
+
+// splitColumns parses a comma separated column list.
+func splitColumns(list string) []string {
+	var columns []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// validate checks the table names and range. Column names are checked
+// against the database when exporting.
+func (o CSVExportOptions) validate() error {
+	for _, name := range o.Tables {
+		if _, ok := exportTables[name]; !ok {
+			return fmt.Errorf("unknown table %q, expected one of %s", name, strings.Join(exportTableNames(), ", "))
+		}
+	}
+	for name := range o.Columns {
+		if _, ok := exportTables[name]; !ok {
+			return fmt.Errorf("columns of unknown table %q", name)
+		}
+	}
+	if !o.From.IsZero() && !o.To.IsZero() && !o.To.After(o.From) {
+		return errors.New("the end of the range must be after its start")
+	}
+	return nil
+}
+
+// ExportCSV writes one <table>.csv file per selected table to dir, with a
+// header row. All tables are read in one transaction so they agree with
+// each other, and each file is renamed into place once complete. It
+// returns the paths written.
+func (s *SaveSoroswapPairsToSQLite) ExportCSV(ctx context.Context, dir string, opts CSVExportOptions) ([]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	if dir == "" {
+		return nil, errors.New("CSV export directory is empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	tables := opts.Tables
+	if len(tables) == 0 {
+		tables = exportTableNames()
+	}
+
+	tx, err := s.readDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Read only; nothing to commit
+
+	var paths []string
+	for _, name := range tables {
+		path := filepath.Join(dir, name+".csv")
+		n, err := s.exportCSVFile(ctx, tx, path, name, opts)
+		if err != nil {
+			return paths, err
+		}
+		logger.Info("Exported CSV", "table", name, "rows", n, "path", path)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) exportCSVFile(ctx context.Context, tx *sql.Tx, path, name string, opts CSVExportOptions) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", tmp, err)
+	}
+	n, err := s.writeCSV(ctx, tx, f, name, opts.Columns[name], opts.From, opts.To)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to export %s: %v", name, err)
+	}
+	return n, nil
+}
+
+// writeCSV writes a table's rows within [from, to) as CSV, returning the
+// number of rows. NULLs are written as empty fields and timestamps in
+// RFC 3339.
+func (s *SaveSoroswapPairsToSQLite) writeCSV(ctx context.Context, tx *sql.Tx, w io.Writer, name string, columns []string, from, to time.Time) (int, error) {
+	t := exportTables[name]
+	columns, err := s.csvColumns(ctx, tx, t, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), t.Table)
+	var where []string
+	var args []interface{}
+	if !from.IsZero() {
+		where = append(where, t.Time+" >= ?")
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		where = append(where, t.Time+" < ?")
+		args = append(args, to.UTC())
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + t.Order
+
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return 0, err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			record[i] = v.String
+		}
+		if err := cw.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// csvColumns returns the requested columns after checking them against the
+// table, or all of the table's columns when none are requested.
+func (s *SaveSoroswapPairsToSQLite) csvColumns(ctx context.Context, tx *sql.Tx, t exportTable, requested []string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind("SELECT * FROM "+t.Table+" WHERE 1 = 0"))
+	if err != nil {
+		return nil, err
+	}
+	all, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(requested) == 0 {
+		return all, nil
+	}
+	known := make(map[string]bool, len(all))
+	for _, c := range all {
+		known[c] = true
+	}
+	for _, c := range requested {
+		if !known[c] {
+			return nil, fmt.Errorf("%w: %s has no column %q", errBadRequest, t.Table, c)
+		}
+	}
+	return requested, nil
+}
+
+// exportHandler serves GET /export/{table}.csv with the optional query
+// parameters columns (comma separated), from and to (RFC 3339), as a
+// download.
+func (s *SaveSoroswapPairsToSQLite) exportHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /export/{file}", func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutSuffix(r.PathValue("file"), ".csv")
+		if _, known := exportTables[name]; !ok || !known {
+			writeAPIError(w, fmt.Errorf("%w: unknown export %q, expected one of %s.csv", errBadRequest,
+				r.PathValue("file"), strings.Join(exportTableNames(), ".csv, ")))
+			return
+		}
+		q := apiQuery{values: r.URL.Query()}
+		from, to := q.time("from"), q.time("to")
+		opts := CSVExportOptions{From: from, To: to}
+		if q.err == nil {
+			if err := opts.validate(); err != nil {
+				q.err = fmt.Errorf("%w: %v", errBadRequest, err)
+			}
+		}
+		if q.err != nil {
+			writeAPIError(w, q.err)
+			return
+		}
+
+		tx, err := s.readDB.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		defer tx.Rollback() // Read only; nothing to commit
+		columns, err := s.csvColumns(r.Context(), tx, exportTables[name], splitColumns(q.values.Get("columns")))
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		// The status is sent with the first row, so later errors can only
+		// cut the download short.
+		if _, err := s.writeCSV(r.Context(), tx, w, name, columns, from, to); err != nil {
+			logger.Error("CSV export failed", "table", name, "error", err)
+		}
+	})
+	return mux
+}
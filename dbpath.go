@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// sqliteFilePath returns the filesystem path behind a SQLite db_path, which
+// may be a plain path or a file: URI, and whether it names an in-memory
+// database instead.
+func sqliteFilePath(dbPath string) (string, bool) {
+	p := dbPath
+	if strings.HasPrefix(p, "file:") {
+		p = strings.TrimPrefix(p, "file:")
+		if i := strings.IndexByte(p, '?'); i >= 0 {
+			if strings.Contains(p[i:], "mode=memory") || strings.Contains(p[i:], "vfs=memdb") {
+				return "", true
+			}
+			p = p[:i]
+		}
+	}
+	return p, p == ":memory:"
+}
+
+// memoryDBs numbers the private in-memory databases of this process.
+var memoryDBs atomic.Int64
+
+// sharedMemoryDSN returns a DSN for an in-memory db_path that every
+// connection of a pool opens. ":memory:" and other private in-memory DSNs
+// give each connection its own empty database, so they become a database
+// of SQLite's memdb VFS named for this pool alone; its parameters are
+// kept. Shared-cache and memdb DSNs already share one database and are
+// returned as they are.
+func sharedMemoryDSN(dbPath string) string {
+	if strings.Contains(dbPath, "cache=shared") || strings.Contains(dbPath, "vfs=memdb") {
+		return dbPath
+	}
+	params := []string{}
+	if i := strings.IndexByte(dbPath, '?'); i >= 0 {
+		for _, p := range strings.Split(dbPath[i+1:], "&") {
+			if p != "" && p != "mode=memory" {
+				params = append(params, p)
+			}
+		}
+	}
+	params = append(params, "vfs=memdb")
+	return fmt.Sprintf("file:/soroswap-memory-%d?%s", memoryDBs.Add(1), strings.Join(params, "&"))
+}
+
+// prepareDBPath makes sure a SQLite database can be created or opened at
+// path before the driver is involved, turning the driver's low-level
+// failures into errors that name the path and the problem. It reports
+// whether the database file already existed.
+func prepareDBPath(path string, createDirs bool, dirMode os.FileMode) (bool, error) {
+	if strings.TrimSpace(path) == "" {
+		return false, fmt.Errorf("db_path must not be empty")
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return false, fmt.Errorf("db_path %s is a directory, not a database file", path)
+	case err == nil:
+		// Opening for writing does not truncate; it only checks permissions.
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return true, fmt.Errorf("database file %s is not writable (mode %s): %v", path, info.Mode().Perm(), err)
+		}
+		f.Close()
+	case !errors.Is(err, fs.ErrNotExist):
+		return false, fmt.Errorf("cannot access database file %s: %v", path, err)
+	}
+	exists := err == nil
+
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		if !createDirs {
+			return exists, fmt.Errorf("database directory %s does not exist and create_dirs is false", dir)
+		}
+		if err := os.MkdirAll(dir, dirMode); err != nil {
+			return exists, fmt.Errorf("failed to create database directory %s: %v", dir, err)
+		}
+	} else if err != nil {
+		return exists, fmt.Errorf("cannot access database directory %s: %v", dir, err)
+	}
+
+	// SQLite also needs to create the -wal and -shm files next to the
+	// database, so the directory itself must be writable.
+	return exists, probeDirWritable(dir)
+}
+
+// probeDirWritable checks files can be created in dir with a probe file.
+func probeDirWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".soroswap-write-probe-*")
+	if err != nil {
+		return fmt.Errorf("database directory %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
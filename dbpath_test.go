@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readOnlyMount returns a directory on a filesystem mounted read-only, or
+// "" when there is none.
+func readOnlyMount() string {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "proc" || fields[0] == "sysfs" {
+			continue
+		}
+		for _, opt := range strings.Split(fields[3], ",") {
+			if info, err := os.Stat(fields[1]); opt == "ro" && err == nil && info.IsDir() {
+				return fields[1]
+			}
+		}
+	}
+	return ""
+}
+
+func TestPrepareDBPath(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.sqlite")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	readOnlyFile := filepath.Join(dir, "readonly.sqlite")
+	if err := os.WriteFile(readOnlyFile, nil, 0o444); err != nil {
+		t.Fatal(err)
+	}
+	readOnlyDir := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readOnlyDir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		createDirs bool
+		// asUser marks checks root passes regardless of permissions.
+		asUser     bool
+		wantExists bool
+		wantErr    string
+	}{
+		{name: "empty", path: " ", wantErr: "must not be empty"},
+		{name: "directory", path: dir, wantErr: "is a directory"},
+		{name: "existing file", path: existing, wantExists: true},
+		{name: "new file", path: filepath.Join(dir, "new.sqlite")},
+		{name: "missing directory created", path: filepath.Join(dir, "a", "b", "new.sqlite"), createDirs: true},
+		{name: "missing directory", path: filepath.Join(dir, "c", "new.sqlite"), wantErr: "create_dirs is false"},
+		{name: "read-only file", path: readOnlyFile, asUser: true, wantErr: "is not writable (mode -r--r--r--)"},
+		{name: "read-only directory", path: filepath.Join(readOnlyDir, "new.sqlite"), asUser: true, wantErr: "directory " + readOnlyDir + " is not writable"},
+		{name: "read-only filesystem", path: filepath.Join(readOnlyMount(), "new.sqlite"), wantErr: "is not writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.asUser && os.Geteuid() == 0 {
+				t.Skip("root bypasses file permissions")
+			}
+			if tt.name == "read-only filesystem" && readOnlyMount() == "" {
+				t.Skip("no read-only mount")
+			}
+			exists, err := prepareDBPath(tt.path, tt.createDirs, 0o750)
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("prepareDBPath error = %v, want one containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("prepareDBPath: %v", err)
+			case exists != tt.wantExists:
+				t.Errorf("exists = %v, want %v", exists, tt.wantExists)
+			}
+			if tt.createDirs && err == nil {
+				info, err := os.Stat(filepath.Dir(tt.path))
+				if err != nil || info.Mode().Perm() != 0o750 {
+					t.Errorf("created directory = %v, %v; want mode 0750", info, err)
+				}
+			}
+			// Probe files are removed.
+			if entries, _ := filepath.Glob(filepath.Join(filepath.Dir(tt.path), ".soroswap-write-probe-*")); len(entries) > 0 {
+				t.Errorf("probe files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestDBPathFileMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "pairs.sqlite")
+	config := map[string]interface{}{"db_path": path, "file_mode": "0600"}
+	s := openTestConsumer(t, config)
+	s.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat database: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("database mode = %s, want -rw-------", info.Mode().Perm())
+	}
+
+	// An existing database keeps the mode it has.
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestConsumer(t, config)
+	s.Close()
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Errorf("reopened database mode = %s, want -rw-r-----", info.Mode().Perm())
+	}
+}
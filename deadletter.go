@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+const (
+	insertDeadLetterQuery = `
+        INSERT INTO dead_letter_events (event_type, ledger_sequence, payload, error, failed_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+
+	resolveDeadLetterQuery = `
+        UPDATE dead_letter_events SET attempts = attempts + 1, resolved_at = ?
+        WHERE id = ?
+    `
+
+	retryFailedDeadLetterQuery = `
+        UPDATE dead_letter_events SET attempts = attempts + 1, error = ?, failed_at = ?
+        WHERE id = ?
+    `
+)
+
+func init() {
+	registerHandlerQuery(insertDeadLetterQuery)
+	// Only resolved rows age out; unresolved ones wait for a retry.
+	registerRetentionTable(retentionTable{Name: "dead_letters", Table: "dead_letter_events", Column: "resolved_at"})
+	registerLedgerTable(ledgerTable{Table: "dead_letter_events"})
+}
+
+// deadLetter persists an event that failed processing, with the error that
+// rejected it, so it can be inspected and retried instead of being lost.
+// Like the raw archive, the row joins an open batch outside the event's
+// savepoint.
+func (s *SaveSoroswapPairsToSQLite) deadLetter(ctx context.Context, eventType string, msg pluginapi.Message, cause error) {
+	payload := rawPayload(msg)
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	ledger, _ := metadataInt64(msg.Metadata, "ledger_sequence")
+
+	tx, err := s.deadLetterTx()
+	if err == nil {
+		// The event's own context may be what failed it.
+		_, err = s.stmts.exec(context.WithoutCancel(ctx), tx, insertDeadLetterQuery,
+			eventType, nullableLedger(ledger), payload, cause.Error(), time.Now().UTC())
+	}
+	if err != nil {
+		logger.Error("Failed to dead-letter event", "event_type", eventType, "error", err)
+		return
+	}
+	s.counters.add(eventType, EventCounters{DeadLettered: 1})
+}
+
+// deadLetterTx returns the open batch when batching, or nil to write
+// directly.
+func (s *SaveSoroswapPairsToSQLite) deadLetterTx() (*sql.Tx, error) {
+	if !s.batching() {
+		return nil, nil
+	}
+	return s.batchTx()
+}
+
+// deadLetterRetryKey marks a context as retrying a dead-lettered event,
+// whose payload was archived when it was first received.
+type deadLetterRetryKey struct{}
+
+// DeadLetterOptions selects which dead-lettered events
+// ReprocessDeadLetters retries.
+type DeadLetterOptions struct {
+	// IDs restricts the retry to the given rows; empty means all.
+	IDs []int64
+	// EventTypes restricts the retry to the given event types; empty means
+	// all.
+	EventTypes []string
+	// Limit caps the number of events retried; zero means no limit.
+	Limit int
+}
+
+// DeadLetterResult reports the outcome of a ReprocessDeadLetters run.
+type DeadLetterResult struct {
+	Retried  int64 `json:"retried"`
+	Resolved int64 `json:"resolved"`
+	Failed   int64 `json:"failed"`
+}
+
+type deadLetterRow struct {
+	id        int64
+	eventType string
+	ledger    sql.NullInt64
+	payload   []byte
+}
+
+// ReprocessDeadLetters retries unresolved dead-lettered events in the order
+// they failed, through the normal handlers. Events that now succeed are
+// marked resolved; the others keep their row with the new error and an
+// incremented attempt count. Live processing is blocked for the duration
+// of the run.
+func (s *SaveSoroswapPairsToSQLite) ReprocessDeadLetters(ctx context.Context, opts DeadLetterOptions) (DeadLetterResult, error) {
+	var result DeadLetterResult
+	if s.dryRun {
+		return result, fmt.Errorf("dead letters cannot be reprocessed in a dry run")
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	where := []string{"resolved_at IS NULL"}
+	var args []interface{}
+	if len(opts.IDs) > 0 {
+		where = append(where, "id IN (?"+strings.Repeat(", ?", len(opts.IDs)-1)+")")
+		for _, id := range opts.IDs {
+			args = append(args, id)
+		}
+	}
+	if len(opts.EventTypes) > 0 {
+		where = append(where, "event_type IN (?"+strings.Repeat(", ?", len(opts.EventTypes)-1)+")")
+		for _, t := range opts.EventTypes {
+			args = append(args, t)
+		}
+	}
+	query := "SELECT id, event_type, ledger_sequence, payload FROM dead_letter_events WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY id"
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.Limit)
+	}
+
+	// Read every row up front; retries write to the same database.
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind(query), args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to query dead letters: %v", err)
+	}
+	var pending []deadLetterRow
+	for rows.Next() {
+		var r deadLetterRow
+		if err := rows.Scan(&r.id, &r.eventType, &r.ledger, &r.payload); err != nil {
+			rows.Close()
+			return result, fmt.Errorf("failed to scan dead letter: %v", err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("failed to read dead letters: %v", err)
+	}
+
+	retryCtx := context.WithValue(ctx, deadLetterRetryKey{}, true)
+	for _, r := range pending {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		msg := pluginapi.Message{Payload: r.payload, Metadata: map[string]interface{}{}}
+		if r.ledger.Valid {
+			msg.Metadata["ledger_sequence"] = r.ledger.Int64
+		}
+
+		result.Retried++
+		eventType, retryErr := s.processWithRetry(retryCtx, msg)
+		if eventType == "" {
+			eventType = r.eventType
+		}
+		tx, err := s.deadLetterTx()
+		if err != nil {
+			return result, err
+		}
+		now := time.Now().UTC()
+		if retryErr != nil {
+			result.Failed++
+			logger.Warn("Dead letter failed again", "id", r.id, "event_type", eventType, "error", retryErr)
+			_, err = s.stmts.exec(ctx, tx, retryFailedDeadLetterQuery, retryErr.Error(), now, r.id)
+		} else {
+			result.Resolved++
+			s.counters.add(eventType, EventCounters{Processed: 1})
+			_, err = s.stmts.exec(ctx, tx, resolveDeadLetterQuery, now, r.id)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to update dead letter %d: %v", r.id, err)
+		}
+	}
+
+	if s.batching() {
+		if err := s.flushBatch(ctx); err != nil {
+			return result, err
+		}
+	} else if err := s.flushCounters(ctx); err != nil {
+		logger.Error("Failed to flush counters", "error", err)
+	}
+	logger.Info("Reprocessed dead letters", "retried", result.Retried, "resolved", result.Resolved,
+		"failed", result.Failed)
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	processedEventExistsQuery = `
+        SELECT 1 FROM processed_events WHERE event_type = ? AND event_key = ?
+    `
+
+	insertProcessedEventQuery = `
+        INSERT INTO processed_events (event_type, event_key, ledger_sequence, processed_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (event_type, event_key) DO NOTHING
+    `
+)
+
+func init() {
+	registerHandlerQuery(processedEventExistsQuery, insertProcessedEventQuery)
+	registerRetentionTable(retentionTable{
+		Name:   "processed_events",
+		Table:  "processed_events",
+		Column: "processed_at",
+		Key:    "event_type, event_key",
+	})
+	// Events of a rolled back range are handled again when redelivered.
+	registerLedgerTable(ledgerTable{Table: "processed_events"})
+}
+
+// dedupKeyCtx carries the *processedEvent of the event being handled when
+// dedup_events is on.
+type dedupKeyCtx struct{}
+
+// processedEvent identifies a delivered event in processed_events.
+type processedEvent struct {
+	eventType string
+	key       string
+	ledger    int64
+}
+
+// eventKey identifies an event across deliveries: its transaction hash and
+// index within the transaction when it has both, otherwise a hash of the
+// payload. The operation index is part of the key when sent, as event
+// indexes restart in every operation.
+func eventKey(payload []byte) string {
+	var e struct {
+		TxHash         string `json:"tx_hash"`
+		OperationIndex *int64 `json:"operation_index"`
+		EventIndex     *int64 `json:"event_index"`
+	}
+	if json.Unmarshal(payload, &e) == nil && e.TxHash != "" && e.EventIndex != nil {
+		if e.OperationIndex != nil {
+			return "tx:" + e.TxHash + ":" + strconv.FormatInt(*e.OperationIndex, 10) + ":" + strconv.FormatInt(*e.EventIndex, 10)
+		}
+		return "tx:" + e.TxHash + ":" + strconv.FormatInt(*e.EventIndex, 10)
+	}
+	sum := sha256.Sum256(payload)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checkProcessed reports whether the event was already processed, and
+// otherwise returns a context under which commitEvent records it. Replays
+// rebuild tables from events that were processed, so they are not checked.
+func (s *SaveSoroswapPairsToSQLite) checkProcessed(ctx context.Context, eventType string, ledger int64, payload []byte) (context.Context, bool, error) {
+	if !s.dedupEvents || replayTables(ctx) != nil {
+		return ctx, false, nil
+	}
+	ev := &processedEvent{eventType: eventType, key: eventKey(payload), ledger: ledger}
+	// Events of the open batch are only visible through it.
+	var tx *sql.Tx
+	if s.batching() {
+		tx = s.batch.tx
+	}
+	var one int
+	err := s.stmts.queryRow(ctx, tx, processedEventExistsQuery, ev.eventType, ev.key).Scan(&one)
+	switch {
+	case err == nil:
+		return ctx, true, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return context.WithValue(ctx, dedupKeyCtx{}, ev), false, nil
+	default:
+		return ctx, false, fmt.Errorf("failed to check for a redelivered event: %v", err)
+	}
+}
+
+// markProcessed records the event being handled in tx, so it commits
+// together with the event's writes.
+func (s *SaveSoroswapPairsToSQLite) markProcessed(ctx context.Context, tx *sql.Tx) error {
+	ev, _ := ctx.Value(dedupKeyCtx{}).(*processedEvent)
+	if ev == nil {
+		return nil
+	}
+	if _, err := s.stmts.exec(ctx, tx, insertProcessedEventQuery,
+		ev.eventType, ev.key, nullableLedger(ev.ledger), time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to record processed event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDedupEvents(t *testing.T) {
+	sync := syncEvent(testPair, "100", "50", 20)
+	indexed := syncEvent(testPair, "100", "50", 20).with(event{"tx_hash": "bb01", "event_index": 1})
+
+	tests := []struct {
+		name           string
+		config         map[string]interface{}
+		events         []event
+		wantDuplicates int64
+		wantProcessed  int
+		wantHistory    int
+		wantRaw        int
+	}{
+		{
+			name:           "redelivered payload",
+			config:         map[string]interface{}{},
+			events:         []event{sync, sync},
+			wantDuplicates: 1,
+			wantProcessed:  2,
+			wantHistory:    1,
+			wantRaw:        2,
+		},
+		{
+			name:           "redelivered within a batch",
+			config:         map[string]interface{}{"batch_size": 10},
+			events:         []event{sync, sync},
+			wantDuplicates: 1,
+			wantProcessed:  2,
+			wantHistory:    1,
+			wantRaw:        2,
+		},
+		{
+			// The key is the transaction hash and event index, not the
+			// payload, which may be encoded differently on redelivery.
+			name:           "same event index",
+			config:         map[string]interface{}{},
+			events:         []event{indexed, indexed.with(event{"timestamp": ledgerTime(20).Format("2006-01-02T15:04:05.000Z07:00")})},
+			wantDuplicates: 1,
+			wantProcessed:  2,
+			wantHistory:    1,
+			wantRaw:        2,
+		},
+		{
+			name:           "other event index",
+			config:         map[string]interface{}{},
+			events:         []event{indexed, indexed.with(event{"event_index": 2, "new_reserve_0": "120", "ledger_sequence": 21})},
+			wantDuplicates: 0,
+			wantProcessed:  3,
+			wantHistory:    2,
+			wantRaw:        3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{"dedup_events": true, "reserve_history": true, "archive_raw_events": true}
+			for k, v := range tt.config {
+				config[k] = v
+			}
+			s := newTestConsumer(t, config)
+			process(t, s, newPairEvent(testPair, 10))
+			process(t, s, tt.events...)
+			if s.batching() {
+				flush(t, s)
+			}
+
+			if got := s.Stats().Outcomes[outcomeDuplicate]; got != tt.wantDuplicates {
+				t.Errorf("duplicate outcomes = %d, want %d", got, tt.wantDuplicates)
+			}
+			for _, c := range []struct {
+				table string
+				want  int
+			}{
+				{"processed_events", tt.wantProcessed},
+				{"pair_reserve_history", tt.wantHistory},
+				{"raw_events", tt.wantRaw},
+			} {
+				if n := countRows(t, s, c.table); n != c.want {
+					t.Errorf("%s = %d rows, want %d", c.table, n, c.want)
+				}
+			}
+		})
+	}
+}
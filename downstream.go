@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// downstreamSink passes persisted events on to the consumers registered
+// through RegisterConsumer, so the plugin can sit in the middle of a
+// pipeline. Each consumer gets every event in processing order.
+type downstreamSink struct {
+	consumers []pluginapi.Consumer
+	enrich    bool
+}
+
+func (d *downstreamSink) Name() string { return "downstream" }
+
+func (d *downstreamSink) Enrich() bool { return d.enrich }
+
+// Publish hands each event to every consumer as a message with the event's
+// JSON, enriched with the pair's state unless downstream_enrich is off,
+// and the original metadata plus what the handler did. A consumer failing
+// does not keep the others from getting the event.
+func (d *downstreamSink) Publish(ctx context.Context, events []forwardedEvent) error {
+	var errs []error
+	for _, ev := range events {
+		payload := []byte(ev.Event)
+		if d.enrich {
+			var err error
+			if payload, err = ev.enriched(); err != nil {
+				return fmt.Errorf("failed to encode %s event: %v", ev.Type, err)
+			}
+		}
+		metadata := make(map[string]interface{}, len(ev.Metadata)+4)
+		for k, v := range ev.Metadata {
+			metadata[k] = v
+		}
+		metadata["event_type"] = ev.Type
+		metadata["outcome"] = ev.Outcome
+		metadata["pair_address"] = ev.PairAddress
+		if ev.Ledger > 0 {
+			metadata["ledger_sequence"] = ev.Ledger
+		}
+		msg := pluginapi.Message{Payload: payload, Metadata: metadata, Timestamp: ev.Timestamp}
+
+		for _, c := range d.consumers {
+			if err := c.Process(ctx, msg); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", c.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close leaves the consumers open; the pipeline that registered them owns
+// them.
+func (d *downstreamSink) Close() error { return nil }
+
+// RegisterConsumer adds a consumer that receives every event once it is
+// persisted, implementing pluginapi.ConsumerRegistry. Consumers may be
+// registered before or after Initialize.
+func (s *SaveSoroswapPairsToSQLite) RegisterConsumer(consumer pluginapi.Consumer) {
+	unlock, _ := s.lockExclusive(context.Background())
+	defer unlock()
+	s.downstream.consumers = append(s.downstream.consumers, consumer)
+	s.addSink(&s.downstream)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const recordDryRunQuery = `
+        INSERT INTO dry_run_report (
+            run_id, event_type, outcome, count, last_pair_address, last_detail, updated_at
+        ) VALUES (?, ?, ?, 1, ?, ?, ?)
+        ON CONFLICT (run_id, event_type, outcome) DO UPDATE SET
+            count = dry_run_report.count + 1,
+            last_pair_address = excluded.last_pair_address,
+            last_detail = excluded.last_detail,
+            updated_at = excluded.updated_at
+    `
+
+// commit commits tx, or rolls it back in dry-run mode so handlers exercise
+// every statement without changing stored data. A successful commit clears
+// the degraded state reported by Status and wakes the webhook worker.
+func (s *SaveSoroswapPairsToSQLite) commit(tx *sql.Tx) error {
+	if s.dryRun {
+		return tx.Rollback()
+	}
+	start := time.Now()
+	err := tx.Commit()
+	s.metrics.observeDB("commit", time.Since(start))
+	s.flow.observeCommit(time.Since(start))
+	if err != nil {
+		return err
+	}
+	if s.health != nil {
+		s.health.recordSuccess()
+	}
+	s.webhook.notify()
+	return nil
+}
+
+// dryRunSawPair reports whether the pair was (would-be) inserted earlier in
+// this dry run. Nothing is committed in dry-run mode, so without this every
+// sync following a new_pair in the same run would look like an unknown pair.
+func (s *SaveSoroswapPairsToSQLite) dryRunSawPair(pair string) bool {
+	s.dryRunMu.Lock()
+	defer s.dryRunMu.Unlock()
+	return s.dryRunPairs[pair]
+}
+
+// recordOutcome counts what a handler did for an event and notes it for
+// forwarding, and on the handler's span. In dry-run mode the finding is also added to the
+// dry_run_report table.
+func (s *SaveSoroswapPairsToSQLite) recordOutcome(ctx context.Context, eventType, outcome, pair string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("soroswap.outcome", outcome))
+	if res, _ := ctx.Value(eventResultKey{}).(*eventResult); res != nil {
+		res.outcome, res.pair = outcome, pair
+	}
+	// Events applied again after their batch failed were counted before.
+	if ctx.Value(batchRetryKey{}) != nil {
+		return
+	}
+	s.stats.recordOutcome(outcome)
+	if outcome == outcomeSkippedStale {
+		s.counters.add(eventType, EventCounters{SkippedStale: 1})
+	}
+	if s.dryRun {
+		if (eventType == "new_pair" && outcome == outcomeInserted) || outcome == outcomePlaceholder {
+			s.dryRunMu.Lock()
+			s.dryRunPairs[pair] = true
+			s.dryRunMu.Unlock()
+		}
+		logger.Info("Dry run finding", "event_type", eventType, "pair", pair, "outcome", outcome)
+		s.recordDryRun(ctx, eventType, outcome, pair, "")
+	}
+}
+
+// recordDryRun upserts a dry-run finding into dry_run_report, keyed by run,
+// event type and outcome, unless dry_run_report is off. Failures are logged
+// rather than returned since the report is best effort.
+func (s *SaveSoroswapPairsToSQLite) recordDryRun(ctx context.Context, eventType, outcome, pair, detail string) {
+	if !s.dryRunReport {
+		return
+	}
+	if _, err := s.db.ExecContext(ctx, s.backend.Rebind(recordDryRunQuery),
+		s.runID, eventType, outcome, pair, detail, time.Now().UTC(),
+	); err != nil {
+		logger.Error("Failed to record dry run finding", "error", err)
+	}
+}
@@ -0,0 +1,6 @@
+// Package eventspb holds the protobuf form of the Soroswap events, which
+// the consumer accepts in place of JSON from messages whose content-type
+// metadata is application/x-protobuf.
+package eventspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative events.proto
@@ -0,0 +1,1110 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: events.proto
+
+package eventspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Event is one Soroswap event. Fields mirror the JSON events; amounts are
+// decimal strings since they overflow int64, and an unset ledger_sequence
+// falls back to the message metadata as in JSON.
+type Event struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*Event_NewPair
+	//	*Event_Sync
+	//	*Event_Swap
+	//	*Event_Deposit
+	//	*Event_Withdraw
+	//	*Event_RouterSwap
+	Event         isEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_events_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Event) GetEvent() isEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *Event) GetNewPair() *NewPair {
+	if x != nil {
+		if x, ok := x.Event.(*Event_NewPair); ok {
+			return x.NewPair
+		}
+	}
+	return nil
+}
+
+func (x *Event) GetSync() *Sync {
+	if x != nil {
+		if x, ok := x.Event.(*Event_Sync); ok {
+			return x.Sync
+		}
+	}
+	return nil
+}
+
+func (x *Event) GetSwap() *Swap {
+	if x != nil {
+		if x, ok := x.Event.(*Event_Swap); ok {
+			return x.Swap
+		}
+	}
+	return nil
+}
+
+func (x *Event) GetDeposit() *Deposit {
+	if x != nil {
+		if x, ok := x.Event.(*Event_Deposit); ok {
+			return x.Deposit
+		}
+	}
+	return nil
+}
+
+func (x *Event) GetWithdraw() *Withdraw {
+	if x != nil {
+		if x, ok := x.Event.(*Event_Withdraw); ok {
+			return x.Withdraw
+		}
+	}
+	return nil
+}
+
+func (x *Event) GetRouterSwap() *RouterSwap {
+	if x != nil {
+		if x, ok := x.Event.(*Event_RouterSwap); ok {
+			return x.RouterSwap
+		}
+	}
+	return nil
+}
+
+type isEvent_Event interface {
+	isEvent_Event()
+}
+
+type Event_NewPair struct {
+	NewPair *NewPair `protobuf:"bytes,1,opt,name=new_pair,json=newPair,proto3,oneof"`
+}
+
+type Event_Sync struct {
+	Sync *Sync `protobuf:"bytes,2,opt,name=sync,proto3,oneof"`
+}
+
+type Event_Swap struct {
+	Swap *Swap `protobuf:"bytes,3,opt,name=swap,proto3,oneof"`
+}
+
+type Event_Deposit struct {
+	Deposit *Deposit `protobuf:"bytes,4,opt,name=deposit,proto3,oneof"`
+}
+
+type Event_Withdraw struct {
+	Withdraw *Withdraw `protobuf:"bytes,5,opt,name=withdraw,proto3,oneof"`
+}
+
+type Event_RouterSwap struct {
+	RouterSwap *RouterSwap `protobuf:"bytes,6,opt,name=router_swap,json=routerSwap,proto3,oneof"`
+}
+
+func (*Event_NewPair) isEvent_Event() {}
+
+func (*Event_Sync) isEvent_Event() {}
+
+func (*Event_Swap) isEvent_Event() {}
+
+func (*Event_Deposit) isEvent_Event() {}
+
+func (*Event_Withdraw) isEvent_Event() {}
+
+func (*Event_RouterSwap) isEvent_Event() {}
+
+// EventBatch is several events sent as one message, like a JSON array.
+type EventBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*Event               `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EventBatch) Reset() {
+	*x = EventBatch{}
+	mi := &file_events_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EventBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventBatch) ProtoMessage() {}
+
+func (x *EventBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventBatch.ProtoReflect.Descriptor instead.
+func (*EventBatch) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *EventBatch) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+// NewPair is a pair created by the factory. reserve_0/reserve_1 are the
+// optional initial reserves; both or neither must be set.
+type NewPair struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	PairAddress    string                 `protobuf:"bytes,1,opt,name=pair_address,json=pairAddress,proto3" json:"pair_address,omitempty"`
+	Token_0        string                 `protobuf:"bytes,2,opt,name=token_0,json=token0,proto3" json:"token_0,omitempty"`
+	Token_1        string                 `protobuf:"bytes,3,opt,name=token_1,json=token1,proto3" json:"token_1,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	LedgerSequence int64                  `protobuf:"varint,5,opt,name=ledger_sequence,json=ledgerSequence,proto3" json:"ledger_sequence,omitempty"`
+	Reserve_0      string                 `protobuf:"bytes,6,opt,name=reserve_0,json=reserve0,proto3" json:"reserve_0,omitempty"`
+	Reserve_1      string                 `protobuf:"bytes,7,opt,name=reserve_1,json=reserve1,proto3" json:"reserve_1,omitempty"`
+	Factory        string                 `protobuf:"bytes,8,opt,name=factory,proto3" json:"factory,omitempty"`
+	NewPairsLength int64                  `protobuf:"varint,9,opt,name=new_pairs_length,json=newPairsLength,proto3" json:"new_pairs_length,omitempty"`
+	TxHash         string                 `protobuf:"bytes,10,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OperationIndex int64                  `protobuf:"varint,11,opt,name=operation_index,json=operationIndex,proto3" json:"operation_index,omitempty"`
+	EventIndex     int64                  `protobuf:"varint,12,opt,name=event_index,json=eventIndex,proto3" json:"event_index,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *NewPair) Reset() {
+	*x = NewPair{}
+	mi := &file_events_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NewPair) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewPair) ProtoMessage() {}
+
+func (x *NewPair) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewPair.ProtoReflect.Descriptor instead.
+func (*NewPair) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *NewPair) GetPairAddress() string {
+	if x != nil {
+		return x.PairAddress
+	}
+	return ""
+}
+
+func (x *NewPair) GetToken_0() string {
+	if x != nil {
+		return x.Token_0
+	}
+	return ""
+}
+
+func (x *NewPair) GetToken_1() string {
+	if x != nil {
+		return x.Token_1
+	}
+	return ""
+}
+
+func (x *NewPair) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *NewPair) GetLedgerSequence() int64 {
+	if x != nil {
+		return x.LedgerSequence
+	}
+	return 0
+}
+
+func (x *NewPair) GetReserve_0() string {
+	if x != nil {
+		return x.Reserve_0
+	}
+	return ""
+}
+
+func (x *NewPair) GetReserve_1() string {
+	if x != nil {
+		return x.Reserve_1
+	}
+	return ""
+}
+
+func (x *NewPair) GetFactory() string {
+	if x != nil {
+		return x.Factory
+	}
+	return ""
+}
+
+func (x *NewPair) GetNewPairsLength() int64 {
+	if x != nil {
+		return x.NewPairsLength
+	}
+	return 0
+}
+
+func (x *NewPair) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *NewPair) GetOperationIndex() int64 {
+	if x != nil {
+		return x.OperationIndex
+	}
+	return 0
+}
+
+func (x *NewPair) GetEventIndex() int64 {
+	if x != nil {
+		return x.EventIndex
+	}
+	return 0
+}
+
+// Sync is a pair's reserves after a transaction.
+type Sync struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ContractId     string                 `protobuf:"bytes,1,opt,name=contract_id,json=contractId,proto3" json:"contract_id,omitempty"`
+	NewReserve_0   string                 `protobuf:"bytes,2,opt,name=new_reserve_0,json=newReserve0,proto3" json:"new_reserve_0,omitempty"`
+	NewReserve_1   string                 `protobuf:"bytes,3,opt,name=new_reserve_1,json=newReserve1,proto3" json:"new_reserve_1,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	LedgerSequence int64                  `protobuf:"varint,5,opt,name=ledger_sequence,json=ledgerSequence,proto3" json:"ledger_sequence,omitempty"`
+	TxHash         string                 `protobuf:"bytes,6,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OperationIndex int64                  `protobuf:"varint,7,opt,name=operation_index,json=operationIndex,proto3" json:"operation_index,omitempty"`
+	EventIndex     int64                  `protobuf:"varint,8,opt,name=event_index,json=eventIndex,proto3" json:"event_index,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Sync) Reset() {
+	*x = Sync{}
+	mi := &file_events_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sync) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sync) ProtoMessage() {}
+
+func (x *Sync) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sync.ProtoReflect.Descriptor instead.
+func (*Sync) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Sync) GetContractId() string {
+	if x != nil {
+		return x.ContractId
+	}
+	return ""
+}
+
+func (x *Sync) GetNewReserve_0() string {
+	if x != nil {
+		return x.NewReserve_0
+	}
+	return ""
+}
+
+func (x *Sync) GetNewReserve_1() string {
+	if x != nil {
+		return x.NewReserve_1
+	}
+	return ""
+}
+
+func (x *Sync) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Sync) GetLedgerSequence() int64 {
+	if x != nil {
+		return x.LedgerSequence
+	}
+	return 0
+}
+
+func (x *Sync) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *Sync) GetOperationIndex() int64 {
+	if x != nil {
+		return x.OperationIndex
+	}
+	return 0
+}
+
+func (x *Sync) GetEventIndex() int64 {
+	if x != nil {
+		return x.EventIndex
+	}
+	return 0
+}
+
+// Swap is a swap executed directly against a pair contract.
+type Swap struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ContractId     string                 `protobuf:"bytes,1,opt,name=contract_id,json=contractId,proto3" json:"contract_id,omitempty"`
+	Trader         string                 `protobuf:"bytes,2,opt,name=trader,proto3" json:"trader,omitempty"`
+	Amount_0In     string                 `protobuf:"bytes,3,opt,name=amount_0_in,json=amount0In,proto3" json:"amount_0_in,omitempty"`
+	Amount_1In     string                 `protobuf:"bytes,4,opt,name=amount_1_in,json=amount1In,proto3" json:"amount_1_in,omitempty"`
+	Amount_0Out    string                 `protobuf:"bytes,5,opt,name=amount_0_out,json=amount0Out,proto3" json:"amount_0_out,omitempty"`
+	Amount_1Out    string                 `protobuf:"bytes,6,opt,name=amount_1_out,json=amount1Out,proto3" json:"amount_1_out,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	LedgerSequence int64                  `protobuf:"varint,8,opt,name=ledger_sequence,json=ledgerSequence,proto3" json:"ledger_sequence,omitempty"`
+	TxHash         string                 `protobuf:"bytes,9,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OperationIndex int64                  `protobuf:"varint,10,opt,name=operation_index,json=operationIndex,proto3" json:"operation_index,omitempty"`
+	EventIndex     int64                  `protobuf:"varint,11,opt,name=event_index,json=eventIndex,proto3" json:"event_index,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Swap) Reset() {
+	*x = Swap{}
+	mi := &file_events_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Swap) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Swap) ProtoMessage() {}
+
+func (x *Swap) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Swap.ProtoReflect.Descriptor instead.
+func (*Swap) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Swap) GetContractId() string {
+	if x != nil {
+		return x.ContractId
+	}
+	return ""
+}
+
+func (x *Swap) GetTrader() string {
+	if x != nil {
+		return x.Trader
+	}
+	return ""
+}
+
+func (x *Swap) GetAmount_0In() string {
+	if x != nil {
+		return x.Amount_0In
+	}
+	return ""
+}
+
+func (x *Swap) GetAmount_1In() string {
+	if x != nil {
+		return x.Amount_1In
+	}
+	return ""
+}
+
+func (x *Swap) GetAmount_0Out() string {
+	if x != nil {
+		return x.Amount_0Out
+	}
+	return ""
+}
+
+func (x *Swap) GetAmount_1Out() string {
+	if x != nil {
+		return x.Amount_1Out
+	}
+	return ""
+}
+
+func (x *Swap) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Swap) GetLedgerSequence() int64 {
+	if x != nil {
+		return x.LedgerSequence
+	}
+	return 0
+}
+
+func (x *Swap) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *Swap) GetOperationIndex() int64 {
+	if x != nil {
+		return x.OperationIndex
+	}
+	return 0
+}
+
+func (x *Swap) GetEventIndex() int64 {
+	if x != nil {
+		return x.EventIndex
+	}
+	return 0
+}
+
+// Deposit is liquidity added to a pair.
+type Deposit struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ContractId     string                 `protobuf:"bytes,1,opt,name=contract_id,json=contractId,proto3" json:"contract_id,omitempty"`
+	Provider       string                 `protobuf:"bytes,2,opt,name=provider,proto3" json:"provider,omitempty"`
+	Amount_0       string                 `protobuf:"bytes,3,opt,name=amount_0,json=amount0,proto3" json:"amount_0,omitempty"`
+	Amount_1       string                 `protobuf:"bytes,4,opt,name=amount_1,json=amount1,proto3" json:"amount_1,omitempty"`
+	Liquidity      string                 `protobuf:"bytes,5,opt,name=liquidity,proto3" json:"liquidity,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	LedgerSequence int64                  `protobuf:"varint,7,opt,name=ledger_sequence,json=ledgerSequence,proto3" json:"ledger_sequence,omitempty"`
+	TxHash         string                 `protobuf:"bytes,8,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OperationIndex int64                  `protobuf:"varint,9,opt,name=operation_index,json=operationIndex,proto3" json:"operation_index,omitempty"`
+	EventIndex     int64                  `protobuf:"varint,10,opt,name=event_index,json=eventIndex,proto3" json:"event_index,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Deposit) Reset() {
+	*x = Deposit{}
+	mi := &file_events_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Deposit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Deposit) ProtoMessage() {}
+
+func (x *Deposit) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Deposit.ProtoReflect.Descriptor instead.
+func (*Deposit) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Deposit) GetContractId() string {
+	if x != nil {
+		return x.ContractId
+	}
+	return ""
+}
+
+func (x *Deposit) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+func (x *Deposit) GetAmount_0() string {
+	if x != nil {
+		return x.Amount_0
+	}
+	return ""
+}
+
+func (x *Deposit) GetAmount_1() string {
+	if x != nil {
+		return x.Amount_1
+	}
+	return ""
+}
+
+func (x *Deposit) GetLiquidity() string {
+	if x != nil {
+		return x.Liquidity
+	}
+	return ""
+}
+
+func (x *Deposit) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Deposit) GetLedgerSequence() int64 {
+	if x != nil {
+		return x.LedgerSequence
+	}
+	return 0
+}
+
+func (x *Deposit) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *Deposit) GetOperationIndex() int64 {
+	if x != nil {
+		return x.OperationIndex
+	}
+	return 0
+}
+
+func (x *Deposit) GetEventIndex() int64 {
+	if x != nil {
+		return x.EventIndex
+	}
+	return 0
+}
+
+// Withdraw is liquidity removed from a pair.
+type Withdraw struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ContractId     string                 `protobuf:"bytes,1,opt,name=contract_id,json=contractId,proto3" json:"contract_id,omitempty"`
+	Recipient      string                 `protobuf:"bytes,2,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Amount_0       string                 `protobuf:"bytes,3,opt,name=amount_0,json=amount0,proto3" json:"amount_0,omitempty"`
+	Amount_1       string                 `protobuf:"bytes,4,opt,name=amount_1,json=amount1,proto3" json:"amount_1,omitempty"`
+	Liquidity      string                 `protobuf:"bytes,5,opt,name=liquidity,proto3" json:"liquidity,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	LedgerSequence int64                  `protobuf:"varint,7,opt,name=ledger_sequence,json=ledgerSequence,proto3" json:"ledger_sequence,omitempty"`
+	TxHash         string                 `protobuf:"bytes,8,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OperationIndex int64                  `protobuf:"varint,9,opt,name=operation_index,json=operationIndex,proto3" json:"operation_index,omitempty"`
+	EventIndex     int64                  `protobuf:"varint,10,opt,name=event_index,json=eventIndex,proto3" json:"event_index,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Withdraw) Reset() {
+	*x = Withdraw{}
+	mi := &file_events_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Withdraw) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Withdraw) ProtoMessage() {}
+
+func (x *Withdraw) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Withdraw.ProtoReflect.Descriptor instead.
+func (*Withdraw) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Withdraw) GetContractId() string {
+	if x != nil {
+		return x.ContractId
+	}
+	return ""
+}
+
+func (x *Withdraw) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *Withdraw) GetAmount_0() string {
+	if x != nil {
+		return x.Amount_0
+	}
+	return ""
+}
+
+func (x *Withdraw) GetAmount_1() string {
+	if x != nil {
+		return x.Amount_1
+	}
+	return ""
+}
+
+func (x *Withdraw) GetLiquidity() string {
+	if x != nil {
+		return x.Liquidity
+	}
+	return ""
+}
+
+func (x *Withdraw) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Withdraw) GetLedgerSequence() int64 {
+	if x != nil {
+		return x.LedgerSequence
+	}
+	return 0
+}
+
+func (x *Withdraw) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *Withdraw) GetOperationIndex() int64 {
+	if x != nil {
+		return x.OperationIndex
+	}
+	return 0
+}
+
+func (x *Withdraw) GetEventIndex() int64 {
+	if x != nil {
+		return x.EventIndex
+	}
+	return 0
+}
+
+// RouterSwap is a swap routed through one or more pairs: hop i swaps
+// path[i] for path[i+1] through pairs[i].
+type RouterSwap struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Path           []string               `protobuf:"bytes,1,rep,name=path,proto3" json:"path,omitempty"`
+	Pairs          []string               `protobuf:"bytes,2,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	AmountIn       string                 `protobuf:"bytes,3,opt,name=amount_in,json=amountIn,proto3" json:"amount_in,omitempty"`
+	AmountOut      string                 `protobuf:"bytes,4,opt,name=amount_out,json=amountOut,proto3" json:"amount_out,omitempty"`
+	Amounts        []string               `protobuf:"bytes,5,rep,name=amounts,proto3" json:"amounts,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	LedgerSequence int64                  `protobuf:"varint,7,opt,name=ledger_sequence,json=ledgerSequence,proto3" json:"ledger_sequence,omitempty"`
+	TxHash         string                 `protobuf:"bytes,8,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	OperationIndex int64                  `protobuf:"varint,9,opt,name=operation_index,json=operationIndex,proto3" json:"operation_index,omitempty"`
+	EventIndex     int64                  `protobuf:"varint,10,opt,name=event_index,json=eventIndex,proto3" json:"event_index,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *RouterSwap) Reset() {
+	*x = RouterSwap{}
+	mi := &file_events_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RouterSwap) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouterSwap) ProtoMessage() {}
+
+func (x *RouterSwap) ProtoReflect() protoreflect.Message {
+	mi := &file_events_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouterSwap.ProtoReflect.Descriptor instead.
+func (*RouterSwap) Descriptor() ([]byte, []int) {
+	return file_events_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RouterSwap) GetPath() []string {
+	if x != nil {
+		return x.Path
+	}
+	return nil
+}
+
+func (x *RouterSwap) GetPairs() []string {
+	if x != nil {
+		return x.Pairs
+	}
+	return nil
+}
+
+func (x *RouterSwap) GetAmountIn() string {
+	if x != nil {
+		return x.AmountIn
+	}
+	return ""
+}
+
+func (x *RouterSwap) GetAmountOut() string {
+	if x != nil {
+		return x.AmountOut
+	}
+	return ""
+}
+
+func (x *RouterSwap) GetAmounts() []string {
+	if x != nil {
+		return x.Amounts
+	}
+	return nil
+}
+
+func (x *RouterSwap) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *RouterSwap) GetLedgerSequence() int64 {
+	if x != nil {
+		return x.LedgerSequence
+	}
+	return 0
+}
+
+func (x *RouterSwap) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *RouterSwap) GetOperationIndex() int64 {
+	if x != nil {
+		return x.OperationIndex
+	}
+	return 0
+}
+
+func (x *RouterSwap) GetEventIndex() int64 {
+	if x != nil {
+		return x.EventIndex
+	}
+	return 0
+}
+
+var File_events_proto protoreflect.FileDescriptor
+
+const file_events_proto_rawDesc = "" +
+	"\n" +
+	"\fevents.proto\x12\x12soroswap.events.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe2\x02\n" +
+	"\x05Event\x128\n" +
+	"\bnew_pair\x18\x01 \x01(\v2\x1b.soroswap.events.v1.NewPairH\x00R\anewPair\x12.\n" +
+	"\x04sync\x18\x02 \x01(\v2\x18.soroswap.events.v1.SyncH\x00R\x04sync\x12.\n" +
+	"\x04swap\x18\x03 \x01(\v2\x18.soroswap.events.v1.SwapH\x00R\x04swap\x127\n" +
+	"\adeposit\x18\x04 \x01(\v2\x1b.soroswap.events.v1.DepositH\x00R\adeposit\x12:\n" +
+	"\bwithdraw\x18\x05 \x01(\v2\x1c.soroswap.events.v1.WithdrawH\x00R\bwithdraw\x12A\n" +
+	"\vrouter_swap\x18\x06 \x01(\v2\x1e.soroswap.events.v1.RouterSwapH\x00R\n" +
+	"routerSwapB\a\n" +
+	"\x05event\"?\n" +
+	"\n" +
+	"EventBatch\x121\n" +
+	"\x06events\x18\x01 \x03(\v2\x19.soroswap.events.v1.EventR\x06events\"\xa2\x03\n" +
+	"\aNewPair\x12!\n" +
+	"\fpair_address\x18\x01 \x01(\tR\vpairAddress\x12\x17\n" +
+	"\atoken_0\x18\x02 \x01(\tR\x06token0\x12\x17\n" +
+	"\atoken_1\x18\x03 \x01(\tR\x06token1\x128\n" +
+	"\ttimestamp\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x0fledger_sequence\x18\x05 \x01(\x03R\x0eledgerSequence\x12\x1b\n" +
+	"\treserve_0\x18\x06 \x01(\tR\breserve0\x12\x1b\n" +
+	"\treserve_1\x18\a \x01(\tR\breserve1\x12\x18\n" +
+	"\afactory\x18\b \x01(\tR\afactory\x12(\n" +
+	"\x10new_pairs_length\x18\t \x01(\x03R\x0enewPairsLength\x12\x17\n" +
+	"\atx_hash\x18\n" +
+	" \x01(\tR\x06txHash\x12'\n" +
+	"\x0foperation_index\x18\v \x01(\x03R\x0eoperationIndex\x12\x1f\n" +
+	"\vevent_index\x18\f \x01(\x03R\n" +
+	"eventIndex\"\xb5\x02\n" +
+	"\x04Sync\x12\x1f\n" +
+	"\vcontract_id\x18\x01 \x01(\tR\n" +
+	"contractId\x12\"\n" +
+	"\rnew_reserve_0\x18\x02 \x01(\tR\vnewReserve0\x12\"\n" +
+	"\rnew_reserve_1\x18\x03 \x01(\tR\vnewReserve1\x128\n" +
+	"\ttimestamp\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x0fledger_sequence\x18\x05 \x01(\x03R\x0eledgerSequence\x12\x17\n" +
+	"\atx_hash\x18\x06 \x01(\tR\x06txHash\x12'\n" +
+	"\x0foperation_index\x18\a \x01(\x03R\x0eoperationIndex\x12\x1f\n" +
+	"\vevent_index\x18\b \x01(\x03R\n" +
+	"eventIndex\"\x89\x03\n" +
+	"\x04Swap\x12\x1f\n" +
+	"\vcontract_id\x18\x01 \x01(\tR\n" +
+	"contractId\x12\x16\n" +
+	"\x06trader\x18\x02 \x01(\tR\x06trader\x12\x1e\n" +
+	"\vamount_0_in\x18\x03 \x01(\tR\tamount0In\x12\x1e\n" +
+	"\vamount_1_in\x18\x04 \x01(\tR\tamount1In\x12 \n" +
+	"\famount_0_out\x18\x05 \x01(\tR\n" +
+	"amount0Out\x12 \n" +
+	"\famount_1_out\x18\x06 \x01(\tR\n" +
+	"amount1Out\x128\n" +
+	"\ttimestamp\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x0fledger_sequence\x18\b \x01(\x03R\x0eledgerSequence\x12\x17\n" +
+	"\atx_hash\x18\t \x01(\tR\x06txHash\x12'\n" +
+	"\x0foperation_index\x18\n" +
+	" \x01(\x03R\x0eoperationIndex\x12\x1f\n" +
+	"\vevent_index\x18\v \x01(\x03R\n" +
+	"eventIndex\"\xe0\x02\n" +
+	"\aDeposit\x12\x1f\n" +
+	"\vcontract_id\x18\x01 \x01(\tR\n" +
+	"contractId\x12\x1a\n" +
+	"\bprovider\x18\x02 \x01(\tR\bprovider\x12\x19\n" +
+	"\bamount_0\x18\x03 \x01(\tR\aamount0\x12\x19\n" +
+	"\bamount_1\x18\x04 \x01(\tR\aamount1\x12\x1c\n" +
+	"\tliquidity\x18\x05 \x01(\tR\tliquidity\x128\n" +
+	"\ttimestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x0fledger_sequence\x18\a \x01(\x03R\x0eledgerSequence\x12\x17\n" +
+	"\atx_hash\x18\b \x01(\tR\x06txHash\x12'\n" +
+	"\x0foperation_index\x18\t \x01(\x03R\x0eoperationIndex\x12\x1f\n" +
+	"\vevent_index\x18\n" +
+	" \x01(\x03R\n" +
+	"eventIndex\"\xe3\x02\n" +
+	"\bWithdraw\x12\x1f\n" +
+	"\vcontract_id\x18\x01 \x01(\tR\n" +
+	"contractId\x12\x1c\n" +
+	"\trecipient\x18\x02 \x01(\tR\trecipient\x12\x19\n" +
+	"\bamount_0\x18\x03 \x01(\tR\aamount0\x12\x19\n" +
+	"\bamount_1\x18\x04 \x01(\tR\aamount1\x12\x1c\n" +
+	"\tliquidity\x18\x05 \x01(\tR\tliquidity\x128\n" +
+	"\ttimestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x0fledger_sequence\x18\a \x01(\x03R\x0eledgerSequence\x12\x17\n" +
+	"\atx_hash\x18\b \x01(\tR\x06txHash\x12'\n" +
+	"\x0foperation_index\x18\t \x01(\x03R\x0eoperationIndex\x12\x1f\n" +
+	"\vevent_index\x18\n" +
+	" \x01(\x03R\n" +
+	"eventIndex\"\xd2\x02\n" +
+	"\n" +
+	"RouterSwap\x12\x12\n" +
+	"\x04path\x18\x01 \x03(\tR\x04path\x12\x14\n" +
+	"\x05pairs\x18\x02 \x03(\tR\x05pairs\x12\x1b\n" +
+	"\tamount_in\x18\x03 \x01(\tR\bamountIn\x12\x1d\n" +
+	"\n" +
+	"amount_out\x18\x04 \x01(\tR\tamountOut\x12\x18\n" +
+	"\aamounts\x18\x05 \x03(\tR\aamounts\x128\n" +
+	"\ttimestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x0fledger_sequence\x18\a \x01(\x03R\x0eledgerSequence\x12\x17\n" +
+	"\atx_hash\x18\b \x01(\tR\x06txHash\x12'\n" +
+	"\x0foperation_index\x18\t \x01(\x03R\x0eoperationIndex\x12\x1f\n" +
+	"\vevent_index\x18\n" +
+	" \x01(\x03R\n" +
+	"eventIndexBKZIgithub.com/withObsrvr/flow-consumer-save-soroswappairs-to-sqlite/eventspbb\x06proto3"
+
+var (
+	file_events_proto_rawDescOnce sync.Once
+	file_events_proto_rawDescData []byte
+)
+
+func file_events_proto_rawDescGZIP() []byte {
+	file_events_proto_rawDescOnce.Do(func() {
+		file_events_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_events_proto_rawDesc), len(file_events_proto_rawDesc)))
+	})
+	return file_events_proto_rawDescData
+}
+
+var file_events_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_events_proto_goTypes = []any{
+	(*Event)(nil),                 // 0: soroswap.events.v1.Event
+	(*EventBatch)(nil),            // 1: soroswap.events.v1.EventBatch
+	(*NewPair)(nil),               // 2: soroswap.events.v1.NewPair
+	(*Sync)(nil),                  // 3: soroswap.events.v1.Sync
+	(*Swap)(nil),                  // 4: soroswap.events.v1.Swap
+	(*Deposit)(nil),               // 5: soroswap.events.v1.Deposit
+	(*Withdraw)(nil),              // 6: soroswap.events.v1.Withdraw
+	(*RouterSwap)(nil),            // 7: soroswap.events.v1.RouterSwap
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_events_proto_depIdxs = []int32{
+	2,  // 0: soroswap.events.v1.Event.new_pair:type_name -> soroswap.events.v1.NewPair
+	3,  // 1: soroswap.events.v1.Event.sync:type_name -> soroswap.events.v1.Sync
+	4,  // 2: soroswap.events.v1.Event.swap:type_name -> soroswap.events.v1.Swap
+	5,  // 3: soroswap.events.v1.Event.deposit:type_name -> soroswap.events.v1.Deposit
+	6,  // 4: soroswap.events.v1.Event.withdraw:type_name -> soroswap.events.v1.Withdraw
+	7,  // 5: soroswap.events.v1.Event.router_swap:type_name -> soroswap.events.v1.RouterSwap
+	0,  // 6: soroswap.events.v1.EventBatch.events:type_name -> soroswap.events.v1.Event
+	8,  // 7: soroswap.events.v1.NewPair.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 8: soroswap.events.v1.Sync.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 9: soroswap.events.v1.Swap.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 10: soroswap.events.v1.Deposit.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 11: soroswap.events.v1.Withdraw.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 12: soroswap.events.v1.RouterSwap.timestamp:type_name -> google.protobuf.Timestamp
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_events_proto_init() }
+func file_events_proto_init() {
+	if File_events_proto != nil {
+		return
+	}
+	file_events_proto_msgTypes[0].OneofWrappers = []any{
+		(*Event_NewPair)(nil),
+		(*Event_Sync)(nil),
+		(*Event_Swap)(nil),
+		(*Event_Deposit)(nil),
+		(*Event_Withdraw)(nil),
+		(*Event_RouterSwap)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_events_proto_rawDesc), len(file_events_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_events_proto_goTypes,
+		DependencyIndexes: file_events_proto_depIdxs,
+		MessageInfos:      file_events_proto_msgTypes,
+	}.Build()
+	File_events_proto = out.File
+	file_events_proto_goTypes = nil
+	file_events_proto_depIdxs = nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FactoryEvent is a change to the Soroswap factory's settings:
+// factory_fee_to sets the address protocol fees are minted to,
+// factory_fee_to_setter the address allowed to change it, and
+// factory_fees_enabled turns the fee switch on or off.
+type FactoryEvent struct {
+	Type string `json:"type"`
+	// ContractID is the factory contract.
+	ContractID  string    `json:"contract_id"`
+	FeeTo       string    `json:"fee_to"`
+	FeeToSetter string    `json:"fee_to_setter"`
+	FeesEnabled *bool     `json:"fees_enabled"`
+	Timestamp   time.Time `json:"timestamp"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const (
+	// upsertFactoryStateQuery sets the columns that are not NULL, unless
+	// the stored state is from a later ledger. Events without a ledger
+	// always apply.
+	upsertFactoryStateQuery = `
+        INSERT INTO factory_state (
+            factory_address, fee_to, fee_to_setter, fees_enabled, pair_count, last_ledger, updated_at
+        ) VALUES (?, ?, ?, ?, 0, ?, ?)
+        ON CONFLICT (factory_address) DO UPDATE SET
+            fee_to = COALESCE(excluded.fee_to, factory_state.fee_to),
+            fee_to_setter = COALESCE(excluded.fee_to_setter, factory_state.fee_to_setter),
+            fees_enabled = COALESCE(excluded.fees_enabled, factory_state.fees_enabled),
+            last_ledger = COALESCE(excluded.last_ledger, factory_state.last_ledger),
+            updated_at = excluded.updated_at
+        WHERE excluded.last_ledger IS NULL OR factory_state.last_ledger IS NULL
+            OR excluded.last_ledger >= factory_state.last_ledger
+    `
+
+	// setFactoryPairsQuery records the pair count a factory reported with
+	// a new pair. Counts only grow, so an older report is ignored.
+	setFactoryPairsQuery = `
+        INSERT INTO factory_state (factory_address, pair_count, updated_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT (factory_address) DO UPDATE SET
+            pair_count = CASE WHEN excluded.pair_count > factory_state.pair_count
+                THEN excluded.pair_count ELSE factory_state.pair_count END,
+            updated_at = excluded.updated_at
+    `
+
+	// addFactoryPairQuery counts a new pair of a factory that did not
+	// report its pair count.
+	addFactoryPairQuery = `
+        INSERT INTO factory_state (factory_address, pair_count, updated_at)
+        VALUES (?, 1, ?)
+        ON CONFLICT (factory_address) DO UPDATE SET
+            pair_count = factory_state.pair_count + 1,
+            updated_at = excluded.updated_at
+    `
+)
+
+func init() {
+	registerHandlerQuery(upsertFactoryStateQuery, setFactoryPairsQuery, addFactoryPairQuery)
+}
+
+// normalize canonicalizes the event's address fields and checks it carries
+// the setting its type changes.
+func (e *FactoryEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.FeeTo, &e.FeeToSetter); err != nil {
+		return err
+	}
+	switch {
+	case e.ContractID == "":
+		return fmt.Errorf("contract_id is required")
+	case e.Type == "factory_fee_to" && e.FeeTo == "":
+		return fmt.Errorf("fee_to is required")
+	case e.Type == "factory_fee_to_setter" && e.FeeToSetter == "":
+		return fmt.Errorf("fee_to_setter is required")
+	case e.Type == "factory_fees_enabled" && e.FeesEnabled == nil:
+		return fmt.Errorf("fees_enabled is required")
+	}
+	return nil
+}
+
+// handleFactoryEvent applies a settings change to factory_state. Only the
+// setting named by the event type is taken from it. A change older than
+// the stored state is skipped as stale.
+func (s *SaveSoroswapPairsToSQLite) handleFactoryEvent(ctx context.Context, event FactoryEvent) error {
+	if replayTables(ctx) != nil {
+		return nil
+	}
+	var feeTo, setter, enabled interface{}
+	switch event.Type {
+	case "factory_fee_to":
+		feeTo = event.FeeTo
+	case "factory_fee_to_setter":
+		setter = event.FeeToSetter
+	case "factory_fees_enabled":
+		enabled = *event.FeesEnabled
+	}
+
+	tx, done, err := s.beginEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer done() // Will be ignored if transaction is committed
+
+	result, err := s.stmts.exec(ctx, tx, upsertFactoryStateQuery,
+		event.ContractID, feeTo, setter, enabled, nullableLedger(event.LedgerSequence), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to update factory %s: %v", event.ContractID, err)
+	}
+	applied, err := inserted(result)
+	if err != nil {
+		return err
+	}
+	if err := s.commitEvent(ctx, tx, applied); err != nil {
+		return fmt.Errorf("failed to commit %s event: %v", event.Type, err)
+	}
+	outcome := outcomeUpdated
+	if !applied {
+		outcome = outcomeSkippedStale
+	}
+	s.recordOutcome(ctx, event.Type, outcome, "")
+	return nil
+}
+
+// countFactoryPair counts a pair its factory just created.
+func (s *SaveSoroswapPairsToSQLite) countFactoryPair(ctx context.Context, tx *sql.Tx, event NewPairEvent) error {
+	var err error
+	if event.NewPairsLength > 0 {
+		_, err = s.stmts.exec(ctx, tx, setFactoryPairsQuery, event.Factory, event.NewPairsLength, time.Now().UTC())
+	} else {
+		_, err = s.stmts.exec(ctx, tx, addFactoryPairQuery, event.Factory, time.Now().UTC())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to count pair of factory %s: %v", event.Factory, err)
+	}
+	return nil
+}
+
+// FactoryState is a factory's settings and pair count as last reported by
+// its events.
+type FactoryState struct {
+	FactoryAddress string `json:"factory_address"`
+	FeeTo          string `json:"fee_to,omitempty"`
+	FeeToSetter    string `json:"fee_to_setter,omitempty"`
+	// FeesEnabled is nil until the fee switch was seen.
+	FeesEnabled *bool     `json:"fees_enabled,omitempty"`
+	PairCount   int64     `json:"pair_count"`
+	LastLedger  int64     `json:"last_ledger,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ErrFactoryNotFound is returned for factories without recorded events.
+var ErrFactoryNotFound = errors.New("factory not found")
+
+// GetFactoryState returns the recorded state of a factory.
+func (s *SaveSoroswapPairsToSQLite) GetFactoryState(ctx context.Context, factory string) (FactoryState, error) {
+	factory, err := normalizeAddress(factory)
+	if err != nil {
+		return FactoryState{}, err
+	}
+	f := FactoryState{FactoryAddress: factory}
+	var feeTo, setter sql.NullString
+	var enabled sql.NullBool
+	var ledger sql.NullInt64
+	err = s.readDB.QueryRowContext(ctx, s.backend.Rebind(`
+        SELECT fee_to, fee_to_setter, fees_enabled, pair_count, last_ledger, updated_at
+        FROM factory_state WHERE factory_address = ?`), factory).Scan(
+		&feeTo, &setter, &enabled, &f.PairCount, &ledger, &f.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return f, fmt.Errorf("%w: %s", ErrFactoryNotFound, factory)
+	}
+	if err != nil {
+		return f, fmt.Errorf("failed to read factory %s: %v", factory, err)
+	}
+	f.FeeTo, f.FeeToSetter, f.LastLedger = feeTo.String, setter.String, ledger.Int64
+	if enabled.Valid {
+		f.FeesEnabled = &enabled.Bool
+	}
+	return f, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// swapFeeBps is the fee Soroswap pairs take from every swap's input, in
+// basis points. It is fixed in the pair contract.
+const swapFeeBps = 30
+
+// ProtocolFeeEvent records LP shares minted to the factory's fee_to
+// address, which is how a pair pays the protocol fee when the fee switch
+// is on. The pair mints them before a deposit or withdrawal.
+type ProtocolFeeEvent struct {
+	Type       string `json:"type"`
+	ContractID string `json:"contract_id"`
+	FeeTo      string `json:"fee_to"`
+	// Liquidity is the number of LP shares minted to FeeTo.
+	Liquidity Amount `json:"liquidity"`
+	TxHash    string `json:"tx_hash"`
+	// OperationIndex is the operation within the transaction.
+	OperationIndex int64     `json:"operation_index"`
+	Timestamp      time.Time `json:"timestamp"`
+	// EventIndex tells apart several fee mints in one transaction.
+	EventIndex int64 `json:"event_index"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const (
+	insertProtocolFeeQuery = `
+        INSERT INTO soroswap_protocol_fees (
+            pair_address, fee_to, liquidity, ledger_sequence, tx_hash, operation_index, event_index, minted_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, pair_address, event_index) DO NOTHING
+    `
+
+	loadPairFeesQuery = `
+        SELECT fee_to, swap_fees_0, swap_fees_1, protocol_fee_shares, protocol_fee_mints
+        FROM pair_fees WHERE pair_address = ?
+    `
+
+	upsertPairFeesQuery = `
+        INSERT INTO pair_fees (
+            pair_address, fee_to, swap_fees_0, swap_fees_1, protocol_fee_shares,
+            protocol_fee_mints, last_ledger, updated_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address) DO UPDATE SET
+            fee_to = excluded.fee_to,
+            swap_fees_0 = excluded.swap_fees_0,
+            swap_fees_1 = excluded.swap_fees_1,
+            protocol_fee_shares = excluded.protocol_fee_shares,
+            protocol_fee_mints = excluded.protocol_fee_mints,
+            last_ledger = COALESCE(excluded.last_ledger, pair_fees.last_ledger),
+            updated_at = excluded.updated_at
+    `
+)
+
+func init() {
+	registerHandlerQuery(insertProtocolFeeQuery, loadPairFeesQuery, upsertPairFeesQuery)
+	registerRetentionTable(retentionTable{Name: "protocol_fees", Table: "soroswap_protocol_fees", Column: "minted_at"})
+	registerLedgerTable(ledgerTable{Table: "soroswap_protocol_fees"})
+	registerPairTable(pairTable{
+		Name:   "soroswap_protocol_fees",
+		Count:  "SELECT COUNT(*) FROM soroswap_protocol_fees WHERE pair_address = ?",
+		Delete: deleteByID("soroswap_protocol_fees"),
+	})
+	registerPairTable(pairTable{
+		Name:   "pair_fees",
+		Count:  "SELECT COUNT(*) FROM pair_fees WHERE pair_address = ?",
+		Delete: deleteByKey("pair_fees", "pair_address"),
+	})
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// amount. Amounts are canonicalized beforehand.
+func (e *ProtocolFeeEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.FeeTo); err != nil {
+		return err
+	}
+	if e.FeeTo == "" || e.Liquidity == "" {
+		return fmt.Errorf("fee_to and liquidity are required")
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleProtocolFee(ctx context.Context, event ProtocolFeeEvent) error {
+	if event.ContractID == "" {
+		return fmt.Errorf("invalid protocol fee event data: missing contract_id")
+	}
+	mintedAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.ContractID, err)
+	}
+	shares, _ := parseAmount(string(event.Liquidity))
+	// derived updates the fee totals and the fee_to position, the ones
+	// selected by replay when rebuilding.
+	derived := func(tx *sql.Tx, replay map[string]bool) error {
+		if replay == nil || replay["pair_fees"] {
+			if err := s.addPairFees(ctx, tx, event.ContractID, event.LedgerSequence, func(f *pairFees) {
+				f.feeTo = sql.NullString{String: event.FeeTo, Valid: true}
+				f.protocolShares.Add(f.protocolShares, shares)
+				f.protocolMints++
+			}); err != nil {
+				return err
+			}
+		}
+		if replay == nil || replay["lp_positions"] {
+			return s.addPosition(ctx, tx, event.ContractID, event.FeeTo, event.LedgerSequence, shares)
+		}
+		return nil
+	}
+
+	if replay := replayTables(ctx); replay != nil {
+		if !replay["pair_fees"] && !replay["lp_positions"] {
+			return nil
+		}
+		return s.replayEvent(ctx, replayEventKey("protocol_fee", event.TxHash, event.ContractID, event.EventIndex),
+			event.ContractID, func(tx *sql.Tx) error { return derived(tx, replay) })
+	}
+
+	return s.storePairEvent(ctx, "protocol_fee", event.ContractID,
+		func(st PairStore) (bool, error) { return st.RecordProtocolFee(ctx, event, mintedAt.Value) },
+		func(tx *sql.Tx) error {
+			// Fee shares are minted, so they count toward the supply.
+			if err := s.addTotalSupply(ctx, tx, event.ContractID, shares); err != nil {
+				return err
+			}
+			return derived(tx, nil)
+		},
+	)
+}
+
+// addSwapFees adds the fee a swap paid to its pair's totals.
+func (s *SaveSoroswapPairsToSQLite) addSwapFees(ctx context.Context, tx *sql.Tx, pair string, ledger int64, in0, in1 *big.Int) error {
+	return s.addPairFees(ctx, tx, pair, ledger, func(f *pairFees) {
+		f.swapFees0.Add(f.swapFees0, swapFee(in0))
+		f.swapFees1.Add(f.swapFees1, swapFee(in1))
+	})
+}
+
+// swapFee is the pair's fee on an input amount, rounded down.
+func swapFee(in *big.Int) *big.Int {
+	fee := new(big.Int).Mul(in, big.NewInt(swapFeeBps))
+	return fee.Quo(fee, big.NewInt(10000))
+}
+
+// pairFees is a pair_fees row being updated.
+type pairFees struct {
+	feeTo          sql.NullString
+	swapFees0      *big.Int
+	swapFees1      *big.Int
+	protocolShares *big.Int
+	protocolMints  int64
+}
+
+// addPairFees loads a pair's fee totals, applies update and stores them.
+func (s *SaveSoroswapPairsToSQLite) addPairFees(ctx context.Context, tx *sql.Tx, pair string, ledger int64, update func(*pairFees)) error {
+	f := pairFees{swapFees0: new(big.Int), swapFees1: new(big.Int), protocolShares: new(big.Int)}
+	fees0, fees1, shares := "0", "0", "0"
+	err := s.stmts.queryRow(ctx, tx, loadPairFeesQuery, pair).Scan(&f.feeTo, &fees0, &fees1, &shares, &f.protocolMints)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load fees for %s: %v", pair, err)
+	}
+	f.swapFees0.SetString(fees0, 10)
+	f.swapFees1.SetString(fees1, 10)
+	f.protocolShares.SetString(shares, 10)
+	update(&f)
+	if _, err := s.stmts.exec(ctx, tx, upsertPairFeesQuery,
+		pair, f.feeTo, f.swapFees0.String(), f.swapFees1.String(), f.protocolShares.String(),
+		f.protocolMints, nullableLedger(ledger), time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to store fees for %s: %v", pair, err)
+	}
+	return nil
+}
+
+// PairFees is a pair's fee revenue. Amounts are decimal strings.
+type PairFees struct {
+	PairAddress string `json:"pair_address"`
+	// FeeTo is the address the last protocol fee was minted to, empty if
+	// none was.
+	FeeTo string `json:"fee_to,omitempty"`
+	// SwapFees0 and SwapFees1 are the fees swaps paid into the pool, per
+	// token.
+	SwapFees0 string `json:"swap_fees_0"`
+	SwapFees1 string `json:"swap_fees_1"`
+	// ProtocolFeeShares is the total of LP shares minted to the protocol.
+	ProtocolFeeShares string     `json:"protocol_fee_shares"`
+	ProtocolFeeMints  int64      `json:"protocol_fee_mints"`
+	LastLedger        int64      `json:"last_ledger,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+}
+
+// GetPairFees returns a pair's fee totals, all zero for a stored pair
+// without swaps or fee mints.
+func (s *SaveSoroswapPairsToSQLite) GetPairFees(ctx context.Context, pair string) (PairFees, error) {
+	pair, err := normalizeAddress(pair)
+	if err != nil {
+		return PairFees{}, err
+	}
+	f := PairFees{PairAddress: pair}
+	var feeTo sql.NullString
+	var ledger sql.NullInt64
+	var updatedAt time.Time
+	err = s.readDB.QueryRowContext(ctx, s.backend.Rebind(`
+        SELECT fee_to, swap_fees_0, swap_fees_1, protocol_fee_shares, protocol_fee_mints, last_ledger, updated_at
+        FROM pair_fees WHERE pair_address = ?`), pair).Scan(
+		&feeTo, &f.SwapFees0, &f.SwapFees1, &f.ProtocolFeeShares, &f.ProtocolFeeMints, &ledger, &updatedAt)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := s.stmts.pairExists.QueryRowContext(ctx, pair).Scan(&exists); err != nil {
+			return f, fmt.Errorf("failed to check pair %s: %v", pair, err)
+		}
+		if !exists {
+			return f, ErrPairNotFound
+		}
+		f.SwapFees0, f.SwapFees1, f.ProtocolFeeShares = "0", "0", "0"
+		return f, nil
+	}
+	if err != nil {
+		return f, fmt.Errorf("failed to read fees for %s: %v", pair, err)
+	}
+	f.FeeTo, f.LastLedger, f.UpdatedAt = feeTo.String, ledger.Int64, &updatedAt
+	return f, nil
+}
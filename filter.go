@@ -0,0 +1,56 @@
+package main
+
+// pairFilter decides which pairs are stored, from token and pair address
+// lists. A pair is kept when it is on pair_allowlist or trades a token on
+// token_allowlist (or when neither allowlist is set), unless it or one of
+// its tokens is on a denylist.
+type pairFilter struct {
+	tokenAllow, tokenDeny map[string]bool
+	pairAllow, pairDeny   map[string]bool
+}
+
+func parsePairFilter(cfg *Config) (pairFilter, error) {
+	var f pairFilter
+	var err error
+	for _, list := range []struct {
+		key    string
+		values []string
+		set    *map[string]bool
+	}{
+		{"token_allowlist", cfg.TokenAllowlist, &f.tokenAllow},
+		{"token_denylist", cfg.TokenDenylist, &f.tokenDeny},
+		{"pair_allowlist", cfg.PairAllowlist, &f.pairAllow},
+		{"pair_denylist", cfg.PairDenylist, &f.pairDeny},
+	} {
+		if *list.set, err = parseAddresses(list.key, list.values); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// active reports whether any list is set.
+func (f pairFilter) active() bool {
+	return len(f.tokenAllow)+len(f.tokenDeny)+len(f.pairAllow)+len(f.pairDeny) > 0
+}
+
+// allows reports whether the pair of token0 and token1 is stored.
+func (f pairFilter) allows(pair, token0, token1 string) bool {
+	if f.pairDeny[pair] || f.tokenDeny[token0] || f.tokenDeny[token1] {
+		return false
+	}
+	if len(f.pairAllow) == 0 && len(f.tokenAllow) == 0 {
+		return true
+	}
+	return f.pairAllow[pair] || f.tokenAllow[token0] || f.tokenAllow[token1]
+}
+
+// allowsPlaceholder reports whether a sync may create a placeholder for an
+// unknown pair. Its tokens are not known yet, so with a token list set the
+// pair could turn out to be filtered, and none is created.
+func (f pairFilter) allowsPlaceholder(pair string) bool {
+	if len(f.tokenAllow) > 0 || len(f.tokenDeny) > 0 {
+		return false
+	}
+	return f.allows(pair, "", "")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// forwardedEvent is a persisted event as handed to the event sinks.
+type forwardedEvent struct {
+	Type        string
+	Outcome     string
+	PairAddress string
+	Ledger      int64
+	// Event is the event's JSON as received.
+	Event json.RawMessage
+	// Metadata and Timestamp are those of the message that carried it.
+	Metadata  map[string]interface{}
+	Timestamp time.Time
+	// Pair is the pair's stored state after the event, set when a sink
+	// asked for enrichment.
+	Pair *Pair
+}
+
+// enriched returns the event's JSON with the pair's current state added as
+// "pair", or the event unchanged when there is none.
+func (e forwardedEvent) enriched() ([]byte, error) {
+	if e.Pair == nil {
+		return e.Event, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.Event, &fields); err != nil {
+		return nil, err
+	}
+	pair, err := json.Marshal(e.Pair)
+	if err != nil {
+		return nil, err
+	}
+	fields["pair"] = pair
+	return json.Marshal(fields)
+}
+
+// eventSink receives events once the transaction that persisted them has
+// committed, in processing order.
+type eventSink interface {
+	Name() string
+	// Enrich reports whether the sink wants forwardedEvent.Pair.
+	Enrich() bool
+	Publish(ctx context.Context, events []forwardedEvent) error
+	Close() error
+}
+
+// forwarder hands persisted events to the configured sinks. Events of an
+// open batch wait in pending until it commits. Sinks are fed with the
+// write lock held, which keeps them in processing order; mu does the same
+// for the events of parallel workers.
+type forwarder struct {
+	sinks   []eventSink
+	mu      sync.Mutex
+	pending []forwardedEvent
+	// failed counts events a sink could not take, by sink.
+	failed map[string]*atomic.Int64
+}
+
+func newForwarder(sinks ...eventSink) *forwarder {
+	f := &forwarder{failed: make(map[string]*atomic.Int64)}
+	for _, sink := range sinks {
+		f.add(sink)
+	}
+	if len(f.sinks) == 0 {
+		return nil
+	}
+	return f
+}
+
+// add appends a sink unless it is nil or already added.
+func (f *forwarder) add(sink eventSink) {
+	if sink == nil {
+		return
+	}
+	for _, existing := range f.sinks {
+		if existing == sink {
+			return
+		}
+	}
+	f.sinks = append(f.sinks, sink)
+	f.failed[sink.Name()] = new(atomic.Int64)
+}
+
+// addSink adds a sink to the forwarder, creating it if there is none yet.
+func (s *SaveSoroswapPairsToSQLite) addSink(sink eventSink) {
+	if s.forward == nil {
+		s.forward = newForwarder(sink)
+		return
+	}
+	s.forward.add(sink)
+}
+
+// enrich reports whether any sink wants forwardedEvent.Pair.
+func (f *forwarder) enrich() bool {
+	for _, sink := range f.sinks {
+		if sink.Enrich() {
+			return true
+		}
+	}
+	return false
+}
+
+// eventResultKey carries an *eventResult through a handler's context.
+type eventResultKey struct{}
+
+// eventResult is what the handler reported doing with an event.
+type eventResult struct {
+	outcome string
+	pair    string
+}
+
+// forwardedOutcomes are the outcomes that persisted an event, which are
+// forwarded and run the after sql_hooks. Duplicates, stale syncs, queued
+// syncs and events of unknown pairs changed nothing worth mirroring.
+var forwardedOutcomes = map[string]bool{
+	outcomeInserted:    true,
+	outcomeUpdated:     true,
+	outcomePlaceholder: true,
+}
+
+// forwardEvent hands a successfully handled event to the sinks if it was
+// persisted. Replays and dry runs persist nothing new.
+func (s *SaveSoroswapPairsToSQLite) forwardEvent(ctx context.Context, eventType string, res *eventResult, msg pluginapi.Message) {
+	f := s.forward
+	if f == nil || s.dryRun || replayTables(ctx) != nil || !forwardedOutcomes[res.outcome] {
+		return
+	}
+	payload, _ := msg.Payload.([]byte)
+	ev := forwardedEvent{
+		Type:        eventType,
+		Outcome:     res.outcome,
+		PairAddress: res.pair,
+		Ledger:      messageLedger(msg),
+		Event:       json.RawMessage(payload),
+		Metadata:    msg.Metadata,
+		Timestamp:   msg.Timestamp,
+	}
+	if f.enrich() && res.pair != "" {
+		p, err := s.forwardedPair(ctx, res.pair)
+		if err != nil {
+			logger.Error("Failed to read pair for forwarding", "pair", res.pair, "error", err)
+		} else {
+			ev.Pair = &p
+		}
+	}
+
+	f.mu.Lock()
+	f.pending = append(f.pending, ev)
+	f.mu.Unlock()
+	if !s.batching() || s.batch.tx == nil {
+		s.flushForwarded(ctx)
+	}
+}
+
+// forwardedPair reads a pair as the event left it, from the open batch
+// when there is one.
+func (s *SaveSoroswapPairsToSQLite) forwardedPair(ctx context.Context, address string) (Pair, error) {
+	var tx *sql.Tx
+	if s.batching() {
+		tx = s.batch.tx
+	}
+	return scanPair(s.stmts.queryRow(ctx, tx, selectPairQuery, address))
+}
+
+// flushForwarded publishes the pending events, which have committed. A
+// sink failing does not fail the events, which are stored; it is logged
+// and counted.
+func (s *SaveSoroswapPairsToSQLite) flushForwarded(ctx context.Context) {
+	f := s.forward
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.pending) == 0 {
+		return
+	}
+	events := f.pending
+	f.pending = nil
+	for _, sink := range f.sinks {
+		if err := sink.Publish(context.WithoutCancel(ctx), events); err != nil {
+			f.failed[sink.Name()].Add(int64(len(events)))
+			logger.Error("Failed to forward events", "sink", sink.Name(), "events", len(events), "error", err)
+		}
+	}
+}
+
+// dropForwarded discards the pending events of a batch that failed to
+// commit.
+func (s *SaveSoroswapPairsToSQLite) dropForwarded() {
+	if f := s.forward; f != nil {
+		f.mu.Lock()
+		f.pending = nil
+		f.mu.Unlock()
+	}
+}
+
+// closeForwarder closes the sinks.
+func (s *SaveSoroswapPairsToSQLite) closeForwarder() {
+	if s.forward == nil {
+		return
+	}
+	for _, sink := range s.forward.sinks {
+		if err := sink.Close(); err != nil {
+			logger.Error("Failed to close event sink", "sink", sink.Name(), "error", err)
+		}
+	}
+}
+
+// parseForwarder builds the forwarder of the configured sinks, nil when
+// none is configured.
+func parseForwarder(cfg *Config) (*forwarder, error) {
+	kafka, err := parseKafkaSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	nats, err := parseNATSSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newForwarder(kafka, nats), nil
+}
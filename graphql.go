@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// The GraphQL schema mirrors the read APIs. Field names match the JSON
+// field names of the REST API.
+var (
+	gqlToken = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Token",
+		Fields: graphql.Fields{
+			"address":    {Type: graphql.NewNonNull(graphql.String)},
+			"symbol":     {Type: graphql.String},
+			"name":       {Type: graphql.String},
+			"decimals":   {Type: graphql.Int},
+			"price_usd":  {Type: graphql.Float},
+			"fetched_at": {Type: graphql.DateTime},
+			"last_error": {Type: graphql.String},
+		},
+	})
+
+	gqlHistoryPoint = graphql.NewObject(graphql.ObjectConfig{
+		Name: "HistoryPoint",
+		Fields: graphql.Fields{
+			"ledger":    {Type: graphql.Int},
+			"timestamp": {Type: graphql.DateTime},
+			"reserve_0": {Type: graphql.String},
+			"reserve_1": {Type: graphql.String},
+			"price_0_1": {Type: graphql.String},
+			"price_1_0": {Type: graphql.String},
+		},
+	})
+
+	gqlSwap = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Swap",
+		Fields: graphql.Fields{
+			"pair_address": {Type: graphql.String},
+			"trader":       {Type: graphql.String},
+			"amount_0_in":  {Type: graphql.String},
+			"amount_1_in":  {Type: graphql.String},
+			"amount_0_out": {Type: graphql.String},
+			"amount_1_out": {Type: graphql.String},
+			"ledger":       {Type: graphql.Int},
+			"tx_hash":      {Type: graphql.String},
+			"event_index":  {Type: graphql.Int},
+			"timestamp":    {Type: graphql.DateTime},
+		},
+	})
+
+	gqlVolume = graphql.NewObject(graphql.ObjectConfig{
+		Name: "PairVolume",
+		Fields: graphql.Fields{
+			"volume_24h_0": {Type: graphql.String},
+			"volume_24h_1": {Type: graphql.String},
+			"swaps_24h":    {Type: graphql.Int},
+			"volume_7d_0":  {Type: graphql.String},
+			"volume_7d_1":  {Type: graphql.String},
+			"swaps_7d":     {Type: graphql.Int},
+			"updated_at":   {Type: graphql.DateTime},
+		},
+	})
+)
+
+// gqlPage returns a page type holding items under itemsField, as the read
+// APIs' page structs do.
+func gqlPage(name, itemsField string, item graphql.Type) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.Fields{
+			itemsField:    {Type: graphql.NewList(item)},
+			"next_cursor": {Type: graphql.String},
+		},
+	})
+}
+
+// pageArgs are the pagination arguments shared by every list field.
+func pageArgs(extra graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{
+		"first": {Type: graphql.Int, Description: "Page size."},
+		"after": {Type: graphql.String, Description: "A previous page's next_cursor."},
+	}
+	for k, v := range extra {
+		args[k] = v
+	}
+	return args
+}
+
+// graphQLSchema builds the schema resolving against s.
+func (s *SaveSoroswapPairsToSQLite) graphQLSchema() (graphql.Schema, error) {
+	historyPage := gqlPage("HistoryPage", "points", gqlHistoryPoint)
+	swapPage := gqlPage("SwapPage", "swaps", gqlSwap)
+
+	pair := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Pair",
+		Fields: graphql.Fields{
+			"pair_address":      {Type: graphql.NewNonNull(graphql.String)},
+			"token_0":           {Type: graphql.String},
+			"token_1":           {Type: graphql.String},
+			"token_a":           {Type: graphql.String},
+			"token_b":           {Type: graphql.String},
+			"tokens_flipped":    {Type: graphql.Boolean},
+			"reserve_0":         {Type: graphql.String},
+			"reserve_1":         {Type: graphql.String},
+			"price_0_1":         {Type: graphql.Float},
+			"price_1_0":         {Type: graphql.Float},
+			"tvl_usd":           {Type: graphql.Float},
+			"total_supply":      {Type: graphql.String},
+			"created_at":        {Type: graphql.DateTime},
+			"created_at_ledger": {Type: graphql.Int},
+			"last_sync_at":      {Type: graphql.DateTime},
+			"last_sync_ledger":  {Type: graphql.Int},
+			"placeholder":       {Type: graphql.Boolean},
+			"active":            {Type: graphql.Boolean},
+			"network":           {Type: graphql.String},
+			"history": {
+				Type: historyPage,
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"from_ledger": {Type: graphql.Int},
+					"to_ledger":   {Type: graphql.Int},
+					"from_time":   {Type: graphql.DateTime},
+					"to_time":     {Type: graphql.DateTime},
+					"step":        {Type: graphql.Int},
+					"bucket":      {Type: graphql.String, Description: "A duration such as 1h."},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					opts := HistoryOptions{
+						FromLedger: int64(gqlInt(p, "from_ledger")),
+						ToLedger:   int64(gqlInt(p, "to_ledger")),
+						FromTime:   gqlTime(p, "from_time"),
+						ToTime:     gqlTime(p, "to_time"),
+						Limit:      gqlInt(p, "first"),
+						Step:       gqlInt(p, "step"),
+						Cursor:     gqlString(p, "after"),
+					}
+					if bucket := gqlString(p, "bucket"); bucket != "" {
+						d, err := time.ParseDuration(bucket)
+						if err != nil {
+							return nil, err
+						}
+						opts.Bucket = d
+					}
+					return s.GetPairHistory(p.Context, p.Source.(Pair).PairAddress, opts)
+				},
+			},
+			"swaps": {
+				Type: swapPage,
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"from_ledger": {Type: graphql.Int},
+					"to_ledger":   {Type: graphql.Int},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.GetPairSwaps(p.Context, p.Source.(Pair).PairAddress, SwapOptions{
+						FromLedger: int64(gqlInt(p, "from_ledger")),
+						ToLedger:   int64(gqlInt(p, "to_ledger")),
+						Limit:      gqlInt(p, "first"),
+						Cursor:     gqlString(p, "after"),
+					})
+				},
+			},
+			"volume": {
+				Type: gqlVolume,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					volume, err := s.GetPairVolume(p.Context, p.Source.(Pair).PairAddress)
+					if err != nil {
+						return nil, err
+					}
+					return volume, nil
+				},
+			},
+		},
+	})
+	pairPage := gqlPage("PairPage", "pairs", pair)
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"pair": {
+				Type: pair,
+				Args: graphql.FieldConfigArgument{
+					"address": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					pair, err := s.GetPair(p.Context, gqlString(p, "address"))
+					if errors.Is(err, ErrPairNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					return pair, nil
+				},
+			},
+			"pairs": {
+				Type: pairPage,
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"token": {Type: graphql.String, Description: "Only pairs trading this token."},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.ListPairs(p.Context, PairListOptions{
+						Token:  gqlString(p, "token"),
+						Limit:  gqlInt(p, "first"),
+						Cursor: gqlString(p, "after"),
+					})
+				},
+			},
+			"token": {
+				Type: gqlToken,
+				Args: graphql.FieldConfigArgument{
+					"address": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					token, err := s.GetToken(p.Context, gqlString(p, "address"))
+					if errors.Is(err, ErrTokenNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					return token, nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+func gqlString(p graphql.ResolveParams, name string) string {
+	v, _ := p.Args[name].(string)
+	return v
+}
+
+func gqlInt(p graphql.ResolveParams, name string) int {
+	v, _ := p.Args[name].(int)
+	return v
+}
+
+func gqlTime(p graphql.ResolveParams, name string) time.Time {
+	v, _ := p.Args[name].(time.Time)
+	return v
+}
+
+// graphQLRequest is a GraphQL request as POSTed by clients.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLHandler serves schema over HTTP, taking the query from a JSON POST
+// body or, for simple reads, the query parameter of a GET.
+func graphQLHandler(schema graphql.Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		switch r.Method {
+		case http.MethodGet:
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+		case http.MethodPost:
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        r.Context(),
+		})
+		writeJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/withObsrvr/flow-consumer-save-soroswappairs-to-sqlite/pairsrpc"
+)
+
+// pairService implements pairsrpc.PairServiceServer over the read APIs.
+type pairService struct {
+	pairsrpc.UnimplementedPairServiceServer
+	s *SaveSoroswapPairsToSQLite
+}
+
+// startGRPC serves the PairService on addr until Close.
+func (s *SaveSoroswapPairsToSQLite) startGRPC(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	srv := grpc.NewServer()
+	pairsrpc.RegisterPairServiceServer(srv, &pairService{s: s})
+	s.grpcServer = srv
+	logger.Info("Serving gRPC PairService", "addr", ln.Addr().String())
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			logger.Error("gRPC server failed", "addr", ln.Addr().String(), "error", err)
+		}
+	}()
+	return nil
+}
+
+// stopGRPC stops the gRPC server, letting open calls finish for as long
+// as the HTTP servers get.
+func (s *SaveSoroswapPairsToSQLite) stopGRPC() {
+	if s.grpcServer == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(httpShutdownTimeout):
+		s.grpcServer.Stop()
+	}
+	s.grpcServer = nil
+}
+
+func (ps *pairService) GetPair(ctx context.Context, req *pairsrpc.GetPairRequest) (*pairsrpc.Pair, error) {
+	addr, err := apiAddress(req.GetPairAddress())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	p, err := ps.s.GetPair(ctx, addr)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pairProto(p), nil
+}
+
+func (ps *pairService) ListPairs(ctx context.Context, req *pairsrpc.ListPairsRequest) (*pairsrpc.ListPairsResponse, error) {
+	opts := PairListOptions{Limit: int(req.GetPageSize()), Cursor: req.GetPageToken()}
+	if req.GetToken() != "" {
+		token, err := apiAddress(req.GetToken())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		opts.Token = token
+	}
+	page, err := ps.s.ListPairs(ctx, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pairsrpc.ListPairsResponse{NextPageToken: page.NextCursor}
+	for _, p := range page.Pairs {
+		resp.Pairs = append(resp.Pairs, pairProto(p))
+	}
+	return resp, nil
+}
+
+func (ps *pairService) GetReserveHistory(ctx context.Context, req *pairsrpc.GetReserveHistoryRequest) (*pairsrpc.GetReserveHistoryResponse, error) {
+	addr, err := apiAddress(req.GetPairAddress())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	opts := HistoryOptions{
+		FromLedger: req.GetFromLedger(),
+		ToLedger:   req.GetToLedger(),
+		Limit:      int(req.GetPageSize()),
+		Step:       int(req.GetStep()),
+		Cursor:     req.GetPageToken(),
+	}
+	if req.FromTime != nil {
+		opts.FromTime = req.FromTime.AsTime()
+	}
+	if req.ToTime != nil {
+		opts.ToTime = req.ToTime.AsTime()
+	}
+	if req.Bucket != nil {
+		opts.Bucket = req.Bucket.AsDuration()
+	}
+	page, err := ps.s.GetPairHistory(ctx, addr, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pairsrpc.GetReserveHistoryResponse{NextPageToken: page.NextCursor}
+	for _, pt := range page.Points {
+		resp.Points = append(resp.Points, &pairsrpc.HistoryPoint{
+			Ledger:    pt.Ledger,
+			Timestamp: timestamppb.New(pt.Timestamp),
+			Reserve_0: pt.Reserve0,
+			Reserve_1: pt.Reserve1,
+			Price_0_1: pt.Price0_1,
+			Price_1_0: pt.Price1_0,
+		})
+	}
+	return resp, nil
+}
+
+func pairProto(p Pair) *pairsrpc.Pair {
+	out := &pairsrpc.Pair{
+		PairAddress:     p.PairAddress,
+		Token_0:         p.Token0,
+		Token_1:         p.Token1,
+		TokenA:          p.TokenA,
+		TokenB:          p.TokenB,
+		TokensFlipped:   p.TokensFlipped,
+		Reserve_0:       p.Reserve0,
+		Reserve_1:       p.Reserve1,
+		CreatedAt:       timestamppb.New(p.CreatedAt),
+		CreatedAtLedger: p.CreatedAtLedger,
+		LastSyncLedger:  p.LastSyncLedger,
+		Placeholder:     p.Placeholder,
+		Network:         p.Network,
+	}
+	if p.LastSyncAt != nil {
+		out.LastSyncAt = timestamppb.New(*p.LastSyncAt)
+	}
+	return out
+}
+
+// grpcError maps read API errors to gRPC status codes like the query API
+// maps them to HTTP statuses.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrPairNotFound), errors.Is(err, ErrTokenNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errBadRequest), errors.Is(err, ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	logger.Error("gRPC request failed", "error", err)
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthState summarizes whether writes are currently succeeding.
+type HealthState string
+
+const (
+	HealthHealthy  HealthState = "healthy"
+	HealthDegraded HealthState = "degraded"
+	HealthFailing  HealthState = "failing"
+)
+
+// maxRecentErrors bounds the distinct error messages kept in Status.
+const maxRecentErrors = 10
+
+// Status is the aggregate health of the consumer, for readiness probes.
+type Status struct {
+	State               HealthState `json:"state"`
+	ConsecutiveFailures int64       `json:"consecutive_failures"`
+	FailingThreshold    int64       `json:"failing_threshold"`
+	FirstErrorAt        *time.Time  `json:"first_error_at,omitempty"`
+	LastErrorAt         *time.Time  `json:"last_error_at,omitempty"`
+	// RecentErrors holds the last distinct error messages, oldest first.
+	RecentErrors []string `json:"recent_errors,omitempty"`
+	// LastEventAt is when an event was last processed successfully.
+	LastEventAt *time.Time `json:"last_event_at,omitempty"`
+}
+
+// healthTracker counts consecutive failures since the last successful
+// commit. One failure makes the consumer degraded; failingThreshold of them
+// makes it failing.
+type healthTracker struct {
+	mu               sync.Mutex
+	failingThreshold int64
+	consecutive      int64
+	firstErrorAt     time.Time
+	lastErrorAt      time.Time
+	recent           []string
+	lastEventAt      time.Time
+}
+
+func newHealthTracker(cfg *Config) *healthTracker {
+	threshold := cfg.FailingThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &healthTracker{failingThreshold: int64(threshold)}
+}
+
+func (h *healthTracker) recordFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now().UTC()
+	if h.consecutive == 0 {
+		h.firstErrorAt = now
+	}
+	h.consecutive++
+	h.lastErrorAt = now
+
+	msg := err.Error()
+	for i, m := range h.recent {
+		if m == msg {
+			h.recent = append(h.recent[:i], h.recent[i+1:]...)
+			break
+		}
+	}
+	h.recent = append(h.recent, msg)
+	if len(h.recent) > maxRecentErrors {
+		h.recent = h.recent[len(h.recent)-maxRecentErrors:]
+	}
+
+	// Log once when crossing into failing, not for every message after.
+	if h.consecutive == h.failingThreshold {
+		logger.Error("Consumer is failing", "consecutive_failures", h.consecutive,
+			"since", h.firstErrorAt.Format(time.RFC3339), "last_error", msg)
+	}
+}
+
+func (h *healthTracker) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.consecutive >= h.failingThreshold {
+		logger.Info("Consumer recovered", "consecutive_failures", h.consecutive)
+	}
+	h.consecutive = 0
+	h.firstErrorAt = time.Time{}
+	h.lastErrorAt = time.Time{}
+	h.recent = nil
+}
+
+// recordEvent notes that an event was processed successfully.
+func (h *healthTracker) recordEvent() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastEventAt = time.Now().UTC()
+}
+
+func (h *healthTracker) status() Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := Status{
+		State:               HealthHealthy,
+		ConsecutiveFailures: h.consecutive,
+		FailingThreshold:    h.failingThreshold,
+	}
+	if !h.lastEventAt.IsZero() {
+		last := h.lastEventAt
+		st.LastEventAt = &last
+	}
+	if h.consecutive == 0 {
+		return st
+	}
+	st.State = HealthDegraded
+	if h.consecutive >= h.failingThreshold {
+		st.State = HealthFailing
+	}
+	first, last := h.firstErrorAt, h.lastErrorAt
+	st.FirstErrorAt = &first
+	st.LastErrorAt = &last
+	st.RecentErrors = append([]string(nil), h.recent...)
+	return st
+}
+
+// Status reports whether the consumer is healthy, degraded (recent failures)
+// or failing (failing_threshold consecutive failures). It resets on the next
+// successful commit.
+func (s *SaveSoroswapPairsToSQLite) Status() Status {
+	if s.health == nil {
+		return Status{State: HealthHealthy}
+	}
+	return s.health.status()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// testPostgresDSNEnv names the environment variable that, when set, makes
+// the backend tests run against PostgreSQL too.
+const testPostgresDSNEnv = "SOROSWAP_TEST_POSTGRES_DSN"
+
+// testEpoch is the close time of ledger 0 in test events; every ledger
+// after it closes five seconds later.
+var testEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	testTokenA = testContract(1)
+	testTokenB = testContract(2)
+	testTokenC = testContract(3)
+	testPair   = testContract(10)
+	testPair2  = testContract(11)
+	testTrader = testAccount(1)
+)
+
+// testContract returns a valid contract address numbered n.
+func testContract(n byte) string {
+	payload := make([]byte, 32)
+	payload[31] = n
+	return encodeStrkey(strkeyVersionContract, payload)
+}
+
+// testAccount returns a valid account address numbered n.
+func testAccount(n byte) string {
+	payload := make([]byte, 32)
+	payload[31] = n
+	return encodeStrkey(strkeyVersionAccount, payload)
+}
+
+// ledgerTime returns the close time of a test ledger.
+func ledgerTime(ledger int64) time.Time {
+	return testEpoch.Add(time.Duration(ledger) * 5 * time.Second)
+}
+
+// testConfig returns config over the defaults tests run with: a private
+// in-memory database and quiet logs.
+func testConfig(config map[string]interface{}) map[string]interface{} {
+	cfg := map[string]interface{}{"db_path": ":memory:", "log_level": "error"}
+	for k, v := range config {
+		cfg[k] = v
+	}
+	return cfg
+}
+
+// decodeTestConfig decodes config the way Initialize does.
+func decodeTestConfig(tb testing.TB, config map[string]interface{}) *Config {
+	tb.Helper()
+	cfg, err := decodeConfig(config)
+	if err != nil {
+		tb.Fatalf("decodeConfig: %v", err)
+	}
+	return &cfg
+}
+
+// newTestConsumer initializes a consumer with testConfig(config) and
+// closes it when the test ends.
+func newTestConsumer(tb testing.TB, config map[string]interface{}) *SaveSoroswapPairsToSQLite {
+	tb.Helper()
+	s := openTestConsumer(tb, config)
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+// openTestConsumer initializes a consumer the test closes itself, e.g. to
+// reopen its database.
+func openTestConsumer(tb testing.TB, config map[string]interface{}) *SaveSoroswapPairsToSQLite {
+	tb.Helper()
+	s := New().(*SaveSoroswapPairsToSQLite)
+	if err := s.Initialize(testConfig(config)); err != nil {
+		tb.Fatalf("Initialize: %v", err)
+	}
+	return s
+}
+
+// testBackends returns the configs of the backends a test runs against:
+// SQLite always, PostgreSQL when testPostgresDSNEnv is set. Each Postgres
+// run gets a schema of its own, dropped when the test ends.
+func testBackends(t *testing.T) map[string]map[string]interface{} {
+	backends := map[string]map[string]interface{}{"sqlite3": {}}
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		return backends
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	schema := fmt.Sprintf("soroswap_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+	// lib/pq passes unknown DSN parameters on as session settings.
+	switch {
+	case !strings.Contains(dsn, "://"):
+		dsn += " search_path=" + schema
+	case strings.Contains(dsn, "?"):
+		dsn += "&search_path=" + schema
+	default:
+		dsn += "?search_path=" + schema
+	}
+	backends["postgres"] = map[string]interface{}{"driver": "postgres", "dsn": dsn}
+	return backends
+}
+
+// event is a test event payload.
+type event map[string]interface{}
+
+// newPairEvent creates pair for the test tokens at ledger.
+func newPairEvent(pair string, ledger int64) event {
+	return event{
+		"type":            "new_pair",
+		"pair_address":    pair,
+		"token_0":         testTokenA,
+		"token_1":         testTokenB,
+		"timestamp":       ledgerTime(ledger).Format(time.RFC3339),
+		"ledger_sequence": ledger,
+	}
+}
+
+// syncEvent sets pair's reserves at ledger.
+func syncEvent(pair, reserve0, reserve1 string, ledger int64) event {
+	return event{
+		"type":            "sync",
+		"contract_id":     pair,
+		"new_reserve_0":   reserve0,
+		"new_reserve_1":   reserve1,
+		"timestamp":       ledgerTime(ledger).Format(time.RFC3339),
+		"ledger_sequence": ledger,
+	}
+}
+
+// swapEvent swaps 10 of token 0 for 9 of token 1 in pair at ledger.
+func swapEvent(pair, txHash string, ledger int64) event {
+	return event{
+		"type":            "swap",
+		"contract_id":     pair,
+		"trader":          testTrader,
+		"amount_0_in":     "10",
+		"amount_1_in":     "0",
+		"amount_0_out":    "0",
+		"amount_1_out":    "9",
+		"tx_hash":         txHash,
+		"timestamp":       ledgerTime(ledger).Format(time.RFC3339),
+		"ledger_sequence": ledger,
+	}
+}
+
+// with returns a copy of e with fields set, or removed when nil.
+func (e event) with(fields event) event {
+	out := make(event, len(e)+len(fields))
+	for k, v := range e {
+		out[k] = v
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// message encodes e as a Process message.
+func (e event) message(tb testing.TB) pluginapi.Message {
+	tb.Helper()
+	payload, err := json.Marshal(e)
+	if err != nil {
+		tb.Fatalf("encode event: %v", err)
+	}
+	return pluginapi.Message{Payload: payload}
+}
+
+// process sends events to s, failing the test on the first error.
+func process(tb testing.TB, s *SaveSoroswapPairsToSQLite, events ...event) {
+	tb.Helper()
+	for _, e := range events {
+		if err := s.Process(context.Background(), e.message(tb)); err != nil {
+			tb.Fatalf("Process %s: %v", e["type"], err)
+		}
+	}
+}
+
+// getPair returns a stored pair, failing the test if it is missing.
+func getPair(tb testing.TB, s *SaveSoroswapPairsToSQLite, pair string) Pair {
+	tb.Helper()
+	p, err := s.GetPair(context.Background(), pair)
+	if err != nil {
+		tb.Fatalf("GetPair: %v", err)
+	}
+	return p
+}
+
+// countRows returns the number of rows of an unprefixed table.
+func countRows(tb testing.TB, s *SaveSoroswapPairsToSQLite, table string) int {
+	tb.Helper()
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM " + s.backend.Table(table)).Scan(&n); err != nil {
+		tb.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrPairNotFound is returned by read APIs for pairs that are not stored.
+var ErrPairNotFound = errors.New("pair not found")
+
+// ErrInvalidCursor is returned by paginated read APIs for cursors they did
+// not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	defaultHistoryLimit = 1000
+	maxHistoryLimit     = 10000
+)
+
+func init() {
+	registerRetentionTable(retentionTable{Name: "reserve_history", Table: "pair_reserve_history", Column: "synced_at"})
+	registerLedgerTable(ledgerTable{Table: "pair_reserve_history"})
+	registerPartitionedTable(partitionedTable{Table: "pair_reserve_history", Column: "synced_at"})
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "pair_history",
+		Query: "SELECT id FROM pair_reserve_history WHERE pair_address = ? AND ledger_sequence >= ? ORDER BY ledger_sequence, id",
+		Args:  []interface{}{"", 0},
+	})
+}
+
+// HistoryOptions filters and shapes a GetPairHistory result. Zero values
+// leave the corresponding filter off.
+type HistoryOptions struct {
+	// FromLedger and ToLedger bound the ledger range, inclusive.
+	FromLedger int64
+	ToLedger   int64
+	// FromTime and ToTime bound the sync time, inclusive.
+	FromTime time.Time
+	ToTime   time.Time
+	// Limit caps the number of points per page (default 1000, max 10000).
+	Limit int
+	// Step keeps every Nth point of the filtered timeline.
+	Step int
+	// Bucket keeps only the last point of each time bucket.
+	Bucket time.Duration
+	// Cursor continues from a previous page's NextCursor.
+	Cursor string
+}
+
+// HistoryPoint is a pair's reserves as of one sync.
+type HistoryPoint struct {
+	Ledger    int64     `json:"ledger"`
+	Timestamp time.Time `json:"timestamp"`
+	Reserve0  string    `json:"reserve_0"`
+	Reserve1  string    `json:"reserve_1"`
+	// Price0_1 and Price1_0 are set when derived prices are recorded.
+	Price0_1 string `json:"price_0_1,omitempty"`
+	Price1_0 string `json:"price_1_0,omitempty"`
+	// TxHash is the sync's transaction, when the processor sent it.
+	TxHash string `json:"tx_hash,omitempty"`
+}
+
+// HistoryPage is one page of a pair's reserve timeline.
+type HistoryPage struct {
+	Points []HistoryPoint `json:"points"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// GetPairHistory returns a pair's reserve timeline ordered by ledger
+// ascending. It requires reserve_history to have been enabled while the
+// syncs were ingested and returns ErrPairNotFound for unknown pairs.
+func (s *SaveSoroswapPairsToSQLite) GetPairHistory(ctx context.Context, pair string, opts HistoryOptions) (*HistoryPage, error) {
+	addr, err := normalizeAddress(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := s.stmts.pairExists.QueryRowContext(ctx, addr).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check pair existence: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPairNotFound, addr)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	var afterLedger, afterID int64
+	if opts.Cursor != "" {
+		if afterLedger, afterID, err = decodeLedgerCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	// Filters apply before downsampling so the same points are chosen on
+	// every page; the cursor applies after.
+	where := []string{"pair_address = ?"}
+	args := []interface{}{addr}
+	if opts.FromLedger > 0 {
+		where = append(where, "ledger_sequence >= ?")
+		args = append(args, opts.FromLedger)
+	}
+	if opts.ToLedger > 0 {
+		where = append(where, "ledger_sequence <= ?")
+		args = append(args, opts.ToLedger)
+	}
+	if !opts.FromTime.IsZero() {
+		where = append(where, "synced_at >= ?")
+		args = append(args, opts.FromTime.UTC())
+	}
+	if !opts.ToTime.IsZero() {
+		where = append(where, "synced_at <= ?")
+		args = append(args, opts.ToTime.UTC())
+	}
+
+	priceCols := ""
+	if s.historyHasPrice {
+		priceCols = ", COALESCE(price_0_1, ''), COALESCE(price_1_0, '')"
+	}
+
+	bucketExpr := "0"
+	if secs := int64(opts.Bucket / time.Second); secs > 0 {
+		bucketExpr = fmt.Sprintf("%s / %d", s.backend.EpochSeconds("synced_at"), secs)
+	}
+
+	outer := []string{"(ledger_sequence, id) > (?, ?)"}
+	if opts.Step > 1 {
+		outer = append(outer, fmt.Sprintf("(rn - 1) %% %d = 0", opts.Step))
+	}
+	if opts.Bucket >= time.Second {
+		outer = append(outer, "brn = 1")
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, ledger_sequence, synced_at, reserve_0, reserve_1, COALESCE(tx_hash, '')%s FROM (
+            SELECT *,
+                ROW_NUMBER() OVER (ORDER BY ledger_sequence, id) AS rn,
+                ROW_NUMBER() OVER (
+                    PARTITION BY %s ORDER BY ledger_sequence DESC, id DESC
+                ) AS brn
+            FROM pair_reserve_history
+            WHERE %s
+        ) h
+        WHERE %s
+        ORDER BY ledger_sequence, id
+        LIMIT ?
+    `, priceCols, bucketExpr, strings.Join(where, " AND "), strings.Join(outer, " AND "))
+	args = append(args, afterLedger, afterID, limit+1)
+
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pair history: %v", err)
+	}
+	defer rows.Close()
+
+	page := &HistoryPage{Points: []HistoryPoint{}}
+	var lastID int64
+	for rows.Next() {
+		if len(page.Points) == limit {
+			last := page.Points[len(page.Points)-1]
+			page.NextCursor = encodeLedgerCursor(last.Ledger, lastID)
+			break
+		}
+		var p HistoryPoint
+		dest := []interface{}{&lastID, &p.Ledger, &p.Timestamp, &p.Reserve0, &p.Reserve1, &p.TxHash}
+		if s.historyHasPrice {
+			dest = append(dest, &p.Price0_1, &p.Price1_0)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan pair history: %v", err)
+		}
+		page.Points = append(page.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pair history: %v", err)
+	}
+	return page, nil
+}
+
+// Ledger cursors are opaque to callers; internally they hold the
+// (ledger, id) of the last row returned.
+func encodeLedgerCursor(ledger, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", ledger, id)))
+}
+
+func decodeLedgerCursor(cursor string) (int64, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	var ledger, id int64
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &ledger, &id); err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return ledger, id, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// hookEventTypes are the event types sql_hooks can be configured for.
+var hookEventTypes = []string{
+	"new_pair", "sync", "swap", "deposit", "mint", "withdraw", "burn",
+	"lp_transfer", "protocol_fee", "factory_fee_to", "factory_fee_to_setter",
+	"factory_fees_enabled", "router_add_liquidity", "router_remove_liquidity",
+	"aggregator_swap", "router_swap",
+}
+
+// sqlHook is an operator-supplied statement run in an event's transaction.
+// Its :name placeholders are bound to the event's payload fields.
+type sqlHook struct {
+	// query has the placeholders replaced with ?.
+	query string
+	// params names the payload field of each ?.
+	params []string
+}
+
+// writeHooks are the hooks of one event type.
+type writeHooks struct {
+	before []sqlHook
+	after  []sqlHook
+}
+
+// parseSQLHooks reads the sql_hooks config map, by event type, e.g.
+//
+//	sql_hooks:
+//	  swap:
+//	    after:
+//	      - INSERT INTO trader_swaps (trader, swaps) VALUES (:trader, 1)
+//	        ON CONFLICT (trader) DO UPDATE SET swaps = trader_swaps.swaps + 1
+func parseSQLHooks(cfg *Config) (map[string]*writeHooks, error) {
+	raw := cfg.SQLHooks
+	if raw == nil {
+		return nil, nil
+	}
+	byType, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config sql_hooks: expected a map of event types, got %T", raw)
+	}
+
+	hooks := make(map[string]*writeHooks, len(byType))
+	for eventType, v := range byType {
+		known := false
+		for _, t := range hookEventTypes {
+			known = known || t == eventType
+		}
+		if !known {
+			return nil, fmt.Errorf("config sql_hooks.%s: unknown event type, expected one of %s", eventType, strings.Join(hookEventTypes, ", "))
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config sql_hooks.%s: expected a map with before and after, got %T", eventType, v)
+		}
+		h := &writeHooks{}
+		for key := range m {
+			if key != "before" && key != "after" {
+				return nil, fmt.Errorf("config sql_hooks.%s.%s: unknown key, expected before or after", eventType, key)
+			}
+		}
+		for _, phase := range []struct {
+			key string
+			dst *[]sqlHook
+		}{{"before", &h.before}, {"after", &h.after}} {
+			stmts, err := configStrings(m, phase.key)
+			if err != nil {
+				return nil, fmt.Errorf("config sql_hooks.%s: %v", eventType, err)
+			}
+			for i, stmt := range stmts {
+				hook, err := compileSQLHook(stmt)
+				if err != nil {
+					return nil, fmt.Errorf("config sql_hooks.%s.%s[%d]: %v", eventType, phase.key, i, err)
+				}
+				*phase.dst = append(*phase.dst, hook)
+			}
+		}
+		hooks[eventType] = h
+	}
+	return hooks, nil
+}
+
+// compileSQLHook replaces the :name placeholders of stmt with ?. Quoted
+// strings and identifiers, and Postgres :: casts, are left alone. Comments
+// are dropped, so nothing in them is taken for a placeholder, by this or by
+// the backend's own placeholder rewriting.
+func compileSQLHook(stmt string) (sqlHook, error) {
+	if strings.TrimSpace(stmt) == "" {
+		return sqlHook{}, fmt.Errorf("empty statement")
+	}
+	var hook sqlHook
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end < 0 {
+				end = len(stmt) - i
+			}
+			out.WriteByte(' ')
+			i += end - 1
+			continue
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				return sqlHook{}, fmt.Errorf("unterminated comment")
+			}
+			out.WriteByte(' ')
+			i += end + 3
+			continue
+		case c == '?':
+			return sqlHook{}, fmt.Errorf("use :name placeholders, not ?")
+		case c == ':' && i+1 < len(stmt) && stmt[i+1] == ':':
+			out.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(stmt) && isIdentStart(stmt[i+1]):
+			j := i + 1
+			for j < len(stmt) && (isIdentStart(stmt[j]) || (stmt[j] >= '0' && stmt[j] <= '9')) {
+				j++
+			}
+			hook.params = append(hook.params, stmt[i+1:j])
+			out.WriteByte('?')
+			i = j - 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	if quote != 0 {
+		return sqlHook{}, fmt.Errorf("unterminated quote")
+	}
+	hook.query = out.String()
+	return hook, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// hookEventKey carries the event the hooks of a transaction run for.
+type hookEventKey struct{}
+
+// hookEvent is the event being handled, for binding hook placeholders.
+type hookEvent struct {
+	hooks  *writeHooks
+	fields map[string]interface{}
+}
+
+// withSQLHooks prepares the hooks of eventType to run in the transactions
+// of the event in payload. Replays rewrite derived tables only and run
+// none.
+func (s *SaveSoroswapPairsToSQLite) withSQLHooks(ctx context.Context, eventType string, payload []byte) (context.Context, error) {
+	h := s.sqlHooks[eventType]
+	if h == nil || replayTables(ctx) != nil {
+		return ctx, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return ctx, fmt.Errorf("error decoding event for sql_hooks: %w", err)
+	}
+	fields["event_type"] = eventType
+	fields["payload"] = string(payload)
+	return context.WithValue(ctx, hookEventKey{}, &hookEvent{hooks: h, fields: fields}), nil
+}
+
+// runSQLHooks runs the before or after hooks of the event in ctx, if any,
+// in tx. A failing hook fails the event.
+func (s *SaveSoroswapPairsToSQLite) runSQLHooks(ctx context.Context, tx *sql.Tx, after bool) error {
+	ev, _ := ctx.Value(hookEventKey{}).(*hookEvent)
+	if ev == nil {
+		return nil
+	}
+	hooks, phase := ev.hooks.before, "before"
+	if after {
+		hooks, phase = ev.hooks.after, "after"
+	}
+	for i, hook := range hooks {
+		args := make([]interface{}, len(hook.params))
+		for j, name := range hook.params {
+			args[j] = hookValue(ev.fields[name])
+		}
+		// Operators name their tables as they are; the table prefix is
+		// not applied.
+		if _, err := tx.ExecContext(ctx, s.backend.Placeholders(hook.query), args...); err != nil {
+			return fmt.Errorf("sql_hooks %s[%d] failed: %v", phase, i, err)
+		}
+	}
+	return nil
+}
+
+// hookValue converts a payload field to a statement argument: numbers are
+// passed as their exact text, objects and lists as JSON, and missing
+// fields as NULL.
+func hookValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	default:
+		return v
+	}
+}
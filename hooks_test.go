@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hookConfig returns sql_hooks logging phase, event type and ledger of the
+// given event types to hook_log.
+func hookConfig(eventTypes ...string) map[string]interface{} {
+	const log = "INSERT INTO hook_log (phase, event_type, ledger) VALUES ('%s', :event_type, :ledger_sequence)"
+	hooks := make(map[string]interface{})
+	for _, t := range eventTypes {
+		hooks[t] = map[string]interface{}{
+			"before": []interface{}{fmt.Sprintf(log, "before")},
+			"after":  []interface{}{fmt.Sprintf(log, "after")},
+		}
+	}
+	return hooks
+}
+
+func createHookLog(t *testing.T, s *SaveSoroswapPairsToSQLite) {
+	t.Helper()
+	if _, err := s.db.Exec("CREATE TABLE hook_log (phase TEXT, event_type TEXT, ledger TEXT)"); err != nil {
+		t.Fatalf("create hook_log: %v", err)
+	}
+}
+
+func TestSQLHooksAfterChangesOnly(t *testing.T) {
+	s := newTestConsumer(t, map[string]interface{}{"sql_hooks": hookConfig("new_pair", "sync", "swap")})
+	createHookLog(t, s)
+	process(t, s,
+		newPairEvent(testPair, 10),
+		newPairEvent(testPair, 10),
+		syncEvent(testPair, "100", "50", 20),
+		syncEvent(testPair, "90", "60", 15),
+		swapEvent(testPair, "aa01", 21),
+		swapEvent(testPair, "aa01", 21),
+	)
+
+	tests := []struct {
+		phase string
+		want  [][]string
+	}{
+		{
+			// Before hooks run for every event the handler gets to.
+			phase: "before",
+			want: [][]string{
+				{"new_pair", "10"}, {"new_pair", "10"}, {"sync", "20"}, {"sync", "15"}, {"swap", "21"}, {"swap", "21"},
+			},
+		},
+		{
+			// After hooks skip the duplicates and the stale sync.
+			phase: "after",
+			want:  [][]string{{"new_pair", "10"}, {"sync", "20"}, {"swap", "21"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phase, func(t *testing.T) {
+			got := queryStrings(t, s, "SELECT event_type, ledger FROM hook_log WHERE phase = ? ORDER BY rowid", tt.phase)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s hooks ran for %v, want %v", tt.phase, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLHooksTablePrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		stmt    string
+		wantErr string
+	}{
+		{"prefixed name", "UPDATE mn_soroswap_pairs SET reserve_1 = '999' WHERE pair_address = :contract_id", ""},
+		{"unprefixed name is not rewritten", "UPDATE soroswap_pairs SET reserve_1 = '999' WHERE pair_address = :contract_id", "no such table: soroswap_pairs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, map[string]interface{}{
+				"table_prefix": "mn_",
+				"sql_hooks":    map[string]interface{}{"sync": map[string]interface{}{"after": []interface{}{tt.stmt}}},
+			})
+			process(t, s, newPairEvent(testPair, 10))
+			err := s.Process(context.Background(), syncEvent(testPair, "100", "50", 20).message(t))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Process = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if p := getPair(t, s, testPair); p.Reserve1 != "999" {
+				t.Errorf("reserve_1 = %s, want 999 set by the hook", p.Reserve1)
+			}
+		})
+	}
+}
+
+func TestCompileSQLHook(t *testing.T) {
+	tests := []struct {
+		name       string
+		stmt       string
+		wantQuery  string
+		wantParams []string
+		wantErr    string
+	}{
+		{
+			name:       "placeholders",
+			stmt:       "INSERT INTO t VALUES (:contract_id, :ledger_sequence::bigint)",
+			wantQuery:  "INSERT INTO t VALUES (?, ?::bigint)",
+			wantParams: []string{"contract_id", "ledger_sequence"},
+		},
+		{
+			name:       "question mark in a literal",
+			stmt:       "INSERT INTO t VALUES ('why?', :ledger_sequence)",
+			wantQuery:  "INSERT INTO t VALUES ('why?', ?)",
+			wantParams: []string{"ledger_sequence"},
+		},
+		{
+			name:       "line comment",
+			stmt:       "-- don't bind :trader?\nINSERT INTO t VALUES (:ledger_sequence) -- or :pair",
+			wantQuery:  " \nINSERT INTO t VALUES (?)  ",
+			wantParams: []string{"ledger_sequence"},
+		},
+		{
+			name:       "block comment",
+			stmt:       "INSERT INTO t /* it's :ignored? */ VALUES (:ledger_sequence)",
+			wantQuery:  "INSERT INTO t   VALUES (?)",
+			wantParams: []string{"ledger_sequence"},
+		},
+		{name: "bare question mark", stmt: "INSERT INTO t VALUES (?)", wantErr: "use :name placeholders, not ?"},
+		{name: "unterminated quote", stmt: "INSERT INTO t VALUES ('x)", wantErr: "unterminated quote"},
+		{name: "unterminated comment", stmt: "INSERT INTO t VALUES (1) /* x", wantErr: "unterminated comment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, err := compileSQLHook(tt.stmt)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("compileSQLHook = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileSQLHook: %v", err)
+			}
+			if hook.query != tt.wantQuery || !reflect.DeepEqual(hook.params, tt.wantParams) {
+				t.Errorf("compileSQLHook = %q %v, want %q %v", hook.query, hook.params, tt.wantQuery, tt.wantParams)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const httpShutdownTimeout = 5 * time.Second
+
+// httpEndpoints collects the handlers to serve per listen address, so
+// endpoints configured with the same address share one listener.
+type httpEndpoints map[string]*http.ServeMux
+
+func (e httpEndpoints) handle(addr, pattern string, handler http.Handler) {
+	if e[addr] == nil {
+		e[addr] = http.NewServeMux()
+	}
+	e[addr].Handle(pattern, handler)
+}
+
+// startHTTP listens on every address in endpoints, serving until Close.
+// Binding errors are returned; serving errors are logged.
+func (s *SaveSoroswapPairsToSQLite) startHTTP(endpoints httpEndpoints) error {
+	for addr, mux := range endpoints {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			s.stopHTTP()
+			return fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		s.httpServers = append(s.httpServers, srv)
+		logger.Info("Serving HTTP endpoints", "addr", ln.Addr().String())
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logger.Error("HTTP server failed", "addr", ln.Addr().String(), "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// stopHTTP shuts the HTTP servers down, waiting briefly for open requests.
+func (s *SaveSoroswapPairsToSQLite) stopHTTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	for _, srv := range s.httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("Failed to stop HTTP server", "error", err)
+		}
+	}
+	s.httpServers = nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// extraIndex is one entry of the indexes config list, an index the
+// operator adds to the plugin's schema.
+type extraIndex struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+}
+
+var indexIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// parseExtraIndexes reads the indexes config list, e.g.
+//
+//	indexes:
+//	  - {table: soroswap_pairs, columns: [last_sync_ledger]}
+//	  - {table: pair_reserve_history, columns: [pair_address, ledger_sequence], name: idx_history_ledger}
+//
+// Names default to idx_<table>_<columns>.
+func parseExtraIndexes(cfg *Config) ([]extraIndex, error) {
+	raw := cfg.Indexes
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config indexes: expected a list, got %T", raw)
+	}
+
+	indexes := make([]extraIndex, 0, len(list))
+	names := make(map[string]bool)
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config indexes[%d]: expected a map, got %T", i, item)
+		}
+		var x extraIndex
+		var err error
+		if x.Table, err = configString(m, "table", ""); err != nil {
+			return nil, fmt.Errorf("config indexes[%d]: %v", i, err)
+		}
+		if !indexIdentifier.MatchString(x.Table) {
+			return nil, fmt.Errorf("config indexes[%d]: table must be a table name, got %q", i, x.Table)
+		}
+		if x.Columns, err = configStrings(m, "columns"); err != nil {
+			return nil, fmt.Errorf("config indexes[%d]: %v", i, err)
+		}
+		if len(x.Columns) == 0 {
+			return nil, fmt.Errorf("config indexes[%d]: columns must not be empty", i)
+		}
+		for _, column := range x.Columns {
+			if !indexIdentifier.MatchString(column) {
+				return nil, fmt.Errorf("config indexes[%d]: columns must be column names, got %q", i, column)
+			}
+		}
+		if x.Name, err = configString(m, "name", "idx_"+x.Table+"_"+strings.Join(x.Columns, "_")); err != nil {
+			return nil, fmt.Errorf("config indexes[%d]: %v", i, err)
+		}
+		if !indexIdentifier.MatchString(x.Name) {
+			return nil, fmt.Errorf("config indexes[%d]: name must be lower case letters, digits and underscores, got %q", i, x.Name)
+		}
+		if names[x.Name] {
+			return nil, fmt.Errorf("config indexes[%d]: duplicate index name %s", i, x.Name)
+		}
+		names[x.Name] = true
+		if x.Unique, err = configBool(m, "unique", false); err != nil {
+			return nil, fmt.Errorf("config indexes[%d]: %v", i, err)
+		}
+		indexes = append(indexes, x)
+	}
+	return indexes, nil
+}
+
+// createExtraIndexes creates the configured indexes that do not exist yet.
+// On a table partitioned by month they go on its template, which new
+// partitions copy, and on every partition. Indexes dropped from the config
+// are left in place.
+func createExtraIndexes(ctx context.Context, db *sql.DB, b backend, partitions *monthPartitions, indexes []extraIndex) error {
+	for _, x := range indexes {
+		for _, column := range x.Columns {
+			ok, err := b.ColumnExists(ctx, db, x.Table, column)
+			if err != nil {
+				return fmt.Errorf("failed to inspect %s: %v", x.Table, err)
+			}
+			if !ok {
+				return fmt.Errorf("config indexes: %s has no column %s", x.Table, column)
+			}
+		}
+
+		targets := map[string]string{b.Table(x.Name): b.Table(x.Table)}
+		if partitions.partitioned(x.Table) {
+			months, _, err := partitionMonths(ctx, db, b, x.Table)
+			if err != nil {
+				return err
+			}
+			targets = map[string]string{b.Table(x.Name) + "_template": partitionTemplate(b, x.Table)}
+			for _, month := range months {
+				targets[b.Table(x.Name)+"_"+month] = partitionName(b, x.Table, month)
+			}
+		}
+
+		create := "CREATE INDEX"
+		if x.Unique {
+			create = "CREATE UNIQUE INDEX"
+		}
+		for name, table := range targets {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf("%s IF NOT EXISTS %s ON %s(%s)",
+				create, name, table, strings.Join(x.Columns, ", "))); err != nil {
+				return fmt.Errorf("failed to create index %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/withObsrvr/pluginapi"
+)
+
+// Categories of failed events in ingest_errors.
+const (
+	errorCategoryDecode      = "decode"
+	errorCategoryValidation  = "validation"
+	errorCategoryConstraint  = "constraint"
+	errorCategoryTimeout     = "timeout"
+	errorCategoryBusy        = "busy"
+	errorCategoryUnknownType = "unknown_type"
+	errorCategoryUnknownPair = "unknown_pair"
+	errorCategoryOther       = "other"
+)
+
+// maxErrorSample caps the payload kept as a sample in ingest_errors.
+const maxErrorSample = 1024
+
+// classifyError returns the ingest_errors category of an event's error.
+// Most errors are wrapped with %v, so messages are checked as well.
+func classifyError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var sqliteErr sqlite3.Error
+	msg := err.Error()
+	switch {
+	case errors.Is(err, errUnknownEventType):
+		return errorCategoryUnknownType
+	case errors.Is(err, errUnknownPair):
+		return errorCategoryUnknownPair
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), strings.HasPrefix(msg, "error decoding"),
+		strings.HasPrefix(msg, "expected []byte"):
+		return errorCategoryDecode
+	case strings.HasPrefix(msg, "invalid "):
+		return errorCategoryValidation
+	case errors.Is(err, context.DeadlineExceeded), strings.Contains(msg, context.DeadlineExceeded.Error()):
+		return errorCategoryTimeout
+	case isBusy(err):
+		return errorCategoryBusy
+	case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint,
+		strings.Contains(msg, "constraint failed"), strings.Contains(msg, "violates"):
+		return errorCategoryConstraint
+	}
+	return errorCategoryOther
+}
+
+// ingestErrorKey groups failed events in ingest_errors.
+type ingestErrorKey struct {
+	category  string
+	eventType string
+}
+
+// ingestErrorDelta is what failed since the last flush: how many events,
+// and the latest of them as a sample.
+type ingestErrorDelta struct {
+	count   int64
+	error   string
+	payload string
+	ledger  int64
+	at      time.Time
+}
+
+// ingestErrorLog counts failed events by category and type. Like the
+// lifetime counters, increments are buffered and written to ingest_errors
+// when the counters are flushed.
+type ingestErrorLog struct {
+	mu      sync.Mutex
+	stored  map[ingestErrorKey]int64
+	pending map[ingestErrorKey]*ingestErrorDelta
+}
+
+func newIngestErrorLog() *ingestErrorLog {
+	return &ingestErrorLog{
+		stored:  make(map[ingestErrorKey]int64),
+		pending: make(map[ingestErrorKey]*ingestErrorDelta),
+	}
+}
+
+// record buffers a failed event.
+func (l *ingestErrorLog) record(eventType string, msg pluginapi.Message, err error) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	payload := rawPayload(msg)
+	if len(payload) > maxErrorSample {
+		payload = payload[:maxErrorSample]
+	}
+	ledger := messageLedger(msg)
+	key := ingestErrorKey{category: classifyError(err), eventType: eventType}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := l.pending[key]
+	if d == nil {
+		d = &ingestErrorDelta{}
+		l.pending[key] = d
+	}
+	d.count++
+	d.error, d.payload, d.ledger, d.at = err.Error(), string(payload), ledger, time.Now().UTC()
+}
+
+// lifetime returns stored plus buffered counts by category and type.
+func (l *ingestErrorLog) lifetime() map[ingestErrorKey]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[ingestErrorKey]int64, len(l.stored))
+	for k, n := range l.stored {
+		out[k] = n
+	}
+	for k, d := range l.pending {
+		out[k] += d.count
+	}
+	return out
+}
+
+// loadIngestErrors reads the persisted error counts.
+func (s *SaveSoroswapPairsToSQLite) loadIngestErrors(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind(`SELECT category, event_type, count FROM ingest_errors`))
+	if err != nil {
+		return fmt.Errorf("failed to load ingest errors: %v", err)
+	}
+	defer rows.Close()
+
+	l := s.ingestErrors
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for rows.Next() {
+		var k ingestErrorKey
+		var n int64
+		if err := rows.Scan(&k.category, &k.eventType, &n); err != nil {
+			return fmt.Errorf("failed to scan ingest errors: %v", err)
+		}
+		l.stored[k] = n
+	}
+	return rows.Err()
+}
+
+// flushIngestErrors writes the buffered error counts and samples in one
+// transaction. A failed flush puts them back.
+func (s *SaveSoroswapPairsToSQLite) flushIngestErrors(ctx context.Context) error {
+	l := s.ingestErrors
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = make(map[ingestErrorKey]*ingestErrorDelta)
+	l.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for k, d := range pending {
+			if _, err := tx.ExecContext(ctx, s.backend.Rebind(upsertIngestErrorQuery),
+				k.category, k.eventType, d.count, d.at, d.at, d.error, d.payload, nullableLedger(d.ledger),
+			); err != nil {
+				return fmt.Errorf("failed to flush ingest errors for %s/%s: %v", k.category, k.eventType, err)
+			}
+		}
+		return nil
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, d := range pending {
+		if err == nil {
+			l.stored[k] += d.count
+			continue
+		}
+		// Newer failures keep their sample.
+		if p := l.pending[k]; p != nil {
+			p.count += d.count
+			continue
+		}
+		l.pending[k] = d
+	}
+	return err
+}
+
+const upsertIngestErrorQuery = `
+        INSERT INTO ingest_errors (
+            category, event_type, count, first_seen_at, last_seen_at,
+            sample_error, sample_payload, sample_ledger
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (category, event_type) DO UPDATE SET
+            count = ingest_errors.count + excluded.count,
+            last_seen_at = excluded.last_seen_at,
+            sample_error = excluded.sample_error,
+            sample_payload = excluded.sample_payload,
+            sample_ledger = excluded.sample_ledger
+    `
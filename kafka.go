@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaSink publishes persisted events to a Kafka topic, keyed by pair
+// address so each pair's events stay in order on one partition.
+type kafkaSink struct {
+	writer *kafka.Writer
+	enrich bool
+}
+
+// parseKafkaSink reads the kafka_* settings, returning nil when
+// kafka_brokers is unset.
+func parseKafkaSink(cfg *Config) (eventSink, error) {
+	if len(cfg.KafkaBrokers) == 0 {
+		return nil, nil
+	}
+	if cfg.KafkaTopic == "" {
+		return nil, fmt.Errorf("config kafka_topic is required with kafka_brokers")
+	}
+	if cfg.KafkaMaxAttempts <= 0 {
+		return nil, fmt.Errorf("config kafka_max_attempts must be positive, got %d", cfg.KafkaMaxAttempts)
+	}
+
+	transport := &kafka.Transport{DialTimeout: cfg.KafkaTimeout, ClientID: "soroswap-pairs-consumer"}
+	if cfg.KafkaTLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	var err error
+	if transport.SASL, err = parseKafkaSASL(cfg); err != nil {
+		return nil, err
+	}
+
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.KafkaBrokers...),
+			Topic:        cfg.KafkaTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			MaxAttempts:  cfg.KafkaMaxAttempts,
+			WriteTimeout: cfg.KafkaTimeout,
+			// Events are handed over in batches already; waiting for more
+			// would only delay them.
+			BatchTimeout: time.Millisecond,
+			Transport:    transport,
+		},
+		enrich: cfg.KafkaEnrich,
+	}, nil
+}
+
+// parseKafkaSASL reads kafka_sasl_mechanism (plain, scram-sha-256 or
+// scram-sha-512) with kafka_username and kafka_password.
+func parseKafkaSASL(cfg *Config) (sasl.Mechanism, error) {
+	username, password := cfg.KafkaUsername, cfg.KafkaPassword
+	switch strings.ToLower(cfg.KafkaSASLMechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("config kafka_sasl_mechanism: unknown mechanism %q", cfg.KafkaSASLMechanism)
+	}
+}
+
+func (k *kafkaSink) Name() string { return "kafka" }
+
+func (k *kafkaSink) Enrich() bool { return k.enrich }
+
+// Publish writes the events as one batch. The value is the event's JSON,
+// with the pair's state added when kafka_enrich is set; the event type and
+// outcome are also sent as headers.
+func (k *kafkaSink) Publish(ctx context.Context, events []forwardedEvent) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, ev := range events {
+		value := []byte(ev.Event)
+		if k.enrich {
+			var err error
+			if value, err = ev.enriched(); err != nil {
+				return fmt.Errorf("failed to encode %s event: %v", ev.Type, err)
+			}
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(ev.PairAddress),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "event_type", Value: []byte(ev.Type)},
+				{Key: "outcome", Value: []byte(ev.Outcome)},
+			},
+		})
+	}
+	return k.writer.WriteMessages(ctx, msgs...)
+}
+
+func (k *kafkaSink) Close() error {
+	return k.writer.Close()
+}
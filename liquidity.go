@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// DepositEvent records liquidity added to a pair (the pair's deposit event,
+// also accepted as "mint").
+type DepositEvent struct {
+	Type       string `json:"type"`
+	ContractID string `json:"contract_id"`
+	// Provider is the address credited with the minted LP shares.
+	Provider  string `json:"provider"`
+	Amount0   Amount `json:"amount_0"`
+	Amount1   Amount `json:"amount_1"`
+	Liquidity Amount `json:"liquidity"`
+	TxHash    string `json:"tx_hash"`
+	// OperationIndex is the operation within the transaction.
+	OperationIndex int64     `json:"operation_index"`
+	Timestamp      time.Time `json:"timestamp"`
+	// EventIndex tells apart several deposits in one transaction.
+	EventIndex int64 `json:"event_index"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const insertDepositQuery = `
+        INSERT INTO soroswap_deposits (
+            pair_address, provider, amount_0, amount_1, liquidity,
+            ledger_sequence, tx_hash, operation_index, event_index, deposited_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, pair_address, event_index) DO NOTHING
+    `
+
+func init() {
+	registerHandlerQuery(insertDepositQuery)
+	registerRetentionTable(retentionTable{Name: "deposits", Table: "soroswap_deposits", Column: "deposited_at"})
+	registerLedgerTable(ledgerTable{Table: "soroswap_deposits"})
+	registerPairTable(pairTable{
+		Name:   "soroswap_deposits",
+		Count:  "SELECT COUNT(*) FROM soroswap_deposits WHERE pair_address = ?",
+		Delete: deleteByID("soroswap_deposits"),
+	})
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// amounts. Amounts are canonicalized beforehand.
+func (e *DepositEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.Provider); err != nil {
+		return err
+	}
+	if e.Amount0 == "" || e.Amount1 == "" || e.Liquidity == "" {
+		return fmt.Errorf("amount_0, amount_1 and liquidity are required")
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleDeposit(ctx context.Context, eventType string, event DepositEvent) error {
+	if event.ContractID == "" {
+		return fmt.Errorf("invalid deposit event data: missing contract_id")
+	}
+	depositedAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.ContractID, err)
+	}
+	// The minted shares are credited to the provider.
+	shares, _ := parseAmount(string(event.Liquidity))
+	credit := func(tx *sql.Tx) error {
+		return s.addPosition(ctx, tx, event.ContractID, event.Provider, event.LedgerSequence, shares)
+	}
+	if replayTables(ctx) != nil {
+		return s.replayPositions(ctx, "deposit", event.TxHash, event.ContractID, event.EventIndex, credit)
+	}
+	return s.storePairEvent(ctx, eventType, event.ContractID,
+		func(st PairStore) (bool, error) { return st.RecordDeposit(ctx, event, depositedAt.Value) },
+		func(tx *sql.Tx) error {
+			if err := s.addTotalSupply(ctx, tx, event.ContractID, shares); err != nil {
+				return err
+			}
+			return credit(tx)
+		},
+	)
+}
+
+// WithdrawEvent records liquidity removed from a pair (the pair's withdraw
+// event, also accepted as "burn").
+type WithdrawEvent struct {
+	Type       string `json:"type"`
+	ContractID string `json:"contract_id"`
+	// Recipient is the address receiving the withdrawn tokens.
+	Recipient string `json:"recipient"`
+	Amount0   Amount `json:"amount_0"`
+	Amount1   Amount `json:"amount_1"`
+	Liquidity Amount `json:"liquidity"`
+	TxHash    string `json:"tx_hash"`
+	// OperationIndex is the operation within the transaction.
+	OperationIndex int64     `json:"operation_index"`
+	Timestamp      time.Time `json:"timestamp"`
+	// EventIndex tells apart several withdrawals in one transaction.
+	EventIndex int64 `json:"event_index"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const insertWithdrawQuery = `
+        INSERT INTO soroswap_withdrawals (
+            pair_address, recipient, amount_0, amount_1, liquidity,
+            ledger_sequence, tx_hash, operation_index, event_index, withdrawn_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, pair_address, event_index) DO NOTHING
+    `
+
+func init() {
+	registerHandlerQuery(insertWithdrawQuery)
+	registerRetentionTable(retentionTable{Name: "withdrawals", Table: "soroswap_withdrawals", Column: "withdrawn_at"})
+	registerLedgerTable(ledgerTable{Table: "soroswap_withdrawals"})
+	registerPairTable(pairTable{
+		Name:   "soroswap_withdrawals",
+		Count:  "SELECT COUNT(*) FROM soroswap_withdrawals WHERE pair_address = ?",
+		Delete: deleteByID("soroswap_withdrawals"),
+	})
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// amounts. Amounts are canonicalized beforehand.
+func (e *WithdrawEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.Recipient); err != nil {
+		return err
+	}
+	if e.Amount0 == "" || e.Amount1 == "" || e.Liquidity == "" {
+		return fmt.Errorf("amount_0, amount_1 and liquidity are required")
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleWithdraw(ctx context.Context, eventType string, event WithdrawEvent) error {
+	if event.ContractID == "" {
+		return fmt.Errorf("invalid withdraw event data: missing contract_id")
+	}
+	withdrawnAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.ContractID, err)
+	}
+	// A pair burns the shares it holds itself, which the provider
+	// transferred to it beforehand.
+	shares, _ := parseAmount(string(event.Liquidity))
+	debit := func(tx *sql.Tx) error {
+		return s.addPosition(ctx, tx, event.ContractID, event.ContractID, event.LedgerSequence, new(big.Int).Neg(shares))
+	}
+	if replayTables(ctx) != nil {
+		return s.replayPositions(ctx, "withdraw", event.TxHash, event.ContractID, event.EventIndex, debit)
+	}
+	return s.storePairEvent(ctx, eventType, event.ContractID,
+		func(st PairStore) (bool, error) { return st.RecordWithdrawal(ctx, event, withdrawnAt.Value) },
+		func(tx *sql.Tx) error {
+			if err := s.addTotalSupply(ctx, tx, event.ContractID, new(big.Int).Neg(shares)); err != nil {
+				return err
+			}
+			return debit(tx)
+		},
+	)
+}
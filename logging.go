@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger is the plugin's structured logger, writing to stderr. It is not
+// installed as slog's default, so the host's logging is left alone.
+var logger = newLogger(os.Stderr, "text", slog.LevelInfo)
+
+// configureLogging reads log_level (debug, info, warn or error, default
+// info) and log_format (text or json, default text). Per-event messages are
+// logged at debug level.
+func configureLogging(cfg *Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("config log_level: expected debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	format := strings.ToLower(cfg.LogFormat)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("config log_format: expected text or json, got %q", format)
+	}
+	logger = newLogger(os.Stderr, format, level)
+	return nil
+}
+
+func newLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
@@ -1,23 +1,165 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/withObsrvr/pluginapi"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc"
 )
 
 // SaveSoroswapPairsToSQLite implements the pluginapi.Consumer interface
 type SaveSoroswapPairsToSQLite struct {
-	db      *sql.DB
-	dbPath  string
-	name    string
-	version string
+	db *sql.DB
+	// readDB runs queries; it is db unless the backend opens a separate
+	// read pool.
+	readDB  *sql.DB
+	backend backend
+	stmts   *statements
+	// newStore, when set, replaces the SQL PairStore used by the handlers
+	newStore func(tx *sql.Tx) PairStore
+	stats    *statsCollector
+	// timestamps validates event timestamps before they are stored
+	timestamps *timestampValidator
+	// reserveHistory appends every sync to pair_reserve_history
+	reserveHistory bool
+	// historyHasPrice is set when pair_reserve_history carries derived prices
+	historyHasPrice bool
+	// dryRun rolls back every handler transaction, recording findings only
+	dryRun bool
+	// partitions routes inserts into tables partitioned by month, nil
+	// unless partition_by_month is set
+	partitions *monthPartitions
+	// sqlHooks are the operator's statements run in each event's
+	// transaction, by event type
+	sqlHooks map[string]*writeHooks
+	// dryRunReport writes dry-run findings to dry_run_report
+	dryRunReport bool
+	// runID identifies this run's rows in dry_run_report
+	runID       string
+	dryRunMu    sync.Mutex
+	dryRunPairs map[string]bool
+	// archiveRawEvents appends every payload to raw_events
+	archiveRawEvents bool
+	// dedupEvents skips events already recorded in processed_events
+	dedupEvents bool
+	// writeLock serializes live processing with Reprocess. Each message
+	// being processed holds one of its writeSlots; lockWrites takes all.
+	writeLock  *semaphore.Weighted
+	writeSlots int64
+	// pool processes events in parallel by pair when workers is set
+	pool *workerPool
+	// eventTxMu serializes event transactions of parallel workers on
+	// SQLite, which allows one writer at a time
+	eventTxMu sync.Mutex
+	// flow applies the optional rate limit and pending-event bound
+	flow *flowControl
+	// batch groups event transactions when batch_size is set
+	batch *eventBatch
+	// alertRules are evaluated against every sync's reserve change
+	alertRules []alertRule
+	// counters are the lifetime per-type counters kept in consumer_counters
+	counters *persistentCounters
+	// ingestErrors counts failed events by error category, kept in
+	// ingest_errors
+	ingestErrors *ingestErrorLog
+	// queryPlans holds the latest canonical query plan check results
+	queryPlans queryPlanState
+	// background jobs run until Close
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+	bgWG     sync.WaitGroup
+	// health tracks consecutive failures for Status
+	health *healthTracker
+	// analytics configures ExportAnalyticsDB
+	analytics analyticsExport
+	// csv configures the CSV export run at startup
+	csv csvExport
+	// parquet configures ExportParquet
+	parquet parquetExport
+	// backup configures the scheduled backups
+	backup backupSchedule
+	// maintenance is the daily maintenance window, nil when unset
+	maintenance *maintenanceWindow
+	replication replicationConfig
+	// shipper ships the WAL to replication_s3_path, nil when unset
+	shipper *walShipper
+	// backupsRunning counts running backups, during which checkpoints do
+	// not restart the WAL.
+	backupsRunning atomic.Int32
+	// busy retries events that failed on a locked database
+	busy *busyRetry
+	// shutdown refuses messages once Close starts and drains the rest
+	shutdown shutdownState
+	// tracing exports spans of event processing, a no-op when unset
+	tracing *tracing
+	// amountTolerance is the fractional part rounded away from amounts
+	amountTolerance *big.Rat
+	// retention is how long rows of the prunable tables are kept
+	retention retentionPolicy
+	// statsRollups maintains pair_stats_hourly and pair_stats_daily
+	statsRollups bool
+	// candleResolutions are the candle sizes maintained in pair_candles
+	candleResolutions []candleResolution
+	// tokens configures the optional token metadata lookups
+	tokens tokenEnrichment
+	// usd configures the optional USD prices derived from pairs
+	usd usdPricing
+	// oracle cross-checks USD prices against a Reflector oracle, nil if off
+	oracle *reflectorOracle
+	// filter restricts which pairs are stored
+	filter pairFilter
+	// minReserve marks pairs with a smaller reserve inactive and keeps
+	// their syncs out of the history tables, nil if unset
+	minReserve *big.Int
+	// createMissingPairs stores placeholders for syncs of unknown pairs
+	createMissingPairs bool
+	// pendingSyncs queues syncs of unknown pairs until their new_pair
+	pendingSyncs bool
+	// payloadContentType picks the codec of messages without content-type
+	// metadata
+	payloadContentType string
+	// strictMode fails events of unknown types and pairs instead of
+	// dead-lettering and skipping them
+	strictMode bool
+	// network is the Stellar network pairs are tagged with, "" if unset
+	network string
+	// backfill re-ingests historical events: no alerts, no warnings for
+	// unknown pairs or stale syncs, and no duplicate history points
+	backfill bool
+	// checkpoint is the highest handled ledger and latest cursor
+	checkpoint checkpointTracker
+	// webhook is notified of new pairs and alerts, nil when unset
+	webhook *webhookSink
+	// forward publishes persisted events to the event sinks, nil when none
+	forward *forwarder
+	// downstream are the consumers registered through RegisterConsumer
+	downstream downstreamSink
+	// metrics holds the latency histograms served on the metrics endpoint
+	metrics *latencyMetrics
+	// probes configures the /healthz and /readyz checks
+	probes probeConfig
+	// httpServers serve the configured HTTP endpoints until Close
+	httpServers []*http.Server
+	// grpcServer serves the PairService when grpc_addr is set
+	grpcServer *grpc.Server
+	// volumeStats maintains rolling per-pair swap volume
+	volumeStats bool
+	dbPath      string
+	name        string
+	version     string
 }
 
 // Event types
@@ -27,22 +169,90 @@ type NewPairEvent struct {
 	Token0      string    `json:"token_0"`
 	Token1      string    `json:"token_1"`
 	Timestamp   time.Time `json:"timestamp"`
+	// LedgerSequence is the ledger the pair was created in, when the
+	// processor provides it.
+	LedgerSequence int64 `json:"ledger_sequence,omitempty"`
+	// Reserve0/Reserve1 are the reserves after the creating transaction's
+	// deposit, sent by newer processors. Both or neither must be set.
+	Reserve0 Amount `json:"reserve_0,omitempty"`
+	Reserve1 Amount `json:"reserve_1,omitempty"`
+
+	// Factory, if set, is the factory that created the pair, whose pair
+	// count in factory_state is then kept. NewPairsLength is the count
+	// the factory reported with the pair, if any.
+	Factory        string `json:"factory,omitempty"`
+	NewPairsLength int64  `json:"new_pairs_length,omitempty"`
+
+	// TxHash, OperationIndex and EventIndex locate the creating event.
+	TxHash         string `json:"tx_hash,omitempty"`
+	OperationIndex int64  `json:"operation_index,omitempty"`
+	EventIndex     int64  `json:"event_index,omitempty"`
 }
 
 type SyncEvent struct {
 	Type           string    `json:"type"`
 	ContractID     string    `json:"contract_id"`
-	NewReserve0    string    `json:"new_reserve_0"`
-	NewReserve1    string    `json:"new_reserve_1"`
+	NewReserve0    Amount    `json:"new_reserve_0"`
+	NewReserve1    Amount    `json:"new_reserve_1"`
 	Timestamp      time.Time `json:"timestamp"`
 	LedgerSequence int64     `json:"ledger_sequence"`
+	TxHash         string    `json:"tx_hash"`
+	OperationIndex int64     `json:"operation_index"`
+	EventIndex     int64     `json:"event_index"`
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// optional initial reserves come in pairs of valid reserves.
+func (e *NewPairEvent) normalize() error {
+	if err := normalizeAddresses(&e.PairAddress, &e.Token0, &e.Token1, &e.Factory); err != nil {
+		return err
+	}
+	if (e.Reserve0 == "") != (e.Reserve1 == "") {
+		return fmt.Errorf("initial reserves need both reserve_0 and reserve_1")
+	}
+	if !e.hasReserves() {
+		return nil
+	}
+	if err := checkReserve("reserve_0", e.Reserve0); err != nil {
+		return err
+	}
+	return checkReserve("reserve_1", e.Reserve1)
+}
+
+// hasReserves reports whether the event carries initial reserves.
+func (e *NewPairEvent) hasReserves() bool {
+	return e.Reserve0 != ""
+}
+
+func (e *NewPairEvent) ref() EventRef {
+	return EventRef{TxHash: e.TxHash, OperationIndex: e.OperationIndex, EventIndex: e.EventIndex}
+}
+
+// normalize canonicalizes the event's address fields and checks its
+// reserves.
+func (e *SyncEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID); err != nil {
+		return err
+	}
+	if err := checkReserve("new_reserve_0", e.NewReserve0); err != nil {
+		return err
+	}
+	return checkReserve("new_reserve_1", e.NewReserve1)
+}
+
+func (e *SyncEvent) ref() EventRef {
+	return EventRef{TxHash: e.TxHash, OperationIndex: e.OperationIndex, EventIndex: e.EventIndex}
 }
 
 // New creates a new instance of the plugin
 func New() pluginapi.Plugin {
 	return &SaveSoroswapPairsToSQLite{
-		name:    "SaveSoroswapPairsToSQLite",
-		version: "1.0.0",
+		name:       "SaveSoroswapPairsToSQLite",
+		version:    "1.0.0",
+		stats:      newStatsCollector(),
+		metrics:    newLatencyMetrics(),
+		writeLock:  semaphore.NewWeighted(1),
+		writeSlots: 1,
 	}
 }
 
@@ -61,214 +271,1135 @@ func (s *SaveSoroswapPairsToSQLite) Type() pluginapi.PluginType {
 	return pluginapi.ConsumerPlugin
 }
 
-// Initialize sets up the SQLite database
+// Initialize sets up the database, SQLite unless another driver is configured
 func (s *SaveSoroswapPairsToSQLite) Initialize(config map[string]interface{}) error {
-	dbPath, ok := config["db_path"].(string)
-	if !ok {
-		dbPath = "soroswap_pairs.sqlite"
+	config, err := expandConfigEnv(config)
+	if err != nil {
+		return err
+	}
+	cfg, err := decodeConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := configureLogging(&cfg); err != nil {
+		return err
+	}
+	b, err := newBackend(&cfg)
+	if err != nil {
+		return err
+	}
+	s.backend = b
+
+	timestamps, err := newTimestampValidator(&cfg)
+	if err != nil {
+		return err
+	}
+	s.timestamps = timestamps
+
+	s.health = newHealthTracker(&cfg)
+
+	flow, err := newFlowControl(&cfg)
+	if err != nil {
+		return err
+	}
+	s.flow = flow
+
+	if s.payloadContentType, err = parsePayloadContentType(&cfg); err != nil {
+		return err
+	}
+	if s.alertRules, err = parseAlertRules(&cfg); err != nil {
+		return err
+	}
+	if s.candleResolutions, err = parseCandleResolutions(&cfg); err != nil {
+		return err
+	}
+	s.statsRollups = cfg.StatsRollups
+	if s.amountTolerance, err = parseAmountTolerance(&cfg); err != nil {
+		return err
+	}
+	if s.batch, err = parseEventBatch(&cfg); err != nil {
+		return err
+	}
+	if s.busy, err = parseBusyRetry(&cfg); err != nil {
+		return err
+	}
+	workers, err := parseWorkers(&cfg)
+	if err != nil {
+		return err
+	}
+	if workers > 1 && s.batch.enabled() {
+		return fmt.Errorf("config workers cannot be combined with batch_size or batch_by_ledger, whose batch is shared by all events")
+	}
+	if s.flow.maxPending > 0 && int64(s.batch.size) > s.flow.maxPending {
+		return fmt.Errorf("config batch_size %d is over max_pending_events %d, which bounds the events a batch holds", s.batch.size, s.flow.maxPending)
+	}
+	if s.tracing, err = parseTracing(&cfg, s.version); err != nil {
+		return err
+	}
+	if s.shutdown.timeout, err = parseShutdownTimeout(&cfg); err != nil {
+		return err
+	}
+	if s.tokens, err = parseTokenEnrichment(&cfg); err != nil {
+		return err
+	}
+	if s.usd, err = parseUSDPricing(&cfg); err != nil {
+		return err
+	}
+	if s.oracle, err = parseReflectorOracle(&cfg, s.usd); err != nil {
+		return err
+	}
+	if s.filter, err = parsePairFilter(&cfg); err != nil {
+		return err
+	}
+	if s.minReserve, err = parseMinReserve(&cfg); err != nil {
+		return err
+	}
+	if s.webhook, err = parseWebhook(&cfg); err != nil {
+		return err
+	}
+	if s.retention, err = parseRetention(&cfg); err != nil {
+		return err
+	}
+	if s.forward, err = parseForwarder(&cfg); err != nil {
+		return err
+	}
+	s.downstream.enrich = cfg.DownstreamEnrich
+	if len(s.downstream.consumers) > 0 {
+		s.addSink(&s.downstream)
+	}
+	if s.analytics, err = parseAnalyticsExport(&cfg); err != nil {
+		return err
+	}
+	if s.csv, err = parseCSVExport(&cfg); err != nil {
+		return err
+	}
+	if s.parquet, err = parseParquetExport(&cfg); err != nil {
+		return err
+	}
+	if s.backup, err = parseBackup(&cfg); err != nil {
+		return err
+	}
+	if _, ok := b.(*sqliteBackend); !ok && s.backup.interval > 0 {
+		return fmt.Errorf("config backup_interval requires the sqlite3 driver")
+	}
+	if s.maintenance, err = parseMaintenance(&cfg); err != nil {
+		return err
+	}
+	if _, ok := b.(*sqliteBackend); !ok && s.maintenance != nil {
+		return fmt.Errorf("config maintenance_window requires the sqlite3 driver")
+	}
+	if s.replication, err = parseReplication(&cfg); err != nil {
+		return err
+	}
+	if err := s.configureReplication(b); err != nil {
+		return err
+	}
+	if _, ok := b.(*sqliteBackend); !ok && cfg.PartitionByMonth {
+		return fmt.Errorf("config partition_by_month requires the sqlite3 driver")
+	}
+	indexes, err := parseExtraIndexes(&cfg)
+	if err != nil {
+		return err
+	}
+	if s.sqlHooks, err = parseSQLHooks(&cfg); err != nil {
+		return err
+	}
+	views, err := parseViewConfig(&cfg)
+	if err != nil {
+		return err
+	}
+	s.network = normalizeNetwork(cfg.Network)
+	s.dryRun = cfg.DryRun
+	s.dryRunReport = cfg.DryRunReport
+	if sb, ok := b.(*sqliteBackend); ok {
+		s.dbPath = sb.path
+	}
+
+	ctx := context.Background()
+	db, err := b.Open(ctx)
+	if err != nil {
+		return err
 	}
-	s.dbPath = dbPath
 
-	// Open SQLite connection
-	db, err := sql.Open("sqlite3", dbPath)
+	unlockSchema, err := b.LockSchema(ctx, db)
 	if err != nil {
-		return fmt.Errorf("failed to open SQLite: %v", err)
-	}
-
-	// Verify connection
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping SQLite: %v", err)
-	}
-
-	// Set pragmas for better performance
-	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA synchronous=NORMAL;"); err != nil {
-		return fmt.Errorf("failed to set SQLite pragmas: %v", err)
-	}
-
-	// Create table with proper constraints
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS soroswap_pairs (
-            pair_address TEXT NOT NULL PRIMARY KEY,
-            token_0 TEXT NOT NULL,
-            token_1 TEXT NOT NULL,
-            reserve_0 TEXT NOT NULL DEFAULT '0',
-            reserve_1 TEXT NOT NULL DEFAULT '0',
-            created_at TIMESTAMP NOT NULL,
-            last_sync_at TIMESTAMP,
-            last_sync_ledger INTEGER,
-            
-            -- Add constraints to prevent empty strings
-            CHECK (length(pair_address) > 0),
-            CHECK (length(token_0) > 0),
-            CHECK (length(token_1) > 0)
-        );
-
-        -- Add an index for faster token lookups
-        CREATE INDEX IF NOT EXISTS idx_tokens ON soroswap_pairs(token_0, token_1);
-    `)
+		db.Close()
+		return err
+	}
+	if s.dryRun {
+		err = checkSchemaCurrent(ctx, db, b)
+	} else {
+		err = createSchema(ctx, db, b)
+	}
+	if err == nil {
+		s.partitions, err = setupPartitions(ctx, db, b, cfg.PartitionByMonth, s.dryRun)
+	}
+	if err == nil && !s.dryRun {
+		err = createExtraIndexes(ctx, db, b, s.partitions, indexes)
+	}
+	if err == nil && !s.dryRun {
+		err = rebuildViews(ctx, db, b, views)
+	}
+	unlockSchema()
 	if err != nil {
-		return fmt.Errorf("failed to create soroswap_pairs table: %v", err)
+		db.Close()
+		return err
+	}
+
+	s.reserveHistory = cfg.ReserveHistory
+	s.createMissingPairs = cfg.CreateMissingPairs
+	s.pendingSyncs = cfg.PendingSyncs
+	s.strictMode = cfg.StrictMode
+	if s.pendingSyncs && s.createMissingPairs {
+		db.Close()
+		return fmt.Errorf("config pending_syncs and create_missing_pairs are exclusive: placeholders take the syncs a queue would hold")
+	}
+	s.volumeStats = cfg.VolumeStats
+	s.backfill = cfg.Backfill
+	s.archiveRawEvents = cfg.ArchiveRawEvents
+	s.dedupEvents = cfg.DedupEvents
+	s.runID = time.Now().UTC().Format(time.RFC3339Nano)
+	s.dryRunPairs = make(map[string]bool)
+	if s.historyHasPrice, err = b.ColumnExists(ctx, db, "pair_reserve_history", "price_0_1"); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to inspect pair_reserve_history: %v", err)
 	}
 
+	stmts, err := prepareStatements(ctx, db, b, s.partitions.routes)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	stmts.metrics = s.metrics
 	s.db = db
-	log.Printf("SQLite database initialized at %s", dbPath)
+	s.stmts = stmts
+	if s.readDB, err = b.OpenReader(ctx, db); err != nil {
+		db.Close()
+		return err
+	}
+	// A dry run records its findings while the event's transaction, which
+	// it rolls back rather than commits, is still open.
+	if s.dryRun && db.Stats().MaxOpenConnections == 1 {
+		db.SetMaxOpenConns(2)
+	}
+
+	s.counters = newPersistentCounters(cfg.CounterFlushEvery)
+	if err := s.loadCounters(ctx); err != nil {
+		s.closeDB()
+		return err
+	}
+	s.ingestErrors = newIngestErrorLog()
+	if err := s.loadIngestErrors(ctx); err != nil {
+		s.closeDB()
+		return err
+	}
+	if err := s.loadCheckpoint(ctx); err != nil {
+		s.closeDB()
+		return err
+	}
+	if err := s.adoptNetwork(ctx); err != nil {
+		s.closeDB()
+		return err
+	}
+
+	s.probes = parseProbeConfig(&cfg)
+	endpoints := httpEndpoints{}
+	if cfg.MetricsAddr != "" {
+		endpoints.handle(cfg.MetricsAddr, "/metrics", s.metricsHandler())
+	}
+	if cfg.HealthAddr != "" {
+		endpoints.handle(cfg.HealthAddr, "/healthz", probeHandler(s.Healthy))
+		endpoints.handle(cfg.HealthAddr, "/readyz", probeHandler(s.Ready))
+	}
+	if cfg.APIAddr != "" {
+		api := s.apiHandler()
+		endpoints.handle(cfg.APIAddr, "/pairs", api)
+		endpoints.handle(cfg.APIAddr, "/pairs/", api)
+		endpoints.handle(cfg.APIAddr, "/tokens/", api)
+	}
+	if cfg.GraphQLAddr != "" {
+		schema, err := s.graphQLSchema()
+		if err != nil {
+			s.closeDB()
+			return fmt.Errorf("failed to build GraphQL schema: %v", err)
+		}
+		endpoints.handle(cfg.GraphQLAddr, "/graphql", graphQLHandler(schema))
+	}
+	if cfg.AdminAddr != "" {
+		endpoints.handle(cfg.AdminAddr, "/export/", s.exportHandler())
+		endpoints.handle(cfg.AdminAddr, "/rollback", s.rollbackHandler())
+		if _, ok := s.backend.(*sqliteBackend); ok {
+			endpoints.handle(cfg.AdminAddr, "/checkpoint", s.walCheckpointHandler())
+		}
+		if sb, ok := s.backend.(*sqliteBackend); ok && sb.key.get() != "" {
+			endpoints.handle(cfg.AdminAddr, "/rekey", s.rekeyHandler())
+		}
+	}
+	if err := s.startHTTP(endpoints); err != nil {
+		s.closeDB()
+		return err
+	}
+	if cfg.GRPCAddr != "" {
+		if err := s.startGRPC(cfg.GRPCAddr); err != nil {
+			s.stopHTTP()
+			s.closeDB()
+			return err
+		}
+	}
+
+	s.bgCtx, s.bgCancel = context.WithCancel(context.Background())
+
+	if err := s.checkQueryPlans(ctx); err != nil {
+		logger.Error("Query plan check failed", "error", err)
+	}
+	s.startBackground("query plan check", cfg.QueryPlanCheckInterval, s.checkQueryPlans)
+	if len(s.retention.keep) > 0 {
+		s.startBackground("retention pruning", s.retention.interval, s.pruneRetention)
+	}
+	if s.volumeStats {
+		s.startBackground("volume refresh", cfg.VolumeStatsRefreshInterval, s.refreshVolumeStats)
+	}
+	if s.tokens.rpc != nil {
+		s.startBackground("token enrichment", s.tokens.interval, s.enrichTokens)
+	}
+	if s.oracle != nil && !s.dryRun {
+		s.startBackground("reflector oracle", s.oracle.interval, s.checkOracle)
+	}
+	if s.webhook != nil && !s.dryRun {
+		s.startWebhook()
+	}
+	if s.batching() {
+		s.startBackground("batch flush", s.batch.interval, s.flushBatchOnTimer)
+	}
+	if workers > 1 {
+		s.writeLock = semaphore.NewWeighted(int64(workers))
+		s.writeSlots = int64(workers)
+		s.pool = s.startWorkers(workers)
+	}
+	s.startBackground("analytics export", s.analytics.interval, func(ctx context.Context) error {
+		return s.ExportAnalyticsDB(ctx, s.analytics.path)
+	})
+	s.startBackground("parquet export", s.parquet.interval, func(ctx context.Context) error {
+		return s.ExportParquet(ctx, s.parquet.dest)
+	})
+	s.startBackground("backup", s.backup.interval, s.backupScheduled)
+	if s.maintenance != nil {
+		s.startBackground("maintenance", maintenanceCheckInterval, s.maintainInWindow)
+	}
+	s.startBackground("WAL checkpoint", s.replication.checkpointInterval, s.checkpointWALScheduled)
+	if s.shipper != nil {
+		s.startBackground("WAL shipping", s.replication.shipInterval, s.shipWAL)
+	}
+	if s.csv.onStart {
+		if _, err := s.ExportCSV(ctx, s.csv.dir, s.csv.opts); err != nil {
+			logger.Error("CSV export failed", "error", err)
+		}
+	}
+	if s.dryRun {
+		logger.Info("Dry run enabled: no changes will be committed", "run_id", s.runID)
+	}
+	if s.dbPath != "" {
+		logger.Info("SQLite database initialized", "path", s.dbPath)
+	} else {
+		logger.Info("Database initialized", "backend", b.Name())
+	}
 	return nil
 }
 
-// Process handles incoming messages
-func (s *SaveSoroswapPairsToSQLite) Process(ctx context.Context, msg pluginapi.Message) error {
+// Process handles incoming messages. A payload may also be a JSON array of
+// events, which are processed in order.
+func (s *SaveSoroswapPairsToSQLite) Process(ctx context.Context, msg pluginapi.Message) (err error) {
+	ctx, leave, ok := s.shutdown.enter(ctx)
+	if !ok {
+		return ErrShuttingDown
+	}
+	defer leave()
+	msg = s.normalizePayload(msg)
+
+	// A rollback blocks processing itself and may outlast the timeout.
+	if ledger, opts, ok := rollbackRequest(msg); ok {
+		_, err := s.Rollback(ctx, ledger, opts)
+		return err
+	}
+
 	// Add timeout to context
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	msgs := splitBatch(msg)
+	ctx, span := s.startSpan(s.traceContext(ctx, msg.Metadata), "Process",
+		attribute.Int("soroswap.batch_size", len(msgs)))
+	defer func() { endSpan(span, err) }()
+	release, err := s.flow.admit(ctx, len(msgs))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockEvents(ctx)
+	if err != nil {
+		release()
+		return err
+	}
+	defer func() {
+		s.holdAdmission(release)
+		unlock()
+	}()
+
+	if s.pool != nil {
+		return s.pool.process(ctx, s, msgs)
+	}
+	var errs []error
+	for _, m := range msgs {
+		if err := s.processOne(ctx, m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitBatch expands a JSON array payload into one message per element.
+// Anything else, including malformed arrays, is returned as is and reported
+// by processMessage.
+func splitBatch(msg pluginapi.Message) []pluginapi.Message {
+	payload, ok := msg.Payload.([]byte)
+	if !ok {
+		return []pluginapi.Message{msg}
+	}
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return []pluginapi.Message{msg}
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(trimmed, &elems); err != nil {
+		return []pluginapi.Message{msg}
+	}
+	msgs := make([]pluginapi.Message, len(elems))
+	for i, elem := range elems {
+		msgs[i] = pluginapi.Message{Payload: []byte(elem), Metadata: msg.Metadata, Timestamp: msg.Timestamp}
+	}
+	return msgs
+}
+
+// processOne handles a single event and accounts for the result.
+func (s *SaveSoroswapPairsToSQLite) processOne(ctx context.Context, msg pluginapi.Message) error {
+	start := time.Now()
+	s.startLedger(ctx, msg)
+	ctx, span := s.startSpan(ctx, "processEvent")
+	eventType, err := s.processWithRetry(ctx, msg)
+	if err != nil && !s.dryRun && replayTables(ctx) == nil {
+		s.ingestErrors.record(eventType, msg, err)
+	}
+	err = s.skipLenient(ctx, eventType, msg, err)
+	span.SetAttributes(attribute.String("soroswap.event_type", eventType))
+	endSpan(span, err)
+	s.metrics.observeEvent(eventType, time.Since(start))
+	s.stats.recordEvent(eventType, err)
+	s.recordCheckpoint(ctx, msg)
+	if replayTables(ctx) == nil {
+		delta := EventCounters{Processed: 1}
+		if err != nil {
+			delta = EventCounters{Failed: 1}
+		}
+		// A batch flushes the counters once it commits.
+		if s.counters.add(eventType, delta) && !s.batching() {
+			if err := s.flushCounters(ctx); err != nil {
+				logger.Error("Failed to flush counters", "error", err)
+			}
+		}
+	}
+	if err != nil && !s.dryRun && replayTables(ctx) == nil && !s.shutdown.aborted() {
+		s.deadLetter(ctx, eventType, msg, err)
+	}
+	if err != nil && !s.dryRun && s.health != nil {
+		s.health.recordFailure(err)
+	}
+	if err == nil && s.health != nil {
+		s.health.recordEvent()
+	}
+	if err != nil && s.dryRun {
+		// Findings are the point of a dry run; keep the pipeline going.
+		logger.Info("Dry run: event failed validation", "event_type", eventType, "error", err)
+		s.stats.recordOutcome(outcomeInvalid)
+		s.recordDryRun(ctx, eventType, outcomeInvalid, "", err.Error())
+		return nil
+	}
+	return err
+}
+
+// lockWrites serializes writers so a Reprocess run never interleaves with
+// live processing. Any open event batch is committed first, so the caller
+// sees every processed event and can write outside it. It gives up when
+// ctx is done.
+func (s *SaveSoroswapPairsToSQLite) lockWrites(ctx context.Context) (func(), error) {
+	unlock, err := s.lockExclusive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.flushBatch(ctx); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// lockEvents takes a slot of the write lock for processing a message.
+// Without a worker pool there is one slot, so messages are processed one at
+// a time.
+func (s *SaveSoroswapPairsToSQLite) lockEvents(ctx context.Context) (func(), error) {
+	return s.acquireWriteLock(ctx, 1)
+}
+
+// lockExclusive takes every slot of the write lock, waiting for the
+// messages being processed, and leaves any open batch open.
+func (s *SaveSoroswapPairsToSQLite) lockExclusive(ctx context.Context) (func(), error) {
+	return s.acquireWriteLock(ctx, s.writeSlots)
+}
+
+func (s *SaveSoroswapPairsToSQLite) acquireWriteLock(ctx context.Context, n int64) (func(), error) {
+	lock := s.writeLock
+	if err := lock.Acquire(ctx, n); err != nil {
+		return nil, err
+	}
+	return func() { lock.Release(n) }, nil
+}
+
+// processMessage decodes and handles one message, returning its event type
+// for accounting. Events it persisted are forwarded to the event sinks.
+func (s *SaveSoroswapPairsToSQLite) processMessage(ctx context.Context, msg pluginapi.Message) (string, error) {
+	res := &eventResult{}
+	eventType, err := s.dispatchMessage(context.WithValue(ctx, eventResultKey{}, res), msg)
+	// An event whose batch failed under it was forwarded when reapplied.
+	if attempt, _ := ctx.Value(eventAttemptKey{}).(*eventAttempt); err == nil && (attempt == nil || !attempt.reapplied) {
+		s.forwardEvent(ctx, eventType, res, msg)
+	}
+	return eventType, err
+}
+
+// dispatchMessage decodes one message and runs its handler.
+func (s *SaveSoroswapPairsToSQLite) dispatchMessage(ctx context.Context, msg pluginapi.Message) (string, error) {
+	if p, ok := msg.Payload.(undecodablePayload); ok {
+		return "", p.err
+	}
 	jsonBytes, ok := msg.Payload.([]byte)
 	if !ok {
-		log.Printf("Error: expected []byte, got %T", msg.Payload)
-		return fmt.Errorf("expected []byte, got %T", msg.Payload)
+		logger.Error("Unexpected payload type", "type", fmt.Sprintf("%T", msg.Payload))
+		return "", fmt.Errorf("expected []byte or a JSON-encodable event, got %T", msg.Payload)
 	}
 
 	// First unmarshal into a temporary struct to check the type
 	var temp struct {
-		Type string `json:"type"`
+		Type              string          `json:"type"`
+		LedgerSequence    int64           `json:"ledger_sequence"`
+		Network           string          `json:"network"`
+		NetworkPassphrase string          `json:"network_passphrase"`
+		Timestamp         json.RawMessage `json:"timestamp"`
 	}
 	if err := json.Unmarshal(jsonBytes, &temp); err != nil {
-		return fmt.Errorf("error decoding event type: %w", err)
+		return "", fmt.Errorf("error decoding event type: %w", err)
+	}
+	if err := s.checkEventNetwork(temp.Network, temp.NetworkPassphrase, msg); err != nil {
+		return temp.Type, err
+	}
+	if temp.Type == "rollback" {
+		return temp.Type, fmt.Errorf("rollback must be sent as a message of its own, not in a batch")
 	}
 
-	log.Printf("Processing event type: %s", temp.Type)
+	ledger := temp.LedgerSequence
+	if ledger == 0 {
+		ledger, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+	}
+	ctx, seen, err := s.checkProcessed(ctx, temp.Type, ledger, jsonBytes)
+	if err != nil {
+		return temp.Type, err
+	}
+	if seen {
+		logger.Debug("Skipping redelivered event", "event_type", temp.Type, "ledger", ledger)
+		s.recordOutcome(ctx, temp.Type, outcomeDuplicate, "")
+		return temp.Type, nil
+	}
+
+	eventTime, canonical, err := parseEventTime(temp.Timestamp, msg.Metadata)
+	if err != nil {
+		return temp.Type, fmt.Errorf("error decoding event timestamp: %w", err)
+	}
+	if !canonical {
+		// Handlers decode RFC3339 only.
+		if jsonBytes, err = withTimestamp(jsonBytes, eventTime); err != nil {
+			return temp.Type, fmt.Errorf("error decoding event: %w", err)
+		}
+	}
+
+	if ctx, err = s.withSQLHooks(ctx, temp.Type, jsonBytes); err != nil {
+		return temp.Type, err
+	}
+
+	retry, _ := ctx.Value(deadLetterRetryKey{}).(bool)
+	attempt, _ := ctx.Value(eventAttemptKey{}).(*eventAttempt)
+	if s.archiveRawEvents && !s.dryRun && replayTables(ctx) == nil && !retry && (attempt == nil || !attempt.archived) {
+		if err := s.archiveRawEvent(ctx, temp.Type, ledger, eventTime, msg); err != nil {
+			return temp.Type, err
+		}
+		if attempt != nil {
+			attempt.archived = true
+		}
+	}
+
+	logger.Debug("Processing event", "event_type", temp.Type)
 
 	switch temp.Type {
 	case "new_pair":
 		var newPairEvent NewPairEvent
 		if err := json.Unmarshal(jsonBytes, &newPairEvent); err != nil {
-			return fmt.Errorf("error decoding new pair event: %w", err)
+			return temp.Type, fmt.Errorf("error decoding new pair event: %w", err)
+		}
+		if err := s.canonicalAmounts(&newPairEvent.Reserve0, &newPairEvent.Reserve1); err != nil {
+			return temp.Type, fmt.Errorf("invalid new pair event: %w", err)
 		}
-		return s.handleNewPair(ctx, newPairEvent)
+		if err := newPairEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid new pair event: %w", err)
+		}
+		if newPairEvent.LedgerSequence == 0 {
+			newPairEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleNewPair(ctx, newPairEvent)
 
 	case "sync":
 		var syncEvent SyncEvent
 		if err := json.Unmarshal(jsonBytes, &syncEvent); err != nil {
-			return fmt.Errorf("error decoding sync event: %w", err)
+			return temp.Type, fmt.Errorf("error decoding sync event: %w", err)
+		}
+		if err := s.canonicalAmounts(&syncEvent.NewReserve0, &syncEvent.NewReserve1); err != nil {
+			return temp.Type, fmt.Errorf("invalid sync event: %w", err)
+		}
+		if err := syncEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid sync event: %w", err)
+		}
+		if syncEvent.LedgerSequence == 0 {
+			syncEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleSync(ctx, syncEvent)
+
+	case "swap":
+		var swapEvent SwapEvent
+		if err := json.Unmarshal(jsonBytes, &swapEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding swap event: %w", err)
+		}
+		if err := s.canonicalAmounts(&swapEvent.Amount0In, &swapEvent.Amount1In,
+			&swapEvent.Amount0Out, &swapEvent.Amount1Out); err != nil {
+			return temp.Type, fmt.Errorf("invalid swap event: %w", err)
+		}
+		if err := swapEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid swap event: %w", err)
+		}
+		if swapEvent.LedgerSequence == 0 {
+			swapEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleSwap(ctx, swapEvent)
+
+	case "deposit", "mint":
+		var depositEvent DepositEvent
+		if err := json.Unmarshal(jsonBytes, &depositEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding deposit event: %w", err)
+		}
+		if err := s.canonicalAmounts(&depositEvent.Amount0, &depositEvent.Amount1, &depositEvent.Liquidity); err != nil {
+			return temp.Type, fmt.Errorf("invalid deposit event: %w", err)
+		}
+		if err := depositEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid deposit event: %w", err)
+		}
+		if depositEvent.LedgerSequence == 0 {
+			depositEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
 		}
-		return s.handleSync(ctx, syncEvent)
+		return temp.Type, s.handleDeposit(ctx, temp.Type, depositEvent)
+
+	case "withdraw", "burn":
+		var withdrawEvent WithdrawEvent
+		if err := json.Unmarshal(jsonBytes, &withdrawEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding withdraw event: %w", err)
+		}
+		if err := s.canonicalAmounts(&withdrawEvent.Amount0, &withdrawEvent.Amount1, &withdrawEvent.Liquidity); err != nil {
+			return temp.Type, fmt.Errorf("invalid withdraw event: %w", err)
+		}
+		if err := withdrawEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid withdraw event: %w", err)
+		}
+		if withdrawEvent.LedgerSequence == 0 {
+			withdrawEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleWithdraw(ctx, temp.Type, withdrawEvent)
+
+	case "lp_transfer":
+		var transferEvent LPTransferEvent
+		if err := json.Unmarshal(jsonBytes, &transferEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding LP transfer event: %w", err)
+		}
+		if err := s.canonicalAmounts(&transferEvent.Amount); err != nil {
+			return temp.Type, fmt.Errorf("invalid LP transfer event: %w", err)
+		}
+		if err := transferEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid LP transfer event: %w", err)
+		}
+		if transferEvent.LedgerSequence == 0 {
+			transferEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleLPTransfer(ctx, transferEvent)
+
+	case "protocol_fee":
+		var feeEvent ProtocolFeeEvent
+		if err := json.Unmarshal(jsonBytes, &feeEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding protocol fee event: %w", err)
+		}
+		if err := s.canonicalAmounts(&feeEvent.Liquidity); err != nil {
+			return temp.Type, fmt.Errorf("invalid protocol fee event: %w", err)
+		}
+		if err := feeEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid protocol fee event: %w", err)
+		}
+		if feeEvent.LedgerSequence == 0 {
+			feeEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleProtocolFee(ctx, feeEvent)
+
+	case "factory_fee_to", "factory_fee_to_setter", "factory_fees_enabled":
+		var factoryEvent FactoryEvent
+		if err := json.Unmarshal(jsonBytes, &factoryEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding factory event: %w", err)
+		}
+		if err := factoryEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid factory event: %w", err)
+		}
+		if factoryEvent.LedgerSequence == 0 {
+			factoryEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleFactoryEvent(ctx, factoryEvent)
+
+	case "router_add_liquidity", "router_remove_liquidity":
+		var liquidityEvent RouterLiquidityEvent
+		if err := json.Unmarshal(jsonBytes, &liquidityEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding router liquidity event: %w", err)
+		}
+		if err := s.canonicalAmounts(&liquidityEvent.AmountA, &liquidityEvent.AmountB, &liquidityEvent.Liquidity); err != nil {
+			return temp.Type, fmt.Errorf("invalid router liquidity event: %w", err)
+		}
+		if err := liquidityEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid router liquidity event: %w", err)
+		}
+		if liquidityEvent.LedgerSequence == 0 {
+			liquidityEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleRouterLiquidity(ctx, liquidityEvent)
+
+	case "aggregator_swap":
+		var aggregatorEvent AggregatorSwapEvent
+		if err := json.Unmarshal(jsonBytes, &aggregatorEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding aggregator swap event: %w", err)
+		}
+		if err := s.canonicalAmounts(aggregatorEvent.amountFields()...); err != nil {
+			return temp.Type, fmt.Errorf("invalid aggregator swap event: %w", err)
+		}
+		if err := aggregatorEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid aggregator swap event: %w", err)
+		}
+		if aggregatorEvent.LedgerSequence == 0 {
+			aggregatorEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleAggregatorSwap(ctx, aggregatorEvent)
+
+	case "router_swap":
+		var routerSwapEvent RouterSwapEvent
+		if err := json.Unmarshal(jsonBytes, &routerSwapEvent); err != nil {
+			return temp.Type, fmt.Errorf("error decoding router swap event: %w", err)
+		}
+		if err := s.canonicalAmounts(routerSwapEvent.amountFields()...); err != nil {
+			return temp.Type, fmt.Errorf("invalid router swap event: %w", err)
+		}
+		if err := routerSwapEvent.normalize(); err != nil {
+			return temp.Type, fmt.Errorf("invalid router swap event: %w", err)
+		}
+		if routerSwapEvent.LedgerSequence == 0 {
+			routerSwapEvent.LedgerSequence, _ = metadataInt64(msg.Metadata, "ledger_sequence")
+		}
+		return temp.Type, s.handleRouterSwap(ctx, routerSwapEvent)
 
 	default:
-		return fmt.Errorf("unknown event type: %s", temp.Type)
+		return temp.Type, fmt.Errorf("%w: %s", errUnknownEventType, temp.Type)
 	}
 }
 
-func (s *SaveSoroswapPairsToSQLite) handleNewPair(ctx context.Context, event NewPairEvent) error {
+func (s *SaveSoroswapPairsToSQLite) handleNewPair(ctx context.Context, event NewPairEvent) (err error) {
+	ctx, span := s.startSpan(ctx, "handleNewPair", pairSpanAttributes(event.PairAddress, event.LedgerSequence)...)
+	defer func() { endSpan(span, err) }()
+
 	// Validate input data
 	if event.PairAddress == "" || event.Token0 == "" || event.Token1 == "" {
 		return fmt.Errorf("invalid new pair event data: missing required fields")
 	}
 
-	log.Printf("Attempting to insert new Soroswap pair: %s (tokens: %s/%s)",
-		event.PairAddress, event.Token0, event.Token1)
+	createdAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.PairAddress, err)
+	}
+
+	if !s.filter.allows(event.PairAddress, event.Token0, event.Token1) {
+		logger.Debug("Skipping filtered pair", "event_type", "new_pair", "pair", event.PairAddress,
+			"token0", event.Token0, "token1", event.Token1)
+		if replayTables(ctx) == nil {
+			s.recordOutcome(ctx, "new_pair", outcomeFiltered, event.PairAddress)
+		}
+		return nil
+	}
+
+	logger.Debug("Inserting new Soroswap pair", "event_type", "new_pair", "pair", event.PairAddress,
+		"token0", event.Token0, "token1", event.Token1, "ledger", event.LedgerSequence)
 
 	// Begin transaction for better error handling
-	tx, err := s.db.BeginTx(ctx, nil)
+	tx, done, err := s.beginEvent(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return err
 	}
-	defer tx.Rollback() // Will be ignored if transaction is committed
+	defer done() // Will be ignored if transaction is committed
 
-	stmt, err := tx.PrepareContext(ctx, `
-        INSERT INTO soroswap_pairs (
-            pair_address, token_0, token_1, created_at,
-            reserve_0, reserve_1
-        ) VALUES (?, ?, ?, ?, '0', '0')
-        ON CONFLICT (pair_address) DO NOTHING
-    `)
-	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %v", err)
+	writeHistory := event.hasReserves() && event.LedgerSequence > 0 &&
+		!s.isDust(string(event.Reserve0), string(event.Reserve1))
+
+	// The pairs table is already correct when replaying; only the initial
+	// reserves feed a derived table.
+	if replay := replayTables(ctx); replay != nil {
+		if !writeHistory || !replay["pair_reserve_history"] {
+			return nil
+		}
+		if err := s.insertInitialHistory(ctx, tx, event, createdAt.Value); err != nil {
+			return err
+		}
+		return s.commitEvent(ctx, tx, true)
 	}
-	defer stmt.Close()
 
-	result, err := stmt.ExecContext(ctx,
-		event.PairAddress,
-		event.Token0,
-		event.Token1,
-		event.Timestamp,
-	)
+	record := PairRecord{
+		PairAddress:     event.PairAddress,
+		Token0:          event.Token0,
+		Token1:          event.Token1,
+		CreatedAt:       createdAt,
+		CreatedAtLedger: event.LedgerSequence,
+		Created:         event.ref(),
+	}
+	if event.hasReserves() {
+		record.Reserves = &ReserveUpdate{
+			PairAddress: event.PairAddress,
+			Reserve0:    string(event.Reserve0),
+			Reserve1:    string(event.Reserve1),
+			SyncedAt:    createdAt,
+			Ledger:      event.LedgerSequence,
+			Event:       event.ref(),
+		}
+		if err := s.setPrices(ctx, tx, record.Reserves, event.Token0, event.Token1); err != nil {
+			return err
+		}
+	}
+	isNew, err := s.store(tx).InsertPair(ctx, record)
 	if err != nil {
-		return fmt.Errorf("failed to insert pair: %v", err)
+		return err
 	}
 
-	affectedRows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
+	// Pairs are created rarely; replays of known ones are routine.
+	level := slog.LevelDebug
+	if isNew {
+		level = slog.LevelInfo
 	}
+	logger.Log(ctx, level, "Inserted new Soroswap pair", "event_type", "new_pair", "pair", event.PairAddress,
+		"new", isNew, "ledger", event.LedgerSequence)
 
-	log.Printf("Inserted new Soroswap pair: %s (rows affected: %d)", event.PairAddress, affectedRows)
+	if isNew {
+		if err := s.reconcileRouterHops(ctx, tx, event.PairAddress); err != nil {
+			return err
+		}
+		if err := s.recordPairAliases(ctx, tx, event); err != nil {
+			return err
+		}
+		if event.hasReserves() {
+			if err := s.markActive(ctx, tx, event.PairAddress, string(event.Reserve0), string(event.Reserve1)); err != nil {
+				return err
+			}
+		}
+		if event.Factory != "" {
+			if err := s.countFactoryPair(ctx, tx, event); err != nil {
+				return err
+			}
+		}
+		if writeHistory && s.reserveHistory {
+			if err := s.insertInitialHistory(ctx, tx, event, createdAt.Value); err != nil {
+				return err
+			}
+		}
+		if s.pendingSyncs {
+			if err := s.applyPendingSyncs(ctx, tx, event.PairAddress); err != nil {
+				return err
+			}
+		}
+		if err := s.queuePairWebhook(ctx, tx, event.PairAddress); err != nil {
+			return err
+		}
+	}
 
-	return tx.Commit()
+	if err := s.commitEvent(ctx, tx, isNew); err != nil {
+		return fmt.Errorf("failed to commit new pair: %v", err)
+	}
+	outcome := outcomeInserted
+	if !isNew {
+		outcome = outcomeDuplicate
+	}
+	s.recordOutcome(ctx, "new_pair", outcome, event.PairAddress)
+	return nil
 }
 
-func (s *SaveSoroswapPairsToSQLite) handleSync(ctx context.Context, event SyncEvent) error {
-	log.Printf("Checking existence of pair: %s", event.ContractID)
+func (s *SaveSoroswapPairsToSQLite) handleSync(ctx context.Context, event SyncEvent) (err error) {
+	ctx, span := s.startSpan(ctx, "handleSync", pairSpanAttributes(event.ContractID, event.LedgerSequence)...)
+	defer func() { endSpan(span, err) }()
 
-	// Begin transaction
-	tx, err := s.db.BeginTx(ctx, nil)
+	syncedAt, err := s.timestamps.check(event.Timestamp)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return fmt.Errorf("pair %s: %w", event.ContractID, err)
 	}
-	defer tx.Rollback() // Will be ignored if transaction is committed
 
-	// First check if the pair exists
-	var exists bool
-	query := `SELECT EXISTS (
-		SELECT 1 FROM soroswap_pairs WHERE pair_address = ?
-	)`
+	logger.Debug("Checking existence of pair", "event_type", "sync", "pair", event.ContractID,
+		"ledger", event.LedgerSequence)
 
-	err = tx.QueryRowContext(ctx, query, event.ContractID).Scan(&exists)
+	// Begin transaction
+	tx, done, err := s.beginEvent(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check pair existence: %v", err)
+		return err
 	}
+	defer done() // Will be ignored if transaction is committed
 
-	if !exists {
-		log.Printf("Warning: Received sync event for unknown pair: %s", event.ContractID)
+	outcome, err := s.applySync(ctx, tx, event, syncedAt)
+	if err != nil || outcome == "" {
+		return err
+	}
+
+	if err := s.commitEvent(ctx, tx, forwardedOutcomes[outcome]); err != nil {
+		return fmt.Errorf("failed to commit sync: %v", err)
+	}
+	if replayTables(ctx) != nil {
 		return nil
 	}
+	s.recordOutcome(ctx, "sync", outcome, event.ContractID)
+	return nil
+}
 
-	stmt, err := tx.PrepareContext(ctx, `
-        UPDATE soroswap_pairs 
-        SET reserve_0 = ?,
-            reserve_1 = ?,
-            last_sync_at = ?,
-            last_sync_ledger = ?
-        WHERE pair_address = ?
-    `)
+// applySync writes a sync inside tx and returns its outcome, or "" when
+// the pair is unknown and nothing was written. Unknown pairs may also be
+// an error, see unknownPair.
+func (s *SaveSoroswapPairsToSQLite) applySync(ctx context.Context, tx *sql.Tx, event SyncEvent, syncedAt validatedTimestamp) (string, error) {
+	// First check if the pair exists
+	store := s.store(tx)
+	prev, err := store.LoadPair(ctx, event.ContractID)
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %v", err)
+		return "", err
+	}
+	exists := prev != nil
+	if !exists && s.dryRun {
+		exists = s.dryRunSawPair(event.ContractID)
 	}
-	defer stmt.Close()
 
-	result, err := stmt.ExecContext(ctx,
-		event.NewReserve0,
-		event.NewReserve1,
-		event.Timestamp,
-		event.LedgerSequence,
-		event.ContractID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update pair reserves: %v", err)
+	// When replaying, the pairs table already holds the final reserves and
+	// only the derived tables being rebuilt are written.
+	replay := replayTables(ctx)
+	outcome := outcomeUpdated
+	update := ReserveUpdate{
+		PairAddress: event.ContractID,
+		Reserve0:    string(event.NewReserve0),
+		Reserve1:    string(event.NewReserve1),
+		SyncedAt:    syncedAt,
+		Ledger:      event.LedgerSequence,
+		Event:       event.ref(),
+	}
+	if replay == nil {
+		var token0, token1 string
+		if prev != nil {
+			token0, token1 = prev.Token0, prev.Token1
+		}
+		if err := s.setPrices(ctx, tx, &update, token0, token1); err != nil {
+			return "", err
+		}
+	}
+	if !exists && replay == nil && s.createMissingPairs && s.filter.allowsPlaceholder(event.ContractID) {
+		if err := store.InsertPlaceholder(ctx, update); err != nil {
+			return "", err
+		}
+		logger.Info("Created placeholder for unknown pair from sync", "event_type", "sync", "pair", event.ContractID,
+			"ledger", event.LedgerSequence)
+		if err := s.markActive(ctx, tx, event.ContractID, update.Reserve0, update.Reserve1); err != nil {
+			return "", err
+		}
+		exists = true
+		outcome = outcomePlaceholder
+	}
+	if !exists && replay == nil && s.pendingSyncs && s.filter.allowsPlaceholder(event.ContractID) {
+		if err := s.queueSync(ctx, tx, event, syncedAt); err != nil {
+			return "", err
+		}
+		return outcomePending, nil
+	}
+	if !exists {
+		return "", s.unknownPair(ctx, "sync", event.ContractID)
 	}
 
-	affectedRows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
+	if replay == nil && outcome != outcomePlaceholder {
+		applied, err := store.UpdateReserves(ctx, update)
+		if err != nil {
+			return "", err
+		}
+		// In a dry run, pairs inserted earlier in the run are not stored and
+		// nothing is applied.
+		if applied || prev == nil {
+			logger.Debug("Updated Soroswap pair reserves", "event_type", "sync", "pair", event.ContractID,
+				"ledger", event.LedgerSequence)
+		} else {
+			// A late event, e.g. from a parallel backfill. It still
+			// belongs in the reserve history and candles.
+			outcome = outcomeSkippedStale
+			if !s.backfill {
+				logger.Debug("Skipping stale sync: the ledger is older than the stored reserves", "event_type", "sync",
+					"pair", event.ContractID, "ledger", event.LedgerSequence)
+			}
+		}
+
+		// Historical changes are not news.
+		if applied && prev != nil && !s.backfill {
+			if err := s.evaluateAlerts(ctx, tx, event, prev, syncedAt.Value); err != nil {
+				return "", err
+			}
+		}
+		if applied && prev != nil && s.usd.enabled() {
+			if err := s.updateUSDPrices(ctx, tx, event.ContractID, prev.Token0, prev.Token1, event.LedgerSequence); err != nil {
+				return "", err
+			}
+		}
+		if applied {
+			if err := s.markActive(ctx, tx, event.ContractID, update.Reserve0, update.Reserve1); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// Dust pools are kept out of the time series.
+	dust := s.isDust(update.Reserve0, update.Reserve1)
+	if !dust && ((replay == nil && s.reserveHistory) || replay["pair_reserve_history"]) {
+		if err := s.insertHistory(ctx, tx, event.ContractID,
+			string(event.NewReserve0), string(event.NewReserve1), event.LedgerSequence, event.ref(), syncedAt.Value,
+		); err != nil {
+			return "", fmt.Errorf("failed to record reserve history: %v", err)
+		}
+	}
+
+	price := syncPrice(string(event.NewReserve0), string(event.NewReserve1))
+	if price != nil && !dust && ((replay == nil && len(s.candleResolutions) > 0) || replay["pair_candles"]) {
+		if err := s.updateCandles(ctx, tx, event.ContractID, candleUpdate{
+			At:     syncedAt.Value,
+			Ledger: event.LedgerSequence,
+			Price:  price,
+		}); err != nil {
+			return "", err
+		}
+	}
+	if !dust {
+		if err := s.updateRollups(ctx, tx, event.ContractID, rollupUpdate{
+			At:       syncedAt.Value,
+			Ledger:   event.LedgerSequence,
+			Reserve0: update.Reserve0,
+			Reserve1: update.Reserve1,
+		}, replay); err != nil {
+			return "", err
+		}
+	}
+
+	return outcome, nil
+}
+
+// insertInitialHistory records a new pair's initial reserves as its first
+// reserve history point.
+func (s *SaveSoroswapPairsToSQLite) insertInitialHistory(ctx context.Context, tx *sql.Tx, event NewPairEvent, at time.Time) error {
+	if err := s.insertHistory(ctx, tx, event.PairAddress,
+		string(event.Reserve0), string(event.Reserve1), event.LedgerSequence, event.ref(), at,
+	); err != nil {
+		return fmt.Errorf("failed to record initial reserve history: %v", err)
 	}
+	return nil
+}
 
-	log.Printf("Updated Soroswap pair reserves: %s (rows affected: %d)", event.ContractID, affectedRows)
+// insertHistory appends a reserve history point. When backfilling, a point
+// with the same ledger and reserves is taken to be the same sync ingested
+// before, and not added again.
+func (s *SaveSoroswapPairsToSQLite) insertHistory(ctx context.Context, tx *sql.Tx, pair, reserve0, reserve1 string, ledger int64, ref EventRef, at time.Time) error {
+	if s.backfill && ledger > 0 {
+		var exists bool
+		if err := s.stmts.queryRow(ctx, tx, historyPointExistsQuery,
+			pair, ledger, reserve0, reserve1).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	query, err := s.partitions.route(ctx, tx, "pair_reserve_history", insertHistoryQuery, at)
+	if err != nil {
+		return err
+	}
+	_, err = s.stmts.exec(ctx, tx, query, pair, reserve0, reserve1, ledger,
+		nullableString(ref.TxHash), ref.OperationIndex, ref.EventIndex, at)
+	return err
+}
 
-	return tx.Commit()
+// unknownPair accounts for an event of a pair that is not stored, and
+// returns errUnknownPair for processOne to fail or skip it by strict_mode.
+// When backfilling from before the pair's creation, such events are
+// expected and counted without a warning or an error, as are they in
+// replays.
+func (s *SaveSoroswapPairsToSQLite) unknownPair(ctx context.Context, eventType, pair string) error {
+	// Events of pairs skipped by the filter are routine, and cannot be told
+	// apart from those of pairs never seen.
+	if s.filter.active() {
+		logger.Debug("Skipping event for unstored pair", "event_type", eventType, "pair", pair)
+		s.recordOutcome(ctx, eventType, outcomeFiltered, pair)
+		return nil
+	}
+	s.recordOutcome(ctx, eventType, outcomeUnknownPair, pair)
+	if s.backfill || replayTables(ctx) != nil {
+		return nil
+	}
+	logger.Warn("Received event for unknown pair", "event_type", eventType, "pair", pair)
+	return fmt.Errorf("%w: %s", errUnknownPair, pair)
 }
 
-// Close closes the database connection
+// Close drains pending writes (see drain), stops the endpoints, background
+// jobs and event sinks, and closes the database connection.
 func (s *SaveSoroswapPairsToSQLite) Close() error {
+	s.stopHTTP()
+	s.stopGRPC()
+	s.stopBackground()
+	var errs []error
+	unlock := func() {}
+	if s.db != nil {
+		u, err := s.drain()
+		if err != nil {
+			logger.Error("Shutdown drain failed", "error", err)
+			errs = append(errs, fmt.Errorf("shutdown: %v", err))
+		}
+		if u != nil {
+			unlock = u
+		}
+	}
+	defer unlock()
+	s.stopWorkers()
+	s.closeForwarder()
+	if err := s.shutdownTracing(); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	if s.stmts != nil {
+		s.stmts.Close()
+	}
 	if s.db != nil {
-		return s.db.Close()
+		errs = append(errs, s.closeDB())
 	}
-	return nil
+	return errors.Join(errs...)
 }
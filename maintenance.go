@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maintenanceCheckInterval is how often the scheduler checks whether the
+// maintenance window has opened.
+const maintenanceCheckInterval = time.Minute
+
+// maintenanceWindow configures the SQLite maintenance run once a day in
+// quiet hours.
+type maintenanceWindow struct {
+	// start and end are offsets from midnight in loc. A window ending
+	// before it starts spans midnight.
+	start, end time.Duration
+	loc        *time.Location
+	// vacuum is incremental, full or off.
+	vacuum string
+	// lastWindow is the start of the window maintenance last ran in.
+	lastWindow time.Time
+}
+
+// parseMaintenance reads maintenance_window, e.g. "02:00-04:00", with
+// maintenance_timezone and maintenance_vacuum. It returns nil when no
+// window is set.
+func parseMaintenance(cfg *Config) (*maintenanceWindow, error) {
+	window := cfg.MaintenanceWindow
+	if window == "" {
+		return nil, nil
+	}
+	m := &maintenanceWindow{vacuum: cfg.MaintenanceVacuum}
+	var err error
+	from, to, ok := strings.Cut(window, "-")
+	if ok {
+		m.start, err = parseClock(strings.TrimSpace(from))
+		if err == nil {
+			m.end, err = parseClock(strings.TrimSpace(to))
+		}
+	}
+	if !ok || err != nil || m.start == m.end {
+		return nil, fmt.Errorf("config maintenance_window: expected a range such as 02:00-04:00, got %q", window)
+	}
+	if m.loc, err = time.LoadLocation(cfg.MaintenanceTimezone); err != nil {
+		return nil, fmt.Errorf("config maintenance_timezone: %v", err)
+	}
+	switch m.vacuum {
+	case "incremental", "full", "off":
+	default:
+		return nil, fmt.Errorf("config maintenance_vacuum: expected incremental, full or off, got %q", m.vacuum)
+	}
+	return m, nil
+}
+
+// parseClock parses a time of day such as "02:30" as an offset from
+// midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// windowStart returns the start of the window now falls in, and false when
+// it falls in none.
+func (m *maintenanceWindow) windowStart(now time.Time) (time.Time, bool) {
+	now = now.In(m.loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, m.loc)
+	offset := now.Sub(midnight)
+	switch {
+	case m.start < m.end:
+		if offset >= m.start && offset < m.end {
+			return midnight.Add(m.start), true
+		}
+	case offset >= m.start:
+		return midnight.Add(m.start), true
+	case offset < m.end:
+		// In the part of a window spanning midnight that began yesterday.
+		return midnight.AddDate(0, 0, -1).Add(m.start), true
+	}
+	return time.Time{}, false
+}
+
+// maintainInWindow runs maintenance once per window, when the current
+// time falls in one.
+func (s *SaveSoroswapPairsToSQLite) maintainInWindow(ctx context.Context) error {
+	start, ok := s.maintenance.windowStart(time.Now())
+	if !ok || start.Equal(s.maintenance.lastWindow) {
+		return nil
+	}
+	s.maintenance.lastWindow = start
+	return s.Maintain(ctx)
+}
+
+// Maintain refreshes the query planner statistics with ANALYZE, reclaims
+// free pages as maintenance_vacuum says and then checkpoints and truncates
+// the WAL, so long running databases neither slow down nor keep growing.
+// Event writes wait while it runs. SQLite only.
+//
+// An incremental vacuum only frees pages of databases using auto_vacuum =
+// INCREMENTAL, which new databases do. A full vacuum rebuilds the file,
+// switching it to incremental auto vacuum on the way.
+func (s *SaveSoroswapPairsToSQLite) Maintain(ctx context.Context) error {
+	if _, ok := s.backend.(*sqliteBackend); !ok {
+		return fmt.Errorf("maintenance requires the sqlite3 driver, not %s", s.backend.Name())
+	}
+	if s.dryRun {
+		return nil
+	}
+	vacuum := "incremental"
+	if s.maintenance != nil {
+		vacuum = s.maintenance.vacuum
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	start := time.Now()
+
+	// A pinned connection keeps the pragmas and the checkpoint on one
+	// connection, which holds no read transaction of its own.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ANALYZE"); err != nil {
+		return fmt.Errorf("failed to analyze: %v", err)
+	}
+	freed, err := vacuumSQLite(ctx, conn, vacuum)
+	if err != nil {
+		return err
+	}
+	// Last, so the WAL written by the steps above is truncated too.
+	res, err := s.checkpointWAL(ctx, conn, "TRUNCATE")
+	if err != nil {
+		return err
+	}
+	if res.Busy {
+		logger.Warn("Maintenance checkpoint could not finish while readers were active", "checkpointed", res.Checkpointed, "frames", res.Frames)
+	}
+	logger.Info("Maintenance done", "duration", time.Since(start).Round(time.Millisecond),
+		"checkpoint_mode", res.Mode, "checkpointed_frames", res.Checkpointed, "freed_pages", freed)
+	return nil
+}
+
+// vacuumSQLite reclaims free pages, returning how many were freed.
+func vacuumSQLite(ctx context.Context, conn *sql.Conn, mode string) (int, error) {
+	if mode == "off" {
+		return 0, nil
+	}
+	var before int
+	if err := conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&before); err != nil {
+		return 0, fmt.Errorf("failed to read free pages: %v", err)
+	}
+	if before == 0 && mode == "incremental" {
+		return 0, nil
+	}
+	switch mode {
+	case "full":
+		if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return 0, fmt.Errorf("failed to set auto_vacuum: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return 0, fmt.Errorf("failed to vacuum: %v", err)
+		}
+	default:
+		var autoVacuum int
+		if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+			return 0, fmt.Errorf("failed to read auto_vacuum: %v", err)
+		}
+		if autoVacuum != 2 {
+			logger.Warn("Free pages not reclaimed: the database does not use incremental auto vacuum; set maintenance_vacuum: full once to convert it", "free_pages", before)
+			return 0, nil
+		}
+		if err := incrementalVacuum(ctx, conn); err != nil {
+			return 0, fmt.Errorf("failed to vacuum: %v", err)
+		}
+	}
+	var after int
+	if err := conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&after); err != nil {
+		return 0, fmt.Errorf("failed to read free pages: %v", err)
+	}
+	return before - after, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// metadataInt64 reads an integer from message metadata, accepting the
+// numeric types produced by the different pluginapi transports as well as
+// decimal strings.
+func metadataInt64(metadata map[string]interface{}, key string) (int64, bool) {
+	switch v := metadata[key].(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// metadataTime reads a time from message metadata: a time.Time, an RFC3339
+// string or Unix seconds or milliseconds.
+func metadataTime(metadata map[string]interface{}, key string) (time.Time, bool) {
+	var t time.Time
+	switch v := metadata[key].(type) {
+	case time.Time:
+		t = v
+	case string:
+		t, _ = parseTimestampString(v)
+	default:
+		if n, ok := metadataInt64(metadata, key); ok {
+			t, _ = unixInteger(n)
+		}
+	}
+	return t, !t.IsZero() && t.Unix() != 0
+}
+
+// nullableLedger maps the zero ledger used for "absent" to SQL NULL.
+func nullableLedger(ledger int64) interface{} {
+	if ledger <= 0 {
+		return nil
+	}
+	return ledger
+}
+
+// nullableString maps an empty string to SQL NULL.
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
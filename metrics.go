@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency
+// histograms.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram counts observations per bucket of latencyBuckets.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// latencyMetrics keeps the processing and database latency histograms
+// exposed on the metrics endpoint. Counters are read from Stats instead.
+type latencyMetrics struct {
+	mu     sync.Mutex
+	events map[string]*histogram
+	db     map[string]*histogram
+}
+
+func newLatencyMetrics() *latencyMetrics {
+	return &latencyMetrics{
+		events: make(map[string]*histogram),
+		db:     make(map[string]*histogram),
+	}
+}
+
+// observeEvent records how long processing one event of eventType took.
+func (m *latencyMetrics) observeEvent(eventType string, d time.Duration) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	m.observe(m.events, eventType, d)
+}
+
+// observeDB records the duration of one database operation: "statement"
+// for a handler statement, "commit" for a transaction commit.
+func (m *latencyMetrics) observeDB(operation string, d time.Duration) {
+	m.observe(m.db, operation, d)
+}
+
+func (m *latencyMetrics) observe(hists map[string]*histogram, label string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := hists[label]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		hists[label] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// metricsHandler serves the consumer's metrics in the Prometheus text
+// exposition format.
+func (s *SaveSoroswapPairsToSQLite) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	})
+}
+
+func (s *SaveSoroswapPairsToSQLite) writeMetrics(w io.Writer) {
+	st := s.Stats()
+
+	writeHeader(w, "soroswap_consumer_events_total", "counter", "Events handled since start, by type and status.")
+	for _, t := range sortedKeys(st.Processed) {
+		writeSample(w, "soroswap_consumer_events_total", st.Processed[t], "type", t, "status", "processed")
+	}
+	for _, t := range sortedKeys(st.Failed) {
+		writeSample(w, "soroswap_consumer_events_total", st.Failed[t], "type", t, "status", "failed")
+	}
+
+	writeHeader(w, "soroswap_consumer_event_outcomes_total", "counter", "What handlers did with events since start (inserted, updated, duplicate, ...).")
+	for _, o := range sortedKeys(st.Outcomes) {
+		writeSample(w, "soroswap_consumer_event_outcomes_total", st.Outcomes[o], "outcome", o)
+	}
+
+	writeHeader(w, "soroswap_consumer_dead_lettered_total", "counter", "Events stored in dead_letter_events, by type, over the database's lifetime.")
+	types := make([]string, 0, len(st.Lifetime))
+	for t := range st.Lifetime {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		writeSample(w, "soroswap_consumer_dead_lettered_total", st.Lifetime[t].DeadLettered, "type", t)
+	}
+
+	if l := s.ingestErrors; l != nil {
+		writeHeader(w, "soroswap_consumer_ingest_errors_total", "counter", "Failed events in ingest_errors, by error category and type, over the database's lifetime.")
+		errs := l.lifetime()
+		keys := make([]ingestErrorKey, 0, len(errs))
+		for k := range errs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].category != keys[j].category {
+				return keys[i].category < keys[j].category
+			}
+			return keys[i].eventType < keys[j].eventType
+		})
+		for _, k := range keys {
+			writeSample(w, "soroswap_consumer_ingest_errors_total", errs[k], "category", k.category, "type", k.eventType)
+		}
+	}
+
+	status := s.Status()
+	writeHeader(w, "soroswap_consumer_consecutive_failures", "gauge", "Failures since the last successful commit.")
+	writeSample(w, "soroswap_consumer_consecutive_failures", status.ConsecutiveFailures)
+
+	writeHeader(w, "soroswap_consumer_checkpoint_ledger", "gauge", "Highest ledger of a handled event.")
+	writeSample(w, "soroswap_consumer_checkpoint_ledger", s.Checkpoint().LastLedger)
+
+	if wh := s.webhook; wh != nil {
+		writeHeader(w, "soroswap_consumer_webhook_deliveries_total", "counter", "Webhook delivery attempts since start, by result.")
+		writeSample(w, "soroswap_consumer_webhook_deliveries_total", wh.delivered.Load(), "result", "delivered")
+		writeSample(w, "soroswap_consumer_webhook_deliveries_total", wh.retried.Load(), "result", "retried")
+		writeSample(w, "soroswap_consumer_webhook_deliveries_total", wh.failed.Load(), "result", "failed")
+	}
+
+	if f := s.forward; f != nil {
+		writeHeader(w, "soroswap_consumer_forward_failures_total", "counter", "Persisted events an event sink failed to take since start.")
+		for _, sink := range f.sinks {
+			writeSample(w, "soroswap_consumer_forward_failures_total", f.failed[sink.Name()].Load(), "sink", sink.Name())
+		}
+	}
+
+	writeHeader(w, "soroswap_consumer_queue_depth", "gauge", "Events admitted or waiting for admission.")
+	writeSample(w, "soroswap_consumer_queue_depth", st.QueueDepth)
+	writeHeader(w, "soroswap_consumer_throttled_total", "counter", "Events delayed by the rate limit.")
+	writeSample(w, "soroswap_consumer_throttled_total", st.Throttled)
+	writeHeader(w, "soroswap_consumer_busy_retries_total", "counter", "Events retried after hitting a locked database.")
+	writeSample(w, "soroswap_consumer_busy_retries_total", st.BusyRetries)
+	writeHeader(w, "soroswap_consumer_rejected_total", "counter", "Messages refused by overflow_policy: reject.")
+	writeSample(w, "soroswap_consumer_rejected_total", st.Rejected)
+	writeHeader(w, "soroswap_consumer_slowed_total", "counter", "Messages held back by max_commit_latency.")
+	writeSample(w, "soroswap_consumer_slowed_total", st.Slowed)
+	writeHeader(w, "soroswap_consumer_commit_latency_seconds", "gauge", "Moving average of commit latency behind max_commit_latency.")
+	writeSample(w, "soroswap_consumer_commit_latency_seconds", st.CommitLatencySeconds)
+
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeHistograms(w, "soroswap_consumer_event_duration_seconds", "Time to process one event, by type.", "type", m.events)
+	writeHistograms(w, "soroswap_consumer_db_duration_seconds", "Duration of database operations, by operation.", "operation", m.db)
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes one sample; labels alternate names and values.
+func writeSample(w io.Writer, name string, value interface{}, labels ...string) {
+	fmt.Fprintf(w, "%s%s %v\n", name, formatLabels(labels...), value)
+}
+
+func writeHistograms(w io.Writer, name, help, label string, hists map[string]*histogram) {
+	writeHeader(w, name, "histogram", help)
+	keys := make([]string, 0, len(hists))
+	for k := range hists {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := hists[k]
+		for i, bound := range latencyBuckets {
+			writeSample(w, name+"_bucket", h.counts[i], label, k, "le", fmt.Sprint(bound))
+		}
+		writeSample(w, name+"_bucket", h.count, label, k, "le", "+Inf")
+		writeSample(w, name+"_sum", h.sum, label, k)
+		writeSample(w, name+"_count", h.count, label, k)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
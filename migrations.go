@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migration is one versioned step of the schema. Pending steps are applied
+// in order at Initialize and recorded in schema_migrations, so each runs
+// once per database.
+type migration struct {
+	version int
+	name    string
+	// statements run in one transaction together with the version record.
+	statements []string
+	// apply runs instead of statements for steps that need more than plain
+	// SQL. It is not transactional, so it must be safe to run again after a
+	// crash left it unrecorded.
+	apply func(ctx context.Context, db *sql.DB, b backend) error
+}
+
+// migrations lists every schema step in version order. Versions are never
+// reused or reordered; changes to the schema append a new step.
+var migrations = []migration{
+	// Everything up to the introduction of versioned migrations. The step
+	// is idempotent, so databases created by older versions are brought up
+	// to date by it rather than detected.
+	{version: 1, name: "baseline", apply: createBaselineSchema},
+	// Stellar network of each pair. A database holds one network, see
+	// adoptNetwork, so pair_address stays the key.
+	{version: 2, name: "pair_network", statements: []string{
+		`ALTER TABLE soroswap_pairs ADD COLUMN network TEXT`,
+	}},
+	// The event's own timestamp and the message metadata of archived
+	// events, so replays see what live processing saw.
+	{version: 3, name: "raw_event_context", statements: []string{
+		`ALTER TABLE raw_events ADD COLUMN event_time {{timestamp}}`,
+		`ALTER TABLE raw_events ADD COLUMN metadata TEXT`,
+	}},
+	// Where live processing got to; a single row.
+	{version: 4, name: "consumer_checkpoint", statements: []string{
+		`CREATE TABLE IF NOT EXISTS consumer_checkpoint (
+            id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+            last_ledger BIGINT NOT NULL,
+            cursor TEXT,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+	}},
+	// Outbox of webhook notifications, kept as a delivery log.
+	{version: 5, name: "webhook_deliveries", statements: []string{
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+            id {{serial_pk}},
+            event TEXT NOT NULL,
+            pair_address TEXT,
+            body TEXT NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            last_error TEXT,
+            created_at {{timestamp}} NOT NULL,
+            next_attempt_at {{timestamp}} NOT NULL,
+            delivered_at {{timestamp}}
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(delivered_at, next_attempt_at)`,
+	}},
+	// Time indexes for retention pruning of the tables not aged by one yet.
+	{version: 6, name: "retention_indexes", statements: []string{
+		`CREATE INDEX IF NOT EXISTS idx_swaps_swapped_at ON soroswap_swaps(swapped_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_deposits_deposited_at ON soroswap_deposits(deposited_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_withdrawals_withdrawn_at ON soroswap_withdrawals(withdrawn_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_candles_bucket_start ON pair_candles(bucket_start)`,
+		`CREATE INDEX IF NOT EXISTS idx_alerts_triggered_at ON alerts(triggered_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_raw_events_received_at ON raw_events(received_at)`,
+	}},
+	// Keys of processed events, so redeliveries are skipped when
+	// dedup_events is on.
+	{version: 7, name: "processed_events", statements: []string{
+		`CREATE TABLE IF NOT EXISTS processed_events (
+            event_type TEXT NOT NULL,
+            event_key TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            processed_at {{timestamp}} NOT NULL,
+            PRIMARY KEY (event_type, event_key)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_processed_events_processed_at ON processed_events(processed_at)`,
+	}},
+	// Protocol fee mints and each pair's fee totals.
+	{version: 8, name: "pair_fees", statements: []string{
+		`CREATE TABLE IF NOT EXISTS soroswap_protocol_fees (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL REFERENCES soroswap_pairs(pair_address),
+            fee_to TEXT NOT NULL,
+            liquidity TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            minted_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, pair_address, event_index)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_protocol_fees_minted_at ON soroswap_protocol_fees(minted_at)`,
+		`CREATE TABLE IF NOT EXISTS pair_fees (
+            pair_address TEXT NOT NULL PRIMARY KEY,
+            fee_to TEXT,
+            swap_fees_0 TEXT NOT NULL DEFAULT '0',
+            swap_fees_1 TEXT NOT NULL DEFAULT '0',
+            protocol_fee_shares TEXT NOT NULL DEFAULT '0',
+            protocol_fee_mints INTEGER NOT NULL DEFAULT 0,
+            last_ledger INTEGER,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+	}},
+	// Settings and pair count of each Soroswap factory.
+	{version: 9, name: "factory_state", statements: []string{
+		`CREATE TABLE IF NOT EXISTS factory_state (
+            factory_address TEXT NOT NULL PRIMARY KEY,
+            fee_to TEXT,
+            fee_to_setter TEXT,
+            fees_enabled BOOLEAN,
+            pair_count INTEGER NOT NULL DEFAULT 0,
+            last_ledger INTEGER,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+	}},
+	// Liquidity added and removed through the router.
+	{version: 10, name: "router_liquidity", statements: []string{
+		`CREATE TABLE IF NOT EXISTS router_liquidity (
+            id {{serial_pk}},
+            action TEXT NOT NULL,
+            pair_address TEXT NOT NULL REFERENCES soroswap_pairs(pair_address),
+            token_a TEXT NOT NULL,
+            token_b TEXT NOT NULL,
+            amount_a TEXT NOT NULL,
+            amount_b TEXT NOT NULL,
+            liquidity TEXT NOT NULL,
+            recipient TEXT,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            occurred_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, pair_address, event_index)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_router_liquidity_pair_ledger ON router_liquidity(pair_address, ledger_sequence)`,
+		`CREATE INDEX IF NOT EXISTS idx_router_liquidity_occurred_at ON router_liquidity(occurred_at)`,
+	}},
+	// Trades of the Soroswap Aggregator, with one leg per protocol used.
+	{version: 11, name: "aggregator_swaps", statements: []string{
+		`CREATE TABLE IF NOT EXISTS aggregator_swaps (
+            id {{serial_pk}},
+            aggregator TEXT NOT NULL,
+            token_in TEXT NOT NULL,
+            token_out TEXT NOT NULL,
+            amount_in TEXT NOT NULL,
+            amount_out TEXT NOT NULL,
+            recipient TEXT,
+            leg_count INTEGER NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            swapped_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, aggregator, event_index)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_aggregator_swaps_time ON aggregator_swaps(swapped_at)`,
+		`CREATE TABLE IF NOT EXISTS aggregator_swap_legs (
+            swap_id INTEGER NOT NULL REFERENCES aggregator_swaps(id),
+            leg_index INTEGER NOT NULL,
+            protocol_id TEXT NOT NULL,
+            path TEXT NOT NULL,
+            parts INTEGER NOT NULL,
+            amount_in TEXT,
+            amount_out TEXT,
+            PRIMARY KEY (swap_id, leg_index)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_aggregator_legs_protocol ON aggregator_swap_legs(protocol_id)`,
+	}},
+	// LP share transfers and each provider's share balance per pair.
+	{version: 12, name: "lp_positions", statements: []string{
+		`CREATE TABLE IF NOT EXISTS lp_transfers (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL REFERENCES soroswap_pairs(pair_address),
+            from_address TEXT NOT NULL,
+            to_address TEXT NOT NULL,
+            amount TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            transferred_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, pair_address, event_index)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_lp_transfers_transferred_at ON lp_transfers(transferred_at)`,
+		`CREATE TABLE IF NOT EXISTS lp_positions (
+            pair_address TEXT NOT NULL,
+            provider TEXT NOT NULL,
+            shares TEXT NOT NULL DEFAULT '0',
+            last_ledger INTEGER,
+            updated_at {{timestamp}} NOT NULL,
+            PRIMARY KEY (pair_address, provider)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_lp_positions_provider ON lp_positions(provider)`,
+	}},
+	// Outstanding LP shares of each pair, kept up to date by mints and
+	// burns.
+	{version: 13, name: "pair_total_supply", statements: []string{
+		`ALTER TABLE soroswap_pairs ADD COLUMN total_supply TEXT NOT NULL DEFAULT '0'`,
+	}},
+	// Prices implied by the reserves, set on every sync.
+	{version: 14, name: "pair_prices", statements: []string{
+		`ALTER TABLE soroswap_pairs ADD COLUMN price_0_1 DOUBLE PRECISION`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN price_1_0 DOUBLE PRECISION`,
+	}},
+	// USD prices of tokens derived through reference tokens, and each
+	// pair's value locked in USD.
+	{version: 15, name: "usd_prices", statements: []string{
+		`CREATE TABLE IF NOT EXISTS token_usd_prices (
+            token TEXT NOT NULL PRIMARY KEY,
+            price_usd DOUBLE PRECISION NOT NULL,
+            source_pair TEXT,
+            ledger_sequence INTEGER,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN tvl_usd DOUBLE PRECISION`,
+	}},
+	// Reflector oracle prices next to the pool-implied ones.
+	{version: 16, name: "oracle_prices", statements: []string{
+		`ALTER TABLE token_usd_prices ADD COLUMN oracle_price_usd DOUBLE PRECISION`,
+		`ALTER TABLE token_usd_prices ADD COLUMN oracle_timestamp {{timestamp}}`,
+		`ALTER TABLE token_usd_prices ADD COLUMN oracle_divergence_pct DOUBLE PRECISION`,
+		`ALTER TABLE token_usd_prices ADD COLUMN oracle_divergent BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE token_usd_prices ADD COLUMN oracle_checked_at {{timestamp}}`,
+	}},
+	// Pairs below min_reserve.
+	{version: 17, name: "pair_active", statements: []string{
+		`ALTER TABLE soroswap_pairs ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE`,
+	}},
+	// Hourly and daily per-pair stats, and the traders seen in each bucket
+	// so they are counted once.
+	{version: 18, name: "pair_stats_rollups", statements: []string{
+		`CREATE TABLE IF NOT EXISTS pair_stats_hourly (
+            pair_address TEXT NOT NULL,
+            bucket_start {{timestamp}} NOT NULL,
+            volume_0 TEXT NOT NULL DEFAULT '0',
+            volume_1 TEXT NOT NULL DEFAULT '0',
+            swap_count INTEGER NOT NULL DEFAULT 0,
+            unique_traders INTEGER NOT NULL DEFAULT 0,
+            reserve_0 TEXT,
+            reserve_1 TEXT,
+            close_ledger INTEGER,
+            PRIMARY KEY (pair_address, bucket_start)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_stats_hourly_bucket_start ON pair_stats_hourly(bucket_start)`,
+		`CREATE TABLE IF NOT EXISTS pair_stats_daily (
+            pair_address TEXT NOT NULL,
+            bucket_start {{timestamp}} NOT NULL,
+            volume_0 TEXT NOT NULL DEFAULT '0',
+            volume_1 TEXT NOT NULL DEFAULT '0',
+            swap_count INTEGER NOT NULL DEFAULT 0,
+            unique_traders INTEGER NOT NULL DEFAULT 0,
+            reserve_0 TEXT,
+            reserve_1 TEXT,
+            close_ledger INTEGER,
+            PRIMARY KEY (pair_address, bucket_start)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_stats_daily_bucket_start ON pair_stats_daily(bucket_start)`,
+		`CREATE TABLE IF NOT EXISTS pair_stats_traders (
+            pair_address TEXT NOT NULL,
+            period TEXT NOT NULL,
+            bucket_start {{timestamp}} NOT NULL,
+            trader TEXT NOT NULL,
+            PRIMARY KEY (pair_address, period, bucket_start, trader)
+        )`,
+	}},
+	// Where on chain each row came from: the transaction, the operation in
+	// it and the event in the operation. Pairs record the new_pair event
+	// and their latest sync.
+	{version: 19, name: "event_provenance", statements: []string{
+		`ALTER TABLE soroswap_pairs ADD COLUMN created_tx_hash TEXT`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN created_operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN created_event_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN last_sync_tx_hash TEXT`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN last_sync_operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN last_sync_event_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE pair_reserve_history ADD COLUMN tx_hash TEXT`,
+		`ALTER TABLE pair_reserve_history ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE pair_reserve_history ADD COLUMN event_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE router_swaps ADD COLUMN tx_hash TEXT`,
+		`ALTER TABLE router_swaps ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE router_swaps ADD COLUMN event_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_swaps ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_deposits ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_withdrawals ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE soroswap_protocol_fees ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE router_liquidity ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE aggregator_swaps ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE lp_transfers ADD COLUMN operation_index INTEGER NOT NULL DEFAULT 0`,
+	}},
+	// Syncs that arrived before their pair's new_pair event, applied when
+	// it does with pending_syncs on.
+	{version: 20, name: "pending_syncs", statements: []string{
+		`CREATE TABLE IF NOT EXISTS pending_syncs (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL,
+            reserve_0 TEXT NOT NULL,
+            reserve_1 TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            operation_index INTEGER NOT NULL DEFAULT 0,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            synced_at {{timestamp}} NOT NULL,
+            synced_at_original {{timestamp}},
+            received_at {{timestamp}} NOT NULL
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_syncs_pair ON pending_syncs(pair_address, ledger_sequence, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_syncs_received_at ON pending_syncs(received_at)`,
+	}},
+	// Failed events by error category and event type, with the latest one
+	// as a sample.
+	{version: 21, name: "ingest_errors", statements: []string{
+		`CREATE TABLE IF NOT EXISTS ingest_errors (
+            category TEXT NOT NULL,
+            event_type TEXT NOT NULL,
+            count BIGINT NOT NULL DEFAULT 0,
+            first_seen_at {{timestamp}} NOT NULL,
+            last_seen_at {{timestamp}} NOT NULL,
+            sample_error TEXT,
+            sample_payload TEXT,
+            sample_ledger INTEGER,
+            PRIMARY KEY (category, event_type)
+        )`,
+	}},
+	// Reserves in whole tokens, for aggregating in SQL. reserve_0 and
+	// reserve_1 stay the exact values.
+	{version: 22, name: "scaled_reserves", statements: []string{
+		`ALTER TABLE soroswap_pairs ADD COLUMN reserve_0_scaled {{decimal}}`,
+		`ALTER TABLE soroswap_pairs ADD COLUMN reserve_1_scaled {{decimal}}`,
+	}},
+	// Pairs trading the same tokens as an older pair, in either order.
+	// Pairs already stored are linked to every older pair of their token
+	// set.
+	{version: 23, name: "pair_aliases", statements: []string{
+		`CREATE TABLE IF NOT EXISTS pair_aliases (
+            pair_address TEXT NOT NULL,
+            alias_of TEXT NOT NULL,
+            relation TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            detected_at {{timestamp}} NOT NULL,
+            PRIMARY KEY (pair_address, alias_of)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_pair_aliases_alias_of ON pair_aliases(alias_of)`,
+		`INSERT INTO pair_aliases (pair_address, alias_of, relation, ledger_sequence, detected_at)
+        SELECT p.pair_address, o.pair_address,
+            CASE WHEN p.tokens_flipped = o.tokens_flipped THEN 'duplicate' ELSE 'reversed' END,
+            p.created_at_ledger, p.created_at
+        FROM soroswap_pairs p JOIN soroswap_pairs o
+            ON o.token_a = p.token_a AND o.token_b = p.token_b
+            AND COALESCE(o.network, '') = COALESCE(p.network, '')
+            AND (o.created_at < p.created_at OR (o.created_at = p.created_at AND o.pair_address < p.pair_address))
+        WHERE NOT p.placeholder AND NOT o.placeholder`,
+	}},
+	// Tables partition_by_month split into monthly partitions, and their
+	// partitions.
+	{version: 24, name: "table_partitions", statements: []string{
+		`CREATE TABLE IF NOT EXISTS partitioned_tables (
+            table_name TEXT NOT NULL PRIMARY KEY,
+            time_column TEXT NOT NULL,
+            partitioned_at {{timestamp}} NOT NULL
+        )`,
+		`CREATE TABLE IF NOT EXISTS table_partitions (
+            table_name TEXT NOT NULL,
+            month TEXT NOT NULL,
+            created_at {{timestamp}} NOT NULL,
+            PRIMARY KEY (table_name, month)
+        )`,
+	}},
+	// Keys of the events a Reprocess job has applied, so it skips
+	// duplicate deliveries in the archive across resumes.
+	{version: 25, name: "reprocess_applied_events", statements: []string{
+		`CREATE TABLE IF NOT EXISTS reprocess_applied_events (
+            job TEXT NOT NULL,
+            event_key TEXT NOT NULL,
+            PRIMARY KEY (job, event_key)
+        )`,
+	}},
+}
+
+const (
+	createMigrationsTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER NOT NULL PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at {{timestamp}} NOT NULL
+        )`
+
+	selectMigrationsQuery = `SELECT version FROM schema_migrations`
+
+	insertMigrationQuery = `
+        INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)
+    `
+)
+
+// createSchema applies the pending migrations. Callers hold the schema
+// lock, so concurrent starts do not apply the same step twice.
+func createSchema(ctx context.Context, db *sql.DB, b backend) error {
+	if _, err := db.ExecContext(ctx, b.DDL(createMigrationsTableQuery)); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	applied, err := appliedMigrations(ctx, db, b)
+	if err != nil {
+		return err
+	}
+
+	if err := checkNotNewer(applied); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := applyMigration(ctx, db, b, m); err != nil {
+			return fmt.Errorf("schema migration %d (%s) failed: %v", m.version, m.name, err)
+		}
+		logger.Info("Applied schema migration", "version", m.version, "name", m.name)
+	}
+	return nil
+}
+
+// checkSchemaCurrent stands in for createSchema in dry runs, which must not
+// change an existing database: it only creates the schema of an empty one,
+// and fails when an existing one needs migrations.
+func checkSchemaCurrent(ctx context.Context, db *sql.DB, b backend) error {
+	tracked, err := b.ColumnExists(ctx, db, "schema_migrations", "version")
+	if err != nil {
+		return fmt.Errorf("failed to inspect schema_migrations: %v", err)
+	}
+	if !tracked {
+		legacy, err := b.ColumnExists(ctx, db, "soroswap_pairs", "pair_address")
+		if err != nil {
+			return fmt.Errorf("failed to inspect soroswap_pairs: %v", err)
+		}
+		if !legacy {
+			return createSchema(ctx, db, b)
+		}
+		return fmt.Errorf("dry run: the database predates schema migrations; run once without dry_run or dry-run against a copy")
+	}
+
+	applied, err := appliedMigrations(ctx, db, b)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNewer(applied); err != nil {
+		return err
+	}
+	var pending []string
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, fmt.Sprintf("%d (%s)", m.version, m.name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("dry run: the database needs schema migrations %s; run once without dry_run or dry-run against a copy",
+			strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// checkNotNewer refuses databases migrated by a newer plugin.
+func checkNotNewer(applied map[int]bool) error {
+	latest := migrations[len(migrations)-1].version
+	for v := range applied {
+		if v > latest {
+			return fmt.Errorf("database schema is at version %d, newer than the %d this plugin supports", v, latest)
+		}
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, db *sql.DB, b backend) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, b.Rebind(selectMigrationsQuery))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+		applied[v] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	return applied, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, b backend, m migration) error {
+	if m.apply != nil {
+		if err := m.apply(ctx, db, b); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(ctx, b.Rebind(insertMigrationQuery), m.version, m.name, time.Now().UTC())
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, b.DDL(stmt)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, b.Rebind(insertMigrationQuery), m.version, m.name, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the latest migration applied to the database.
+func (s *SaveSoroswapPairsToSQLite) SchemaVersion(ctx context.Context) (int, error) {
+	var v sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, s.backend.Rebind("SELECT MAX(version) FROM schema_migrations")).Scan(&v); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return int(v.Int64), nil
+}
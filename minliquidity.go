@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+)
+
+// setPairActiveQuery flips a pair's active flag, reporting a change
+// through the affected row count.
+const setPairActiveQuery = `UPDATE soroswap_pairs SET active = ? WHERE pair_address = ? AND active <> ?`
+
+func init() {
+	registerHandlerQuery(setPairActiveQuery)
+}
+
+// parseMinReserve reads min_reserve, the raw reserve either side of a pair
+// must reach for it to count as active. nil leaves every pair active.
+func parseMinReserve(cfg *Config) (*big.Int, error) {
+	raw := cfg.MinReserve
+	if raw == nil {
+		return nil, nil
+	}
+	value := fmt.Sprint(raw)
+	v, ok := new(big.Int).SetString(value, 10)
+	if !ok || v.Sign() <= 0 {
+		return nil, fmt.Errorf("config min_reserve must be a positive integer, got %q", value)
+	}
+	return v, nil
+}
+
+// isDust reports whether either reserve is below min_reserve. Unparsable
+// reserves are not dust.
+func (s *SaveSoroswapPairsToSQLite) isDust(reserve0, reserve1 string) bool {
+	if s.minReserve == nil {
+		return false
+	}
+	for _, reserve := range []string{reserve0, reserve1} {
+		if r, ok := new(big.Int).SetString(reserve, 10); ok && r.Cmp(s.minReserve) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// markActive sets a pair's active flag from the reserves it now holds.
+func (s *SaveSoroswapPairsToSQLite) markActive(ctx context.Context, tx *sql.Tx, pair, reserve0, reserve1 string) error {
+	if s.minReserve == nil {
+		return nil
+	}
+	active := !s.isDust(reserve0, reserve1)
+	res, err := s.stmts.exec(ctx, tx, setPairActiveQuery, active, pair, active)
+	if err != nil {
+		return fmt.Errorf("failed to update active flag of %s: %v", pair, err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 && !s.backfill {
+		logger.Info("Pair liquidity crossed min_reserve", "pair", pair, "active", active,
+			"reserve0", reserve0, "reserve1", reserve1)
+	}
+	return nil
+}
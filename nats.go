@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsSink publishes persisted events to NATS on one subject per pair,
+// <nats_subject_prefix>.<pair address>, through JetStream unless
+// nats_jetstream is off.
+type natsSink struct {
+	url       string
+	prefix    string
+	enrich    bool
+	jetStream bool
+	timeout   time.Duration
+	options   []nats.Option
+
+	// The connection is opened by the first Publish, so Initialize never
+	// waits for NATS or leaks a connection when it fails later.
+	nc *nats.Conn
+	js jetstream.JetStream
+}
+
+// parseNATSSink reads the nats_* settings, returning nil when nats_url is
+// unset.
+func parseNATSSink(cfg *Config) (eventSink, error) {
+	if cfg.NATSURL == "" {
+		return nil, nil
+	}
+	n := &natsSink{
+		url:       cfg.NATSURL,
+		prefix:    cfg.NATSSubjectPrefix,
+		enrich:    cfg.NATSEnrich,
+		jetStream: cfg.NATSJetStream,
+		timeout:   cfg.NATSTimeout,
+	}
+	if n.prefix == "" {
+		return nil, fmt.Errorf("config nats_subject_prefix must not be empty")
+	}
+
+	n.options = []nats.Option{
+		nats.Name("soroswap-pairs-consumer"),
+		nats.Timeout(n.timeout),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if cfg.NATSCredsFile != "" {
+		n.options = append(n.options, nats.UserCredentials(cfg.NATSCredsFile))
+	}
+	if cfg.NATSToken != "" {
+		n.options = append(n.options, nats.Token(cfg.NATSToken))
+	}
+	return n, nil
+}
+
+func (n *natsSink) Name() string { return "nats" }
+
+func (n *natsSink) Enrich() bool { return n.enrich }
+
+func (n *natsSink) connect() error {
+	if n.nc != nil {
+		return nil
+	}
+	nc, err := nats.Connect(n.url, n.options...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", n.url, err)
+	}
+	if n.jetStream {
+		if n.js, err = jetstream.New(nc); err != nil {
+			nc.Close()
+			return err
+		}
+	}
+	n.nc = nc
+	return nil
+}
+
+// Publish sends the events in order. Through JetStream each is
+// acknowledged by the stream and carries a Nats-Msg-Id derived from its
+// content, so the stream drops copies published twice; core NATS
+// publishes are flushed to the server.
+func (n *natsSink) Publish(ctx context.Context, events []forwardedEvent) error {
+	if err := n.connect(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	for _, ev := range events {
+		data := []byte(ev.Event)
+		if n.enrich {
+			var err error
+			if data, err = ev.enriched(); err != nil {
+				return fmt.Errorf("failed to encode %s event: %v", ev.Type, err)
+			}
+		}
+		msg := nats.NewMsg(n.subject(ev.PairAddress))
+		msg.Data = data
+		msg.Header.Set("Soroswap-Event-Type", ev.Type)
+		msg.Header.Set("Soroswap-Outcome", ev.Outcome)
+
+		if n.js == nil {
+			if err := n.nc.PublishMsg(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		sum := sha256.Sum256(append([]byte(ev.Type+"\x00"), ev.Event...))
+		if _, err := n.js.PublishMsg(ctx, msg, jetstream.WithMsgID(hex.EncodeToString(sum[:16]))); err != nil {
+			return fmt.Errorf("%s: %v", msg.Subject, err)
+		}
+	}
+	if n.js == nil {
+		return n.nc.FlushWithContext(ctx)
+	}
+	return nil
+}
+
+func (n *natsSink) subject(pair string) string {
+	if pair == "" {
+		pair = "unknown"
+	}
+	return n.prefix + "." + pair
+}
+
+func (n *natsSink) Close() error {
+	if n.nc == nil {
+		return nil
+	}
+	n.nc.Close()
+	n.nc, n.js = nil, nil
+	return nil
+}
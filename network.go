@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// ErrWrongNetwork is returned for events from a different Stellar network
+// than the consumer is configured for.
+var ErrWrongNetwork = errors.New("event from another network")
+
+// networkNames maps the well-known passphrases and aliases to the names
+// stored in the network column.
+var networkNames = map[string]string{
+	"public global stellar network ; september 2015": "mainnet",
+	"test sdf network ; september 2015":              "testnet",
+	"test sdf future network ; october 2022":         "futurenet",
+	"public":                                         "mainnet",
+	"pubnet":                                         "mainnet",
+}
+
+// normalizeNetwork returns the stored name of a network given by name or
+// passphrase. Unknown networks, such as standalone ones, keep their
+// lower-cased value.
+func normalizeNetwork(network string) string {
+	network = strings.ToLower(strings.TrimSpace(network))
+	if name, ok := networkNames[network]; ok {
+		return name
+	}
+	return network
+}
+
+// eventNetwork returns the network an event names in its payload or the
+// message metadata, or "" when it names none.
+func eventNetwork(payload, passphrase string, metadata map[string]interface{}) string {
+	for _, v := range []string{payload, passphrase} {
+		if v != "" {
+			return normalizeNetwork(v)
+		}
+	}
+	for _, key := range []string{"network", "network_passphrase"} {
+		if v, _ := metadata[key].(string); v != "" {
+			return normalizeNetwork(v)
+		}
+	}
+	return ""
+}
+
+// checkEventNetwork rejects events naming another network than the
+// configured one. Events naming none are accepted.
+func (s *SaveSoroswapPairsToSQLite) checkEventNetwork(payload, passphrase string, msg pluginapi.Message) error {
+	if s.network == "" {
+		return nil
+	}
+	if network := eventNetwork(payload, passphrase, msg.Metadata); network != "" && network != s.network {
+		return fmt.Errorf("%w: %s, configured for %s", ErrWrongNetwork, network, s.network)
+	}
+	return nil
+}
+
+// adoptNetwork checks that the stored pairs belong to the configured
+// network and tags pairs written before networks were recorded. Without a
+// configured network, pairs tagged with one are refused, since writing
+// untagged rows among them is how networks get mixed.
+func (s *SaveSoroswapPairsToSQLite) adoptNetwork(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind(
+		"SELECT DISTINCT network FROM soroswap_pairs WHERE network IS NOT NULL"))
+	if err != nil {
+		return fmt.Errorf("failed to read stored networks: %v", err)
+	}
+	var stored []string
+	for rows.Next() {
+		var network string
+		if err := rows.Scan(&network); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read stored networks: %v", err)
+		}
+		stored = append(stored, network)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to read stored networks: %v", err)
+	}
+
+	for _, network := range stored {
+		if network != s.network {
+			if s.network == "" {
+				return fmt.Errorf("database holds pairs of network %s; set network to match", network)
+			}
+			return fmt.Errorf("database holds pairs of network %s, not %s; use another database or table_prefix", network, s.network)
+		}
+	}
+	if s.network == "" || s.dryRun {
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx, s.backend.Rebind(
+		"UPDATE soroswap_pairs SET network = ? WHERE network IS NULL"), s.network)
+	if err != nil {
+		return fmt.Errorf("failed to tag pairs with network %s: %v", s.network, err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Tagged existing pairs with network", "pairs", n, "network", s.network)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNetworkOnePerDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pairs.sqlite")
+	s := openTestConsumer(t, map[string]interface{}{"db_path": path, "network": "Public Global Stellar Network ; September 2015"})
+	process(t, s, newPairEvent(testPair, 10))
+	if p := getPair(t, s, testPair); p.Network != "mainnet" {
+		t.Errorf("network = %q, want mainnet", p.Network)
+	}
+	s.Close()
+
+	tests := []struct {
+		name    string
+		network interface{}
+		wantErr string
+	}{
+		{"same network", "mainnet", ""},
+		{"other network", "testnet", "holds pairs of network mainnet, not testnet"},
+		{"no network", nil, "set network to match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(map[string]interface{}{"db_path": path, "network": tt.network})
+			if tt.network == nil {
+				delete(config, "network")
+			}
+			s := New().(*SaveSoroswapPairsToSQLite)
+			err := s.Initialize(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Initialize: %v", err)
+				}
+				s.Close()
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Initialize error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetworkEventRejected(t *testing.T) {
+	s := newTestConsumer(t, map[string]interface{}{"network": "mainnet", "strict_mode": true})
+	process(t, s, newPairEvent(testPair, 10))
+	err := s.Process(context.Background(),
+		syncEvent(testPair, "100", "5", 20).with(event{"network_passphrase": "Test SDF Network ; September 2015"}).message(t))
+	if !errors.Is(err, ErrWrongNetwork) {
+		t.Fatalf("Process = %v, want %v", err, ErrWrongNetwork)
+	}
+	if p := getPair(t, s, testPair); p.LastSyncLedger != nil {
+		t.Errorf("sync of another network was applied at ledger %d", *p.LastSyncLedger)
+	}
+}
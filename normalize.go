@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// addressColumn is a column holding pair or token addresses, outside
+// soroswap_pairs. When the column is part of a unique key, key lists the
+// key's other columns.
+type addressColumn struct {
+	table  string
+	column string
+	unique bool
+	key    []string
+}
+
+// addressColumns are the columns NormalizeExistingRows rewrites besides
+// those of soroswap_pairs. Token paths, stored as JSON lists, are left as
+// written.
+var addressColumns = []addressColumn{
+	{table: "pair_reserve_history", column: "pair_address"},
+	{table: "soroswap_swaps", column: "pair_address", unique: true, key: []string{"tx_hash", "event_index"}},
+	{table: "soroswap_deposits", column: "pair_address", unique: true, key: []string{"tx_hash", "event_index"}},
+	{table: "soroswap_withdrawals", column: "pair_address", unique: true, key: []string{"tx_hash", "event_index"}},
+	{table: "soroswap_protocol_fees", column: "pair_address", unique: true, key: []string{"tx_hash", "event_index"}},
+	{table: "lp_transfers", column: "pair_address", unique: true, key: []string{"tx_hash", "event_index"}},
+	{table: "lp_positions", column: "pair_address", unique: true, key: []string{"provider"}},
+	{table: "router_liquidity", column: "pair_address", unique: true, key: []string{"tx_hash", "event_index"}},
+	{table: "router_liquidity", column: "token_a"},
+	{table: "router_liquidity", column: "token_b"},
+	{table: "router_swap_hops", column: "pair_address"},
+	{table: "router_swap_hops", column: "pair_ref"},
+	{table: "router_swap_hops", column: "token_in"},
+	{table: "router_swap_hops", column: "token_out"},
+	{table: "router_swaps", column: "token_in"},
+	{table: "router_swaps", column: "token_out"},
+	{table: "aggregator_swaps", column: "token_in"},
+	{table: "aggregator_swaps", column: "token_out"},
+	{table: "pair_fees", column: "pair_address", unique: true},
+	{table: "pair_candles", column: "pair_address", unique: true, key: []string{"resolution", "bucket_start"}},
+	{table: "pair_stats_hourly", column: "pair_address", unique: true, key: []string{"bucket_start"}},
+	{table: "pair_stats_daily", column: "pair_address", unique: true, key: []string{"bucket_start"}},
+	{table: "pair_stats_traders", column: "pair_address", unique: true, key: []string{"period", "bucket_start", "trader"}},
+	{table: "pair_volume_hourly", column: "pair_address", unique: true, key: []string{"hour_start"}},
+	{table: "pair_volume_stats", column: "pair_address", unique: true},
+	{table: "pair_aliases", column: "pair_address", unique: true, key: []string{"alias_of"}},
+	{table: "pair_aliases", column: "alias_of", unique: true, key: []string{"pair_address"}},
+	{table: "pending_syncs", column: "pair_address"},
+	{table: "pair_deletions", column: "pair_address"},
+	{table: "webhook_deliveries", column: "pair_address"},
+	{table: "alerts", column: "pair_address", unique: true, key: []string{"rule_id", "ledger_sequence"}},
+	{table: "alerts", column: "token"},
+	{table: "tokens", column: "address", unique: true},
+	{table: "token_usd_prices", column: "token", unique: true},
+}
+
+// pairRow is the subset of a soroswap_pairs row needed to merge duplicates.
+type pairRow struct {
+	address    string
+	network    string
+	token0     string
+	token1     string
+	syncLedger sql.NullInt64
+}
+
+// NormalizeExistingRows rewrites rows stored before address normalization
+// was introduced so every pair and token address, in soroswap_pairs and in
+// addressColumns, is in canonical form, in one transaction. Pairs of a
+// network whose addresses collapse to the same canonical address are
+// merged, keeping the row with the newest last_sync_ledger; where the
+// merged pairs both have a row of a keyed table, such as a daily rollup,
+// the kept pair's row wins. Canonical addresses shared by pairs of
+// different networks, and undecodable addresses, are logged and left
+// untouched.
+func (s *SaveSoroswapPairsToSQLite) NormalizeExistingRows(ctx context.Context) error {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Tables of features never enabled may not exist.
+	var columns []addressColumn
+	for _, c := range addressColumns {
+		ok, err := s.backend.ColumnExists(ctx, s.db, c.table, c.column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %v", c.table, err)
+		}
+		if ok {
+			columns = append(columns, c)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind(`
+        SELECT pair_address, COALESCE(network, ''), COALESCE(token_0, ''), COALESCE(token_1, ''), last_sync_ledger
+        FROM soroswap_pairs`))
+	if err != nil {
+		return fmt.Errorf("failed to query pairs: %v", err)
+	}
+	// Pairs are grouped by network and canonical address.
+	type groupKey struct{ network, canonical string }
+	groups := make(map[groupKey][]pairRow)
+	networks := make(map[string]map[string]bool)
+	var order []groupKey
+	for rows.Next() {
+		var r pairRow
+		if err := rows.Scan(&r.address, &r.network, &r.token0, &r.token1, &r.syncLedger); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pair: %v", err)
+		}
+		canonical, err := normalizeAddress(r.address)
+		if err != nil {
+			logger.Warn("Leaving pair unnormalized", "pair", r.address, "error", err)
+			continue
+		}
+		key := groupKey{r.network, canonical}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], r)
+		if networks[canonical] == nil {
+			networks[canonical] = make(map[string]bool)
+		}
+		networks[canonical][r.network] = true
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to read pairs: %v", err)
+	}
+
+	pairColumns, err := pairTableColumns(ctx, tx, s.backend)
+	if err != nil {
+		return err
+	}
+	copyPair := s.backend.Rebind(fmt.Sprintf(
+		"INSERT INTO soroswap_pairs (pair_address, %[1]s) SELECT ?, %[1]s FROM soroswap_pairs WHERE pair_address = ?",
+		strings.Join(pairColumns, ", ")))
+	overwritePair := s.backend.Rebind(fmt.Sprintf(
+		"UPDATE soroswap_pairs SET (%[1]s) = (SELECT %[1]s FROM soroswap_pairs WHERE pair_address = ?) WHERE pair_address = ?",
+		strings.Join(pairColumns, ", ")))
+	updateTokens := s.backend.Rebind(`
+        UPDATE soroswap_pairs
+        SET token_0 = ?, token_1 = ?, token_a = ?, token_b = ?, tokens_flipped = ?
+        WHERE pair_address = ?
+    `)
+	deletePair := s.backend.Rebind("DELETE FROM soroswap_pairs WHERE pair_address = ?")
+
+	// kept are the addresses of the pairs kept in merges, whose rows win in
+	// keyed tables; skip are those left untouched.
+	kept := make(map[string]bool)
+	skip := make(map[string]bool)
+	var losers []string
+	var rewritten, merged int
+	for _, key := range order {
+		group := groups[key]
+		if len(networks[key.canonical]) > 1 {
+			logger.Warn("Leaving pair unnormalized: its canonical address is stored on several networks",
+				"pair", key.canonical, "network", key.network)
+			for _, r := range group {
+				skip[r.address] = true
+			}
+			continue
+		}
+
+		// Keep the most recently synced row; unsynced rows lose to any
+		// synced one.
+		keep := group[0]
+		stored := false
+		for _, r := range group {
+			if r.syncLedger.Int64 > keep.syncLedger.Int64 ||
+				(r.syncLedger.Valid && !keep.syncLedger.Valid) {
+				keep = r
+			}
+			stored = stored || r.address == key.canonical
+		}
+		kept[keep.address] = true
+
+		// Child rows reference pairs by address, so the kept pair is copied
+		// to its canonical address before they are moved to it, and the
+		// other rows deleted only after.
+		switch {
+		case keep.address == key.canonical:
+		case stored:
+			if _, err := tx.ExecContext(ctx, overwritePair, keep.address, key.canonical); err != nil {
+				return fmt.Errorf("failed to merge pair %q: %v", keep.address, err)
+			}
+		default:
+			if _, err := tx.ExecContext(ctx, copyPair, key.canonical, keep.address); err != nil {
+				return fmt.Errorf("failed to normalize pair %q: %v", keep.address, err)
+			}
+		}
+		for _, r := range group {
+			if r.address == key.canonical {
+				continue
+			}
+			if r.address != keep.address {
+				logger.Info("Merging duplicate pair", "pair", r.address, "into", key.canonical,
+					"keeping", keep.address, "ledger", keep.syncLedger.Int64, "network", key.network)
+				merged++
+			}
+			losers = append(losers, r.address)
+		}
+
+		token0, err0 := normalizeAddress(keep.token0)
+		token1, err1 := normalizeAddress(keep.token1)
+		if err0 != nil || err1 != nil {
+			logger.Warn("Leaving tokens of pair unnormalized", "pair", key.canonical)
+			token0, token1 = keep.token0, keep.token1
+		}
+		if keep.address == key.canonical && keep.token0 == token0 && keep.token1 == token1 {
+			continue
+		}
+		tokenA, tokenB, flipped := canonicalTokens(token0, token1)
+		// Placeholder pairs keep their null tokens.
+		if _, err := tx.ExecContext(ctx, updateTokens,
+			nullableString(token0), nullableString(token1),
+			nullableString(tokenA), nullableString(tokenB), flipped, key.canonical); err != nil {
+			return fmt.Errorf("failed to normalize pair %q: %v", keep.address, err)
+		}
+		rewritten++
+	}
+
+	var moved int64
+	for _, c := range columns {
+		n, err := s.normalizeColumn(ctx, tx, c, kept, skip)
+		if err != nil {
+			return err
+		}
+		moved += n
+	}
+	for _, address := range losers {
+		if _, err := tx.ExecContext(ctx, deletePair, address); err != nil {
+			return fmt.Errorf("failed to delete pair %q: %v", address, err)
+		}
+	}
+
+	if err := s.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit normalization: %v", err)
+	}
+	logger.Info("Normalized existing rows", "pairs_rewritten", rewritten, "pairs_merged", merged, "rows_rewritten", moved)
+	return nil
+}
+
+// pairTableColumns returns the columns of soroswap_pairs other than
+// pair_address.
+func pairTableColumns(ctx context.Context, tx *sql.Tx, b backend) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, b.Rebind("SELECT * FROM soroswap_pairs LIMIT 0"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pair columns: %v", err)
+	}
+	defer rows.Close()
+	all, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pair columns: %v", err)
+	}
+	var columns []string
+	for _, column := range all {
+		if column != "pair_address" {
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// normalizeColumn rewrites the addresses of one address column in tx,
+// returning the number of rows changed. In a unique key, a row the rewrite
+// would collide with is deleted: the one stored under the canonical
+// address, unless the rewritten address is kept, whose rows win.
+func (s *SaveSoroswapPairsToSQLite) normalizeColumn(ctx context.Context, tx *sql.Tx, c addressColumn, kept, skip map[string]bool) (int64, error) {
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind(fmt.Sprintf(
+		"SELECT DISTINCT %[2]s FROM %[1]s WHERE %[2]s IS NOT NULL", c.table, c.column)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s: %v", c.table, err)
+	}
+	var addresses []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s: %v", c.table, err)
+		}
+		if canonical, err := normalizeAddress(address); err == nil && canonical != address && !skip[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", c.table, err)
+	}
+	if len(addresses) == 0 {
+		return 0, nil
+	}
+	// Kept addresses go first, so the rows of the others collide with
+	// theirs.
+	sort.SliceStable(addresses, func(i, j int) bool {
+		if kept[addresses[i]] != kept[addresses[j]] {
+			return kept[addresses[i]]
+		}
+		return addresses[i] < addresses[j]
+	})
+
+	deletes, err := onPartitions(ctx, tx, s.backend, c.table, conflictDelete(c))
+	if err != nil {
+		return 0, err
+	}
+	updates, err := onPartitions(ctx, tx, s.backend, c.table, fmt.Sprintf(
+		"UPDATE %[1]s SET %[2]s = ? WHERE %[2]s = ?", c.table, c.column))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, address := range addresses {
+		canonical, _ := normalizeAddress(address)
+		if c.unique {
+			loser, winner := address, canonical
+			if kept[address] {
+				loser, winner = canonical, address
+			}
+			for _, stmt := range deletes {
+				if _, err := tx.ExecContext(ctx, s.backend.Rebind(stmt), loser, winner); err != nil {
+					return 0, fmt.Errorf("failed to merge %s rows of %q: %v", c.table, address, err)
+				}
+			}
+		}
+		for _, stmt := range updates {
+			result, err := tx.ExecContext(ctx, s.backend.Rebind(stmt), canonical, address)
+			if err != nil {
+				return 0, fmt.Errorf("failed to normalize %s rows of %q: %v", c.table, address, err)
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// conflictDelete returns the statement deleting the rows of the address
+// given first whose key is also held by the address given second.
+func conflictDelete(c addressColumn) string {
+	match := []string{fmt.Sprintf("k.%s = ?", c.column)}
+	for _, column := range c.key {
+		match = append(match, fmt.Sprintf("k.%[2]s = %[1]s.%[2]s", c.table, column))
+	}
+	return fmt.Sprintf("DELETE FROM %[1]s WHERE %[2]s = ? AND EXISTS (SELECT 1 FROM %[1]s AS k WHERE %[3]s)",
+		c.table, c.column, strings.Join(match, " AND "))
+}
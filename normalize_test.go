@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// storeAs rewrites every stored occurrence of address to raw, as written by
+// versions before address normalization.
+func storeAs(t *testing.T, s *SaveSoroswapPairsToSQLite, address, raw string) {
+	t.Helper()
+	for _, c := range append([]addressColumn{
+		{table: "soroswap_pairs", column: "pair_address"},
+		{table: "soroswap_pairs", column: "token_0"},
+	}, addressColumns...) {
+		if ok, err := s.backend.ColumnExists(context.Background(), s.db, c.table, c.column); err != nil || !ok {
+			continue
+		}
+		if _, err := s.db.Exec(s.backend.Rebind("UPDATE "+c.table+" SET "+c.column+" = ? WHERE "+c.column+" = ?"), raw, address); err != nil {
+			t.Fatalf("rewrite %s.%s: %v", c.table, c.column, err)
+		}
+	}
+}
+
+func TestNormalizeExistingRows(t *testing.T) {
+	ctx := context.Background()
+	s := newTestConsumer(t, map[string]interface{}{"reserve_history": true, "stats_rollups": true})
+	// testPair2 becomes a second spelling of testPair: the newer sync at
+	// ledger 30 is kept, and both swaps share a transaction and index.
+	process(t, s,
+		newPairEvent(testPair, 10), syncEvent(testPair, "100", "5", 30), swapEvent(testPair, "aa01", 31),
+		newPairEvent(testPair2, 10), syncEvent(testPair2, "50", "5", 20), swapEvent(testPair2, "aa01", 21),
+	)
+	storeAs(t, s, testPair, strings.ToLower(testPair))
+	storeAs(t, s, testPair2, " "+testPair+" ")
+	storeAs(t, s, testTokenA, strings.ToLower(testTokenA))
+
+	if err := s.NormalizeExistingRows(ctx); err != nil {
+		t.Fatalf("NormalizeExistingRows: %v", err)
+	}
+
+	p := getPair(t, s, testPair)
+	if p.Reserve0 != "100" || p.Token0 != testTokenA {
+		t.Errorf("merged pair = %s/%s, want reserves of ledger 30 and token %s", p.Reserve0, p.Token0, testTokenA)
+	}
+	tests := []struct {
+		table string
+		want  int
+	}{
+		{"soroswap_pairs", 1},
+		{"pair_reserve_history", 2},
+		{"soroswap_swaps", 1},
+		{"pair_stats_daily", 1},
+	}
+	for _, tt := range tests {
+		var n int
+		if err := s.db.QueryRow(s.backend.Rebind("SELECT COUNT(*) FROM "+tt.table+" WHERE pair_address = ?"), testPair).Scan(&n); err != nil {
+			t.Fatalf("count %s: %v", tt.table, err)
+		}
+		if n != tt.want || countRows(t, s, tt.table) != tt.want {
+			t.Errorf("%s: %d canonical rows of %d, want %d", tt.table, n, countRows(t, s, tt.table), tt.want)
+		}
+	}
+	var reserve string
+	if err := s.db.QueryRow(s.backend.Rebind("SELECT reserve_0 FROM pair_stats_daily WHERE pair_address = ?"), testPair).Scan(&reserve); err != nil {
+		t.Fatalf("query daily stats: %v", err)
+	}
+	if reserve != "100" {
+		t.Errorf("daily stats reserve_0 = %s, want the kept pair's 100", reserve)
+	}
+
+	// Running again finds nothing to do.
+	if err := s.NormalizeExistingRows(ctx); err != nil {
+		t.Fatalf("NormalizeExistingRows again: %v", err)
+	}
+	if n := countRows(t, s, "pair_reserve_history"); n != 2 {
+		t.Errorf("history rows after second run = %d, want 2", n)
+	}
+}
+
+func TestNormalizeExistingRowsNetworks(t *testing.T) {
+	s := newTestConsumer(t, nil)
+	process(t, s, newPairEvent(testPair, 10), newPairEvent(testPair2, 10))
+	storeAs(t, s, testPair, strings.ToLower(testPair))
+	storeAs(t, s, testPair2, " "+testPair)
+	for address, network := range map[string]string{strings.ToLower(testPair): "mainnet", " " + testPair: "testnet"} {
+		if _, err := s.db.Exec(s.backend.Rebind("UPDATE soroswap_pairs SET network = ? WHERE pair_address = ?"), network, address); err != nil {
+			t.Fatalf("tag network: %v", err)
+		}
+	}
+
+	if err := s.NormalizeExistingRows(context.Background()); err != nil {
+		t.Fatalf("NormalizeExistingRows: %v", err)
+	}
+	// Pairs of different networks are never merged.
+	if n := countRows(t, s, "soroswap_pairs"); n != 2 {
+		t.Errorf("pairs = %d, want 2", n)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+// reflectorOracle cross-checks the pool-implied USD prices against a
+// Reflector price oracle (SEP-40), read over Soroban RPC.
+type reflectorOracle struct {
+	rpc      *sorobanRPC
+	contract string
+	// contractID is the raw contract hash of contract.
+	contractID []byte
+	interval   time.Duration
+	// divergencePct is the difference from the oracle price, in percent,
+	// beyond which a pool price is flagged.
+	divergencePct float64
+	// decimals is the oracle's price precision, read on the first check.
+	decimals *int64
+}
+
+func parseReflectorOracle(cfg *Config, usd usdPricing) (*reflectorOracle, error) {
+	if cfg.ReflectorContract == "" {
+		return nil, nil
+	}
+	if !usd.enabled() {
+		return nil, fmt.Errorf("config reflector_contract requires usd_stablecoins, whose prices it checks")
+	}
+	o := &reflectorOracle{interval: cfg.ReflectorInterval, divergencePct: cfg.ReflectorDivergencePct}
+	var err error
+	if o.contract, err = normalizeAddress(cfg.ReflectorContract); err != nil {
+		return nil, fmt.Errorf("config reflector_contract: %v", err)
+	}
+	version, id, err := decodeStrkey(o.contract)
+	if err != nil || version != strkeyVersionContract {
+		return nil, fmt.Errorf("config reflector_contract: %s is not a contract address", o.contract)
+	}
+	o.contractID = id
+	url := cfg.ReflectorRPCURL
+	if url == "" {
+		url = cfg.TokenRPCURL
+	}
+	if url == "" {
+		return nil, fmt.Errorf("config reflector_contract requires reflector_rpc_url or token_rpc_url")
+	}
+	if o.divergencePct <= 0 {
+		return nil, fmt.Errorf("config reflector_divergence_pct must be positive, got %v", o.divergencePct)
+	}
+	if o.rpc, err = newSorobanRPC(url, cfg.TokenRPCSourceAccount, cfg.TokenRPCTimeout); err != nil {
+		return nil, fmt.Errorf("config token_rpc_source_account: %v", err)
+	}
+	return o, nil
+}
+
+const (
+	pooledUSDPricesQuery = `SELECT token, price_usd FROM token_usd_prices ORDER BY token`
+
+	setOraclePriceQuery = `
+        UPDATE token_usd_prices SET
+            oracle_price_usd = ?, oracle_timestamp = ?, oracle_divergence_pct = ?,
+            oracle_divergent = ?, oracle_checked_at = ?
+        WHERE token = ?
+    `
+)
+
+// lastPrice reads the oracle's latest USD price of a token, nil when the
+// oracle does not quote it.
+func (o *reflectorOracle) lastPrice(ctx context.Context, token string) (*float64, time.Time, error) {
+	_, id, err := decodeStrkey(token)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// The oracle's Asset enum: Stellar(Address) for Soroban tokens.
+	v, err := o.rpc.call(ctx, o.contract, "lastprice", scvVec(scvSymbol("Stellar"), scvContract(id)))
+	if err != nil || v == nil {
+		return nil, time.Time{}, err
+	}
+	data, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("lastprice() returned %T", v)
+	}
+	price, ok1 := data["price"].(*big.Int)
+	timestamp, ok2 := data["timestamp"].(uint64)
+	if !ok1 || !ok2 {
+		return nil, time.Time{}, fmt.Errorf("lastprice() returned no price or timestamp")
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(*o.decimals), nil)
+	p, _ := new(big.Rat).SetFrac(price, scale).Float64()
+	return &p, time.Unix(int64(timestamp), 0).UTC(), nil
+}
+
+// checkOracle stores the oracle price of every token with a pool-implied
+// USD price next to it, and flags those diverging by more than
+// reflector_divergence_pct.
+func (s *SaveSoroswapPairsToSQLite) checkOracle(ctx context.Context) error {
+	o := s.oracle
+	if o.decimals == nil {
+		v, err := o.rpc.call(ctx, o.contract, "decimals")
+		if err != nil {
+			return err
+		}
+		d, ok := v.(uint32)
+		if !ok {
+			return fmt.Errorf("decimals() returned %T", v)
+		}
+		decimals := int64(d)
+		o.decimals = &decimals
+	}
+
+	rows, err := s.db.QueryContext(ctx, pooledUSDPricesQuery)
+	if err != nil {
+		return fmt.Errorf("failed to query USD prices: %v", err)
+	}
+	pooled := map[string]float64{}
+	var tokens []string
+	for rows.Next() {
+		var token string
+		var price float64
+		if err := rows.Scan(&token, &price); err != nil {
+			rows.Close()
+			return err
+		}
+		pooled[token] = price
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var quoted, divergent int
+	for _, token := range tokens {
+		oraclePrice, at, err := o.lastPrice(ctx, token)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.Warn("Reflector price lookup failed", "token", token, "error", err)
+			continue
+		}
+		if oraclePrice == nil || *oraclePrice == 0 {
+			continue
+		}
+		quoted++
+		pct := math.Abs(pooled[token]-*oraclePrice) / *oraclePrice * 100
+		flagged := pct > o.divergencePct
+		if flagged {
+			divergent++
+			logger.Warn("Pool price diverges from the Reflector oracle", "token", token,
+				"pool_price_usd", pooled[token], "oracle_price_usd", *oraclePrice, "divergence_pct", pct)
+		}
+		if err := s.storeOraclePrice(ctx, token, *oraclePrice, at, pct, flagged); err != nil {
+			return err
+		}
+	}
+	if len(tokens) > 0 {
+		logger.Info("Reflector oracle check", "tokens", len(tokens), "quoted", quoted, "divergent", divergent)
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) storeOraclePrice(ctx context.Context, token string, price float64, at time.Time, pct float64, divergent bool) error {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := s.db.ExecContext(ctx, s.backend.Rebind(setOraclePriceQuery),
+		price, at, pct, divergent, time.Now().UTC(), token,
+	); err != nil {
+		return fmt.Errorf("failed to store oracle price of %s: %v", token, err)
+	}
+	return nil
+}
+
+// OraclePrice is a token's latest price from the Reflector oracle.
+type OraclePrice struct {
+	PriceUSD float64   `json:"price_usd"`
+	At       time.Time `json:"at"`
+	// DivergencePct is how far the pool-implied price is from it, in
+	// percent; Divergent is set beyond reflector_divergence_pct.
+	DivergencePct float64 `json:"divergence_pct"`
+	Divergent     bool    `json:"divergent"`
+}
+
+// scanOraclePrice reads the oracle columns of token_usd_prices, nil when
+// the token was not quoted yet.
+func scanOraclePrice(price sql.NullFloat64, at sql.NullTime, pct sql.NullFloat64, divergent bool) *OraclePrice {
+	if !price.Valid {
+		return nil
+	}
+	return &OraclePrice{PriceUSD: price.Float64, At: at.Time, DivergencePct: pct.Float64, Divergent: divergent}
+}
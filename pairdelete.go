@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultDeleteChunkSize = 1000
+
+// pairTable is a table holding rows that belong to a pair. Tables added for
+// new per-pair data register here so DeletePair removes them too.
+type pairTable struct {
+	Name string
+	// Count counts the pair's rows, taking the pair address.
+	Count string
+	// Delete deletes up to chunk of the pair's rows inside tx.
+	Delete func(ctx context.Context, tx *sql.Tx, b backend, pair string, chunk int) (int64, error)
+}
+
+var pairTables []pairTable
+
+func registerPairTable(t pairTable) {
+	pairTables = append(pairTables, t)
+}
+
+// deleteByID returns a pairTable.Delete for a table with an id key and a
+// pair_address column.
+func deleteByID(table string) func(context.Context, *sql.Tx, backend, string, int) (int64, error) {
+	query := fmt.Sprintf(`DELETE FROM %[1]s WHERE id IN (
+            SELECT id FROM %[1]s WHERE pair_address = ? LIMIT ?)`, table)
+	return func(ctx context.Context, tx *sql.Tx, b backend, pair string, chunk int) (int64, error) {
+		stmts, err := onPartitions(ctx, tx, b, table, query)
+		if err != nil {
+			return 0, err
+		}
+		var total int64
+		for _, stmt := range stmts {
+			result, err := tx.ExecContext(ctx, b.Rebind(stmt), pair, chunk-int(total))
+			if err != nil {
+				return 0, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			if total += n; total >= int64(chunk) {
+				break
+			}
+		}
+		return total, nil
+	}
+}
+
+// deleteByKey returns a pairTable.Delete for a table keyed by pair_address
+// plus the given columns.
+func deleteByKey(table string, columns ...string) func(context.Context, *sql.Tx, backend, string, int) (int64, error) {
+	key := strings.Join(columns, ", ")
+	query := fmt.Sprintf(`DELETE FROM %[1]s WHERE pair_address = ? AND (%[2]s) IN (
+            SELECT %[2]s FROM %[1]s WHERE pair_address = ? LIMIT ?)`, table, key)
+	return func(ctx context.Context, tx *sql.Tx, b backend, pair string, chunk int) (int64, error) {
+		result, err := tx.ExecContext(ctx, b.Rebind(query), pair, pair, chunk)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+}
+
+// deleteRouterSwaps removes routed swaps through a pair whole, hops through
+// other pairs included, so no swap is left with a missing leg.
+func deleteRouterSwaps(ctx context.Context, tx *sql.Tx, b backend, pair string, chunk int) (int64, error) {
+	rows, err := tx.QueryContext(ctx, b.Rebind(
+		"SELECT DISTINCT swap_id FROM router_swap_hops WHERE pair_address = ? LIMIT ?"), pair, chunk)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM router_swap_hops WHERE swap_id = ?"), id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM router_swaps WHERE id = ?"), id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), nil
+}
+
+func init() {
+	registerPairTable(pairTable{
+		Name:   "pair_reserve_history",
+		Count:  "SELECT COUNT(*) FROM pair_reserve_history WHERE pair_address = ?",
+		Delete: deleteByID("pair_reserve_history"),
+	})
+	registerPairTable(pairTable{
+		Name:   "alerts",
+		Count:  "SELECT COUNT(*) FROM alerts WHERE pair_address = ?",
+		Delete: deleteByID("alerts"),
+	})
+	registerPairTable(pairTable{
+		Name:   "router_swaps",
+		Count:  "SELECT COUNT(DISTINCT swap_id) FROM router_swap_hops WHERE pair_address = ?",
+		Delete: deleteRouterSwaps,
+	})
+}
+
+// DeleteOptions controls DeletePair. RequestedBy and Reason are recorded in
+// the pair_deletions audit table.
+type DeleteOptions struct {
+	RequestedBy string
+	Reason      string
+	// DryRun counts the rows that would be deleted without deleting them.
+	DryRun bool
+	// ChunkSize caps the rows deleted per transaction (default 1000).
+	ChunkSize int
+}
+
+// DeleteResult reports the rows deleted, or that would be, per table.
+type DeleteResult struct {
+	PairAddress string           `json:"pair_address"`
+	DryRun      bool             `json:"dry_run"`
+	Rows        map[string]int64 `json:"rows"`
+}
+
+// DeletePair removes a pair and every row the plugin stores for it. Related
+// tables are emptied in chunks, each in its own short transaction taken
+// between event writes, and the pair row goes last so an interrupted call
+// can simply be repeated. Events arriving afterwards treat the pair as
+// unknown. Archived raw events are kept.
+func (s *SaveSoroswapPairsToSQLite) DeletePair(ctx context.Context, pairAddress string, opts DeleteOptions) (DeleteResult, error) {
+	pair, err := normalizeAddress(pairAddress)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	res := DeleteResult{
+		PairAddress: pair,
+		DryRun:      opts.DryRun || s.dryRun,
+		Rows:        make(map[string]int64),
+	}
+	chunk := opts.ChunkSize
+	if chunk <= 0 {
+		chunk = defaultDeleteChunkSize
+	}
+
+	var exists bool
+	if err := s.stmts.pairExists.QueryRowContext(ctx, pair).Scan(&exists); err != nil {
+		return res, fmt.Errorf("failed to check pair %s: %v", pair, err)
+	}
+	if !exists {
+		return res, ErrPairNotFound
+	}
+
+	if res.DryRun {
+		for _, t := range pairTables {
+			var n int64
+			if err := s.db.QueryRowContext(ctx, s.backend.Rebind(t.Count), pair).Scan(&n); err != nil {
+				return res, fmt.Errorf("failed to count %s rows: %v", t.Name, err)
+			}
+			res.Rows[t.Name] = n
+		}
+		res.Rows["soroswap_pairs"] = 1
+		if s.dryRun {
+			// Nothing is written in dry-run mode, the audit row included.
+			return res, nil
+		}
+		return res, s.recordDeletion(ctx, res, opts)
+	}
+
+	for _, t := range pairTables {
+		for {
+			n, err := s.deleteChunk(ctx, t, pair, chunk)
+			if err != nil {
+				return res, err
+			}
+			res.Rows[t.Name] += n
+			if n < int64(chunk) {
+				break
+			}
+		}
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	// Events processed since the chunks ran may have written rows of the
+	// pair; they are few, so one pass without a chunk limit removes them.
+	for _, t := range pairTables {
+		n, err := t.Delete(ctx, tx, s.backend, pair, 1<<31-1)
+		if err != nil {
+			return res, fmt.Errorf("failed to delete %s rows of %s: %v", t.Name, pair, err)
+		}
+		res.Rows[t.Name] += n
+	}
+	if _, err := tx.ExecContext(ctx, s.backend.Rebind(
+		"UPDATE router_swap_hops SET pair_ref = NULL WHERE pair_ref = ?"), pair); err != nil {
+		return res, fmt.Errorf("failed to unlink router hops: %v", err)
+	}
+	result, err := tx.ExecContext(ctx, s.backend.Rebind("DELETE FROM soroswap_pairs WHERE pair_address = ?"), pair)
+	if err != nil {
+		return res, fmt.Errorf("failed to delete pair %s: %v", pair, err)
+	}
+	res.Rows["soroswap_pairs"], _ = result.RowsAffected()
+	if err := s.insertDeletion(ctx, tx, res, opts); err != nil {
+		return res, err
+	}
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("failed to commit pair deletion: %v", err)
+	}
+	logger.Info("Deleted pair", "pair", pair, "requested_by", opts.RequestedBy, "reason", opts.Reason, "rows", res.Rows)
+	return res, nil
+}
+
+// deleteChunk deletes up to chunk rows of one table in a transaction,
+// holding the write lock only for that transaction.
+func (s *SaveSoroswapPairsToSQLite) deleteChunk(ctx context.Context, t pairTable, pair string, chunk int) (int64, error) {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	deleted, err := t.Delete(ctx, tx, s.backend, pair, chunk)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete %s rows: %v", t.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit %s deletion: %v", t.Name, err)
+	}
+	return deleted, nil
+}
+
+const insertDeletionQuery = `
+        INSERT INTO pair_deletions (
+            pair_address, requested_by, reason, dry_run, row_counts, deleted_at
+        ) VALUES (?, ?, ?, ?, ?, ?)
+    `
+
+func (s *SaveSoroswapPairsToSQLite) recordDeletion(ctx context.Context, res DeleteResult, opts DeleteOptions) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+	if err := s.insertDeletion(ctx, tx, res, opts); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SaveSoroswapPairsToSQLite) insertDeletion(ctx context.Context, tx *sql.Tx, res DeleteResult, opts DeleteOptions) error {
+	counts, err := json.Marshal(res.Rows)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.backend.Rebind(insertDeletionQuery),
+		res.PairAddress, opts.RequestedBy, opts.Reason, res.DryRun, string(counts), time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to record pair deletion: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDeletePair(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        DeleteOptions
+		wantDeleted bool
+	}{
+		{"one chunk", DeleteOptions{Reason: "test"}, true},
+		{"chunks of one row", DeleteOptions{Reason: "test", ChunkSize: 1}, true},
+		{"dry run", DeleteOptions{Reason: "test", DryRun: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, map[string]interface{}{"reserve_history": true})
+			process(t, s,
+				newPairEvent(testPair, 10),
+				newPairEvent(testPair2, 10),
+				syncEvent(testPair, "100", "50", 20),
+				syncEvent(testPair2, "100", "50", 20),
+				swapEvent(testPair, "aa01", 21),
+				swapEvent(testPair, "aa02", 22),
+				swapEvent(testPair2, "aa03", 22),
+			)
+
+			res, err := s.DeletePair(context.Background(), testPair, tt.opts)
+			if err != nil {
+				t.Fatalf("DeletePair: %v", err)
+			}
+			if res.Rows["soroswap_swaps"] != 2 || res.Rows["pair_reserve_history"] != 1 || res.Rows["soroswap_pairs"] != 1 {
+				t.Errorf("rows = %v, want 2 swaps, 1 history row and the pair", res.Rows)
+			}
+			_, err = s.GetPair(context.Background(), testPair)
+			if deleted := errors.Is(err, ErrPairNotFound); deleted != tt.wantDeleted {
+				t.Errorf("GetPair = %v, want deleted %v", err, tt.wantDeleted)
+			}
+			wantSwaps := 3
+			if tt.wantDeleted {
+				wantSwaps = 1
+			}
+			if n := countRows(t, s, "soroswap_swaps"); n != wantSwaps {
+				t.Errorf("swaps = %d, want %d", n, wantSwaps)
+			}
+			if n := countRows(t, s, "pair_deletions"); n != 1 {
+				t.Errorf("deletions = %d, want the audit row", n)
+			}
+			getPair(t, s, testPair2)
+		})
+	}
+}
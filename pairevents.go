@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// storePairEvent records one row of pair activity (a swap, deposit or
+// withdrawal) with record, which reports false for duplicates. after, if
+// set, runs in the same transaction once a new row is inserted.
+// Events for unknown pairs are skipped like syncs, and since these tables
+// are not derived from other events, replays leave them alone.
+func (s *SaveSoroswapPairsToSQLite) storePairEvent(ctx context.Context, eventType, pair string, record func(PairStore) (bool, error), after func(*sql.Tx) error) error {
+	if replayTables(ctx) != nil {
+		return nil
+	}
+
+	tx, done, err := s.beginEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer done() // Will be ignored if transaction is committed
+
+	known, err := s.pairKnown(ctx, tx, pair)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return s.unknownPair(ctx, eventType, pair)
+	}
+
+	isNew, err := record(s.store(tx))
+	if err != nil {
+		return err
+	}
+	if isNew && after != nil {
+		if err := after(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.commitEvent(ctx, tx, isNew); err != nil {
+		return fmt.Errorf("failed to commit %s event: %v", eventType, err)
+	}
+	outcome := outcomeInserted
+	if !isNew {
+		outcome = outcomeDuplicate
+	}
+	s.recordOutcome(ctx, eventType, outcome, pair)
+	return nil
+}
+
+// pairKnown reports whether pair is stored, or was inserted earlier in this
+// dry run.
+func (s *SaveSoroswapPairsToSQLite) pairKnown(ctx context.Context, tx *sql.Tx, pair string) (bool, error) {
+	exists, err := s.store(tx).PairExists(ctx, pair)
+	if err != nil {
+		return false, err
+	}
+	if !exists && s.dryRun {
+		exists = s.dryRunSawPair(pair)
+	}
+	return exists, nil
+}
+
+// inTx runs fn in a transaction, committed through s.commit.
+func (s *SaveSoroswapPairsToSQLite) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return s.commit(tx)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pair is a stored pair as returned by the read APIs. Reserve0/Reserve1
+// always belong to Token0/Token1 as emitted by the factory; TokenA/TokenB
+// are the same tokens in canonical (sorted) order for lookups.
+type Pair struct {
+	PairAddress   string `json:"pair_address"`
+	Token0        string `json:"token_0"`
+	Token1        string `json:"token_1"`
+	TokenA        string `json:"token_a"`
+	TokenB        string `json:"token_b"`
+	TokensFlipped bool   `json:"tokens_flipped"`
+	Reserve0      string `json:"reserve_0"`
+	Reserve1      string `json:"reserve_1"`
+	// Price01 is token_1 per token_0 and Price10 token_0 per token_1,
+	// scaled by the tokens' decimals once known; nil while a reserve is
+	// zero.
+	Price01 *float64 `json:"price_0_1,omitempty"`
+	Price10 *float64 `json:"price_1_0,omitempty"`
+	// TVLUSD is the value of the reserves in USD, nil unless USD pricing
+	// can price a side.
+	TVLUSD *float64 `json:"tvl_usd,omitempty"`
+	// TotalSupply is the pair's outstanding LP shares.
+	TotalSupply     string     `json:"total_supply"`
+	CreatedAt       time.Time  `json:"created_at"`
+	CreatedAtLedger *int64     `json:"created_at_ledger,omitempty"`
+	LastSyncAt      *time.Time `json:"last_sync_at,omitempty"`
+	LastSyncLedger  *int64     `json:"last_sync_ledger,omitempty"`
+	// Placeholder is set for pairs only known from a sync so far, whose
+	// tokens are still empty.
+	Placeholder bool `json:"placeholder,omitempty"`
+	// Active is cleared while a reserve is below min_reserve.
+	Active bool `json:"active"`
+	// Network is the Stellar network the pair was recorded on, when known.
+	Network string `json:"network,omitempty"`
+}
+
+// pairColumns is the select list scanned by scanPair.
+const pairColumns = `pair_address, COALESCE(token_0, ''), COALESCE(token_1, ''),
+        COALESCE(token_a, ''), COALESCE(token_b, ''), tokens_flipped,
+        reserve_0, reserve_1, price_0_1, price_1_0, tvl_usd, total_supply, created_at, created_at_ledger,
+        last_sync_at, last_sync_ledger, placeholder, active, COALESCE(network, '')`
+
+// selectPairQuery reads one pair for scanPair. Handlers use it to pass on
+// the state an event left a pair in.
+const selectPairQuery = "SELECT " + pairColumns + " FROM soroswap_pairs WHERE pair_address = ?"
+
+func scanPair(row interface{ Scan(...interface{}) error }) (Pair, error) {
+	var p Pair
+	var createdLedger, syncLedger sql.NullInt64
+	var syncAt sql.NullTime
+	var price01, price10, tvl sql.NullFloat64
+	err := row.Scan(&p.PairAddress, &p.Token0, &p.Token1,
+		&p.TokenA, &p.TokenB, &p.TokensFlipped,
+		&p.Reserve0, &p.Reserve1, &price01, &price10, &tvl, &p.TotalSupply, &p.CreatedAt, &createdLedger,
+		&syncAt, &syncLedger, &p.Placeholder, &p.Active, &p.Network)
+	if err != nil {
+		return p, err
+	}
+	if createdLedger.Valid {
+		p.CreatedAtLedger = &createdLedger.Int64
+	}
+	if price01.Valid {
+		p.Price01 = &price01.Float64
+	}
+	if price10.Valid {
+		p.Price10 = &price10.Float64
+	}
+	if tvl.Valid {
+		p.TVLUSD = &tvl.Float64
+	}
+	if syncAt.Valid {
+		p.LastSyncAt = &syncAt.Time
+	}
+	if syncLedger.Valid {
+		p.LastSyncLedger = &syncLedger.Int64
+	}
+	return p, nil
+}
+
+func init() {
+	registerHandlerQuery(selectPairQuery)
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "canonical_token_lookup",
+		Query: "SELECT " + pairColumns + " FROM soroswap_pairs WHERE token_a = ? AND token_b = ?",
+		Args:  []interface{}{"", ""},
+	})
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "pairs_by_token",
+		Query: "SELECT " + pairColumns + " FROM soroswap_pairs WHERE pair_address > ? AND (token_a = ? OR token_b = ?) ORDER BY pair_address LIMIT ?",
+		Args:  []interface{}{"", "", "", defaultPairListLimit + 1},
+	})
+}
+
+// canonicalTokens orders two tokens so the lexicographically smaller one
+// comes first, reporting whether that swapped them.
+func canonicalTokens(token0, token1 string) (string, string, bool) {
+	if token0 <= token1 {
+		return token0, token1, false
+	}
+	return token1, token0, true
+}
+
+// FindPairByTokens returns every pair trading the two tokens, in either
+// order. More than one pair is possible when several factory versions
+// created a market for the same tokens.
+func (s *SaveSoroswapPairsToSQLite) FindPairByTokens(ctx context.Context, tokenX, tokenY string) ([]Pair, error) {
+	x, err := normalizeAddress(tokenX)
+	if err != nil {
+		return nil, err
+	}
+	y, err := normalizeAddress(tokenY)
+	if err != nil {
+		return nil, err
+	}
+	a, b, _ := canonicalTokens(x, y)
+
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(`
+        SELECT `+pairColumns+` FROM soroswap_pairs
+        WHERE token_a = ? AND token_b = ?
+        ORDER BY created_at, pair_address
+    `), a, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pairs by tokens: %v", err)
+	}
+	defer rows.Close()
+
+	pairs := []Pair{}
+	for rows.Next() {
+		p, err := scanPair(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pair: %v", err)
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pairs: %v", err)
+	}
+	return pairs, nil
+}
+
+const (
+	defaultPairListLimit = 100
+	maxPairListLimit     = 1000
+)
+
+// GetPair returns a stored pair, or ErrPairNotFound.
+func (s *SaveSoroswapPairsToSQLite) GetPair(ctx context.Context, address string) (Pair, error) {
+	addr, err := normalizeAddress(address)
+	if err != nil {
+		return Pair{}, err
+	}
+	p, err := scanPair(s.readDB.QueryRowContext(ctx, s.backend.Rebind(
+		"SELECT "+pairColumns+" FROM soroswap_pairs WHERE pair_address = ?"), addr))
+	if err == sql.ErrNoRows {
+		return p, fmt.Errorf("%w: %s", ErrPairNotFound, addr)
+	}
+	if err != nil {
+		return p, fmt.Errorf("failed to read pair %s: %v", addr, err)
+	}
+	return p, nil
+}
+
+// PairListOptions filters and pages a ListPairs result.
+type PairListOptions struct {
+	// Token restricts the list to pairs trading the token, on either side.
+	Token string
+	// Limit caps the number of pairs per page (default 100, max 1000).
+	Limit int
+	// Cursor continues from a previous page's NextCursor.
+	Cursor string
+}
+
+// PairPage is one page of pairs ordered by address.
+type PairPage struct {
+	Pairs []Pair `json:"pairs"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListPairs returns stored pairs ordered by address.
+func (s *SaveSoroswapPairsToSQLite) ListPairs(ctx context.Context, opts PairListOptions) (*PairPage, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultPairListLimit
+	}
+	if limit > maxPairListLimit {
+		limit = maxPairListLimit
+	}
+
+	var after string
+	if opts.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		after = string(raw)
+	}
+
+	where := []string{"pair_address > ?"}
+	args := []interface{}{after}
+	if opts.Token != "" {
+		token, err := normalizeAddress(opts.Token)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "(token_a = ? OR token_b = ?)")
+		args = append(args, token, token)
+	}
+	// One extra row tells whether another page follows.
+	args = append(args, limit+1)
+
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(
+		"SELECT "+pairColumns+" FROM soroswap_pairs WHERE "+strings.Join(where, " AND ")+
+			" ORDER BY pair_address LIMIT ?"), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pairs: %v", err)
+	}
+	defer rows.Close()
+
+	page := &PairPage{Pairs: []Pair{}}
+	for rows.Next() {
+		p, err := scanPair(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pair: %v", err)
+		}
+		page.Pairs = append(page.Pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pairs: %v", err)
+	}
+	if len(page.Pairs) > limit {
+		page.Pairs = page.Pairs[:limit]
+		last := page.Pairs[limit-1].PairAddress
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	return page, nil
+}
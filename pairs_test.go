@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewPairInitialReserves(t *testing.T) {
+	withReserves := func(ledger int64) event {
+		return newPairEvent(testPair, ledger).with(event{"reserve_0": "1000", "reserve_1": "400"})
+	}
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		events []event
+		// wantLedger 0 means no sync recorded.
+		wantReserve string
+		wantLedger  int64
+	}{
+		{
+			name:        "no initial reserves",
+			events:      []event{newPairEvent(testPair, 10)},
+			wantReserve: "0",
+		},
+		{
+			name:        "initial reserves",
+			events:      []event{withReserves(10)},
+			wantReserve: "1000", wantLedger: 10,
+		},
+		{
+			name:        "later sync applies",
+			events:      []event{withReserves(10), syncEvent(testPair, "1200", "350", 11)},
+			wantReserve: "1200", wantLedger: 11,
+		},
+		{
+			name:        "sync of the same ledger applies",
+			events:      []event{withReserves(10), syncEvent(testPair, "1100", "380", 10)},
+			wantReserve: "1100", wantLedger: 10,
+		},
+		{
+			name:        "earlier sync is stale",
+			events:      []event{withReserves(10), syncEvent(testPair, "900", "450", 9)},
+			wantReserve: "1000", wantLedger: 10,
+		},
+		{
+			name:        "placeholder sync first keeps the later reserves",
+			config:      map[string]interface{}{"create_missing_pairs": true},
+			events:      []event{syncEvent(testPair, "1200", "350", 11), withReserves(10)},
+			wantReserve: "1200", wantLedger: 11,
+		},
+		{
+			name:        "queued later sync applies after creation",
+			config:      map[string]interface{}{"pending_syncs": true},
+			events:      []event{syncEvent(testPair, "1200", "350", 11), withReserves(10)},
+			wantReserve: "1200", wantLedger: 11,
+		},
+		{
+			name:        "queued earlier sync is stale after creation",
+			config:      map[string]interface{}{"pending_syncs": true},
+			events:      []event{syncEvent(testPair, "900", "450", 9), withReserves(10)},
+			wantReserve: "1000", wantLedger: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, tt.config)
+			process(t, s, tt.events...)
+			p := getPair(t, s, testPair)
+			if p.Reserve0 != tt.wantReserve {
+				t.Errorf("reserve_0 = %s, want %s", p.Reserve0, tt.wantReserve)
+			}
+			switch {
+			case tt.wantLedger == 0 && (p.LastSyncLedger != nil || p.LastSyncAt != nil):
+				t.Errorf("last sync = %v at %v, want none", p.LastSyncLedger, p.LastSyncAt)
+			case tt.wantLedger != 0 && (p.LastSyncLedger == nil || *p.LastSyncLedger != tt.wantLedger):
+				t.Errorf("last_sync_ledger = %v, want %d", p.LastSyncLedger, tt.wantLedger)
+			case tt.wantLedger != 0 && (p.LastSyncAt == nil || !p.LastSyncAt.Equal(ledgerTime(tt.wantLedger))):
+				t.Errorf("last_sync_at = %v, want %v", p.LastSyncAt, ledgerTime(tt.wantLedger))
+			}
+		})
+	}
+}
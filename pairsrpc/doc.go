@@ -0,0 +1,6 @@
+// Package pairsrpc holds the gRPC PairService served by the consumer with
+// grpc_addr, for other plugins and sidecars to query pair state without
+// opening the database.
+package pairsrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pairs.proto
@@ -0,0 +1,790 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        (unknown)
+// source: pairs.proto
+
+package pairsrpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Pair is a stored pair. reserve_0/reserve_1 belong to token_0/token_1 as
+// emitted by the factory; token_a/token_b are the same tokens sorted.
+// Amounts are decimal strings since they overflow int64.
+type Pair struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	PairAddress     string                 `protobuf:"bytes,1,opt,name=pair_address,json=pairAddress,proto3" json:"pair_address,omitempty"`
+	Token_0         string                 `protobuf:"bytes,2,opt,name=token_0,json=token0,proto3" json:"token_0,omitempty"`
+	Token_1         string                 `protobuf:"bytes,3,opt,name=token_1,json=token1,proto3" json:"token_1,omitempty"`
+	TokenA          string                 `protobuf:"bytes,4,opt,name=token_a,json=tokenA,proto3" json:"token_a,omitempty"`
+	TokenB          string                 `protobuf:"bytes,5,opt,name=token_b,json=tokenB,proto3" json:"token_b,omitempty"`
+	TokensFlipped   bool                   `protobuf:"varint,6,opt,name=tokens_flipped,json=tokensFlipped,proto3" json:"tokens_flipped,omitempty"`
+	Reserve_0       string                 `protobuf:"bytes,7,opt,name=reserve_0,json=reserve0,proto3" json:"reserve_0,omitempty"`
+	Reserve_1       string                 `protobuf:"bytes,8,opt,name=reserve_1,json=reserve1,proto3" json:"reserve_1,omitempty"`
+	CreatedAt       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	CreatedAtLedger *int64                 `protobuf:"varint,10,opt,name=created_at_ledger,json=createdAtLedger,proto3,oneof" json:"created_at_ledger,omitempty"`
+	LastSyncAt      *timestamppb.Timestamp `protobuf:"bytes,11,opt,name=last_sync_at,json=lastSyncAt,proto3" json:"last_sync_at,omitempty"`
+	LastSyncLedger  *int64                 `protobuf:"varint,12,opt,name=last_sync_ledger,json=lastSyncLedger,proto3,oneof" json:"last_sync_ledger,omitempty"`
+	// placeholder is set for pairs only known from a sync so far, whose
+	// tokens are still empty.
+	Placeholder bool `protobuf:"varint,13,opt,name=placeholder,proto3" json:"placeholder,omitempty"`
+	// network is the Stellar network the pair was recorded on, when known.
+	Network       string `protobuf:"bytes,14,opt,name=network,proto3" json:"network,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Pair) Reset() {
+	*x = Pair{}
+	mi := &file_pairs_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pair) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pair) ProtoMessage() {}
+
+func (x *Pair) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pair.ProtoReflect.Descriptor instead.
+func (*Pair) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Pair) GetPairAddress() string {
+	if x != nil {
+		return x.PairAddress
+	}
+	return ""
+}
+
+func (x *Pair) GetToken_0() string {
+	if x != nil {
+		return x.Token_0
+	}
+	return ""
+}
+
+func (x *Pair) GetToken_1() string {
+	if x != nil {
+		return x.Token_1
+	}
+	return ""
+}
+
+func (x *Pair) GetTokenA() string {
+	if x != nil {
+		return x.TokenA
+	}
+	return ""
+}
+
+func (x *Pair) GetTokenB() string {
+	if x != nil {
+		return x.TokenB
+	}
+	return ""
+}
+
+func (x *Pair) GetTokensFlipped() bool {
+	if x != nil {
+		return x.TokensFlipped
+	}
+	return false
+}
+
+func (x *Pair) GetReserve_0() string {
+	if x != nil {
+		return x.Reserve_0
+	}
+	return ""
+}
+
+func (x *Pair) GetReserve_1() string {
+	if x != nil {
+		return x.Reserve_1
+	}
+	return ""
+}
+
+func (x *Pair) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Pair) GetCreatedAtLedger() int64 {
+	if x != nil && x.CreatedAtLedger != nil {
+		return *x.CreatedAtLedger
+	}
+	return 0
+}
+
+func (x *Pair) GetLastSyncAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSyncAt
+	}
+	return nil
+}
+
+func (x *Pair) GetLastSyncLedger() int64 {
+	if x != nil && x.LastSyncLedger != nil {
+		return *x.LastSyncLedger
+	}
+	return 0
+}
+
+func (x *Pair) GetPlaceholder() bool {
+	if x != nil {
+		return x.Placeholder
+	}
+	return false
+}
+
+func (x *Pair) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+type GetPairRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PairAddress   string                 `protobuf:"bytes,1,opt,name=pair_address,json=pairAddress,proto3" json:"pair_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPairRequest) Reset() {
+	*x = GetPairRequest{}
+	mi := &file_pairs_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPairRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPairRequest) ProtoMessage() {}
+
+func (x *GetPairRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPairRequest.ProtoReflect.Descriptor instead.
+func (*GetPairRequest) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetPairRequest) GetPairAddress() string {
+	if x != nil {
+		return x.PairAddress
+	}
+	return ""
+}
+
+type ListPairsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// token restricts the list to pairs trading the token, on either side.
+	Token string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	// page_size defaults to 100, at most 1000.
+	PageSize int32 `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// page_token continues from a previous response's next_page_token.
+	PageToken     string `protobuf:"bytes,3,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPairsRequest) Reset() {
+	*x = ListPairsRequest{}
+	mi := &file_pairs_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPairsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPairsRequest) ProtoMessage() {}
+
+func (x *ListPairsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPairsRequest.ProtoReflect.Descriptor instead.
+func (*ListPairsRequest) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListPairsRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *ListPairsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListPairsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListPairsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Pairs []*Pair                `protobuf:"bytes,1,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	// next_page_token is empty on the last page.
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPairsResponse) Reset() {
+	*x = ListPairsResponse{}
+	mi := &file_pairs_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPairsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPairsResponse) ProtoMessage() {}
+
+func (x *ListPairsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPairsResponse.ProtoReflect.Descriptor instead.
+func (*ListPairsResponse) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListPairsResponse) GetPairs() []*Pair {
+	if x != nil {
+		return x.Pairs
+	}
+	return nil
+}
+
+func (x *ListPairsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type GetReserveHistoryRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	PairAddress string                 `protobuf:"bytes,1,opt,name=pair_address,json=pairAddress,proto3" json:"pair_address,omitempty"`
+	// from_ledger and to_ledger bound the ledger range, inclusive.
+	FromLedger int64 `protobuf:"varint,2,opt,name=from_ledger,json=fromLedger,proto3" json:"from_ledger,omitempty"`
+	ToLedger   int64 `protobuf:"varint,3,opt,name=to_ledger,json=toLedger,proto3" json:"to_ledger,omitempty"`
+	// from_time and to_time bound the sync time, inclusive.
+	FromTime *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=from_time,json=fromTime,proto3" json:"from_time,omitempty"`
+	ToTime   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=to_time,json=toTime,proto3" json:"to_time,omitempty"`
+	// page_size defaults to 1000, at most 10000.
+	PageSize  int32  `protobuf:"varint,6,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken string `protobuf:"bytes,7,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// step keeps every Nth point of the filtered timeline.
+	Step int32 `protobuf:"varint,8,opt,name=step,proto3" json:"step,omitempty"`
+	// bucket keeps only the last point of each time bucket.
+	Bucket        *durationpb.Duration `protobuf:"bytes,9,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetReserveHistoryRequest) Reset() {
+	*x = GetReserveHistoryRequest{}
+	mi := &file_pairs_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetReserveHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetReserveHistoryRequest) ProtoMessage() {}
+
+func (x *GetReserveHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetReserveHistoryRequest.ProtoReflect.Descriptor instead.
+func (*GetReserveHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetReserveHistoryRequest) GetPairAddress() string {
+	if x != nil {
+		return x.PairAddress
+	}
+	return ""
+}
+
+func (x *GetReserveHistoryRequest) GetFromLedger() int64 {
+	if x != nil {
+		return x.FromLedger
+	}
+	return 0
+}
+
+func (x *GetReserveHistoryRequest) GetToLedger() int64 {
+	if x != nil {
+		return x.ToLedger
+	}
+	return 0
+}
+
+func (x *GetReserveHistoryRequest) GetFromTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FromTime
+	}
+	return nil
+}
+
+func (x *GetReserveHistoryRequest) GetToTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ToTime
+	}
+	return nil
+}
+
+func (x *GetReserveHistoryRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *GetReserveHistoryRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *GetReserveHistoryRequest) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *GetReserveHistoryRequest) GetBucket() *durationpb.Duration {
+	if x != nil {
+		return x.Bucket
+	}
+	return nil
+}
+
+// HistoryPoint is a pair's reserves as of one sync. Prices are set when
+// derived prices are recorded.
+type HistoryPoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ledger        int64                  `protobuf:"varint,1,opt,name=ledger,proto3" json:"ledger,omitempty"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Reserve_0     string                 `protobuf:"bytes,3,opt,name=reserve_0,json=reserve0,proto3" json:"reserve_0,omitempty"`
+	Reserve_1     string                 `protobuf:"bytes,4,opt,name=reserve_1,json=reserve1,proto3" json:"reserve_1,omitempty"`
+	Price_0_1     string                 `protobuf:"bytes,5,opt,name=price_0_1,json=price01,proto3" json:"price_0_1,omitempty"`
+	Price_1_0     string                 `protobuf:"bytes,6,opt,name=price_1_0,json=price10,proto3" json:"price_1_0,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistoryPoint) Reset() {
+	*x = HistoryPoint{}
+	mi := &file_pairs_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistoryPoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryPoint) ProtoMessage() {}
+
+func (x *HistoryPoint) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryPoint.ProtoReflect.Descriptor instead.
+func (*HistoryPoint) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *HistoryPoint) GetLedger() int64 {
+	if x != nil {
+		return x.Ledger
+	}
+	return 0
+}
+
+func (x *HistoryPoint) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *HistoryPoint) GetReserve_0() string {
+	if x != nil {
+		return x.Reserve_0
+	}
+	return ""
+}
+
+func (x *HistoryPoint) GetReserve_1() string {
+	if x != nil {
+		return x.Reserve_1
+	}
+	return ""
+}
+
+func (x *HistoryPoint) GetPrice_0_1() string {
+	if x != nil {
+		return x.Price_0_1
+	}
+	return ""
+}
+
+func (x *HistoryPoint) GetPrice_1_0() string {
+	if x != nil {
+		return x.Price_1_0
+	}
+	return ""
+}
+
+type GetReserveHistoryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Points        []*HistoryPoint        `protobuf:"bytes,1,rep,name=points,proto3" json:"points,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetReserveHistoryResponse) Reset() {
+	*x = GetReserveHistoryResponse{}
+	mi := &file_pairs_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetReserveHistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetReserveHistoryResponse) ProtoMessage() {}
+
+func (x *GetReserveHistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pairs_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetReserveHistoryResponse.ProtoReflect.Descriptor instead.
+func (*GetReserveHistoryResponse) Descriptor() ([]byte, []int) {
+	return file_pairs_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetReserveHistoryResponse) GetPoints() []*HistoryPoint {
+	if x != nil {
+		return x.Points
+	}
+	return nil
+}
+
+func (x *GetReserveHistoryResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+var File_pairs_proto protoreflect.FileDescriptor
+
+var file_pairs_proto_rawDesc = string([]byte{
+	0x0a, 0x0b, 0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x11, 0x73,
+	0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x76, 0x31,
+	0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x22, 0xae, 0x04, 0x0a, 0x04, 0x50, 0x61, 0x69, 0x72, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61,
+	0x69, 0x72, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0b, 0x70, 0x61, 0x69, 0x72, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x17, 0x0a,
+	0x07, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x5f, 0x30, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x30, 0x12, 0x17, 0x0a, 0x07, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x5f,
+	0x31, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x31, 0x12,
+	0x17, 0x0a, 0x07, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x5f, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x41, 0x12, 0x17, 0x0a, 0x07, 0x74, 0x6f, 0x6b, 0x65,
+	0x6e, 0x5f, 0x62, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x6f, 0x6b, 0x65, 0x6e,
+	0x42, 0x12, 0x25, 0x0a, 0x0e, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x73, 0x5f, 0x66, 0x6c, 0x69, 0x70,
+	0x70, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x74, 0x6f, 0x6b, 0x65, 0x6e,
+	0x73, 0x46, 0x6c, 0x69, 0x70, 0x70, 0x65, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x73, 0x65,
+	0x72, 0x76, 0x65, 0x5f, 0x30, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x73,
+	0x65, 0x72, 0x76, 0x65, 0x30, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65,
+	0x5f, 0x31, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x73, 0x65, 0x72, 0x76,
+	0x65, 0x31, 0x12, 0x39, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x2f, 0x0a,
+	0x11, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x5f, 0x6c, 0x65, 0x64, 0x67,
+	0x65, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x48, 0x00, 0x52, 0x0f, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x64, 0x41, 0x74, 0x4c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x88, 0x01, 0x01, 0x12, 0x3c,
+	0x0a, 0x0c, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x61, 0x74, 0x18, 0x0b,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x0a, 0x6c, 0x61, 0x73, 0x74, 0x53, 0x79, 0x6e, 0x63, 0x41, 0x74, 0x12, 0x2d, 0x0a, 0x10,
+	0x6c, 0x61, 0x73, 0x74, 0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x72,
+	0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x48, 0x01, 0x52, 0x0e, 0x6c, 0x61, 0x73, 0x74, 0x53, 0x79,
+	0x6e, 0x63, 0x4c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x88, 0x01, 0x01, 0x12, 0x20, 0x0a, 0x0b, 0x70,
+	0x6c, 0x61, 0x63, 0x65, 0x68, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0b, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x68, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x18, 0x0a,
+	0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x42, 0x14, 0x0a, 0x12, 0x5f, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x5f, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x42, 0x13, 0x0a,
+	0x11, 0x5f, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x6c, 0x65, 0x64, 0x67,
+	0x65, 0x72, 0x22, 0x33, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x50, 0x61, 0x69, 0x72, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61, 0x69, 0x72, 0x5f, 0x61, 0x64, 0x64,
+	0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x70, 0x61, 0x69, 0x72,
+	0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x22, 0x64, 0x0a, 0x10, 0x4c, 0x69, 0x73, 0x74, 0x50,
+	0x61, 0x69, 0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x74,
+	0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65,
+	0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x70, 0x61, 0x67, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1d,
+	0x0a, 0x0a, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x70, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x6a, 0x0a,
+	0x11, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x69, 0x72, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x2d, 0x0a, 0x05, 0x70, 0x61, 0x69, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x17, 0x2e, 0x73, 0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69,
+	0x72, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x61, 0x69, 0x72, 0x52, 0x05, 0x70, 0x61, 0x69, 0x72,
+	0x73, 0x12, 0x26, 0x0a, 0x0f, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x74,
+	0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6e, 0x65, 0x78, 0x74,
+	0x50, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0xec, 0x02, 0x0a, 0x18, 0x47, 0x65,
+	0x74, 0x52, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61, 0x69, 0x72, 0x5f, 0x61,
+	0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x70, 0x61,
+	0x69, 0x72, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x66, 0x72, 0x6f,
+	0x6d, 0x5f, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a,
+	0x66, 0x72, 0x6f, 0x6d, 0x4c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x6f,
+	0x5f, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x74,
+	0x6f, 0x4c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x12, 0x37, 0x0a, 0x09, 0x66, 0x72, 0x6f, 0x6d, 0x5f,
+	0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x66, 0x72, 0x6f, 0x6d, 0x54, 0x69, 0x6d, 0x65,
+	0x12, 0x33, 0x0a, 0x07, 0x74, 0x6f, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x06, 0x74,
+	0x6f, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x73, 0x69,
+	0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x70, 0x61, 0x67, 0x65, 0x53, 0x69,
+	0x7a, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x70, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65,
+	0x6e, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x74, 0x65, 0x70, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x04, 0x73, 0x74, 0x65, 0x70, 0x12, 0x31, 0x0a, 0x06, 0x62, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x52, 0x06, 0x62, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x22, 0xd2, 0x01, 0x0a, 0x0c, 0x48, 0x69, 0x73,
+	0x74, 0x6f, 0x72, 0x79, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x6c, 0x65, 0x64,
+	0x67, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x6c, 0x65, 0x64, 0x67, 0x65,
+	0x72, 0x12, 0x38, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x1b, 0x0a, 0x09, 0x72,
+	0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x5f, 0x30, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x72, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x30, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x73, 0x65,
+	0x72, 0x76, 0x65, 0x5f, 0x31, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x73,
+	0x65, 0x72, 0x76, 0x65, 0x31, 0x12, 0x1a, 0x0a, 0x09, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x30,
+	0x5f, 0x31, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x72, 0x69, 0x63, 0x65, 0x30,
+	0x31, 0x12, 0x1a, 0x0a, 0x09, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x31, 0x5f, 0x30, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x72, 0x69, 0x63, 0x65, 0x31, 0x30, 0x22, 0x7c, 0x0a,
+	0x19, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x48, 0x69, 0x73, 0x74, 0x6f,
+	0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x37, 0x0a, 0x06, 0x70, 0x6f,
+	0x69, 0x6e, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x73, 0x6f, 0x72,
+	0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x48,
+	0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x52, 0x06, 0x70, 0x6f, 0x69,
+	0x6e, 0x74, 0x73, 0x12, 0x26, 0x0a, 0x0f, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x70, 0x61, 0x67, 0x65,
+	0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6e, 0x65,
+	0x78, 0x74, 0x50, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x32, 0x9c, 0x02, 0x0a, 0x0b,
+	0x50, 0x61, 0x69, 0x72, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x45, 0x0a, 0x07, 0x47,
+	0x65, 0x74, 0x50, 0x61, 0x69, 0x72, 0x12, 0x21, 0x2e, 0x73, 0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61,
+	0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x61,
+	0x69, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x73, 0x6f, 0x72, 0x6f,
+	0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x61,
+	0x69, 0x72, 0x12, 0x56, 0x0a, 0x09, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x69, 0x72, 0x73, 0x12,
+	0x23, 0x2e, 0x73, 0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73,
+	0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x69, 0x72, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x24, 0x2e, 0x73, 0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e,
+	0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x69,
+	0x72, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x6e, 0x0a, 0x11, 0x47, 0x65,
+	0x74, 0x52, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x12,
+	0x2b, 0x2e, 0x73, 0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73,
+	0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x48, 0x69,
+	0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2c, 0x2e, 0x73,
+	0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x2e, 0x70, 0x61, 0x69, 0x72, 0x73, 0x2e, 0x76, 0x31,
+	0x2e, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x48, 0x69, 0x73, 0x74, 0x6f,
+	0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x4b, 0x5a, 0x49, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x77, 0x69, 0x74, 0x68, 0x4f, 0x62, 0x73,
+	0x72, 0x76, 0x72, 0x2f, 0x66, 0x6c, 0x6f, 0x77, 0x2d, 0x63, 0x6f, 0x6e, 0x73, 0x75, 0x6d, 0x65,
+	0x72, 0x2d, 0x73, 0x61, 0x76, 0x65, 0x2d, 0x73, 0x6f, 0x72, 0x6f, 0x73, 0x77, 0x61, 0x70, 0x70,
+	0x61, 0x69, 0x72, 0x73, 0x2d, 0x74, 0x6f, 0x2d, 0x73, 0x71, 0x6c, 0x69, 0x74, 0x65, 0x2f, 0x70,
+	0x61, 0x69, 0x72, 0x73, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+})
+
+var (
+	file_pairs_proto_rawDescOnce sync.Once
+	file_pairs_proto_rawDescData []byte
+)
+
+func file_pairs_proto_rawDescGZIP() []byte {
+	file_pairs_proto_rawDescOnce.Do(func() {
+		file_pairs_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pairs_proto_rawDesc), len(file_pairs_proto_rawDesc)))
+	})
+	return file_pairs_proto_rawDescData
+}
+
+var file_pairs_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_pairs_proto_goTypes = []any{
+	(*Pair)(nil),                      // 0: soroswap.pairs.v1.Pair
+	(*GetPairRequest)(nil),            // 1: soroswap.pairs.v1.GetPairRequest
+	(*ListPairsRequest)(nil),          // 2: soroswap.pairs.v1.ListPairsRequest
+	(*ListPairsResponse)(nil),         // 3: soroswap.pairs.v1.ListPairsResponse
+	(*GetReserveHistoryRequest)(nil),  // 4: soroswap.pairs.v1.GetReserveHistoryRequest
+	(*HistoryPoint)(nil),              // 5: soroswap.pairs.v1.HistoryPoint
+	(*GetReserveHistoryResponse)(nil), // 6: soroswap.pairs.v1.GetReserveHistoryResponse
+	(*timestamppb.Timestamp)(nil),     // 7: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),       // 8: google.protobuf.Duration
+}
+var file_pairs_proto_depIdxs = []int32{
+	7,  // 0: soroswap.pairs.v1.Pair.created_at:type_name -> google.protobuf.Timestamp
+	7,  // 1: soroswap.pairs.v1.Pair.last_sync_at:type_name -> google.protobuf.Timestamp
+	0,  // 2: soroswap.pairs.v1.ListPairsResponse.pairs:type_name -> soroswap.pairs.v1.Pair
+	7,  // 3: soroswap.pairs.v1.GetReserveHistoryRequest.from_time:type_name -> google.protobuf.Timestamp
+	7,  // 4: soroswap.pairs.v1.GetReserveHistoryRequest.to_time:type_name -> google.protobuf.Timestamp
+	8,  // 5: soroswap.pairs.v1.GetReserveHistoryRequest.bucket:type_name -> google.protobuf.Duration
+	7,  // 6: soroswap.pairs.v1.HistoryPoint.timestamp:type_name -> google.protobuf.Timestamp
+	5,  // 7: soroswap.pairs.v1.GetReserveHistoryResponse.points:type_name -> soroswap.pairs.v1.HistoryPoint
+	1,  // 8: soroswap.pairs.v1.PairService.GetPair:input_type -> soroswap.pairs.v1.GetPairRequest
+	2,  // 9: soroswap.pairs.v1.PairService.ListPairs:input_type -> soroswap.pairs.v1.ListPairsRequest
+	4,  // 10: soroswap.pairs.v1.PairService.GetReserveHistory:input_type -> soroswap.pairs.v1.GetReserveHistoryRequest
+	0,  // 11: soroswap.pairs.v1.PairService.GetPair:output_type -> soroswap.pairs.v1.Pair
+	3,  // 12: soroswap.pairs.v1.PairService.ListPairs:output_type -> soroswap.pairs.v1.ListPairsResponse
+	6,  // 13: soroswap.pairs.v1.PairService.GetReserveHistory:output_type -> soroswap.pairs.v1.GetReserveHistoryResponse
+	11, // [11:14] is the sub-list for method output_type
+	8,  // [8:11] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_pairs_proto_init() }
+func file_pairs_proto_init() {
+	if File_pairs_proto != nil {
+		return
+	}
+	file_pairs_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pairs_proto_rawDesc), len(file_pairs_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pairs_proto_goTypes,
+		DependencyIndexes: file_pairs_proto_depIdxs,
+		MessageInfos:      file_pairs_proto_msgTypes,
+	}.Build()
+	File_pairs_proto = out.File
+	file_pairs_proto_goTypes = nil
+	file_pairs_proto_depIdxs = nil
+}
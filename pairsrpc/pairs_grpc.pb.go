@@ -0,0 +1,207 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: pairs.proto
+
+package pairsrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PairService_GetPair_FullMethodName           = "/soroswap.pairs.v1.PairService/GetPair"
+	PairService_ListPairs_FullMethodName         = "/soroswap.pairs.v1.PairService/ListPairs"
+	PairService_GetReserveHistory_FullMethodName = "/soroswap.pairs.v1.PairService/GetReserveHistory"
+)
+
+// PairServiceClient is the client API for PairService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PairService answers queries about the stored Soroswap pairs.
+type PairServiceClient interface {
+	// GetPair returns one pair with its current reserves, or NOT_FOUND.
+	GetPair(ctx context.Context, in *GetPairRequest, opts ...grpc.CallOption) (*Pair, error)
+	// ListPairs returns pairs ordered by address.
+	ListPairs(ctx context.Context, in *ListPairsRequest, opts ...grpc.CallOption) (*ListPairsResponse, error)
+	// GetReserveHistory returns a pair's reserve timeline ordered by ledger.
+	GetReserveHistory(ctx context.Context, in *GetReserveHistoryRequest, opts ...grpc.CallOption) (*GetReserveHistoryResponse, error)
+}
+
+type pairServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPairServiceClient(cc grpc.ClientConnInterface) PairServiceClient {
+	return &pairServiceClient{cc}
+}
+
+func (c *pairServiceClient) GetPair(ctx context.Context, in *GetPairRequest, opts ...grpc.CallOption) (*Pair, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pair)
+	err := c.cc.Invoke(ctx, PairService_GetPair_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pairServiceClient) ListPairs(ctx context.Context, in *ListPairsRequest, opts ...grpc.CallOption) (*ListPairsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPairsResponse)
+	err := c.cc.Invoke(ctx, PairService_ListPairs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pairServiceClient) GetReserveHistory(ctx context.Context, in *GetReserveHistoryRequest, opts ...grpc.CallOption) (*GetReserveHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetReserveHistoryResponse)
+	err := c.cc.Invoke(ctx, PairService_GetReserveHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PairServiceServer is the server API for PairService service.
+// All implementations must embed UnimplementedPairServiceServer
+// for forward compatibility.
+//
+// PairService answers queries about the stored Soroswap pairs.
+type PairServiceServer interface {
+	// GetPair returns one pair with its current reserves, or NOT_FOUND.
+	GetPair(context.Context, *GetPairRequest) (*Pair, error)
+	// ListPairs returns pairs ordered by address.
+	ListPairs(context.Context, *ListPairsRequest) (*ListPairsResponse, error)
+	// GetReserveHistory returns a pair's reserve timeline ordered by ledger.
+	GetReserveHistory(context.Context, *GetReserveHistoryRequest) (*GetReserveHistoryResponse, error)
+	mustEmbedUnimplementedPairServiceServer()
+}
+
+// UnimplementedPairServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPairServiceServer struct{}
+
+func (UnimplementedPairServiceServer) GetPair(context.Context, *GetPairRequest) (*Pair, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPair not implemented")
+}
+func (UnimplementedPairServiceServer) ListPairs(context.Context, *ListPairsRequest) (*ListPairsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPairs not implemented")
+}
+func (UnimplementedPairServiceServer) GetReserveHistory(context.Context, *GetReserveHistoryRequest) (*GetReserveHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReserveHistory not implemented")
+}
+func (UnimplementedPairServiceServer) mustEmbedUnimplementedPairServiceServer() {}
+func (UnimplementedPairServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafePairServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PairServiceServer will
+// result in compilation errors.
+type UnsafePairServiceServer interface {
+	mustEmbedUnimplementedPairServiceServer()
+}
+
+func RegisterPairServiceServer(s grpc.ServiceRegistrar, srv PairServiceServer) {
+	// If the following call pancis, it indicates UnimplementedPairServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PairService_ServiceDesc, srv)
+}
+
+func _PairService_GetPair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PairServiceServer).GetPair(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PairService_GetPair_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PairServiceServer).GetPair(ctx, req.(*GetPairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PairService_ListPairs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPairsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PairServiceServer).ListPairs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PairService_ListPairs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PairServiceServer).ListPairs(ctx, req.(*ListPairsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PairService_GetReserveHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReserveHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PairServiceServer).GetReserveHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PairService_GetReserveHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PairServiceServer).GetReserveHistory(ctx, req.(*GetReserveHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PairService_ServiceDesc is the grpc.ServiceDesc for PairService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PairService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "soroswap.pairs.v1.PairService",
+	HandlerType: (*PairServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPair",
+			Handler:    _PairService_GetPair_Handler,
+		},
+		{
+			MethodName: "ListPairs",
+			Handler:    _PairService_ListPairs_Handler,
+		},
+		{
+			MethodName: "GetReserveHistory",
+			Handler:    _PairService_GetReserveHistory_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pairs.proto",
+}
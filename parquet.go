@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/parquet-go/parquet-go"
+)
+
+// defaultParquetTables are snapshotted when parquet_export_tables is not set.
+var defaultParquetTables = []string{"pairs", "history"}
+
+// parquetExport configures ExportParquet and its scheduled mode.
+type parquetExport struct {
+	tables []string
+	// dest is a local directory or an s3://bucket/prefix URI.
+	dest     string
+	interval time.Duration
+	s3       s3Settings
+}
+
+// s3Settings holds the S3 settings used for s3:// URIs.
+type s3Settings struct {
+	endpoint  string
+	region    string
+	insecure  bool
+	accessKey string
+	secretKey string
+}
+
+func parseParquetExport(cfg *Config) (parquetExport, error) {
+	pe := parquetExport{
+		dest:     cfg.ParquetExportPath,
+		interval: cfg.ParquetExportInterval,
+		tables:   cfg.ParquetExportTables,
+		s3: s3Settings{
+			endpoint:  cfg.ParquetS3Endpoint,
+			region:    cfg.ParquetS3Region,
+			insecure:  cfg.ParquetS3Insecure,
+			accessKey: cfg.ParquetS3AccessKeyID,
+			secretKey: cfg.ParquetS3SecretAccessKey,
+		},
+	}
+	if pe.interval > 0 && pe.dest == "" {
+		return pe, fmt.Errorf("config parquet_export_interval requires parquet_export_path")
+	}
+	if len(pe.tables) == 0 {
+		pe.tables = defaultParquetTables
+	}
+	for _, name := range pe.tables {
+		if _, ok := exportTables[name]; !ok {
+			return pe, fmt.Errorf("config parquet_export_tables: unknown table %q, expected one of %s", name, strings.Join(exportTableNames(), ", "))
+		}
+	}
+	if strings.HasPrefix(pe.dest, "s3://") {
+		if _, _, err := parseS3URI(pe.dest); err != nil {
+			return pe, fmt.Errorf("config parquet_export_path: %v", err)
+		}
+	}
+	return pe, nil
+}
+
+// parseS3URI splits s3://bucket/prefix into the bucket and the key prefix.
+func parseS3URI(uri string) (bucket, prefix string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("expected s3://bucket/prefix, got %q", uri)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// client connects to the S3 endpoint. Without configured keys the
+// credentials are taken from the AWS environment variables, the shared
+// credentials file or the instance role, in that order.
+func (c s3Settings) client() (*minio.Client, error) {
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	if c.accessKey != "" {
+		creds = credentials.NewStaticV4(c.accessKey, c.secretKey, "")
+	}
+	return minio.New(c.endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !c.insecure,
+		Region: c.region,
+	})
+}
+
+// ExportParquet writes a <table>.parquet snapshot of each of the
+// parquet_export_tables to dest, a local directory or an s3://bucket/prefix
+// URI, replacing the previous snapshot. All tables are read in one
+// transaction so they agree with each other. Each file's key-value
+// metadata records max_ledger and exported_at.
+func (s *SaveSoroswapPairsToSQLite) ExportParquet(ctx context.Context, dest string) error {
+	if dest == "" {
+		return errors.New("parquet export path is empty")
+	}
+	var (
+		s3     *minio.Client
+		bucket string
+		prefix string
+		dir    = dest
+	)
+	if strings.HasPrefix(dest, "s3://") {
+		var err error
+		if bucket, prefix, err = parseS3URI(dest); err != nil {
+			return err
+		}
+		if s3, err = s.parquet.s3.client(); err != nil {
+			return fmt.Errorf("failed to create S3 client: %v", err)
+		}
+		// Files are staged locally and uploaded once complete.
+		if dir, err = os.MkdirTemp("", "soroswap-parquet-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	tx, err := s.readDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Read only; nothing to commit
+
+	var maxLedger sql.NullInt64
+	if err := tx.QueryRowContext(ctx, s.backend.Rebind(
+		"SELECT MAX(last_sync_ledger) FROM soroswap_pairs")).Scan(&maxLedger); err != nil {
+		return fmt.Errorf("failed to read max ledger: %v", err)
+	}
+	meta := []parquet.WriterOption{
+		parquet.KeyValueMetadata("exported_at", time.Now().UTC().Format(time.RFC3339)),
+		parquet.KeyValueMetadata("plugin_version", s.version),
+	}
+	if maxLedger.Valid {
+		meta = append(meta, parquet.KeyValueMetadata("max_ledger", strconv.FormatInt(maxLedger.Int64, 10)))
+	}
+
+	for _, name := range s.parquet.tables {
+		file := name + ".parquet"
+		local := filepath.Join(dir, file)
+		n, err := s.exportParquetFile(ctx, tx, local, name, meta)
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %v", name, err)
+		}
+		target := local
+		if s3 != nil {
+			key := path.Join(prefix, file)
+			if _, err := s3.FPutObject(ctx, bucket, key, local, minio.PutObjectOptions{
+				ContentType: "application/vnd.apache.parquet",
+			}); err != nil {
+				return fmt.Errorf("failed to upload %s to s3://%s/%s: %v", name, bucket, key, err)
+			}
+			target = "s3://" + bucket + "/" + key
+		}
+		logger.Info("Exported Parquet", "table", name, "rows", n, "target", target)
+	}
+	return nil
+}
+
+// exportParquetFile writes one table to path through a temporary file, so
+// readers of a local snapshot never see a partial one.
+func (s *SaveSoroswapPairsToSQLite) exportParquetFile(ctx context.Context, tx *sql.Tx, path, name string, meta []parquet.WriterOption) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.writeParquet(ctx, tx, f, name, meta)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+// parquetKind is how a database column is stored in Parquet.
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetInt
+	parquetFloat
+	parquetBool
+	parquetTime
+)
+
+// parquetKindOf maps a column's database type. Amounts are TEXT in the
+// database and stay strings, keeping their full precision.
+func parquetKindOf(dbType string) parquetKind {
+	t := strings.ToUpper(dbType)
+	switch {
+	case strings.Contains(t, "INT") || t == "BIGSERIAL":
+		return parquetInt
+	case strings.Contains(t, "BOOL"):
+		return parquetBool
+	case strings.Contains(t, "TIMESTAMP") || strings.Contains(t, "DATETIME"):
+		return parquetTime
+	case strings.Contains(t, "REAL") || strings.Contains(t, "FLOAT") || strings.Contains(t, "DOUBLE"):
+		return parquetFloat
+	default:
+		return parquetString
+	}
+}
+
+func (k parquetKind) node() parquet.Node {
+	switch k {
+	case parquetInt:
+		return parquet.Optional(parquet.Int(64))
+	case parquetFloat:
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case parquetBool:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case parquetTime:
+		return parquet.Optional(parquet.Timestamp(parquet.Microsecond))
+	default:
+		return parquet.Optional(parquet.String())
+	}
+}
+
+// parquetField is a value scanned from a column and converted to its
+// Parquet value.
+type parquetField interface {
+	value() parquet.Value
+}
+
+type parquetStringField struct{ sql.NullString }
+type parquetIntField struct{ sql.NullInt64 }
+type parquetFloatField struct{ sql.NullFloat64 }
+type parquetBoolField struct{ sql.NullBool }
+type parquetTimeField struct{ sql.NullTime }
+
+func (f *parquetStringField) value() parquet.Value {
+	if !f.Valid {
+		return parquet.NullValue()
+	}
+	return parquet.ByteArrayValue([]byte(f.String))
+}
+
+func (f *parquetIntField) value() parquet.Value {
+	if !f.Valid {
+		return parquet.NullValue()
+	}
+	return parquet.Int64Value(f.Int64)
+}
+
+func (f *parquetFloatField) value() parquet.Value {
+	if !f.Valid {
+		return parquet.NullValue()
+	}
+	return parquet.DoubleValue(f.Float64)
+}
+
+func (f *parquetBoolField) value() parquet.Value {
+	if !f.Valid {
+		return parquet.NullValue()
+	}
+	return parquet.BooleanValue(f.Bool)
+}
+
+func (f *parquetTimeField) value() parquet.Value {
+	if !f.Valid {
+		return parquet.NullValue()
+	}
+	return parquet.Int64Value(f.Time.UnixMicro())
+}
+
+func (k parquetKind) field() parquetField {
+	switch k {
+	case parquetInt:
+		return &parquetIntField{}
+	case parquetFloat:
+		return &parquetFloatField{}
+	case parquetBool:
+		return &parquetBoolField{}
+	case parquetTime:
+		return &parquetTimeField{}
+	default:
+		return &parquetStringField{}
+	}
+}
+
+// writeParquet writes every row of a table, with one optional column per
+// database column, returning the number of rows.
+func (s *SaveSoroswapPairsToSQLite) writeParquet(ctx context.Context, tx *sql.Tx, w io.Writer, name string, meta []parquet.WriterOption) (int, error) {
+	t := exportTables[name]
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind("SELECT * FROM "+t.Table+" ORDER BY "+t.Order))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	group := make(parquet.Group, len(types))
+	fields := make([]parquetField, len(types))
+	dest := make([]interface{}, len(types))
+	for i, ct := range types {
+		kind := parquetKindOf(ct.DatabaseTypeName())
+		group[ct.Name()] = kind.node()
+		fields[i] = kind.field()
+		dest[i] = fields[i]
+	}
+	schema := parquet.NewSchema(name, group)
+	// The schema orders its columns by name; values must follow that order.
+	index := make([]int, len(types))
+	for i, ct := range types {
+		leaf, _ := schema.Lookup(ct.Name())
+		index[leaf.ColumnIndex] = i
+	}
+
+	options := append([]parquet.WriterOption{schema, parquet.Compression(&parquet.Zstd)}, meta...)
+	pw := parquet.NewWriter(w, options...)
+	n := 0
+	row := make(parquet.Row, len(types))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for col, i := range index {
+			v := fields[i].value()
+			definition := 1
+			if v.IsNull() {
+				definition = 0
+			}
+			row[col] = v.Level(0, definition, col)
+		}
+		if _, err := pw.WriteRows([]parquet.Row{row}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, pw.Close()
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partitionedTable is a table partition_by_month splits into one table per
+// month of Column, named like soroswap_swaps_2025_01. A view under the
+// table's name unions them, so queries keep reading the table. Files owning
+// such tables register them. Once partitioned, a table's schema lives in
+// its _template table and each partition: migrations changing it must
+// change those and rebuild the view.
+type partitionedTable struct {
+	Table  string
+	Column string
+}
+
+var partitionedTables []partitionedTable
+
+func registerPartitionedTable(t partitionedTable) {
+	partitionedTables = append(partitionedTables, t)
+}
+
+const (
+	// partitionMonthFormat names a partition's month, as strftime's
+	// %Y_%m does.
+	partitionMonthFormat = "2006_01"
+	// partitionIDSpan sets apart the ids of each month: a partition numbers
+	// its rows from YYYYMM * partitionIDSpan, so ids stay unique across
+	// the view.
+	partitionIDSpan = 10_000_000_000
+)
+
+const (
+	// partitionMonthsQuery lists the months of a partitioned table, with a
+	// NULL month when it has none; a table that is not partitioned has no
+	// row.
+	partitionMonthsQuery = `
+        SELECT p.month FROM partitioned_tables t
+        LEFT JOIN table_partitions p ON p.table_name = t.table_name
+        WHERE t.table_name = ?
+        ORDER BY p.month
+    `
+
+	partitionExistsQuery = `SELECT EXISTS (
+		SELECT 1 FROM table_partitions WHERE table_name = ? AND month = ?
+	)`
+
+	insertPartitionQuery = `
+        INSERT INTO table_partitions (table_name, month, created_at) VALUES (?, ?, ?)
+    `
+
+	insertPartitionedTableQuery = `
+        INSERT INTO partitioned_tables (table_name, time_column, partitioned_at) VALUES (?, ?, ?)
+    `
+)
+
+var (
+	createTableName = regexp.MustCompile(`(?is)^(\s*CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?)("[^"]+"|\w+)`)
+	createIndexName = regexp.MustCompile(`(?is)^(\s*CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:IF\s+NOT\s+EXISTS\s+)?)("[^"]+"|\w+)(\s+ON\s+)("[^"]+"|\w+)`)
+)
+
+// queryer is a *sql.DB or *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// partitionName returns the database name of table's partition of month.
+func partitionName(b backend, table, month string) string {
+	return b.Table(table) + "_" + month
+}
+
+// partitionTemplate returns the database name of table's template, the
+// empty table partitions are created like.
+func partitionTemplate(b backend, table string) string {
+	return b.Table(table) + "_template"
+}
+
+// partitionMonths returns the months table is partitioned into, and whether
+// it is partitioned at all.
+func partitionMonths(ctx context.Context, q queryer, b backend, table string) ([]string, bool, error) {
+	rows, err := q.QueryContext(ctx, b.Rebind(partitionMonthsQuery), table)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query partitions of %s: %v", table, err)
+	}
+	defer rows.Close()
+	var months []string
+	partitioned := false
+	for rows.Next() {
+		var month sql.NullString
+		if err := rows.Scan(&month); err != nil {
+			return nil, false, fmt.Errorf("failed to scan partition of %s: %v", table, err)
+		}
+		partitioned = true
+		if month.Valid {
+			months = append(months, month.String)
+		}
+	}
+	return months, partitioned, rows.Err()
+}
+
+// onPartitions returns stmt once for every table holding table's rows:
+// naming each of its partitions instead when table is partitioned, since
+// its view cannot be written, or unchanged when not.
+func onPartitions(ctx context.Context, q queryer, b backend, table, stmt string) ([]string, error) {
+	months, partitioned, err := partitionMonths(ctx, q, b, table)
+	if err != nil || !partitioned {
+		return []string{stmt}, err
+	}
+	name := regexp.MustCompile(`\b` + regexp.QuoteMeta(table) + `\b`)
+	stmts := make([]string, len(months))
+	for i, month := range months {
+		stmts[i] = name.ReplaceAllLiteralString(stmt, partitionName(b, table, month))
+	}
+	return stmts, nil
+}
+
+// tableSchema returns the CREATE statements of a table and its indexes, by
+// index name.
+func tableSchema(ctx context.Context, q queryer, name string) (string, map[string]string, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT type, name, sql FROM sqlite_master WHERE tbl_name = ? AND type IN ('table', 'index') AND sql IS NOT NULL", name)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read schema of %s: %v", name, err)
+	}
+	defer rows.Close()
+	var create string
+	indexes := make(map[string]string)
+	for rows.Next() {
+		var typ, object, stmt string
+		if err := rows.Scan(&typ, &object, &stmt); err != nil {
+			return "", nil, fmt.Errorf("failed to read schema of %s: %v", name, err)
+		}
+		if typ == "table" {
+			create = stmt
+		} else {
+			indexes[object] = stmt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("failed to read schema of %s: %v", name, err)
+	}
+	if create == "" {
+		return "", nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return create, indexes, nil
+}
+
+// createLike creates table name with the columns and indexes of another
+// table, given by tableSchema. Index names get suffix in place of the
+// source's own.
+func createLike(ctx context.Context, tx *sql.Tx, name, create string, indexes map[string]string, trim, suffix string) error {
+	if _, err := tx.ExecContext(ctx, createTableName.ReplaceAllString(create, "${1}"+name)); err != nil {
+		return fmt.Errorf("failed to create %s: %v", name, err)
+	}
+	for index, stmt := range indexes {
+		index = strings.TrimSuffix(index, trim) + suffix
+		if _, err := tx.ExecContext(ctx, createIndexName.ReplaceAllString(stmt, "${1}"+index+"${3}"+name)); err != nil {
+			return fmt.Errorf("failed to create index %s: %v", index, err)
+		}
+	}
+	return nil
+}
+
+// createPartition creates t's partition of month like its template. Its
+// ids start past those of earlier months.
+func createPartition(ctx context.Context, tx *sql.Tx, b backend, t partitionedTable, month string) error {
+	create, indexes, err := tableSchema(ctx, tx, partitionTemplate(b, t.Table))
+	if err != nil {
+		return err
+	}
+	name := partitionName(b, t.Table, month)
+	if err := createLike(ctx, tx, name, create, indexes, "_template", "_"+month); err != nil {
+		return err
+	}
+	if strings.Contains(strings.ToUpper(create), "AUTOINCREMENT") {
+		n, err := strconv.ParseInt(strings.ReplaceAll(month, "_", ""), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid partition month %q", month)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)", name, n*partitionIDSpan); err != nil {
+			return fmt.Errorf("failed to number %s: %v", name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, b.Rebind(insertPartitionQuery), t.Table, month, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record partition %s: %v", name, err)
+	}
+	return nil
+}
+
+// rebuildPartitionView recreates the view unioning table's template and
+// partitions.
+func rebuildPartitionView(ctx context.Context, tx *sql.Tx, b backend, table string) error {
+	months, _, err := partitionMonths(ctx, tx, b, table)
+	if err != nil {
+		return err
+	}
+	selects := []string{"SELECT * FROM " + partitionTemplate(b, table)}
+	for _, month := range months {
+		selects = append(selects, "SELECT * FROM "+partitionName(b, table, month))
+	}
+	view := b.Table(table)
+	if _, err := tx.ExecContext(ctx, "DROP VIEW IF EXISTS "+view); err != nil {
+		return fmt.Errorf("failed to drop view %s: %v", view, err)
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE VIEW "+view+" AS "+strings.Join(selects, " UNION ALL ")); err != nil {
+		return fmt.Errorf("failed to create view %s: %v", view, err)
+	}
+	return nil
+}
+
+// monthPartitions routes inserts into the partitioned tables to the
+// partition of their month, creating it when it is the month's first row.
+type monthPartitions struct {
+	b      backend
+	tables map[string]partitionedTable
+}
+
+// setupPartitions partitions the registered tables by month when enabled,
+// moving their rows into monthly partitions the first time, and returns
+// the router for their inserts. A partitioned database cannot go back.
+func setupPartitions(ctx context.Context, db *sql.DB, b backend, enabled, dryRun bool) (*monthPartitions, error) {
+	p := &monthPartitions{b: b, tables: make(map[string]partitionedTable)}
+	for _, t := range partitionedTables {
+		_, partitioned, err := partitionMonths(ctx, db, b, t.Table)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case partitioned && !enabled:
+			return nil, fmt.Errorf("%s is partitioned by month; partition_by_month cannot be turned off", t.Table)
+		case !enabled:
+			continue
+		case !partitioned && dryRun:
+			return nil, fmt.Errorf("dry run: partition_by_month needs a run without dry_run to partition %s", t.Table)
+		case !partitioned:
+			if err := partitionTable(ctx, db, b, t); err != nil {
+				return nil, fmt.Errorf("failed to partition %s: %v", t.Table, err)
+			}
+		}
+		p.tables[t.Table] = t
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// partitionTable turns t into a view over monthly partitions, in one
+// transaction: the table's rows move to the partition of their month, and
+// the table itself is dropped for the view.
+func partitionTable(ctx context.Context, db *sql.DB, b backend, t partitionedTable) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	table := b.Table(t.Table)
+	create, indexes, err := tableSchema(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	if err := createLike(ctx, tx, partitionTemplate(b, t.Table), create, indexes, "", "_template"); err != nil {
+		return err
+	}
+
+	monthOf := fmt.Sprintf("strftime('%%Y_%%m', %s)", t.Column)
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM %s", monthOf, table))
+	if err != nil {
+		return err
+	}
+	var months []string
+	for rows.Next() {
+		var month sql.NullString
+		if err := rows.Scan(&month); err != nil {
+			rows.Close()
+			return err
+		}
+		if !month.Valid {
+			rows.Close()
+			return fmt.Errorf("rows with an unreadable %s cannot be partitioned", t.Column)
+		}
+		months = append(months, month.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var moved int64
+	for _, month := range months {
+		if err := createPartition(ctx, tx, b, t, month); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s = ?",
+			partitionName(b, t.Table, month), table, monthOf), month)
+		if err != nil {
+			return fmt.Errorf("failed to move rows of %s: %v", month, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		moved += n
+	}
+	if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, b.Rebind(insertPartitionedTableQuery), t.Table, t.Column, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := rebuildPartitionView(ctx, tx, b, t.Table); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.Info("Partitioned table by month", "table", t.Table, "partitions", len(months), "rows", moved)
+	return nil
+}
+
+// partitioned reports whether table is partitioned by month.
+func (p *monthPartitions) partitioned(table string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.tables[table]
+	return ok
+}
+
+// routes reports whether query inserts into a partitioned table, and so
+// must be routed rather than prepared against the view.
+func (p *monthPartitions) routes(query string) bool {
+	if p == nil {
+		return false
+	}
+	for table := range p.tables {
+		if strings.Contains(query, "INSERT INTO "+table+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns query, an INSERT into table, inserting into the partition
+// of at's month instead when table is partitioned. A missing partition is
+// created in tx, so it goes if the event rolls back.
+func (p *monthPartitions) route(ctx context.Context, tx *sql.Tx, table, query string, at time.Time) (string, error) {
+	if p == nil {
+		return query, nil
+	}
+	t, ok := p.tables[table]
+	if !ok {
+		return query, nil
+	}
+	month := at.UTC().Format(partitionMonthFormat)
+	var exists bool
+	if err := tx.QueryRowContext(ctx, p.b.Rebind(partitionExistsQuery), table, month).Scan(&exists); err != nil {
+		return "", fmt.Errorf("failed to look up partition %s of %s: %v", month, table, err)
+	}
+	if !exists {
+		if err := createPartition(ctx, tx, p.b, t, month); err != nil {
+			return "", err
+		}
+		if err := rebuildPartitionView(ctx, tx, p.b, table); err != nil {
+			return "", err
+		}
+		logger.Info("Created partition", "table", table, "month", month)
+	}
+	insert := "INSERT INTO " + table + " "
+	if !strings.Contains(query, insert) {
+		return "", errors.New("partitioned insert does not name " + table)
+	}
+	return strings.Replace(query, insert, "INSERT INTO "+partitionName(p.b, table, month)+" ", 1), nil
+}
+
+// dropPartitionsBefore drops the partitions of table whose whole month is
+// older than cutoff, returning how many it dropped.
+func (s *SaveSoroswapPairsToSQLite) dropPartitionsBefore(ctx context.Context, table string, cutoff time.Time) (int, error) {
+	if !s.partitions.partitioned(table) {
+		return 0, nil
+	}
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+	months, _, err := partitionMonths(ctx, tx, s.backend, table)
+	if err != nil {
+		return 0, err
+	}
+	var dropped []string
+	for _, month := range months {
+		start, err := time.Parse(partitionMonthFormat, month)
+		if err != nil {
+			return 0, fmt.Errorf("invalid partition month %q", month)
+		}
+		if start.AddDate(0, 1, 0).After(cutoff) {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, "DROP TABLE "+partitionName(s.backend, table, month)); err != nil {
+			return 0, fmt.Errorf("failed to drop partition %s of %s: %v", month, table, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.backend.Rebind(
+			"DELETE FROM table_partitions WHERE table_name = ? AND month = ?"), table, month); err != nil {
+			return 0, err
+		}
+		dropped = append(dropped, month)
+	}
+	if len(dropped) == 0 {
+		return 0, nil
+	}
+	if err := rebuildPartitionView(ctx, tx, s.backend, table); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	logger.Info("Dropped partitions past retention", "table", table, "months", dropped)
+	return len(dropped), nil
+}
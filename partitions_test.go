@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// monthEvents syncs and swaps testPair once in each of January, February
+// and March 2025.
+func monthEvents() []event {
+	events := []event{newPairEvent(testPair, 10)}
+	for i, month := range []time.Month{time.January, time.February, time.March} {
+		at := time.Date(2025, month, 10, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		ledger := int64(20 + i)
+		events = append(events,
+			syncEvent(testPair, fmt.Sprint(100+i), "50", ledger).with(event{"timestamp": at}),
+			swapEvent(testPair, fmt.Sprintf("cc%02d", i), ledger).with(event{"timestamp": at}),
+		)
+	}
+	return events
+}
+
+// partitionMonthsOf returns the months table_partitions lists for table,
+// failing the test when a partition's table is missing.
+func partitionMonthsOf(t *testing.T, s *SaveSoroswapPairsToSQLite, table string) []string {
+	t.Helper()
+	var months []string
+	for _, row := range queryStrings(t, s, "SELECT month FROM table_partitions WHERE table_name = ? ORDER BY month", table) {
+		months = append(months, row[0])
+		name := partitionName(s.backend, table, row[0])
+		if got := queryStrings(t, s, "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", name); len(got) != 1 {
+			t.Errorf("partition %s is listed but has no table", name)
+		}
+	}
+	return months
+}
+
+func TestPartitionByMonth(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing processes the events before partitioning, so Initialize
+		// moves the rows of an existing database.
+		existing bool
+	}{
+		{"new database", false},
+		{"existing database", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{"reserve_history": true, "partition_by_month": true}
+			var s *SaveSoroswapPairsToSQLite
+			if tt.existing {
+				path := filepath.Join(t.TempDir(), "pairs.sqlite")
+				before := openTestConsumer(t, map[string]interface{}{"db_path": path, "reserve_history": true})
+				process(t, before, monthEvents()...)
+				before.Close()
+				config["db_path"] = path
+				s = newTestConsumer(t, config)
+			} else {
+				s = newTestConsumer(t, config)
+				process(t, s, monthEvents()...)
+			}
+
+			want := []string{"2025_01", "2025_02", "2025_03"}
+			for _, table := range []string{"pair_reserve_history", "soroswap_swaps"} {
+				if got := partitionMonthsOf(t, s, table); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s partitions = %v, want %v", table, got, want)
+				}
+				// The view under the table's name unions the partitions.
+				if n := countRows(t, s, table); n != 3 {
+					t.Errorf("%s view = %d rows, want 3", table, n)
+				}
+			}
+			got := queryStrings(t, s, "SELECT reserve_0 FROM pair_reserve_history_2025_02")
+			if !reflect.DeepEqual(got, [][]string{{"101"}}) {
+				t.Errorf("February partition holds %v, want the sync of 101", got)
+			}
+		})
+	}
+}
+
+func TestDropPartitionsBefore(t *testing.T) {
+	tests := []struct {
+		name        string
+		cutoff      time.Time
+		wantDropped int
+		wantMonths  []string
+	}{
+		{"before every month", time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC), 0, []string{"2025_01", "2025_02", "2025_03"}},
+		{"within a month", time.Date(2025, time.February, 15, 0, 0, 0, 0, time.UTC), 1, []string{"2025_02", "2025_03"}},
+		{"at a month's end", time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC), 2, []string{"2025_03"}},
+		{"after every month", time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC), 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, map[string]interface{}{"reserve_history": true, "partition_by_month": true})
+			process(t, s, monthEvents()...)
+
+			dropped, err := s.dropPartitionsBefore(context.Background(), "soroswap_swaps", tt.cutoff)
+			if err != nil {
+				t.Fatalf("dropPartitionsBefore: %v", err)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("dropped %d partitions, want %d", dropped, tt.wantDropped)
+			}
+			if got := partitionMonthsOf(t, s, "soroswap_swaps"); !reflect.DeepEqual(got, tt.wantMonths) {
+				t.Errorf("partitions = %v, want %v", got, tt.wantMonths)
+			}
+			for _, month := range []string{"2025_01", "2025_02", "2025_03"} {
+				name := partitionName(s.backend, "soroswap_swaps", month)
+				kept := len(queryStrings(t, s, "SELECT name FROM sqlite_master WHERE name = ?", name)) == 1
+				if listed := slices.Contains(tt.wantMonths, month); kept != listed {
+					t.Errorf("table %s exists = %v, want %v", name, kept, listed)
+				}
+			}
+			if n := countRows(t, s, "soroswap_swaps"); n != len(tt.wantMonths) {
+				t.Errorf("swaps view = %d rows, want %d", n, len(tt.wantMonths))
+			}
+			// Other tables keep their partitions.
+			if n := countRows(t, s, "pair_reserve_history"); n != 3 {
+				t.Errorf("reserve history = %d rows, want 3", n)
+			}
+		})
+	}
+}
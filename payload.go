@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// normalizePayload turns a structured payload into the JSON the handlers
+// decode, so the consumer can sit behind processors that emit decoded
+// objects: maps, slices of events (handled like a JSON array) and the event
+// structs of this package, by value or pointer. An event struct without a
+// Type gets the one its struct implies, where that is unambiguous. Payloads
+// that cannot be encoded are left as they are and fail in processMessage.
+// Encoded payloads go through the codec of their content-type metadata, or
+// of payload_content_type when they have none.
+func (s *SaveSoroswapPairsToSQLite) normalizePayload(msg pluginapi.Message) pluginapi.Message {
+	switch p := msg.Payload.(type) {
+	case []byte:
+		codec, params, err := payloadCodecFor(msg, s.payloadContentType)
+		if err != nil {
+			msg.Payload = undecodablePayload{raw: p, err: fmt.Errorf("error decoding payload: %v", err)}
+			return msg
+		}
+		encoded, err := codec.Decode(p, params)
+		if err != nil {
+			msg.Payload = undecodablePayload{raw: p, err: fmt.Errorf("error decoding %s payload: %v", codec.Name, err)}
+			return msg
+		}
+		msg.Payload = encoded
+		return msg
+	case json.RawMessage:
+		msg.Payload = []byte(p)
+		return msg
+	case string:
+		msg.Payload = []byte(p)
+		return msg
+	case nil:
+		return msg
+	}
+	encoded, err := json.Marshal(withEventType(msg.Payload))
+	if err != nil {
+		return msg
+	}
+	msg.Payload = encoded
+	return msg
+}
+
+// withEventType fills in the Type of an event struct sent without one.
+// Events whose struct covers several types (factory and router liquidity
+// events) must set it themselves.
+func withEventType(payload interface{}) interface{} {
+	switch e := payload.(type) {
+	case *NewPairEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case NewPairEvent:
+		e.Type = defaultString(e.Type, "new_pair")
+		return e
+	case *SyncEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case SyncEvent:
+		e.Type = defaultString(e.Type, "sync")
+		return e
+	case *SwapEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case SwapEvent:
+		e.Type = defaultString(e.Type, "swap")
+		return e
+	case *DepositEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case DepositEvent:
+		e.Type = defaultString(e.Type, "deposit")
+		return e
+	case *WithdrawEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case WithdrawEvent:
+		e.Type = defaultString(e.Type, "withdraw")
+		return e
+	case *LPTransferEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case LPTransferEvent:
+		e.Type = defaultString(e.Type, "lp_transfer")
+		return e
+	case *ProtocolFeeEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case ProtocolFeeEvent:
+		e.Type = defaultString(e.Type, "protocol_fee")
+		return e
+	case *AggregatorSwapEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case AggregatorSwapEvent:
+		e.Type = defaultString(e.Type, "aggregator_swap")
+		return e
+	case *RouterSwapEvent:
+		if e != nil {
+			return withEventType(*e)
+		}
+	case RouterSwapEvent:
+		e.Type = defaultString(e.Type, "router_swap")
+		return e
+	case []interface{}:
+		events := make([]interface{}, len(e))
+		for i, elem := range e {
+			events[i] = withEventType(elem)
+		}
+		return events
+	}
+	return payload
+}
+
+// undecodablePayload stands in for a payload normalizePayload failed to
+// decode, so processMessage reports the failure and the raw payload is
+// dead-lettered like an undecodable JSON one.
+type undecodablePayload struct {
+	raw []byte
+	err error
+}
+
+// rawPayload returns a message's payload as received, for storing with
+// its error.
+func rawPayload(msg pluginapi.Message) []byte {
+	switch p := msg.Payload.(type) {
+	case []byte:
+		return p
+	case undecodablePayload:
+		return p.raw
+	}
+	return []byte(fmt.Sprint(msg.Payload))
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	insertPendingSyncQuery = `
+        INSERT INTO pending_syncs (
+            pair_address, reserve_0, reserve_1, ledger_sequence,
+            tx_hash, operation_index, event_index, synced_at, synced_at_original, received_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+	loadPendingSyncsQuery = `
+        SELECT reserve_0, reserve_1, COALESCE(ledger_sequence, 0), COALESCE(tx_hash, ''),
+            operation_index, event_index, synced_at, synced_at_original
+        FROM pending_syncs WHERE pair_address = ?
+        ORDER BY COALESCE(ledger_sequence, 0), id
+    `
+
+	deletePendingSyncsQuery = `DELETE FROM pending_syncs WHERE pair_address = ?`
+)
+
+func init() {
+	registerHandlerQuery(insertPendingSyncQuery, loadPendingSyncsQuery, deletePendingSyncsQuery)
+	// Syncs of pairs whose new_pair never arrives, e.g. because it was
+	// filtered out, only leave through retention.
+	registerRetentionTable(retentionTable{Name: "pending_syncs", Table: "pending_syncs", Column: "received_at"})
+	registerLedgerTable(ledgerTable{Table: "pending_syncs"})
+}
+
+// queueSync holds a sync of a pair that is not stored yet in
+// pending_syncs, until the pair's new_pair event applies it.
+func (s *SaveSoroswapPairsToSQLite) queueSync(ctx context.Context, tx *sql.Tx, event SyncEvent, syncedAt validatedTimestamp) error {
+	if _, err := s.stmts.exec(ctx, tx, insertPendingSyncQuery,
+		event.ContractID,
+		string(event.NewReserve0),
+		string(event.NewReserve1),
+		nullableLedger(event.LedgerSequence),
+		nullableString(event.TxHash),
+		event.OperationIndex,
+		event.EventIndex,
+		syncedAt.Value,
+		syncedAt.Original,
+		time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to queue sync for %s: %v", event.ContractID, err)
+	}
+	if !s.backfill {
+		logger.Info("Queued sync for pair not stored yet", "event_type", "sync", "pair", event.ContractID,
+			"ledger", event.LedgerSequence)
+	}
+	return nil
+}
+
+// applyPendingSyncs applies the queued syncs of a pair just stored, in
+// ledger order, inside the new pair's transaction, and removes them.
+func (s *SaveSoroswapPairsToSQLite) applyPendingSyncs(ctx context.Context, tx *sql.Tx, pair string) error {
+	rows, err := s.stmts.query(ctx, tx, loadPendingSyncsQuery, pair)
+	if err != nil {
+		return fmt.Errorf("failed to query pending syncs of %s: %v", pair, err)
+	}
+	type pendingSync struct {
+		event    SyncEvent
+		syncedAt validatedTimestamp
+	}
+	var pending []pendingSync
+	for rows.Next() {
+		p := pendingSync{event: SyncEvent{Type: "sync", ContractID: pair}}
+		var original sql.NullTime
+		if err := rows.Scan(&p.event.NewReserve0, &p.event.NewReserve1, &p.event.LedgerSequence, &p.event.TxHash,
+			&p.event.OperationIndex, &p.event.EventIndex, &p.syncedAt.Value, &original); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pending sync of %s: %v", pair, err)
+		}
+		if original.Valid {
+			p.syncedAt.Original = &original.Time
+		}
+		p.event.Timestamp = p.syncedAt.Value
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read pending syncs of %s: %v", pair, err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	for _, p := range pending {
+		if _, err := s.applySync(ctx, tx, p.event, p.syncedAt); err != nil {
+			return fmt.Errorf("failed to apply pending sync of %s at ledger %d: %v", pair, p.event.LedgerSequence, err)
+		}
+	}
+	if _, err := s.stmts.exec(ctx, tx, deletePendingSyncsQuery, pair); err != nil {
+		return fmt.Errorf("failed to remove pending syncs of %s: %v", pair, err)
+	}
+	logger.Info("Applied pending syncs", "event_type", "new_pair", "pair", pair, "syncs", len(pending))
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// workerPool processes events on several goroutines, partitioned by pair
+// address: all events of a pair go to the same worker, in the order they
+// arrived, while different pairs are written in parallel.
+type workerPool struct {
+	queues []chan poolTask
+	// stop ends the workers once Close has drained them.
+	stop chan struct{}
+}
+
+// poolTask is one event handed to a worker.
+type poolTask struct {
+	ctx    context.Context
+	msg    pluginapi.Message
+	result chan<- error
+}
+
+// poolQueueSize is how many events may wait for each worker.
+const poolQueueSize = 64
+
+// parseWorkers reads workers, the size of the worker pool. 0 or 1 processes
+// events one at a time on the caller's goroutine.
+func parseWorkers(cfg *Config) (int, error) {
+	if cfg.Workers < 0 {
+		return 0, fmt.Errorf("config workers must not be negative, got %d", cfg.Workers)
+	}
+	return cfg.Workers, nil
+}
+
+// startWorkers starts the pool's goroutines, which run processOne.
+func (s *SaveSoroswapPairsToSQLite) startWorkers(n int) *workerPool {
+	p := &workerPool{queues: make([]chan poolTask, n), stop: make(chan struct{})}
+	for i := range p.queues {
+		q := make(chan poolTask, poolQueueSize)
+		p.queues[i] = q
+		go func() {
+			for {
+				select {
+				case <-p.stop:
+					return
+				case t := <-q:
+					t.result <- s.processOne(t.ctx, t.msg)
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// stopWorkers ends the workers. Process no longer hands them events once
+// Close has drained.
+func (s *SaveSoroswapPairsToSQLite) stopWorkers() {
+	if s.pool != nil {
+		close(s.pool.stop)
+		s.pool = nil
+	}
+}
+
+// process runs msgs on the pool and waits for them, returning their errors
+// in message order. Events without a single pair, such as router swaps,
+// touch several partitions: they wait for the events before them and run
+// alone, so ordering is kept across them too.
+func (p *workerPool) process(ctx context.Context, s *SaveSoroswapPairsToSQLite, msgs []pluginapi.Message) error {
+	errs := make([]error, len(msgs))
+	var pending []int
+	results := make([]chan error, len(msgs))
+	wait := func() {
+		for _, i := range pending {
+			errs[i] = <-results[i]
+		}
+		pending = pending[:0]
+	}
+	for i, msg := range msgs {
+		key, ok := partitionKey(msg)
+		if !ok {
+			wait()
+			errs[i] = s.processOne(ctx, msg)
+			continue
+		}
+		results[i] = make(chan error, 1)
+		task := poolTask{ctx: ctx, msg: msg, result: results[i]}
+		select {
+		case p.queues[partition(key, len(p.queues))] <- task:
+			pending = append(pending, i)
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		case <-p.stop:
+			errs[i] = ErrShuttingDown
+		}
+	}
+	wait()
+	return errors.Join(errs...)
+}
+
+// partitionKey returns the pair an event belongs to, and false for events
+// of several pairs or none.
+func partitionKey(msg pluginapi.Message) (string, bool) {
+	payload, ok := msg.Payload.([]byte)
+	if !ok {
+		return "", false
+	}
+	var e struct {
+		Type        string `json:"type"`
+		PairAddress string `json:"pair_address"`
+		ContractID  string `json:"contract_id"`
+	}
+	if json.Unmarshal(payload, &e) != nil || e.Type == "router_swap" {
+		return "", false
+	}
+	key := e.ContractID
+	if key == "" {
+		key = e.PairAddress
+	}
+	if key == "" {
+		return "", false
+	}
+	// The handlers accept several spellings of an address; partition by
+	// the one they store.
+	normalizeAddresses(&key)
+	return key, true
+}
+
+// partition maps a pair address to one of n workers.
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// batchMessage encodes events as one Process message holding an array.
+func batchMessage(tb testing.TB, events ...event) pluginapi.Message {
+	tb.Helper()
+	payload, err := json.Marshal(events)
+	if err != nil {
+		tb.Fatalf("encode events: %v", err)
+	}
+	return pluginapi.Message{Payload: payload}
+}
+
+func TestWorkerPoolKeepsPairOrder(t *testing.T) {
+	pairs := []string{testPair, testPair2, testContract(12), testContract(13)}
+	const syncs = 20
+
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{"one worker", 1},
+		{"two workers", 2},
+		{"four workers", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, map[string]interface{}{"workers": tt.workers, "reserve_history": true})
+			var events []event
+			for _, p := range pairs {
+				events = append(events, newPairEvent(p, 10))
+			}
+			// Syncs of the pairs interleave, each pair's reserve 0
+			// counting up in ledger order.
+			for i := 1; i <= syncs; i++ {
+				for _, p := range pairs {
+					events = append(events, syncEvent(p, fmt.Sprint(i), "5", int64(10+i)))
+				}
+			}
+			if err := s.Process(context.Background(), batchMessage(t, events...)); err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+
+			for _, p := range pairs {
+				got := getPair(t, s, p)
+				if got.Reserve0 != fmt.Sprint(syncs) || got.LastSyncLedger == nil || *got.LastSyncLedger != 10+syncs {
+					t.Errorf("%s: reserve_0 = %s at %v, want %d at %d", p, got.Reserve0, got.LastSyncLedger, syncs, 10+syncs)
+				}
+			}
+			if n := countRows(t, s, "pair_reserve_history"); n != len(pairs)*syncs {
+				t.Errorf("reserve history = %d rows, want %d", n, len(pairs)*syncs)
+			}
+			if got := s.Stats().Outcomes[outcomeUpdated]; got != int64(len(pairs)*syncs) {
+				t.Errorf("updated outcomes = %d, want %d", got, len(pairs)*syncs)
+			}
+		})
+	}
+}
+
+func TestWorkersConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{"negative", map[string]interface{}{"workers": -1}, "workers must not be negative"},
+		{"with batch_size", map[string]interface{}{"workers": 2, "batch_size": 10}, "cannot be combined with batch_size"},
+		{"with batch_by_ledger", map[string]interface{}{"workers": 2, "batch_by_ledger": true}, "cannot be combined with batch_size"},
+		{"one worker with batch_size", map[string]interface{}{"workers": 1, "batch_size": 10}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New().(*SaveSoroswapPairsToSQLite)
+			err := s.Initialize(testConfig(tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Initialize: %v", err)
+				}
+				s.Close()
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				s.Close()
+				t.Fatalf("Initialize error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
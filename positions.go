@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// LPTransferEvent is a transfer of a pair's LP shares between two
+// addresses. The pair contract is the LP token, so ContractID is the pair.
+type LPTransferEvent struct {
+	Type       string    `json:"type"`
+	ContractID string    `json:"contract_id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Amount     Amount    `json:"amount"`
+	TxHash     string    `json:"tx_hash"`
+	Timestamp  time.Time `json:"timestamp"`
+	// OperationIndex is the operation within the transaction.
+	OperationIndex int64 `json:"operation_index"`
+	// EventIndex tells apart several transfers in one transaction.
+	EventIndex int64 `json:"event_index"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const (
+	insertLPTransferQuery = `
+        INSERT INTO lp_transfers (
+            pair_address, from_address, to_address, amount,
+            ledger_sequence, tx_hash, operation_index, event_index, transferred_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, pair_address, event_index) DO NOTHING
+    `
+
+	loadLPPositionQuery = `
+        SELECT shares FROM lp_positions WHERE pair_address = ? AND provider = ?
+    `
+
+	upsertLPPositionQuery = `
+        INSERT INTO lp_positions (pair_address, provider, shares, last_ledger, updated_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address, provider) DO UPDATE SET
+            shares = excluded.shares,
+            last_ledger = COALESCE(excluded.last_ledger, lp_positions.last_ledger),
+            updated_at = excluded.updated_at
+    `
+
+	loadTotalSupplyQuery = `SELECT total_supply FROM soroswap_pairs WHERE pair_address = ?`
+
+	setTotalSupplyQuery = `UPDATE soroswap_pairs SET total_supply = ? WHERE pair_address = ?`
+)
+
+func init() {
+	registerHandlerQuery(insertLPTransferQuery, loadLPPositionQuery, upsertLPPositionQuery,
+		loadTotalSupplyQuery, setTotalSupplyQuery)
+	registerRetentionTable(retentionTable{Name: "lp_transfers", Table: "lp_transfers", Column: "transferred_at"})
+	registerLedgerTable(ledgerTable{Table: "lp_transfers"})
+	registerPairTable(pairTable{
+		Name:   "lp_transfers",
+		Count:  "SELECT COUNT(*) FROM lp_transfers WHERE pair_address = ?",
+		Delete: deleteByID("lp_transfers"),
+	})
+	registerPairTable(pairTable{
+		Name:   "lp_positions",
+		Count:  "SELECT COUNT(*) FROM lp_positions WHERE pair_address = ?",
+		Delete: deleteByKey("lp_positions", "provider"),
+	})
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// amount. Amounts are canonicalized beforehand.
+func (e *LPTransferEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.From, &e.To); err != nil {
+		return err
+	}
+	if e.From == "" || e.To == "" || e.Amount == "" {
+		return fmt.Errorf("from, to and amount are required")
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleLPTransfer(ctx context.Context, event LPTransferEvent) error {
+	if event.ContractID == "" {
+		return fmt.Errorf("invalid LP transfer event data: missing contract_id")
+	}
+	transferredAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.ContractID, err)
+	}
+	amount, _ := parseAmount(string(event.Amount))
+	move := func(tx *sql.Tx) error {
+		if err := s.addPosition(ctx, tx, event.ContractID, event.From, event.LedgerSequence, new(big.Int).Neg(amount)); err != nil {
+			return err
+		}
+		return s.addPosition(ctx, tx, event.ContractID, event.To, event.LedgerSequence, amount)
+	}
+
+	if replayTables(ctx) != nil {
+		return s.replayPositions(ctx, "lp_transfer", event.TxHash, event.ContractID, event.EventIndex, move)
+	}
+	return s.storePairEvent(ctx, "lp_transfer", event.ContractID,
+		func(st PairStore) (bool, error) { return st.RecordLPTransfer(ctx, event, transferredAt.Value) },
+		move,
+	)
+}
+
+// replayPositions applies update to lp_positions when a Reprocess run
+// rebuilds it, once per event.
+func (s *SaveSoroswapPairsToSQLite) replayPositions(ctx context.Context, kind, txHash, pair string, index int64, update func(*sql.Tx) error) error {
+	if !replayTables(ctx)["lp_positions"] {
+		return nil
+	}
+	return s.replayEvent(ctx, replayEventKey(kind, txHash, pair, index), pair, update)
+}
+
+// addPosition adds delta LP shares to provider's position in pair. An
+// empty provider is ignored.
+func (s *SaveSoroswapPairsToSQLite) addPosition(ctx context.Context, tx *sql.Tx, pair, provider string, ledger int64, delta *big.Int) error {
+	if provider == "" {
+		return nil
+	}
+	stored := "0"
+	err := s.stmts.queryRow(ctx, tx, loadLPPositionQuery, pair, provider).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load LP position of %s in %s: %v", provider, pair, err)
+	}
+	shares, ok := new(big.Int).SetString(stored, 10)
+	if !ok {
+		return fmt.Errorf("invalid LP position %q of %s in %s", stored, provider, pair)
+	}
+	shares.Add(shares, delta)
+	if _, err := s.stmts.exec(ctx, tx, upsertLPPositionQuery,
+		pair, provider, shares.String(), nullableLedger(ledger), time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to store LP position of %s in %s: %v", provider, pair, err)
+	}
+	return nil
+}
+
+// addTotalSupply adds delta minted (or, negative, burned) LP shares to
+// the pair's total_supply.
+func (s *SaveSoroswapPairsToSQLite) addTotalSupply(ctx context.Context, tx *sql.Tx, pair string, delta *big.Int) error {
+	var stored string
+	if err := s.stmts.queryRow(ctx, tx, loadTotalSupplyQuery, pair).Scan(&stored); err != nil {
+		return fmt.Errorf("failed to load total supply of %s: %v", pair, err)
+	}
+	supply, ok := new(big.Int).SetString(stored, 10)
+	if !ok {
+		return fmt.Errorf("invalid total supply %q of %s", stored, pair)
+	}
+	supply.Add(supply, delta)
+	if _, err := s.stmts.exec(ctx, tx, setTotalSupplyQuery, supply.String(), pair); err != nil {
+		return fmt.Errorf("failed to store total supply of %s: %v", pair, err)
+	}
+	return nil
+}
+
+// LPPosition is a provider's LP share balance in a pair. Shares is a
+// decimal string.
+type LPPosition struct {
+	PairAddress string    `json:"pair_address"`
+	Provider    string    `json:"provider"`
+	Shares      string    `json:"shares"`
+	LastLedger  int64     `json:"last_ledger,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GetPairPositions returns the providers holding LP shares of a pair,
+// largest position first.
+func (s *SaveSoroswapPairsToSQLite) GetPairPositions(ctx context.Context, pair string) ([]LPPosition, error) {
+	pair, err := normalizeAddress(pair)
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	if err := s.stmts.pairExists.QueryRowContext(ctx, pair).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check pair %s: %v", pair, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPairNotFound, pair)
+	}
+	return s.queryPositions(ctx, "pair_address = ?", pair)
+}
+
+// GetProviderPositions returns the pairs an address holds LP shares of,
+// largest position first.
+func (s *SaveSoroswapPairsToSQLite) GetProviderPositions(ctx context.Context, provider string) ([]LPPosition, error) {
+	provider, err := normalizeAddress(provider)
+	if err != nil {
+		return nil, err
+	}
+	return s.queryPositions(ctx, "provider = ?", provider)
+}
+
+// queryPositions reads the non-zero positions matching where. Shares are
+// stored as text, so they are ordered here rather than in SQL.
+func (s *SaveSoroswapPairsToSQLite) queryPositions(ctx context.Context, where, arg string) ([]LPPosition, error) {
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(`
+        SELECT pair_address, provider, shares, last_ledger, updated_at
+        FROM lp_positions WHERE `+where+` AND shares <> '0'`), arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query LP positions: %v", err)
+	}
+	defer rows.Close()
+
+	positions := []LPPosition{}
+	for rows.Next() {
+		var p LPPosition
+		var ledger sql.NullInt64
+		if err := rows.Scan(&p.PairAddress, &p.Provider, &p.Shares, &ledger, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan LP position: %v", err)
+		}
+		p.LastLedger = ledger.Int64
+		positions = append(positions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read LP positions: %v", err)
+	}
+	sort.SliceStable(positions, func(i, j int) bool {
+		return positionShares(positions[i]).Cmp(positionShares(positions[j])) > 0
+	})
+	return positions, nil
+}
+
+func positionShares(p LPPosition) *big.Int {
+	v, ok := new(big.Int).SetString(p.Shares, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return v
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlitePragmas are the per-connection SQLite settings, run on every
+// connection the pool opens since SQLite applies most of them to one
+// connection only.
+type sqlitePragmas struct {
+	journalMode string
+	synchronous string
+	busyTimeout time.Duration
+	// cacheSize and mmapSize are left at SQLite's defaults when nil.
+	cacheSize *int
+	mmapSize  *int
+	// walAutocheckpoint is left at SQLite's default when nil, unless
+	// replication takes over checkpoints.
+	walAutocheckpoint *int
+	// incrementalVacuum sets auto_vacuum = INCREMENTAL, which must come
+	// before the journal mode on a new database.
+	incrementalVacuum bool
+	// key, when set, is the SQLCipher key, given before anything else
+	// reads the file.
+	key *sqliteKey
+	// queryOnly sets query_only on the read pool's connections, after the
+	// settings that may still write.
+	queryOnly bool
+}
+
+var (
+	sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqliteSyncModes    = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// parseSQLitePragmas reads sqlite_journal_mode (default WAL),
+// sqlite_synchronous (default NORMAL), sqlite_busy_timeout (default 5s),
+// sqlite_cache_size, sqlite_mmap_size and sqlite_wal_autocheckpoint.
+func parseSQLitePragmas(cfg *Config) (sqlitePragmas, error) {
+	p := sqlitePragmas{
+		busyTimeout:       cfg.SQLiteBusyTimeout,
+		cacheSize:         cfg.SQLiteCacheSize,
+		mmapSize:          cfg.SQLiteMmapSize,
+		walAutocheckpoint: cfg.SQLiteWALAutoCheckpoint,
+	}
+	var err error
+	if p.journalMode, err = checkChoice("sqlite_journal_mode", cfg.SQLiteJournalMode, sqliteJournalModes); err != nil {
+		return p, err
+	}
+	if p.synchronous, err = checkChoice("sqlite_synchronous", cfg.SQLiteSynchronous, sqliteSyncModes); err != nil {
+		return p, err
+	}
+	if p.busyTimeout < 0 {
+		return p, fmt.Errorf("config sqlite_busy_timeout must not be negative, got %s", p.busyTimeout)
+	}
+	if p.mmapSize != nil && *p.mmapSize < 0 {
+		return p, fmt.Errorf("config sqlite_mmap_size must not be negative, got %d", *p.mmapSize)
+	}
+	return p, nil
+}
+
+// checkChoice checks that the value v of key is one of choices,
+// case-insensitively, and returns it upper case.
+func checkChoice(key, v string, choices []string) (string, error) {
+	v = strings.ToUpper(v)
+	for _, c := range choices {
+		if v == c {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("config %s: expected one of %s, got %q", key, strings.Join(choices, ", "), v)
+}
+
+// statements returns the PRAGMA statements run on each new connection.
+// busy_timeout comes first so the others wait out a locked database.
+func (p sqlitePragmas) statements() []string {
+	stmts := []string{fmt.Sprintf("PRAGMA busy_timeout = %d", p.busyTimeout.Milliseconds())}
+	if p.incrementalVacuum {
+		stmts = append(stmts, "PRAGMA auto_vacuum = INCREMENTAL")
+	}
+	stmts = append(stmts,
+		"PRAGMA journal_mode = "+p.journalMode,
+		"PRAGMA synchronous = "+p.synchronous,
+	)
+	if p.cacheSize != nil {
+		stmts = append(stmts, fmt.Sprintf("PRAGMA cache_size = %d", *p.cacheSize))
+	}
+	if p.mmapSize != nil {
+		stmts = append(stmts, fmt.Sprintf("PRAGMA mmap_size = %d", *p.mmapSize))
+	}
+	if p.walAutocheckpoint != nil {
+		stmts = append(stmts, fmt.Sprintf("PRAGMA wal_autocheckpoint = %d", *p.walAutocheckpoint))
+	}
+	if p.queryOnly {
+		stmts = append(stmts, "PRAGMA query_only = ON")
+	}
+	return stmts
+}
+
+// sqliteConnector opens SQLite connections that run the pragmas first.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+	// keepAlive, outside the pool, keeps an in-memory database alive
+	// until the pool is closed.
+	keepAlive driver.Conn
+}
+
+func newSQLiteConnector(dsn string, pragmas sqlitePragmas) *sqliteConnector {
+	stmts := pragmas.statements()
+	return &sqliteConnector{
+		dsn: dsn,
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				if key := pragmas.key.get(); key != "" {
+					if _, err := conn.Exec(keyPragma("key", key), nil); err != nil {
+						return fmt.Errorf("failed to set SQLCipher key: %v", err)
+					}
+				}
+				for _, stmt := range stmts {
+					if _, err := conn.Exec(stmt, nil); err != nil {
+						return fmt.Errorf("%s: %v", stmt, err)
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (c *sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqliteConnector) Driver() driver.Driver { return c.driver }
+
+// keepOpen opens the connection that keeps an in-memory database alive.
+// SQLite frees one with its last connection, which the pool may close
+// while idle, taking the schema with it.
+func (c *sqliteConnector) keepOpen(ctx context.Context) error {
+	conn, err := c.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	c.keepAlive = conn
+	return nil
+}
+
+// Close is called by sql.DB.Close.
+func (c *sqliteConnector) Close() error {
+	if c.keepAlive == nil {
+		return nil
+	}
+	return c.keepAlive.Close()
+}
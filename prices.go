@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	tokenDecimalsQuery = `SELECT decimals FROM tokens WHERE address = ?`
+
+	pairsByTokenQuery = `
+        SELECT pair_address, token_0, token_1, reserve_0, reserve_1
+        FROM soroswap_pairs WHERE token_0 = ? OR token_1 = ?
+    `
+
+	setPairPricesQuery = `
+        UPDATE soroswap_pairs SET reserve_0_scaled = ?, reserve_1_scaled = ?, price_0_1 = ?, price_1_0 = ?
+        WHERE pair_address = ?
+    `
+)
+
+func init() {
+	registerHandlerQuery(tokenDecimalsQuery)
+}
+
+// reservePrices returns the prices implied by a pair's reserves: p01 is
+// token_1 per token_0 and p10 token_0 per token_1. They are scaled by the
+// tokens' decimals when both are known, and nil when the reserve divided
+// by is zero or a reserve is unparsable.
+func reservePrices(reserve0, reserve1 string, decimals0, decimals1 *int64) (p01, p10 *float64) {
+	r0, ok0 := new(big.Int).SetString(reserve0, 10)
+	r1, ok1 := new(big.Int).SetString(reserve1, 10)
+	if !ok0 || !ok1 {
+		return nil, nil
+	}
+	// scale converts a ratio of raw amounts to a ratio of whole tokens.
+	scale := new(big.Rat).SetInt64(1)
+	if decimals0 != nil && decimals1 != nil {
+		shift := *decimals0 - *decimals1
+		pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(abs64(shift)), nil)
+		if shift >= 0 {
+			scale.SetInt(pow)
+		} else {
+			scale.SetFrac(big.NewInt(1), pow)
+		}
+	}
+	price := func(num, den *big.Int, by *big.Rat) *float64 {
+		if den.Sign() == 0 {
+			return nil
+		}
+		p, _ := new(big.Rat).Mul(new(big.Rat).SetFrac(num, den), by).Float64()
+		return &p
+	}
+	return price(r1, r0, scale), price(r0, r1, new(big.Rat).Inv(scale))
+}
+
+// scaleReserve returns a raw reserve in whole tokens as an exact decimal
+// string, nil when the token's decimals are unknown or the reserve is
+// unparsable.
+func scaleReserve(reserve string, decimals *int64) *string {
+	if decimals == nil {
+		return nil
+	}
+	r, ok := new(big.Int).SetString(reserve, 10)
+	if !ok {
+		return nil
+	}
+	v := new(big.Rat).SetFrac(r, new(big.Int).Exp(big.NewInt(10), big.NewInt(*decimals), nil)).FloatString(int(*decimals))
+	if strings.Contains(v, ".") {
+		v = strings.TrimRight(strings.TrimRight(v, "0"), ".")
+	}
+	return &v
+}
+
+// nullableDecimal maps a missing scaled reserve to NULL.
+func nullableDecimal(d *string) interface{} {
+	if d == nil {
+		return nil
+	}
+	return *d
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// nullablePrice maps a missing price to NULL.
+func nullablePrice(p *float64) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// tokenDecimals returns a token's decimals from the tokens table, nil when
+// they are not known (yet).
+func (s *SaveSoroswapPairsToSQLite) tokenDecimals(ctx context.Context, tx *sql.Tx, token string) (*int64, error) {
+	if token == "" {
+		return nil, nil
+	}
+	var decimals sql.NullInt64
+	err := s.stmts.queryRow(ctx, tx, tokenDecimalsQuery, token).Scan(&decimals)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read decimals of %s: %v", token, err)
+	}
+	if !decimals.Valid {
+		return nil, nil
+	}
+	return &decimals.Int64, nil
+}
+
+// setPrices fills in the prices and scaled reserves of u, the reserves of
+// a pair of token0 and token1.
+func (s *SaveSoroswapPairsToSQLite) setPrices(ctx context.Context, tx *sql.Tx, u *ReserveUpdate, token0, token1 string) error {
+	decimals0, err := s.tokenDecimals(ctx, tx, token0)
+	if err != nil {
+		return err
+	}
+	decimals1, err := s.tokenDecimals(ctx, tx, token1)
+	if err != nil {
+		return err
+	}
+	u.Scaled0, u.Scaled1 = scaleReserve(u.Reserve0, decimals0), scaleReserve(u.Reserve1, decimals1)
+	u.Price01, u.Price10 = reservePrices(u.Reserve0, u.Reserve1, decimals0, decimals1)
+	return nil
+}
+
+// refreshPrices recomputes the prices and scaled reserves of every pair
+// trading token, once its decimals are known.
+func (s *SaveSoroswapPairsToSQLite) refreshPrices(ctx context.Context, token string) error {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := s.stmts.query(ctx, tx, pairsByTokenQuery, token, token)
+		if err != nil {
+			return fmt.Errorf("failed to query pairs of %s: %v", token, err)
+		}
+		type pair struct {
+			update         ReserveUpdate
+			token0, token1 sql.NullString
+		}
+		var pairs []pair
+		for rows.Next() {
+			var p pair
+			if err := rows.Scan(&p.update.PairAddress, &p.token0, &p.token1, &p.update.Reserve0, &p.update.Reserve1); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan pair of %s: %v", token, err)
+			}
+			pairs = append(pairs, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read pairs of %s: %v", token, err)
+		}
+		for _, p := range pairs {
+			u := &p.update
+			if err := s.setPrices(ctx, tx, u, p.token0.String, p.token1.String); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, s.backend.Rebind(setPairPricesQuery),
+				nullableDecimal(u.Scaled0), nullableDecimal(u.Scaled1),
+				nullablePrice(u.Price01), nullablePrice(u.Price10), u.PairAddress); err != nil {
+				return fmt.Errorf("failed to update prices of %s: %v", u.PairAddress, err)
+			}
+		}
+		return nil
+	})
+}
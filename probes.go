@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// probeConfig configures the /healthz and /readyz endpoints.
+type probeConfig struct {
+	// maxEventAge fails /healthz when no event was processed for this
+	// long, counted from Initialize until the first one; 0 disables it.
+	maxEventAge time.Duration
+	// timeout bounds the database checks of one probe.
+	timeout   time.Duration
+	startedAt time.Time
+}
+
+func parseProbeConfig(cfg *Config) probeConfig {
+	return probeConfig{
+		maxEventAge: cfg.HealthMaxEventAge,
+		timeout:     cfg.HealthCheckTimeout,
+		startedAt:   time.Now().UTC(),
+	}
+}
+
+// ProbeResult is the body of a /healthz or /readyz response.
+type ProbeResult struct {
+	OK bool `json:"ok"`
+	// Checks maps each check to "ok" or the reason it failed.
+	Checks map[string]string `json:"checks"`
+	Status Status            `json:"status"`
+}
+
+// Ready verifies the consumer can serve writes: the database answers and
+// can currently be written to.
+func (s *SaveSoroswapPairsToSQLite) Ready(ctx context.Context) ProbeResult {
+	r := ProbeResult{OK: true, Checks: map[string]string{}, Status: s.Status()}
+	ctx, cancel := context.WithTimeout(ctx, s.probes.timeout)
+	defer cancel()
+
+	r.check("database", s.db.PingContext(ctx))
+	r.check("writable", s.backend.CheckWritable(ctx, s.db))
+	return r
+}
+
+// Healthy extends Ready with checks that the consumer is not wedged: it is
+// not failing every commit, and events keep arriving within
+// health_max_event_age.
+func (s *SaveSoroswapPairsToSQLite) Healthy(ctx context.Context) ProbeResult {
+	r := s.Ready(ctx)
+
+	var err error
+	if r.Status.State == HealthFailing {
+		err = fmt.Errorf("%d consecutive failures", r.Status.ConsecutiveFailures)
+	}
+	r.check("failures", err)
+
+	if s.probes.maxEventAge > 0 {
+		last := s.probes.startedAt
+		if r.Status.LastEventAt != nil {
+			last = *r.Status.LastEventAt
+		}
+		err = nil
+		if age := time.Since(last); age > s.probes.maxEventAge {
+			err = fmt.Errorf("no event processed for %s", age.Round(time.Millisecond))
+		}
+		r.check("last_event", err)
+	}
+	return r
+}
+
+func (r *ProbeResult) check(name string, err error) {
+	if err != nil {
+		r.OK = false
+		r.Checks[name] = err.Error()
+		return
+	}
+	r.Checks[name] = "ok"
+}
+
+// probeHandler serves probe as JSON, with status 503 when it fails.
+func probeHandler(probe func(context.Context) ProbeResult) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := probe(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if !result.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
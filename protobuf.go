@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/withObsrvr/flow-consumer-save-soroswappairs-to-sqlite/eventspb"
+)
+
+// eventBatchMessage is the proto parameter of the content type that marks
+// a payload as an eventspb.EventBatch rather than a single eventspb.Event.
+const eventBatchMessage = "soroswap.events.v1.EventBatch"
+
+// The protobuf codec decodes an EventBatch when the content type's proto
+// parameter names it, as in
+// "application/x-protobuf; proto=soroswap.events.v1.EventBatch".
+func init() {
+	registerPayloadCodec(payloadCodec{
+		Name:         "protobuf",
+		ContentTypes: []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"},
+		Decode: func(payload []byte, params map[string]string) ([]byte, error) {
+			return decodeProtobuf(payload, params["proto"] == eventBatchMessage)
+		},
+	})
+}
+
+// decodeProtobuf converts a protobuf payload to the JSON the handlers
+// decode: an Event to one event, an EventBatch to an array of them.
+func decodeProtobuf(payload []byte, isBatch bool) ([]byte, error) {
+	if !isBatch {
+		var e eventspb.Event
+		if err := proto.Unmarshal(payload, &e); err != nil {
+			return nil, err
+		}
+		event, err := eventFromProto(&e)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(event)
+	}
+
+	var batch eventspb.EventBatch
+	if err := proto.Unmarshal(payload, &batch); err != nil {
+		return nil, err
+	}
+	events := make([]interface{}, len(batch.Events))
+	for i, e := range batch.Events {
+		event, err := eventFromProto(e)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %v", i, err)
+		}
+		events[i] = event
+	}
+	return json.Marshal(events)
+}
+
+// eventFromProto returns the event struct of a protobuf event.
+func eventFromProto(e *eventspb.Event) (interface{}, error) {
+	switch ev := e.Event.(type) {
+	case *eventspb.Event_NewPair:
+		p := ev.NewPair
+		return NewPairEvent{
+			Type:           "new_pair",
+			PairAddress:    p.PairAddress,
+			Token0:         p.Token_0,
+			Token1:         p.Token_1,
+			Timestamp:      protoTime(p.Timestamp),
+			LedgerSequence: p.LedgerSequence,
+			Reserve0:       Amount(p.Reserve_0),
+			Reserve1:       Amount(p.Reserve_1),
+			Factory:        p.Factory,
+			NewPairsLength: p.NewPairsLength,
+			TxHash:         p.TxHash,
+			OperationIndex: p.OperationIndex,
+			EventIndex:     p.EventIndex,
+		}, nil
+	case *eventspb.Event_Sync:
+		p := ev.Sync
+		return SyncEvent{
+			Type:           "sync",
+			ContractID:     p.ContractId,
+			NewReserve0:    Amount(p.NewReserve_0),
+			NewReserve1:    Amount(p.NewReserve_1),
+			Timestamp:      protoTime(p.Timestamp),
+			LedgerSequence: p.LedgerSequence,
+			TxHash:         p.TxHash,
+			OperationIndex: p.OperationIndex,
+			EventIndex:     p.EventIndex,
+		}, nil
+	case *eventspb.Event_Swap:
+		p := ev.Swap
+		return SwapEvent{
+			Type:           "swap",
+			ContractID:     p.ContractId,
+			Trader:         p.Trader,
+			Amount0In:      Amount(p.Amount_0In),
+			Amount1In:      Amount(p.Amount_1In),
+			Amount0Out:     Amount(p.Amount_0Out),
+			Amount1Out:     Amount(p.Amount_1Out),
+			Timestamp:      protoTime(p.Timestamp),
+			LedgerSequence: p.LedgerSequence,
+			TxHash:         p.TxHash,
+			OperationIndex: p.OperationIndex,
+			EventIndex:     p.EventIndex,
+		}, nil
+	case *eventspb.Event_Deposit:
+		p := ev.Deposit
+		return DepositEvent{
+			Type:           "deposit",
+			ContractID:     p.ContractId,
+			Provider:       p.Provider,
+			Amount0:        Amount(p.Amount_0),
+			Amount1:        Amount(p.Amount_1),
+			Liquidity:      Amount(p.Liquidity),
+			Timestamp:      protoTime(p.Timestamp),
+			LedgerSequence: p.LedgerSequence,
+			TxHash:         p.TxHash,
+			OperationIndex: p.OperationIndex,
+			EventIndex:     p.EventIndex,
+		}, nil
+	case *eventspb.Event_Withdraw:
+		p := ev.Withdraw
+		return WithdrawEvent{
+			Type:           "withdraw",
+			ContractID:     p.ContractId,
+			Recipient:      p.Recipient,
+			Amount0:        Amount(p.Amount_0),
+			Amount1:        Amount(p.Amount_1),
+			Liquidity:      Amount(p.Liquidity),
+			Timestamp:      protoTime(p.Timestamp),
+			LedgerSequence: p.LedgerSequence,
+			TxHash:         p.TxHash,
+			OperationIndex: p.OperationIndex,
+			EventIndex:     p.EventIndex,
+		}, nil
+	case *eventspb.Event_RouterSwap:
+		p := ev.RouterSwap
+		var amounts []Amount
+		for _, a := range p.Amounts {
+			amounts = append(amounts, Amount(a))
+		}
+		return RouterSwapEvent{
+			Type:           "router_swap",
+			Path:           p.Path,
+			Pairs:          p.Pairs,
+			AmountIn:       Amount(p.AmountIn),
+			AmountOut:      Amount(p.AmountOut),
+			Amounts:        amounts,
+			Timestamp:      protoTime(p.Timestamp),
+			LedgerSequence: p.LedgerSequence,
+			TxHash:         p.TxHash,
+			OperationIndex: p.OperationIndex,
+			EventIndex:     p.EventIndex,
+		}, nil
+	}
+	return nil, fmt.Errorf("protobuf event has no event set")
+}
+
+// protoTime converts an optional timestamp, leaving unset ones zero for
+// zero_timestamp_policy to handle like missing JSON timestamps.
+func protoTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// canonicalQuery is a representative read query whose plan is checked for
+// full table scans. Read APIs register theirs with registerCanonicalQuery.
+type canonicalQuery struct {
+	Name  string
+	Query string
+	Args  []interface{}
+	// AllowScan marks queries that inherently read the whole table, whose
+	// plans are reported but never trigger ANALYZE.
+	AllowScan bool
+}
+
+var canonicalQueries []canonicalQuery
+
+func registerCanonicalQuery(q canonicalQuery) {
+	canonicalQueries = append(canonicalQueries, q)
+}
+
+func init() {
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "pair_lookup",
+		Query: "SELECT " + pairColumns + " FROM soroswap_pairs WHERE pair_address = ?",
+		Args:  []interface{}{""},
+	})
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "token_lookup",
+		Query: "SELECT " + pairColumns + " FROM soroswap_pairs WHERE token_0 = ? AND token_1 = ?",
+		Args:  []interface{}{"", ""},
+	})
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "stale_pairs",
+		Query: "SELECT " + pairColumns + " FROM soroswap_pairs WHERE last_sync_at < ? ORDER BY last_sync_at",
+		Args:  []interface{}{time.Time{}},
+	})
+	registerCanonicalQuery(canonicalQuery{
+		Name: "top_pairs",
+		// Reserves are stored as text, so ranking by them reads every row.
+		Query:     "SELECT " + pairColumns + " FROM soroswap_pairs ORDER BY length(reserve_0) DESC, reserve_0 DESC LIMIT 100",
+		AllowScan: true,
+	})
+}
+
+// QueryPlanCheck is the latest plan check result for one canonical query.
+type QueryPlanCheck struct {
+	Name      string    `json:"name"`
+	Plan      []string  `json:"plan"`
+	FullScan  bool      `json:"full_scan"`
+	Analyzed  bool      `json:"analyzed"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// queryPlanState holds the latest results for Stats.
+type queryPlanState struct {
+	mu      sync.Mutex
+	results []QueryPlanCheck
+}
+
+func (q *queryPlanState) set(results []QueryPlanCheck) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.results = results
+}
+
+func (q *queryPlanState) get() []QueryPlanCheck {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]QueryPlanCheck(nil), q.results...)
+}
+
+// checkQueryPlans explains every canonical query and warns about full table
+// scans. When one is found, statistics are refreshed with ANALYZE and the
+// plans are checked again, so stale statistics fix themselves.
+func (s *SaveSoroswapPairsToSQLite) checkQueryPlans(ctx context.Context) error {
+	results, scans := s.explainCanonicalQueries(ctx)
+	// ANALYZE writes the statistics tables, which dry runs must not.
+	if scans > 0 && !s.dryRun {
+		unlock, err := s.lockWrites(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.ExecContext(ctx, "ANALYZE")
+		unlock()
+		if err != nil {
+			return fmt.Errorf("failed to analyze: %v", err)
+		}
+		results, scans = s.explainCanonicalQueries(ctx)
+		for i := range results {
+			results[i].Analyzed = true
+		}
+		for _, r := range results {
+			if r.FullScan {
+				logger.Warn("Query still scans the full table after ANALYZE", "query", r.Name,
+					"plan", strings.Join(r.Plan, "; "))
+			}
+		}
+	}
+	s.queryPlans.set(results)
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) explainCanonicalQueries(ctx context.Context) ([]QueryPlanCheck, int) {
+	results := make([]QueryPlanCheck, 0, len(canonicalQueries))
+	scans := 0
+	for _, q := range canonicalQueries {
+		r := QueryPlanCheck{Name: q.Name, CheckedAt: time.Now().UTC()}
+		plan, fullScan, err := s.backend.QueryPlan(ctx, s.db, s.backend.Rebind(q.Query), q.Args...)
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Plan = plan
+			r.FullScan = fullScan
+		}
+		if r.FullScan && !q.AllowScan {
+			logger.Warn("Query uses a full table scan", "query", q.Name, "plan", strings.Join(plan, "; "))
+			scans++
+		}
+		results = append(results, r)
+	}
+	return results, scans
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+const insertRawEventQuery = `
+        INSERT INTO raw_events (event_type, ledger_sequence, payload, received_at, event_time, metadata)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `
+
+func init() {
+	registerHandlerQuery(insertRawEventQuery)
+	registerRetentionTable(retentionTable{Name: "raw_events", Table: "raw_events", Column: "received_at"})
+	registerLedgerTable(ledgerTable{Table: "raw_events"})
+}
+
+// archiveRawEvent appends the payload byte-for-byte to raw_events so derived
+// tables can later be rebuilt with Reprocess, along with the event's
+// timestamp, if it has a valid one, and the message metadata. When
+// batching, the row joins the batch outside the event's savepoint, so it is
+// kept even if the event fails.
+func (s *SaveSoroswapPairsToSQLite) archiveRawEvent(ctx context.Context, eventType string, ledger int64, timestamp time.Time, msg pluginapi.Message) error {
+	var eventTime interface{}
+	if !timestamp.IsZero() {
+		eventTime = timestamp
+	}
+	var metadata interface{}
+	if len(msg.Metadata) > 0 {
+		encoded, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata of raw event: %v", err)
+		}
+		metadata = string(encoded)
+	}
+
+	var tx *sql.Tx
+	if s.batching() {
+		var err error
+		if tx, err = s.batchTx(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.stmts.exec(ctx, tx, insertRawEventQuery,
+		eventType, nullableLedger(ledger), msg.Payload, time.Now().UTC(), eventTime, metadata,
+	); err != nil {
+		return fmt.Errorf("failed to archive raw event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	replicationModes = []string{"LITESTREAM", "LITEFS"}
+	checkpointModes  = []string{"PASSIVE", "FULL", "RESTART", "TRUNCATE"}
+)
+
+const (
+	defaultShipInterval     = 10 * time.Second
+	defaultSnapshotInterval = 24 * time.Hour
+	// shipCheckpointFrames is how many shipped WAL frames the shipper lets
+	// accumulate before it truncates the WAL, SQLite's own auto-checkpoint
+	// size.
+	shipCheckpointFrames = 1000
+)
+
+// replicationConfig coordinates the database with continuous replication:
+// an external replicator (Litestream or LiteFS), scheduled checkpoints and
+// the built-in WAL shipping to S3.
+type replicationConfig struct {
+	// mode is "", "LITESTREAM" or "LITEFS".
+	mode string
+	// checkpointInterval schedules checkpoints in checkpointMode.
+	checkpointInterval time.Duration
+	checkpointMode     string
+	// s3Path, an s3://bucket/prefix URI, turns on WAL shipping.
+	s3Path           string
+	s3               s3Settings
+	shipInterval     time.Duration
+	snapshotInterval time.Duration
+}
+
+// parseReplication reads replication, wal_checkpoint_interval,
+// wal_checkpoint_mode and the replication_s3_* keys.
+func parseReplication(cfg *Config) (replicationConfig, error) {
+	rc := replicationConfig{
+		checkpointInterval: cfg.WALCheckpointInterval,
+		s3Path:             cfg.ReplicationS3Path,
+		s3: s3Settings{
+			endpoint:  cfg.ReplicationS3Endpoint,
+			region:    cfg.ReplicationS3Region,
+			insecure:  cfg.ReplicationS3Insecure,
+			accessKey: cfg.ReplicationS3AccessKeyID,
+			secretKey: cfg.ReplicationS3SecretAccessKey,
+		},
+		shipInterval:     cfg.ReplicationS3Interval,
+		snapshotInterval: cfg.ReplicationS3SnapshotInterval,
+	}
+	var err error
+	if cfg.Replication != "" {
+		if rc.mode, err = checkChoice("replication", cfg.Replication, replicationModes); err != nil {
+			return rc, err
+		}
+	}
+	if rc.checkpointMode, err = checkChoice("wal_checkpoint_mode", cfg.WALCheckpointMode, checkpointModes); err != nil {
+		return rc, err
+	}
+	if rc.s3Path == "" {
+		return rc, nil
+	}
+	if _, _, err := parseS3URI(rc.s3Path); err != nil {
+		return rc, fmt.Errorf("config replication_s3_path: %v", err)
+	}
+	if rc.mode != "" {
+		return rc, fmt.Errorf("config replication_s3_path ships the WAL itself and cannot be combined with replication %s", strings.ToLower(rc.mode))
+	}
+	if rc.shipInterval <= 0 || rc.snapshotInterval <= 0 {
+		return rc, fmt.Errorf("config replication_s3_interval and replication_s3_snapshot_interval must be positive")
+	}
+	return rc, nil
+}
+
+// configureReplication checks the replication settings against the
+// backend and applies them before the database is opened.
+func (s *SaveSoroswapPairsToSQLite) configureReplication(b backend) error {
+	rc := s.replication
+	sb, ok := b.(*sqliteBackend)
+	if !ok {
+		if rc.mode != "" || rc.s3Path != "" || rc.checkpointInterval > 0 {
+			return fmt.Errorf("config replication, replication_s3_path and wal_checkpoint_interval require the sqlite3 driver")
+		}
+		return nil
+	}
+	if rc.mode == "" && rc.s3Path == "" {
+		return nil
+	}
+	file, memory := sqliteFilePath(sb.path)
+	if memory {
+		return errors.New("replication requires a database file, not an in-memory database")
+	}
+	if sb.pragmas.journalMode != "WAL" {
+		return fmt.Errorf("replication requires sqlite_journal_mode WAL, not %s", sb.pragmas.journalMode)
+	}
+	if rc.ownsCheckpoints() {
+		if sb.pragmas.walAutocheckpoint == nil {
+			off := 0
+			sb.pragmas.walAutocheckpoint = &off
+		} else if *sb.pragmas.walAutocheckpoint != 0 && rc.s3Path != "" {
+			return fmt.Errorf("config replication_s3_path requires sqlite_wal_autocheckpoint 0, got %d", *sb.pragmas.walAutocheckpoint)
+		}
+	}
+	sb.litefs = rc.mode == "LITEFS"
+	if rc.s3Path != "" {
+		var err error
+		if s.shipper, err = newWALShipper(rc, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownsCheckpoints reports whether something other than SQLite's
+// auto-checkpoint must checkpoint the WAL: the external replicator, or
+// the shipper, which must see every frame before it is overwritten.
+func (rc replicationConfig) ownsCheckpoints() bool {
+	return rc.mode == "LITESTREAM" || rc.s3Path != ""
+}
+
+// WALCheckpointResult reports a WAL checkpoint.
+type WALCheckpointResult struct {
+	// Mode is the mode run, which may be weaker than the one asked for.
+	Mode string `json:"mode"`
+	// Busy is set when readers or writers kept the checkpoint from
+	// finishing.
+	Busy         bool `json:"busy"`
+	Frames       int  `json:"frames"`
+	Checkpointed int  `json:"checkpointed"`
+}
+
+// CheckpointWAL copies the WAL into the database file with PRAGMA
+// wal_checkpoint in mode: PASSIVE, FULL, RESTART or TRUNCATE. Event writes
+// wait while it runs. SQLite only.
+func (s *SaveSoroswapPairsToSQLite) CheckpointWAL(ctx context.Context, mode string) (WALCheckpointResult, error) {
+	if _, ok := s.backend.(*sqliteBackend); !ok {
+		return WALCheckpointResult{}, fmt.Errorf("checkpoints require the sqlite3 driver, not %s", s.backend.Name())
+	}
+	mode = strings.ToUpper(mode)
+	if !containsString(checkpointModes, mode) {
+		return WALCheckpointResult{}, fmt.Errorf("%w: checkpoint mode must be one of %s, got %q", errBadRequest, strings.Join(checkpointModes, ", "), mode)
+	}
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return WALCheckpointResult{}, err
+	}
+	defer unlock()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return WALCheckpointResult{}, fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	return s.checkpointWAL(ctx, conn, mode)
+}
+
+// checkpointWALScheduled runs the wal_checkpoint_interval checkpoint.
+func (s *SaveSoroswapPairsToSQLite) checkpointWALScheduled(ctx context.Context) error {
+	res, err := s.CheckpointWAL(ctx, s.replication.checkpointMode)
+	if err != nil {
+		return err
+	}
+	logger.Debug("WAL checkpointed", "mode", res.Mode, "busy", res.Busy, "frames", res.Frames, "checkpointed", res.Checkpointed)
+	return nil
+}
+
+// checkpointWAL runs a checkpoint on conn with the write lock held. RESTART
+// and TRUNCATE, which make the next writer start the WAL over, become
+// PASSIVE while an external replicator may still be reading the WAL or a
+// backup is running. The shipper ships the frames the checkpoint may
+// discard first.
+func (s *SaveSoroswapPairsToSQLite) checkpointWAL(ctx context.Context, conn *sql.Conn, mode string) (WALCheckpointResult, error) {
+	res := WALCheckpointResult{Mode: mode}
+	if (mode == "RESTART" || mode == "TRUNCATE") && (s.replication.mode != "" || s.backupsRunning.Load() > 0) {
+		res.Mode = "PASSIVE"
+	}
+	if s.shipper != nil {
+		if err := s.shipper.ship(ctx); err != nil && !errors.Is(err, errWALReset) {
+			return res, fmt.Errorf("failed to ship WAL before checkpoint: %v", err)
+		}
+	}
+	var blocked int
+	if err := conn.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+res.Mode+")").Scan(&blocked, &res.Frames, &res.Checkpointed); err != nil {
+		return res, fmt.Errorf("failed to checkpoint: %v", err)
+	}
+	res.Busy = blocked != 0
+	if s.shipper != nil && !res.Busy && (res.Mode == "RESTART" || res.Mode == "TRUNCATE") {
+		s.shipper.expectReset = true
+	}
+	return res, nil
+}
+
+// walCheckpointHandler serves POST /checkpoint?mode=truncate on the admin
+// address; mode defaults to wal_checkpoint_mode.
+func (s *SaveSoroswapPairsToSQLite) walCheckpointHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = s.replication.checkpointMode
+		}
+		res, err := s.CheckpointWAL(r.Context(), mode)
+		writeAPIResult(w, res, err)
+	})
+	return mux
+}
+
+// litefsReplica reports the primary of a LiteFS mount on which this node
+// is a replica. LiteFS keeps a .primary file next to the databases of
+// replicas only.
+func litefsReplica(dir string) (string, bool) {
+	primary, err := os.ReadFile(filepath.Join(dir, ".primary"))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(primary)), true
+}
+
+// SQLite WAL layout, see https://www.sqlite.org/fileformat.html#the_write_ahead_log.
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// errWALReset reports a WAL started over before the shipper read all of
+// it, which leaves a gap only a new snapshot closes.
+var errWALReset = errors.New("WAL was reset before it was shipped")
+
+// walShipper copies the database to S3 in the layout Litestream uses: each
+// generation is a snapshot followed by the WAL frames committed after it,
+// in numbered segments. Every segment holds whole transactions and starts
+// with the WAL header, so it can be applied on its own. All methods run
+// with the write lock held, so no frames are written while it reads.
+type walShipper struct {
+	client           *minio.Client
+	bucket, prefix   string
+	walPath          string
+	snapshotInterval time.Duration
+
+	generation string
+	snapshotAt time.Time
+	// index numbers the generation's next segment.
+	index int
+	// salt identifies the current WAL, whose frames up to offset are
+	// shipped. It is nil before the first WAL is seen.
+	salt   []byte
+	offset int64
+	// frames counts the WAL's frames, shipped or not.
+	frames int64
+	// expectReset is set by checkpoints after which SQLite starts the
+	// WAL over, so a new salt does not mean lost frames.
+	expectReset bool
+}
+
+func newWALShipper(rc replicationConfig, dbFile string) (*walShipper, error) {
+	bucket, prefix, err := parseS3URI(rc.s3Path)
+	if err != nil {
+		return nil, err
+	}
+	client, err := rc.s3.client()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %v", err)
+	}
+	return &walShipper{
+		client:           client,
+		bucket:           bucket,
+		prefix:           prefix,
+		walPath:          dbFile + "-wal",
+		snapshotInterval: rc.snapshotInterval,
+	}, nil
+}
+
+// generationsKey is the key prefix of the replica's generations.
+func generationsKey(prefix string) string {
+	return path.Join(prefix, "generations") + "/"
+}
+
+// shipWAL is the replication_s3_interval job: it ships the WAL frames
+// committed since the last run, starting a new generation with a snapshot
+// when one is due, and truncates the WAL once it has grown.
+func (s *SaveSoroswapPairsToSQLite) shipWAL(ctx context.Context) error {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	w := s.shipper
+	if w.generation == "" || time.Since(w.snapshotAt) >= w.snapshotInterval {
+		return s.snapshotReplica(ctx)
+	}
+	err = w.ship(ctx)
+	if errors.Is(err, errWALReset) {
+		logger.Warn("WAL was reset before it was shipped; starting a new replica generation")
+		return s.snapshotReplica(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if w.frames < shipCheckpointFrames {
+		return nil
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	_, err = s.checkpointWAL(ctx, conn, "TRUNCATE")
+	return err
+}
+
+// snapshotReplica starts a new generation with a snapshot of the
+// database, taken with the online backup API.
+func (s *SaveSoroswapPairsToSQLite) snapshotReplica(ctx context.Context) error {
+	w := s.shipper
+	file, _ := sqliteFilePath(s.dbPath)
+	tmp := file + "-replica-snapshot"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale snapshot %s: %v", tmp, err)
+	}
+	defer os.Remove(tmp)
+	if err := s.backupTo(ctx, tmp); err != nil {
+		return err
+	}
+
+	generation := time.Now().UTC().Format("20060102T150405.000000000Z")
+	key := path.Join(generationsKey(w.prefix), generation, "snapshot.sqlite.gz")
+	if err := w.putGzipFile(ctx, key, tmp); err != nil {
+		return fmt.Errorf("failed to upload snapshot: %v", err)
+	}
+	w.generation, w.snapshotAt, w.index = generation, time.Now(), 0
+	// The snapshot holds every frame committed so far.
+	w.salt, w.expectReset = nil, false
+	header, end, err := w.committed()
+	if err != nil {
+		return err
+	}
+	if header != nil {
+		w.offset = end
+	}
+	logger.Info("Replica snapshot uploaded", "generation", generation, "key", key)
+	return nil
+}
+
+// ship uploads the WAL frames committed since the last call as the next
+// segment.
+func (w *walShipper) ship(ctx context.Context) error {
+	if w.generation == "" {
+		return nil
+	}
+	header, end, err := w.committed()
+	if err != nil || header == nil {
+		return err
+	}
+	if end <= w.offset {
+		return nil
+	}
+	f, err := os.Open(w.walPath)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL: %v", err)
+	}
+	defer f.Close()
+	segment := make([]byte, walHeaderSize+end-w.offset)
+	copy(segment, header)
+	if _, err := f.ReadAt(segment[walHeaderSize:], w.offset); err != nil {
+		return fmt.Errorf("failed to read WAL: %v", err)
+	}
+	key := path.Join(generationsKey(w.prefix), w.generation, "wal", fmt.Sprintf("%08d.wal.gz", w.index))
+	if err := w.putGzip(ctx, key, bytes.NewReader(segment)); err != nil {
+		return fmt.Errorf("failed to upload WAL segment: %v", err)
+	}
+	logger.Debug("WAL segment shipped", "key", key, "bytes", end-w.offset)
+	w.offset = end
+	w.index++
+	return nil
+}
+
+// committed reads the WAL header and returns it with the end of the last
+// committed frame, catching up with a WAL that started over. The header is
+// nil while the WAL is empty.
+func (w *walShipper) committed() ([]byte, int64, error) {
+	f, err := os.Open(w.walPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open WAL: %v", err)
+	}
+	defer f.Close()
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read WAL header: %v", err)
+	}
+	salt := header[16:24]
+	if w.salt != nil && !bytes.Equal(salt, w.salt) {
+		if !w.expectReset {
+			return nil, 0, errWALReset
+		}
+		w.salt = nil
+	}
+	if w.salt == nil {
+		w.salt, w.offset, w.expectReset = append([]byte(nil), salt...), walHeaderSize, false
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read WAL: %v", err)
+	}
+	pageSize := int64(binary.BigEndian.Uint32(header[8:12]))
+	frameSize := walFrameHeaderSize + pageSize
+	end, frames := int64(walHeaderSize), int64(0)
+	frameHeader := make([]byte, walFrameHeaderSize)
+	for pos := int64(walHeaderSize); pos+frameSize <= st.Size(); pos += frameSize {
+		if _, err := f.ReadAt(frameHeader, pos); err != nil {
+			return nil, 0, fmt.Errorf("failed to read WAL: %v", err)
+		}
+		// Frames left over from before the WAL started over carry the
+		// old salt.
+		if !bytes.Equal(frameHeader[8:16], salt) {
+			break
+		}
+		frames++
+		if binary.BigEndian.Uint32(frameHeader[4:8]) != 0 {
+			end = pos + frameSize
+		}
+	}
+	w.frames = frames
+	return header, end, nil
+}
+
+func (w *walShipper) putGzipFile(ctx context.Context, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return w.putGzip(ctx, key, f)
+}
+
+// putGzip uploads r compressed with gzip.
+func (w *walShipper) putGzip(ctx context.Context, key string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, r)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	_, err := w.client.PutObject(ctx, w.bucket, key, pr, -1, minio.PutObjectOptions{ContentType: "application/gzip"})
+	pr.CloseWithError(err)
+	return err
+}
+
+// RestoreReplica rebuilds the database shipped to replication_s3_path at
+// dest, from the latest generation's snapshot and WAL segments. The S3
+// settings are read from config like Initialize does; dest must not
+// exist. An encrypted replica restores to a file that opens with the
+// same sqlite_key.
+func RestoreReplica(ctx context.Context, config map[string]interface{}, dest string) error {
+	config, err := expandConfigEnv(config)
+	if err != nil {
+		return err
+	}
+	cfg, err := decodeConfig(config)
+	if err != nil {
+		return err
+	}
+	rc, err := parseReplication(&cfg)
+	if err != nil {
+		return err
+	}
+	if rc.s3Path == "" {
+		return errors.New("config replication_s3_path is required to restore a replica")
+	}
+	w, err := newWALShipper(rc, "")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	var generations []string
+	for obj := range w.client.ListObjects(ctx, w.bucket, minio.ListObjectsOptions{Prefix: generationsKey(w.prefix)}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list generations: %v", obj.Err)
+		}
+		generations = append(generations, path.Base(strings.TrimSuffix(obj.Key, "/")))
+	}
+	if len(generations) == 0 {
+		return fmt.Errorf("no replica found at %s", rc.s3Path)
+	}
+	sort.Strings(generations)
+	generation := path.Join(generationsKey(w.prefix), generations[len(generations)-1])
+
+	var segments []string
+	for obj := range w.client.ListObjects(ctx, w.bucket, minio.ListObjectsOptions{Prefix: generation + "/wal/", Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list WAL segments: %v", obj.Err)
+		}
+		segments = append(segments, obj.Key)
+	}
+	sort.Strings(segments)
+
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmp, err)
+	}
+	defer os.Remove(tmp)
+	err = w.restore(ctx, f, generation+"/snapshot.sqlite.gz", segments)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("failed to move restored database into place: %v", err)
+	}
+	logger.Info("Replica restored", "generation", path.Base(generation), "segments", len(segments), "path", dest)
+	return nil
+}
+
+// restore writes the snapshot to f and applies the segments on top.
+func (w *walShipper) restore(ctx context.Context, f *os.File, snapshot string, segments []string) error {
+	if err := w.getGzip(ctx, snapshot, func(r io.Reader) error {
+		_, err := io.Copy(f, r)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to download snapshot: %v", err)
+	}
+	for _, key := range segments {
+		if err := w.getGzip(ctx, key, func(r io.Reader) error {
+			segment, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return applyWALSegment(f, segment)
+		}); err != nil {
+			return fmt.Errorf("failed to apply WAL segment %s: %v", key, err)
+		}
+	}
+	return f.Sync()
+}
+
+func (w *walShipper) getGzip(ctx context.Context, key string, fn func(io.Reader) error) error {
+	obj, err := w.client.GetObject(ctx, w.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	zr, err := gzip.NewReader(obj)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return fn(zr)
+}
+
+// applyWALSegment writes the pages of a segment's transactions into the
+// database file, as a checkpoint would.
+func applyWALSegment(f *os.File, segment []byte) error {
+	if len(segment) < walHeaderSize {
+		return errors.New("segment is shorter than a WAL header")
+	}
+	pageSize := int64(binary.BigEndian.Uint32(segment[8:12]))
+	frameSize := walFrameHeaderSize + pageSize
+	if pageSize == 0 || int64(len(segment)-walHeaderSize)%frameSize != 0 {
+		return errors.New("segment does not hold whole frames")
+	}
+	pages := map[uint32][]byte{}
+	for pos := int64(walHeaderSize); pos < int64(len(segment)); pos += frameSize {
+		frame := segment[pos : pos+frameSize]
+		pages[binary.BigEndian.Uint32(frame[0:4])] = frame[walFrameHeaderSize:]
+		dbSize := binary.BigEndian.Uint32(frame[4:8])
+		if dbSize == 0 {
+			continue
+		}
+		for pgno, page := range pages {
+			if _, err := f.WriteAt(page, int64(pgno-1)*pageSize); err != nil {
+				return err
+			}
+		}
+		if err := f.Truncate(int64(dbSize) * pageSize); err != nil {
+			return err
+		}
+		pages = map[uint32][]byte{}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// derivedTable is a table populated entirely from events, which Reprocess
+// can reset and rebuild from raw_events. Reset deletes its rows and Also
+// those of the tables kept alongside it; Ranged tables have a
+// ledger_sequence column and can be rebuilt for a ledger range, others
+// only in full. Events lists the event types the table is built from, by
+// their canonical names, see eventTypeAliases.
+type derivedTable struct {
+	Reset  string
+	Also   []string
+	Ranged bool
+	Events []string
+}
+
+// derivedTables lists the derived tables by name. The pairs table is
+// primary data and is never rewritten by a replay.
+var derivedTables = map[string]derivedTable{
+	"pair_reserve_history": {
+		Reset:  "DELETE FROM pair_reserve_history",
+		Ranged: true,
+		Events: []string{"new_pair", "sync"},
+	},
+	// Candles aggregate whole buckets, so a partial replay would undercount.
+	"pair_candles": {Reset: "DELETE FROM pair_candles", Events: []string{"swap", "sync"}},
+	// Rolling stats catch up on the next volume refresh.
+	"pair_volume_hourly": {Reset: "DELETE FROM pair_volume_hourly", Events: []string{"swap"}},
+	// Fee totals add up every swap and fee mint.
+	"pair_fees": {Reset: "DELETE FROM pair_fees", Events: []string{"swap", "protocol_fee"}},
+	// Positions add up every mint, burn and LP transfer.
+	"lp_positions": {
+		Reset:  "DELETE FROM lp_positions",
+		Events: []string{"deposit", "withdraw", "lp_transfer", "protocol_fee"},
+	},
+}
+
+// eventTypeAliases maps the other names handlers accept for an event type
+// to its canonical one.
+var eventTypeAliases = map[string]string{"mint": "deposit", "burn": "withdraw"}
+
+// canonicalEventType returns the canonical name of an event type.
+func canonicalEventType(t string) string {
+	if c, ok := eventTypeAliases[t]; ok {
+		return c
+	}
+	return t
+}
+
+// builtFrom reports whether every event type t is built from is in types,
+// so replaying only those rebuilds all of t.
+func (t derivedTable) builtFrom(types map[string]bool) bool {
+	for _, e := range t.Events {
+		if !types[e] {
+			return false
+		}
+	}
+	return true
+}
+
+const reprocessPageSize = 500
+
+// ReprocessOptions selects what Reprocess rebuilds.
+type ReprocessOptions struct {
+	// Tables lists the derived tables to rebuild; empty means all.
+	Tables []string
+	// FromLedger and ToLedger restrict the replayed range, inclusive. Rows
+	// of the selected tables inside the range are deleted first.
+	FromLedger int64
+	ToLedger   int64
+	// EventTypes restricts replay to the given event types; empty means all.
+	EventTypes []string
+	// Job names the checkpoint; a later call with the same Job and Resume
+	// set continues where an interrupted run stopped.
+	Job    string
+	Resume bool
+	// Progress, if set, is called after every page of replayed events.
+	Progress func(ReprocessProgress)
+}
+
+// ReprocessProgress reports how far a Reprocess run has got.
+type ReprocessProgress struct {
+	Events int64
+	Failed int64
+	Ledger int64
+}
+
+// replayKey marks a context as belonging to a Reprocess run.
+type replayKey struct{}
+
+// replayTables returns the derived tables being rebuilt when ctx belongs
+// to a Reprocess run, or nil for live processing. Handlers skip writes to
+// primary tables during a replay and only write the selected derived ones.
+func replayTables(ctx context.Context) map[string]bool {
+	tables, _ := ctx.Value(replayKey{}).(map[string]bool)
+	return tables
+}
+
+const (
+	insertReplayedEventQuery = `
+        INSERT INTO reprocess_applied_events (job, event_key) VALUES (?, ?)
+        ON CONFLICT (job, event_key) DO NOTHING
+    `
+
+	clearReplayedEventsQuery = `DELETE FROM reprocess_applied_events WHERE job = ?`
+)
+
+func init() {
+	registerHandlerQuery(insertReplayedEventQuery)
+}
+
+// replayJobKey holds the job name of a Reprocess run.
+type replayJobKey struct{}
+
+// replayEventKey identifies an archived event by the unique key live
+// processing stores it under, or is empty for an event without a
+// transaction hash.
+func replayEventKey(kind, txHash, pair string, index int64) string {
+	if txHash == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:%s:%d", kind, txHash, pair, index)
+}
+
+// replayEvent applies update to the derived tables for an event replayed
+// by Reprocess, in a transaction of its own. The archive keeps duplicate
+// deliveries, which live processing skips by unique key, so an event is
+// applied once per key: keys are recorded in reprocess_applied_events with
+// the writes, so a resumed job also skips the copies replayed before it
+// was interrupted.
+func (s *SaveSoroswapPairsToSQLite) replayEvent(ctx context.Context, key, pair string, update func(*sql.Tx) error) error {
+	return s.inEventTx(ctx, func(tx *sql.Tx) error {
+		if known, err := s.pairKnown(ctx, tx, pair); err != nil || !known {
+			return err
+		}
+		if key != "" {
+			job, _ := ctx.Value(replayJobKey{}).(string)
+			res, err := s.stmts.exec(ctx, tx, insertReplayedEventQuery, job, key)
+			if err != nil {
+				return fmt.Errorf("failed to record replayed event: %v", err)
+			}
+			if n, err := res.RowsAffected(); err != nil || n == 0 {
+				return err
+			}
+		}
+		return update(tx)
+	})
+}
+
+// Reprocess streams archived events from raw_events in ledger order through
+// the normal handlers to rebuild derived tables, without rewriting the pairs
+// table. Live processing is blocked for the duration of the run; a run
+// interrupted by ctx can be resumed from its checkpoint.
+func (s *SaveSoroswapPairsToSQLite) Reprocess(ctx context.Context, opts ReprocessOptions) (ReprocessProgress, error) {
+	var progress ReprocessProgress
+
+	ranged := opts.FromLedger > 0 || opts.ToLedger > 0
+	// A table is reset before the replay, so it must be rebuilt from every
+	// event type it is built from.
+	var eventTypes map[string]bool
+	if len(opts.EventTypes) > 0 {
+		eventTypes = make(map[string]bool)
+		for _, t := range opts.EventTypes {
+			eventTypes[canonicalEventType(t)] = true
+		}
+	}
+	tables := make(map[string]bool)
+	if len(opts.Tables) == 0 {
+		for name, t := range derivedTables {
+			if ranged && !t.Ranged {
+				logger.Info("Reprocess: skipping table, which can only be rebuilt in full", "table", name)
+				continue
+			}
+			if eventTypes != nil && !t.builtFrom(eventTypes) {
+				logger.Info("Reprocess: skipping table, which is also built from other event types",
+					"table", name, "event_types", t.Events)
+				continue
+			}
+			tables[name] = true
+		}
+		if len(tables) == 0 {
+			return progress, fmt.Errorf("no derived table is built from event types %s alone", strings.Join(opts.EventTypes, ", "))
+		}
+	}
+	for _, name := range opts.Tables {
+		t, ok := derivedTables[name]
+		if !ok {
+			return progress, fmt.Errorf("%s is not a derived table", name)
+		}
+		if ranged && !t.Ranged {
+			return progress, fmt.Errorf("%s can only be rebuilt in full, without a ledger range", name)
+		}
+		if eventTypes != nil && !t.builtFrom(eventTypes) {
+			return progress, fmt.Errorf("%s is built from event types %s; replay all of them to rebuild it",
+				name, strings.Join(t.Events, ", "))
+		}
+		tables[name] = true
+	}
+	job := opts.Job
+	if job == "" {
+		job = "default"
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return progress, err
+	}
+	defer unlock()
+
+	var afterLedger, afterID int64
+	if opts.Resume {
+		err := s.db.QueryRowContext(ctx, s.backend.Rebind(
+			"SELECT last_ledger, last_id FROM reprocess_checkpoints WHERE job = ?"), job,
+		).Scan(&afterLedger, &afterID)
+		if err != nil {
+			return progress, fmt.Errorf("no checkpoint to resume for job %s: %v", job, err)
+		}
+		logger.Info("Resuming reprocess job", "job", job, "after_ledger", afterLedger)
+	} else {
+		if err := s.resetDerivedTables(ctx, tables, opts); err != nil {
+			return progress, err
+		}
+		if _, err := s.db.ExecContext(ctx, s.backend.Rebind(clearReplayedEventsQuery), job); err != nil {
+			return progress, fmt.Errorf("failed to clear replayed events of job %s: %v", job, err)
+		}
+	}
+
+	where := []string{"(COALESCE(ledger_sequence, 0), id) > (?, ?)"}
+	var filterArgs []interface{}
+	if opts.FromLedger > 0 {
+		where = append(where, "ledger_sequence >= ?")
+		filterArgs = append(filterArgs, opts.FromLedger)
+	}
+	if opts.ToLedger > 0 {
+		where = append(where, "ledger_sequence <= ?")
+		filterArgs = append(filterArgs, opts.ToLedger)
+	}
+	if eventTypes != nil {
+		// Events are archived under the name they were delivered with.
+		var names []interface{}
+		for t := range eventTypes {
+			names = append(names, t)
+		}
+		for alias, t := range eventTypeAliases {
+			if eventTypes[t] {
+				names = append(names, alias)
+			}
+		}
+		where = append(where, "event_type IN (?"+strings.Repeat(", ?", len(names)-1)+")")
+		filterArgs = append(filterArgs, names...)
+	}
+	query := s.backend.Rebind(fmt.Sprintf(`
+        SELECT id, COALESCE(ledger_sequence, 0), payload, metadata FROM raw_events
+        WHERE %s
+        ORDER BY COALESCE(ledger_sequence, 0), id
+        LIMIT %d
+    `, strings.Join(where, " AND "), reprocessPageSize))
+
+	replayCtx := context.WithValue(ctx, replayKey{}, tables)
+	replayCtx = context.WithValue(replayCtx, replayJobKey{}, job)
+	for {
+		type rawEvent struct {
+			id, ledger int64
+			payload    []byte
+			metadata   sql.NullString
+		}
+		args := append([]interface{}{afterLedger, afterID}, filterArgs...)
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return progress, fmt.Errorf("failed to query raw events: %v", err)
+		}
+		var page []rawEvent
+		for rows.Next() {
+			var e rawEvent
+			if err := rows.Scan(&e.id, &e.ledger, &e.payload, &e.metadata); err != nil {
+				rows.Close()
+				return progress, fmt.Errorf("failed to scan raw event: %v", err)
+			}
+			page = append(page, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return progress, fmt.Errorf("failed to read raw events: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, e := range page {
+			if err := ctx.Err(); err != nil {
+				return progress, err
+			}
+			// Events archived before metadata was kept replay with the
+			// ledger alone.
+			metadata := map[string]interface{}{}
+			if e.metadata.Valid {
+				if err := json.Unmarshal([]byte(e.metadata.String), &metadata); err != nil {
+					logger.Warn("Ignoring metadata of raw event", "id", e.id, "error", err)
+					metadata = map[string]interface{}{}
+				}
+			}
+			metadata["ledger_sequence"] = e.ledger
+			msg := pluginapi.Message{
+				Payload:   e.payload,
+				Metadata:  metadata,
+				Timestamp: time.Now(),
+			}
+			if eventType, err := s.processWithRetry(replayCtx, msg); err != nil {
+				progress.Failed++
+				logger.Warn("Reprocessing raw event failed", "id", e.id, "event_type", eventType, "error", err)
+			}
+			progress.Events++
+			progress.Ledger = e.ledger
+			afterLedger, afterID = e.ledger, e.id
+		}
+
+		// The checkpoint must not get ahead of the events it covers.
+		if err := s.flushBatch(ctx); err != nil {
+			return progress, err
+		}
+		if _, err := s.db.ExecContext(ctx, s.backend.Rebind(`
+            INSERT INTO reprocess_checkpoints (job, last_ledger, last_id, updated_at)
+            VALUES (?, ?, ?, ?)
+            ON CONFLICT (job) DO UPDATE SET
+                last_ledger = excluded.last_ledger,
+                last_id = excluded.last_id,
+                updated_at = excluded.updated_at
+        `), job, afterLedger, afterID, time.Now().UTC()); err != nil {
+			return progress, fmt.Errorf("failed to save reprocess checkpoint: %v", err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		logger.Info("Reprocess job progress", "job", job, "events", progress.Events,
+			"failed", progress.Failed, "ledger", progress.Ledger)
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.backend.Rebind(clearReplayedEventsQuery), job); err != nil {
+		return progress, fmt.Errorf("failed to clear replayed events of job %s: %v", job, err)
+	}
+	logger.Info("Reprocess job complete", "job", job, "events", progress.Events, "failed", progress.Failed)
+	return progress, nil
+}
+
+// resetDerivedTables clears the selected tables, or only their rows inside
+// the requested ledger range.
+func (s *SaveSoroswapPairsToSQLite) resetDerivedTables(ctx context.Context, tables map[string]bool, opts ReprocessOptions) error {
+	for name := range tables {
+		stmt := derivedTables[name].Reset
+		var args []interface{}
+		if opts.FromLedger > 0 || opts.ToLedger > 0 {
+			stmt += " WHERE ledger_sequence BETWEEN ? AND ?"
+			to := opts.ToLedger
+			if to == 0 {
+				to = 1<<63 - 1
+			}
+			args = append(args, opts.FromLedger, to)
+		}
+		stmts, err := onPartitions(ctx, s.db, s.backend, name, stmt)
+		if err != nil {
+			return fmt.Errorf("failed to reset %s: %v", name, err)
+		}
+		for _, stmt := range stmts {
+			if _, err := s.db.ExecContext(ctx, s.backend.Rebind(stmt), args...); err != nil {
+				return fmt.Errorf("failed to reset %s: %v", name, err)
+			}
+		}
+		for _, stmt := range derivedTables[name].Also {
+			if _, err := s.db.ExecContext(ctx, s.backend.Rebind(stmt)); err != nil {
+				return fmt.Errorf("failed to reset %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReprocessEventTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ReprocessOptions
+		wantErr string
+		// wantHistory is the reserve history left after the run.
+		wantHistory int
+	}{
+		{
+			name:        "swap rebuilds only swap tables",
+			opts:        ReprocessOptions{EventTypes: []string{"swap"}},
+			wantHistory: 2,
+		},
+		{
+			name:        "sync and new_pair rebuild the history",
+			opts:        ReprocessOptions{EventTypes: []string{"sync", "new_pair"}, Tables: []string{"pair_reserve_history"}},
+			wantHistory: 2,
+		},
+		{
+			name:    "table built from other event types",
+			opts:    ReprocessOptions{EventTypes: []string{"swap"}, Tables: []string{"pair_reserve_history"}},
+			wantErr: "pair_reserve_history is built from event types new_pair, sync",
+		},
+		{
+			name:    "no table built from the event types alone",
+			opts:    ReprocessOptions{EventTypes: []string{"lp_transfer"}},
+			wantErr: "no derived table is built from event types lp_transfer alone",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, map[string]interface{}{"archive_raw_events": true, "reserve_history": true})
+			process(t, s,
+				newPairEvent(testPair, 10),
+				syncEvent(testPair, "100", "50", 20),
+				swapEvent(testPair, "aa01", 21),
+				syncEvent(testPair, "110", "41", 22),
+			)
+			wantVolume := queryStrings(t, s, "SELECT * FROM pair_volume_hourly")
+
+			_, err := s.Reprocess(context.Background(), tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reprocess = %v, want an error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Reprocess: %v", err)
+			}
+			if n := countRows(t, s, "pair_reserve_history"); n != 2 {
+				t.Errorf("reserve history = %d rows, want 2", n)
+			}
+			if got := queryStrings(t, s, "SELECT * FROM pair_volume_hourly"); !reflect.DeepEqual(got, wantVolume) {
+				t.Errorf("hourly volume = %v, want %v", got, wantVolume)
+			}
+		})
+	}
+}
+
+func TestReprocessResumeSkipsDuplicates(t *testing.T) {
+	s := newTestConsumer(t, map[string]interface{}{"archive_raw_events": true})
+	swap := swapEvent(testPair, "aa01", 20).with(event{"amount_0_in": "1000"})
+	events := []event{newPairEvent(testPair, 10), swap}
+	// A first page of events, then a redelivery of the swap on the next.
+	for i := int64(0); i < reprocessPageSize; i++ {
+		events = append(events, syncEvent(testPair, "100", "50", 100+i))
+	}
+	events = append(events, swap.with(event{"ledger_sequence": 100 + reprocessPageSize}))
+	process(t, s, events...)
+	want := queryStrings(t, s, "SELECT swap_fees_0, swap_fees_1 FROM pair_fees")
+	wantVolume := queryStrings(t, s, "SELECT swap_count FROM pair_volume_hourly")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := ReprocessOptions{Tables: []string{"pair_fees", "pair_volume_hourly"}, Job: "fees", Progress: func(ReprocessProgress) { cancel() }}
+	if _, err := s.Reprocess(ctx, opts); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("Reprocess = %v, want it interrupted after the first page", err)
+	}
+	opts.Resume, opts.Progress = true, nil
+	if _, err := s.Reprocess(context.Background(), opts); err != nil {
+		t.Fatalf("Reprocess resume: %v", err)
+	}
+
+	if got := queryStrings(t, s, "SELECT swap_fees_0, swap_fees_1 FROM pair_fees"); !reflect.DeepEqual(got, want) {
+		t.Errorf("swap fees = %v, want %v", got, want)
+	}
+	if got := queryStrings(t, s, "SELECT swap_count FROM pair_volume_hourly"); !reflect.DeepEqual(got, wantVolume) {
+		t.Errorf("swap count = %v, want %v", got, wantVolume)
+	}
+	if n := countRows(t, s, "reprocess_applied_events"); n != 0 {
+		t.Errorf("applied events = %d rows after the job completed, want 0", n)
+	}
+}
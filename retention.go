@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionTable is a table whose old rows can be pruned, configured by
+// Name under retention. Files owning such tables register them.
+type retentionTable struct {
+	Name  string
+	Table string
+	// Column is the time a row is aged by. Rows where it is NULL are kept.
+	Column string
+	// Key identifies rows in the delete; "id" when empty.
+	Key string
+	// Prune replaces the default chunked delete, e.g. to remove dependent
+	// rows first. It deletes up to chunk rows older than cutoff inside tx.
+	Prune func(ctx context.Context, tx *sql.Tx, b backend, cutoff time.Time, chunk int) (int64, error)
+}
+
+var retentionTables = map[string]retentionTable{}
+
+func registerRetentionTable(t retentionTable) {
+	retentionTables[t.Name] = t
+}
+
+// prune deletes one chunk of rows older than cutoff.
+func (t retentionTable) prune(ctx context.Context, tx *sql.Tx, b backend, cutoff time.Time, chunk int) (int64, error) {
+	if t.Prune != nil {
+		return t.Prune(ctx, tx, b, cutoff, chunk)
+	}
+	key := t.Key
+	if key == "" {
+		key = "id"
+	}
+	stmts, err := onPartitions(ctx, tx, b, t.Table, fmt.Sprintf(`DELETE FROM %[1]s WHERE (%[2]s) IN (
+            SELECT %[2]s FROM %[1]s WHERE %[3]s < ? LIMIT ?)`, t.Table, key, t.Column))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, stmt := range stmts {
+		result, err := tx.ExecContext(ctx, b.Rebind(stmt), cutoff, chunk-int(total))
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if total += n; total >= int64(chunk) {
+			break
+		}
+	}
+	return total, nil
+}
+
+// retentionPolicy is the parsed retention configuration.
+type retentionPolicy struct {
+	// keep is how long rows are kept, by retention table name.
+	keep     map[string]time.Duration
+	interval time.Duration
+	// vacuum reclaims the space of pruned rows, at most every
+	// vacuumInterval.
+	vacuum         bool
+	vacuumInterval time.Duration
+	lastVacuum     time.Time
+}
+
+// parseRetention reads the retention map, e.g.
+//
+//	retention:
+//	  reserve_history: 90d
+//	  swaps: 30d
+//
+// reserve_history_retention and reserve_history_prune_interval are still
+// accepted for the reserve history.
+func parseRetention(cfg *Config) (retentionPolicy, error) {
+	policy := retentionPolicy{
+		keep:           make(map[string]time.Duration),
+		interval:       cfg.ReserveHistoryPruneInterval,
+		vacuum:         cfg.RetentionVacuum,
+		vacuumInterval: cfg.RetentionVacuumInterval,
+	}
+	for name, value := range cfg.Retention {
+		if _, ok := retentionTables[name]; !ok {
+			return policy, fmt.Errorf("config retention.%s: unknown table, expected one of %s", name, strings.Join(retentionTableNames(), ", "))
+		}
+		d, err := parseRetentionDuration(value)
+		if err != nil || d <= 0 {
+			return policy, fmt.Errorf("config retention.%s: expected a positive duration such as 90d or 720h, got %q", name, value)
+		}
+		policy.keep[name] = d
+	}
+	if _, ok := policy.keep["reserve_history"]; !ok && cfg.ReserveHistoryRetention > 0 {
+		policy.keep["reserve_history"] = cfg.ReserveHistoryRetention
+	}
+	if cfg.RetentionPruneInterval != nil {
+		policy.interval = *cfg.RetentionPruneInterval
+	}
+	return policy, nil
+}
+
+// parseRetentionDuration parses a Go duration, or a whole number of days
+// such as "90d".
+func parseRetentionDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func retentionTableNames() []string {
+	names := make([]string, 0, len(retentionTables))
+	for name := range retentionTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pruneRetention deletes rows past their retention from every configured
+// table, in chunks so event writes are held up only briefly, then vacuums
+// if enabled and anything was deleted.
+func (s *SaveSoroswapPairsToSQLite) pruneRetention(ctx context.Context) error {
+	if len(s.retention.keep) == 0 || s.dryRun {
+		return nil
+	}
+	var pruned []string
+	for _, name := range retentionTableNames() {
+		keep, ok := s.retention.keep[name]
+		if !ok {
+			continue
+		}
+		t := retentionTables[name]
+		cutoff := time.Now().UTC().Add(-keep)
+		// Whole months past the cutoff go with their partition.
+		dropped, err := s.dropPartitionsBefore(ctx, t.Table, cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to prune %s: %v", name, err)
+		}
+		if dropped > 0 {
+			pruned = append(pruned, t.Table)
+		}
+		var total int64
+		for {
+			n, err := s.pruneChunk(ctx, t, cutoff)
+			if err != nil {
+				return fmt.Errorf("failed to prune %s: %v", name, err)
+			}
+			total += n
+			if n < defaultDeleteChunkSize {
+				break
+			}
+		}
+		if total > 0 {
+			logger.Info("Pruned rows", "table", name, "rows", total, "older_than", cutoff.Format(time.RFC3339))
+			if dropped == 0 {
+				pruned = append(pruned, t.Table)
+			}
+		}
+	}
+
+	if s.retention.vacuum && len(pruned) > 0 && time.Since(s.retention.lastVacuum) >= s.retention.vacuumInterval {
+		unlock, err := s.lockWrites(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		start := time.Now()
+		if err := s.backend.Vacuum(ctx, s.db, pruned...); err != nil {
+			return fmt.Errorf("failed to vacuum after pruning: %v", err)
+		}
+		s.retention.lastVacuum = time.Now()
+		logger.Info("Vacuumed after pruning", "backend", s.backend.Name(), "duration", time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) pruneChunk(ctx context.Context, t retentionTable, cutoff time.Time) (int64, error) {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+	n, err := t.prune(ctx, tx, s.backend, cutoff, defaultDeleteChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// ledgerTable is a table whose rows carry the ledger_sequence they came
+// from, so Rollback can remove those above a ledger. Files owning such
+// tables register them. Rows without a ledger are kept.
+type ledgerTable struct {
+	Table string
+	// Delete replaces the default delete, e.g. to remove dependent rows
+	// first. It deletes the rows above ledger inside tx.
+	Delete func(ctx context.Context, tx *sql.Tx, b backend, ledger int64) (int64, error)
+}
+
+var ledgerTables []ledgerTable
+
+func registerLedgerTable(t ledgerTable) {
+	ledgerTables = append(ledgerTables, t)
+}
+
+// delete deletes the table's rows above ledger.
+func (t ledgerTable) delete(ctx context.Context, tx *sql.Tx, b backend, ledger int64) (int64, error) {
+	if t.Delete != nil {
+		return t.Delete(ctx, tx, b, ledger)
+	}
+	stmts, err := onPartitions(ctx, tx, b, t.Table, "DELETE FROM "+t.Table+" WHERE ledger_sequence > ?")
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, stmt := range stmts {
+		result, err := tx.ExecContext(ctx, b.Rebind(stmt), ledger)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// lpReversals undo the LP share changes of the mints, burns and transfers
+// above a ledger. Each query returns the pair, the account and the amount
+// of a row; the amount is added to the pair's total_supply times supply
+// and to the account's position times position.
+var lpReversals = []struct {
+	query            string
+	supply, position int64
+}{
+	{"SELECT pair_address, provider, liquidity FROM soroswap_deposits WHERE ledger_sequence > ?", -1, -1},
+	// Burned shares were taken from the pair's own position.
+	{"SELECT pair_address, pair_address, liquidity FROM soroswap_withdrawals WHERE ledger_sequence > ?", 1, 1},
+	{"SELECT pair_address, fee_to, liquidity FROM soroswap_protocol_fees WHERE ledger_sequence > ?", -1, -1},
+	{"SELECT pair_address, from_address, amount FROM lp_transfers WHERE ledger_sequence > ?", 0, 1},
+	{"SELECT pair_address, to_address, amount FROM lp_transfers WHERE ledger_sequence > ?", 0, -1},
+}
+
+const (
+	pairsCreatedAboveQuery = `SELECT pair_address FROM soroswap_pairs WHERE created_at_ledger > ? ORDER BY pair_address`
+
+	pairsSyncedAboveQuery = `
+        SELECT pair_address, token_0, token_1 FROM soroswap_pairs
+        WHERE last_sync_ledger > ? AND (created_at_ledger IS NULL OR created_at_ledger <= ?)
+        ORDER BY pair_address
+    `
+
+	lastHistoryAtQuery = `
+        SELECT reserve_0, reserve_1, ledger_sequence, COALESCE(tx_hash, ''), operation_index, event_index, synced_at
+        FROM pair_reserve_history
+        WHERE pair_address = ? AND ledger_sequence <= ?
+        ORDER BY ledger_sequence DESC, id DESC LIMIT 1
+    `
+
+	restoreReservesQuery = `
+        UPDATE soroswap_pairs SET
+            reserve_0 = ?, reserve_1 = ?, reserve_0_scaled = ?, reserve_1_scaled = ?,
+            price_0_1 = ?, price_1_0 = ?,
+            last_sync_at = ?, last_sync_ledger = ?,
+            last_sync_tx_hash = ?, last_sync_operation_index = ?, last_sync_event_index = ?
+        WHERE pair_address = ?
+    `
+
+	// A pair without a sync on record keeps its reserves, and takes the
+	// next sync whatever its ledger.
+	resetSyncLedgerQuery = `
+        UPDATE soroswap_pairs SET
+            last_sync_ledger = NULL, last_sync_tx_hash = NULL, last_sync_operation_index = 0, last_sync_event_index = 0
+        WHERE pair_address = ?
+    `
+)
+
+// RollbackOptions controls Rollback. RequestedBy and Reason are recorded
+// in the pair_deletions audit rows of the pairs it removes.
+type RollbackOptions struct {
+	RequestedBy string
+	Reason      string
+	// DryRun reports what would change, rolling the transaction back.
+	DryRun bool
+}
+
+// RollbackResult reports what a Rollback changed, or would have.
+type RollbackResult struct {
+	Ledger int64 `json:"ledger"`
+	DryRun bool  `json:"dry_run"`
+	// Rows counts the rows deleted per table.
+	Rows map[string]int64 `json:"rows"`
+	// DeletedPairs were created above the ledger.
+	DeletedPairs []string `json:"deleted_pairs"`
+	// RestoredPairs got back the reserves of their last sync at or below
+	// the ledger. ResetPairs had none in the reserve history; they keep
+	// their reserves until the next sync. Without reserve_history, a
+	// rollback reverting syncs fails instead.
+	RestoredPairs []string `json:"restored_pairs"`
+	ResetPairs    []string `json:"reset_pairs"`
+	// Rebuilt lists the derived tables rebuilt from raw_events, Stale
+	// those that could not be and still count the rolled back events.
+	Rebuilt []string `json:"rebuilt,omitempty"`
+	Stale   []string `json:"stale,omitempty"`
+}
+
+// Rollback reverts everything stored from ledgers above ledger, for a
+// chain reorganization or a source that delivered a bad range. Rows above
+// the ledger are deleted, pairs created above it are removed, the others
+// get their reserves and LP supply back, and the checkpoint moves back to
+// ledger so the range is consumed again. It all happens in one
+// transaction with live processing blocked. Aggregates without a ledger
+// column are then rebuilt from raw_events when they are archived in full.
+func (s *SaveSoroswapPairsToSQLite) Rollback(ctx context.Context, ledger int64, opts RollbackOptions) (RollbackResult, error) {
+	res := RollbackResult{
+		Ledger: ledger,
+		DryRun: opts.DryRun || s.dryRun,
+		Rows:   make(map[string]int64),
+	}
+	if ledger <= 0 {
+		return res, fmt.Errorf("rollback ledger must be positive, got %d", ledger)
+	}
+	if opts.RequestedBy == "" {
+		opts.RequestedBy = "rollback"
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return res, err
+	}
+	err = s.rollbackLedgers(ctx, ledger, opts, &res)
+	if err == nil && !res.DryRun {
+		s.checkpoint.rewind(ledger)
+		err = s.flushCheckpoint(ctx)
+	}
+	unlock()
+	if err != nil {
+		return res, err
+	}
+	if res.DryRun {
+		logger.Info("Rollback dry run", "ledger", ledger, "rows", res.Rows,
+			"deleted_pairs", len(res.DeletedPairs), "restored_pairs", len(res.RestoredPairs))
+		return res, nil
+	}
+	logger.Info("Rolled back ledgers", "ledger", ledger, "requested_by", opts.RequestedBy, "reason", opts.Reason,
+		"rows", res.Rows, "deleted_pairs", len(res.DeletedPairs), "restored_pairs", len(res.RestoredPairs),
+		"reset_pairs", len(res.ResetPairs))
+
+	return res, s.rebuildAfterRollback(ctx, &res)
+}
+
+// rollbackLedgers makes the changes of Rollback in one transaction, which
+// a dry run rolls back.
+func (s *SaveSoroswapPairsToSQLite) rollbackLedgers(ctx context.Context, ledger int64, opts RollbackOptions, res *RollbackResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	if err := s.reverseLPShares(ctx, tx, ledger); err != nil {
+		return err
+	}
+	if err := s.restorePairs(ctx, tx, ledger, res); err != nil {
+		return err
+	}
+	if err := s.deletePairsAbove(ctx, tx, ledger, opts, res); err != nil {
+		return err
+	}
+	for _, t := range ledgerTables {
+		n, err := t.delete(ctx, tx, s.backend, ledger)
+		if err != nil {
+			return fmt.Errorf("failed to roll back %s: %v", t.Table, err)
+		}
+		res.Rows[t.Table] += n
+	}
+	if res.DryRun {
+		return nil
+	}
+	if err := s.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit rollback: %v", err)
+	}
+	return nil
+}
+
+// reverseLPShares takes the LP share changes above ledger back out of the
+// pairs' total_supply and the providers' positions.
+func (s *SaveSoroswapPairsToSQLite) reverseLPShares(ctx context.Context, tx *sql.Tx, ledger int64) error {
+	for _, r := range lpReversals {
+		type change struct {
+			pair, account string
+			amount        *big.Int
+		}
+		rows, err := tx.QueryContext(ctx, s.backend.Rebind(r.query), ledger)
+		if err != nil {
+			return fmt.Errorf("failed to query LP share changes: %v", err)
+		}
+		var changes []change
+		for rows.Next() {
+			var c change
+			var amount string
+			if err := rows.Scan(&c.pair, &c.account, &amount); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan LP share change: %v", err)
+			}
+			c.amount, _ = parseAmount(amount)
+			changes = append(changes, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read LP share changes: %v", err)
+		}
+
+		for _, c := range changes {
+			if c.amount == nil {
+				continue
+			}
+			if r.supply != 0 {
+				delta := new(big.Int).Mul(c.amount, big.NewInt(r.supply))
+				if err := s.addTotalSupply(ctx, tx, c.pair, delta); err != nil {
+					return err
+				}
+			}
+			delta := new(big.Int).Mul(c.amount, big.NewInt(r.position))
+			if err := s.addPosition(ctx, tx, c.pair, c.account, 0, delta); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restorePairs puts back the reserves of the pairs synced above ledger
+// from their reserve history. Without reserve_history there is nothing to
+// restore them from, so the rollback is refused rather than leave them with
+// the reserves of reverted ledgers.
+func (s *SaveSoroswapPairsToSQLite) restorePairs(ctx context.Context, tx *sql.Tx, ledger int64, res *RollbackResult) error {
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind(pairsSyncedAboveQuery), ledger, ledger)
+	if err != nil {
+		return fmt.Errorf("failed to query pairs synced above ledger %d: %v", ledger, err)
+	}
+	type pair struct {
+		address        string
+		token0, token1 sql.NullString
+	}
+	var pairs []pair
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.address, &p.token0, &p.token1); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pair: %v", err)
+		}
+		pairs = append(pairs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read pairs: %v", err)
+	}
+	if len(pairs) > 0 && !s.reserveHistory {
+		return fmt.Errorf("%d pairs were synced above ledger %d and their earlier reserves are unknown without reserve_history",
+			len(pairs), ledger)
+	}
+
+	for _, p := range pairs {
+		u := ReserveUpdate{PairAddress: p.address}
+		var syncedAt time.Time
+		err := tx.QueryRowContext(ctx, s.backend.Rebind(lastHistoryAtQuery), p.address, ledger).Scan(
+			&u.Reserve0, &u.Reserve1, &u.Ledger, &u.Event.TxHash, &u.Event.OperationIndex, &u.Event.EventIndex, &syncedAt)
+		if err == sql.ErrNoRows {
+			if _, err := tx.ExecContext(ctx, s.backend.Rebind(resetSyncLedgerQuery), p.address); err != nil {
+				return fmt.Errorf("failed to reset sync ledger of %s: %v", p.address, err)
+			}
+			res.ResetPairs = append(res.ResetPairs, p.address)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load reserve history of %s: %v", p.address, err)
+		}
+		if err := s.setPrices(ctx, tx, &u, p.token0.String, p.token1.String); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, s.backend.Rebind(restoreReservesQuery),
+			u.Reserve0, u.Reserve1, nullableDecimal(u.Scaled0), nullableDecimal(u.Scaled1), nullablePrice(u.Price01), nullablePrice(u.Price10), syncedAt, u.Ledger,
+			nullableString(u.Event.TxHash), u.Event.OperationIndex, u.Event.EventIndex, p.address,
+		); err != nil {
+			return fmt.Errorf("failed to restore reserves of %s: %v", p.address, err)
+		}
+		if err := s.markActive(ctx, tx, p.address, u.Reserve0, u.Reserve1); err != nil {
+			return err
+		}
+		res.RestoredPairs = append(res.RestoredPairs, p.address)
+	}
+	return nil
+}
+
+// deletePairsAbove removes the pairs created above ledger with all their
+// rows, recording each in pair_deletions.
+func (s *SaveSoroswapPairsToSQLite) deletePairsAbove(ctx context.Context, tx *sql.Tx, ledger int64, opts RollbackOptions, res *RollbackResult) error {
+	rows, err := tx.QueryContext(ctx, s.backend.Rebind(pairsCreatedAboveQuery), ledger)
+	if err != nil {
+		return fmt.Errorf("failed to query pairs created above ledger %d: %v", ledger, err)
+	}
+	var pairs []string
+	for rows.Next() {
+		var pair string
+		if err := rows.Scan(&pair); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pair: %v", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read pairs: %v", err)
+	}
+
+	reason := opts.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("created above rolled back ledger %d", ledger)
+	}
+	for _, pair := range pairs {
+		deletion := DeleteResult{PairAddress: pair, DryRun: res.DryRun, Rows: make(map[string]int64)}
+		for _, t := range pairTables {
+			// Without a chunk limit, one call deletes every row.
+			n, err := t.Delete(ctx, tx, s.backend, pair, 1<<31-1)
+			if err != nil {
+				return fmt.Errorf("failed to delete %s rows of %s: %v", t.Name, pair, err)
+			}
+			deletion.Rows[t.Name] = n
+			res.Rows[t.Name] += n
+		}
+		if _, err := tx.ExecContext(ctx, s.backend.Rebind(
+			"UPDATE router_swap_hops SET pair_ref = NULL WHERE pair_ref = ?"), pair); err != nil {
+			return fmt.Errorf("failed to unlink router hops: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, s.backend.Rebind("DELETE FROM soroswap_pairs WHERE pair_address = ?"), pair); err != nil {
+			return fmt.Errorf("failed to delete pair %s: %v", pair, err)
+		}
+		deletion.Rows["soroswap_pairs"] = 1
+		res.Rows["soroswap_pairs"]++
+		if err := s.insertDeletion(ctx, tx, deletion, DeleteOptions{RequestedBy: opts.RequestedBy, Reason: reason}); err != nil {
+			return err
+		}
+		res.DeletedPairs = append(res.DeletedPairs, pair)
+	}
+	return nil
+}
+
+// rebuildAfterRollback rebuilds the non-empty derived tables that have no
+// ledger column from raw_events. That needs the full archive, so it is
+// skipped, and the tables reported stale, when raw events are not
+// archived or are pruned.
+func (s *SaveSoroswapPairsToSQLite) rebuildAfterRollback(ctx context.Context, res *RollbackResult) error {
+	var tables []string
+	for name, t := range derivedTables {
+		// Positions were corrected along with the supply.
+		if t.Ranged || name == "lp_positions" {
+			continue
+		}
+		var n int
+		err := s.db.QueryRowContext(ctx, s.backend.Rebind("SELECT 1 FROM "+name+" LIMIT 1")).Scan(&n)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %v", name, err)
+		}
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	if len(tables) == 0 {
+		return nil
+	}
+	if _, pruned := s.retention.keep["raw_events"]; !s.archiveRawEvents || pruned {
+		res.Stale = tables
+		logger.Warn("Derived tables still include rolled back events; they need archive_raw_events without raw_events retention to be rebuilt",
+			"tables", tables)
+		return nil
+	}
+	if _, err := s.Reprocess(ctx, ReprocessOptions{Tables: tables, Job: "rollback"}); err != nil {
+		res.Stale = tables
+		return fmt.Errorf("failed to rebuild derived tables after rollback: %v", err)
+	}
+	res.Rebuilt = tables
+	return nil
+}
+
+// rollbackRequest reads a rollback control message:
+//
+//	{"type": "rollback", "ledger_sequence": 51234567, "reason": "reorg"}
+func rollbackRequest(msg pluginapi.Message) (int64, RollbackOptions, bool) {
+	payload, ok := msg.Payload.([]byte)
+	if !ok {
+		return 0, RollbackOptions{}, false
+	}
+	var req struct {
+		Type           string `json:"type"`
+		LedgerSequence int64  `json:"ledger_sequence"`
+		Reason         string `json:"reason"`
+		DryRun         bool   `json:"dry_run"`
+	}
+	if json.Unmarshal(payload, &req) != nil || req.Type != "rollback" {
+		return 0, RollbackOptions{}, false
+	}
+	return req.LedgerSequence, RollbackOptions{RequestedBy: "control message", Reason: req.Reason, DryRun: req.DryRun}, true
+}
+
+// rollbackHandler serves POST /rollback?ledger=N on the admin address,
+// with dry_run=true to only report what would change.
+func (s *SaveSoroswapPairsToSQLite) rollbackHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /rollback", func(w http.ResponseWriter, r *http.Request) {
+		q := apiQuery{values: r.URL.Query()}
+		ledger := q.int("ledger")
+		var dryRun bool
+		q.parse("dry_run", func(v string) (err error) {
+			dryRun, err = strconv.ParseBool(v)
+			return err
+		})
+		if q.err == nil && ledger <= 0 {
+			q.err = fmt.Errorf("%w: ledger must be a positive ledger sequence", errBadRequest)
+		}
+		if q.err != nil {
+			writeAPIError(w, q.err)
+			return
+		}
+		res, err := s.Rollback(r.Context(), ledger, RollbackOptions{
+			RequestedBy: "admin api",
+			Reason:      q.values.Get("reason"),
+			DryRun:      dryRun,
+		})
+		writeAPIResult(w, res, err)
+	})
+	return mux
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]interface{}
+		wantRebuilt bool
+		wantErr     string
+	}{
+		{"archived", map[string]interface{}{"reserve_history": true, "archive_raw_events": true}, true, ""},
+		{"archived with table_prefix", map[string]interface{}{"reserve_history": true, "archive_raw_events": true, "table_prefix": "mn_"}, true, ""},
+		{"not archived with table_prefix", map[string]interface{}{"reserve_history": true, "table_prefix": "mn_"}, false, ""},
+		{"without reserve history", map[string]interface{}{"archive_raw_events": true}, false,
+			"1 pairs were synced above ledger 25 and their earlier reserves are unknown without reserve_history"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, tt.config)
+			process(t, s,
+				newPairEvent(testPair, 10),
+				syncEvent(testPair, "100", "50", 20),
+				swapEvent(testPair, "aa01", 21),
+				syncEvent(testPair, "110", "41", 30),
+				swapEvent(testPair, "aa02", 31),
+				newPairEvent(testPair2, 32),
+			)
+
+			res, err := s.Rollback(context.Background(), 25, RollbackOptions{Reason: "test"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Rollback = %v, want an error containing %q", err, tt.wantErr)
+				}
+				// Nothing was rolled back.
+				if p := getPair(t, s, testPair); p.Reserve0 != "110" || p.LastSyncLedger == nil || *p.LastSyncLedger != 30 {
+					t.Errorf("reserve_0 = %s at %v, want 110 at 30 kept", p.Reserve0, p.LastSyncLedger)
+				}
+				if n := countRows(t, s, "soroswap_swaps"); n != 2 {
+					t.Errorf("swaps = %d, want 2 kept", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rollback: %v", err)
+			}
+			if !reflect.DeepEqual(res.DeletedPairs, []string{testPair2}) || !reflect.DeepEqual(res.RestoredPairs, []string{testPair}) {
+				t.Errorf("deleted %v, restored %v; want [%s], [%s]", res.DeletedPairs, res.RestoredPairs, testPair2, testPair)
+			}
+			if p := getPair(t, s, testPair); p.Reserve0 != "100" || p.LastSyncLedger == nil || *p.LastSyncLedger != 20 {
+				t.Errorf("reserve_0 = %s at %v, want 100 at 20", p.Reserve0, p.LastSyncLedger)
+			}
+			if n := countRows(t, s, "soroswap_swaps"); n != 1 {
+				t.Errorf("swaps = %d, want 1", n)
+			}
+			derived := res.Stale
+			if tt.wantRebuilt {
+				derived = res.Rebuilt
+			}
+			if len(derived) == 0 || len(res.Rebuilt)+len(res.Stale) != len(derived) {
+				t.Errorf("rebuilt %v, stale %v; want the derived tables %s", res.Rebuilt, res.Stale,
+					map[bool]string{true: "rebuilt", false: "stale"}[tt.wantRebuilt])
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// rollupPeriod is one of the per-pair stats rollups. Traders seen in a
+// bucket are kept in pair_stats_traders under the period's name, so each
+// is counted once.
+type rollupPeriod struct {
+	name     string
+	table    string
+	duration time.Duration
+}
+
+var rollupPeriods = []rollupPeriod{
+	{name: "1h", table: "pair_stats_hourly", duration: time.Hour},
+	{name: "1d", table: "pair_stats_daily", duration: 24 * time.Hour},
+}
+
+func (p rollupPeriod) loadQuery() string {
+	return `
+        SELECT volume_0, volume_1, swap_count, unique_traders, reserve_0, reserve_1, close_ledger
+        FROM ` + p.table + ` WHERE pair_address = ? AND bucket_start = ?
+    `
+}
+
+func (p rollupPeriod) upsertQuery() string {
+	return `
+        INSERT INTO ` + p.table + ` (
+            pair_address, bucket_start, volume_0, volume_1, swap_count, unique_traders,
+            reserve_0, reserve_1, close_ledger
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address, bucket_start) DO UPDATE SET
+            volume_0 = excluded.volume_0,
+            volume_1 = excluded.volume_1,
+            swap_count = excluded.swap_count,
+            unique_traders = excluded.unique_traders,
+            reserve_0 = excluded.reserve_0,
+            reserve_1 = excluded.reserve_1,
+            close_ledger = excluded.close_ledger
+    `
+}
+
+const insertRollupTraderQuery = `
+        INSERT INTO pair_stats_traders (pair_address, period, bucket_start, trader)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (pair_address, period, bucket_start, trader) DO NOTHING
+    `
+
+func init() {
+	registerHandlerQuery(insertRollupTraderQuery)
+	for _, p := range rollupPeriods {
+		registerHandlerQuery(p.loadQuery(), p.upsertQuery())
+		registerRetentionTable(retentionTable{
+			Name:   strings.TrimPrefix(p.table, "pair_"),
+			Table:  p.table,
+			Column: "bucket_start",
+			Prune:  pruneRollup(p),
+		})
+		registerPairTable(pairTable{
+			Name:   p.table,
+			Count:  "SELECT COUNT(*) FROM " + p.table + " WHERE pair_address = ?",
+			Delete: deleteByKey(p.table, "bucket_start"),
+		})
+		derivedTables[p.table] = derivedTable{
+			Reset:  "DELETE FROM " + p.table,
+			Events: []string{"swap", "sync"},
+			Also:   []string{"DELETE FROM pair_stats_traders WHERE period = '" + p.name + "'"},
+		}
+	}
+	registerPairTable(pairTable{
+		Name:   "pair_stats_traders",
+		Count:  "SELECT COUNT(*) FROM pair_stats_traders WHERE pair_address = ?",
+		Delete: deleteByKey("pair_stats_traders", "period", "bucket_start", "trader"),
+	})
+}
+
+// rollupUpdate is what one event contributes to a pair's rollups: traded
+// volume and a trader from a swap, or the reserves after a sync.
+type rollupUpdate struct {
+	At     time.Time
+	Ledger int64
+	// Volume0/Volume1 are token amounts traded, nil for non-swaps.
+	Volume0 *big.Int
+	Volume1 *big.Int
+	Trader  string
+	// Reserve0/Reserve1 are set for syncs.
+	Reserve0 string
+	Reserve1 string
+}
+
+// updateRollups folds u into the pair's bucket of every rollup, live when
+// stats_rollups is on or when replay rebuilds the rollup. The reserves at
+// close follow ledger order, like candle closes.
+func (s *SaveSoroswapPairsToSQLite) updateRollups(ctx context.Context, tx *sql.Tx, pair string, u rollupUpdate, replay map[string]bool) error {
+	for _, p := range rollupPeriods {
+		if !((replay == nil && s.statsRollups) || replay[p.table]) {
+			continue
+		}
+		bucket := u.At.UTC().Truncate(p.duration)
+
+		volume0, volume1 := "0", "0"
+		var swaps, traders int64
+		var reserve0, reserve1 sql.NullString
+		var closeLedger sql.NullInt64
+		err := s.stmts.queryRow(ctx, tx, p.loadQuery(), pair, bucket).Scan(
+			&volume0, &volume1, &swaps, &traders, &reserve0, &reserve1, &closeLedger)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to load %s rollup for %s: %v", p.name, pair, err)
+		}
+
+		if u.Volume0 != nil {
+			volume0 = addAmounts(volume0, u.Volume0)
+			volume1 = addAmounts(volume1, u.Volume1)
+			swaps++
+			if u.Trader != "" {
+				res, err := s.stmts.exec(ctx, tx, insertRollupTraderQuery, pair, p.name, bucket, u.Trader)
+				if err != nil {
+					return fmt.Errorf("failed to record %s rollup trader for %s: %v", p.name, pair, err)
+				}
+				if n, _ := res.RowsAffected(); n > 0 {
+					traders++
+				}
+			}
+		}
+		if u.Reserve0 != "" {
+			ledger := sql.NullInt64{Int64: u.Ledger, Valid: u.Ledger > 0}
+			if !reserve0.Valid || !ledger.Valid || !closeLedger.Valid || ledger.Int64 >= closeLedger.Int64 {
+				reserve0 = sql.NullString{String: u.Reserve0, Valid: true}
+				reserve1 = sql.NullString{String: u.Reserve1, Valid: true}
+				closeLedger = ledger
+			}
+		}
+
+		if _, err := s.stmts.exec(ctx, tx, p.upsertQuery(),
+			pair, bucket, volume0, volume1, swaps, traders, reserve0, reserve1, closeLedger,
+		); err != nil {
+			return fmt.Errorf("failed to update %s rollup for %s: %v", p.name, pair, err)
+		}
+	}
+	return nil
+}
+
+// pruneRollup deletes the oldest buckets of a rollup with their traders.
+func pruneRollup(p rollupPeriod) func(context.Context, *sql.Tx, backend, time.Time, int) (int64, error) {
+	return func(ctx context.Context, tx *sql.Tx, b backend, cutoff time.Time, chunk int) (int64, error) {
+		oldest := `SELECT pair_address, bucket_start FROM ` + p.table + `
+            WHERE bucket_start < ? ORDER BY bucket_start LIMIT ?`
+		if _, err := tx.ExecContext(ctx, b.Rebind(`DELETE FROM pair_stats_traders
+            WHERE period = ? AND (pair_address, bucket_start) IN (`+oldest+`)`), p.name, cutoff, chunk); err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, b.Rebind(`DELETE FROM `+p.table+`
+            WHERE (pair_address, bucket_start) IN (`+oldest+`)`), cutoff, chunk)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// RouterSwapEvent is a swap routed by the Soroswap router through one or
+// more pairs. Path lists the tokens in order and Pairs the pair contracts
+// traversed, so hop i swaps Path[i] for Path[i+1] through Pairs[i].
+type RouterSwapEvent struct {
+	Type      string   `json:"type"`
+	Path      []string `json:"path"`
+	Pairs     []string `json:"pairs"`
+	AmountIn  Amount   `json:"amount_in"`
+	AmountOut Amount   `json:"amount_out"`
+	// Amounts optionally holds the amount of every token in Path, giving
+	// per-hop amounts and defaulting AmountIn/AmountOut. Without it only the
+	// first hop's input and the last hop's output are known.
+	Amounts        []Amount  `json:"amounts,omitempty"`
+	TxHash         string    `json:"tx_hash"`
+	OperationIndex int64     `json:"operation_index"`
+	EventIndex     int64     `json:"event_index"`
+	Timestamp      time.Time `json:"timestamp"`
+	LedgerSequence int64     `json:"ledger_sequence"`
+}
+
+const (
+	insertRouterSwapQuery = `
+        INSERT INTO router_swaps (
+            token_in, token_out, path, amount_in, amount_out, hop_count,
+            ledger_sequence, tx_hash, operation_index, event_index, swapped_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING id
+    `
+
+	insertRouterHopQuery = `
+        INSERT INTO router_swap_hops (
+            swap_id, hop_index, pair_address, pair_ref,
+            token_in, token_out, amount_in, amount_out
+        ) VALUES (?, ?, ?, (SELECT pair_address FROM soroswap_pairs WHERE pair_address = ?), ?, ?, ?, ?)
+    `
+
+	reconcileRouterHopsQuery = `
+        UPDATE router_swap_hops SET pair_ref = pair_address
+        WHERE pair_address = ? AND pair_ref IS NULL
+    `
+
+	routerVolumeQuery = `
+        SELECT h.token_in, h.amount_in, h.token_out, h.amount_out
+        FROM router_swap_hops h
+        JOIN router_swaps r ON r.id = h.swap_id
+        WHERE h.pair_address = ? AND r.swapped_at >= ? AND r.swapped_at < ?
+    `
+)
+
+func init() {
+	registerHandlerQuery(insertRouterSwapQuery, insertRouterHopQuery, reconcileRouterHopsQuery)
+	registerRetentionTable(retentionTable{
+		Name:   "router_swaps",
+		Table:  "router_swaps",
+		Column: "swapped_at",
+		Prune:  pruneRouterSwaps,
+	})
+	registerLedgerTable(ledgerTable{Table: "router_swaps", Delete: rollbackRouterSwaps})
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "router_hops_by_pair",
+		Query: "SELECT swap_id FROM router_swap_hops WHERE pair_address = ?",
+		Args:  []interface{}{""},
+	})
+}
+
+// normalize canonicalizes the event's addresses and checks that the path,
+// pairs and amounts line up. Amounts are canonicalized beforehand.
+func (e *RouterSwapEvent) normalize() error {
+	if len(e.Path) < 2 {
+		return fmt.Errorf("path needs at least two tokens, got %d", len(e.Path))
+	}
+	if len(e.Pairs) != len(e.Path)-1 {
+		return fmt.Errorf("path of %d tokens needs %d pairs, got %d", len(e.Path), len(e.Path)-1, len(e.Pairs))
+	}
+	if len(e.Amounts) != 0 && len(e.Amounts) != len(e.Path) {
+		return fmt.Errorf("path of %d tokens needs %d amounts, got %d", len(e.Path), len(e.Path), len(e.Amounts))
+	}
+	for i := range e.Path {
+		if err := normalizeAddresses(&e.Path[i]); err != nil {
+			return err
+		}
+		if e.Path[i] == "" {
+			return fmt.Errorf("path token %d is empty", i)
+		}
+	}
+	for i := range e.Pairs {
+		if err := normalizeAddresses(&e.Pairs[i]); err != nil {
+			return err
+		}
+		if e.Pairs[i] == "" {
+			return fmt.Errorf("pair %d is empty", i)
+		}
+	}
+	if len(e.Amounts) != 0 {
+		if e.AmountIn == "" {
+			e.AmountIn = e.Amounts[0]
+		}
+		if e.AmountOut == "" {
+			e.AmountOut = e.Amounts[len(e.Amounts)-1]
+		}
+	}
+	for i, a := range e.Amounts {
+		if a == "" {
+			return fmt.Errorf("amount %d is empty", i)
+		}
+	}
+	if e.AmountIn == "" || e.AmountOut == "" {
+		return fmt.Errorf("amount_in and amount_out are required")
+	}
+	return nil
+}
+
+// amountFields returns pointers to every amount in the event.
+func (e *RouterSwapEvent) amountFields() []*Amount {
+	fields := []*Amount{&e.AmountIn, &e.AmountOut}
+	for i := range e.Amounts {
+		fields = append(fields, &e.Amounts[i])
+	}
+	return fields
+}
+
+// parseAmount parses a non-negative integer token amount.
+func parseAmount(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok || v.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return v, nil
+}
+
+// hopAmounts returns the amounts swapped in and out at hop i, nil when the
+// event does not carry them.
+func (e *RouterSwapEvent) hopAmounts(i int) (in, out interface{}) {
+	if len(e.Amounts) != 0 {
+		return e.Amounts[i], e.Amounts[i+1]
+	}
+	if i == 0 {
+		in = e.AmountIn
+	}
+	if i == len(e.Pairs)-1 {
+		out = e.AmountOut
+	}
+	return in, out
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleRouterSwap(ctx context.Context, event RouterSwapEvent) error {
+	swappedAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("router swap at ledger %d: %w", event.LedgerSequence, err)
+	}
+
+	// router_swaps is not derived from other events, so replays leave it.
+	if replayTables(ctx) != nil {
+		return nil
+	}
+
+	tx, done, err := s.beginEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer done() // Will be ignored if transaction is committed
+
+	var swapID int64
+	if err := s.stmts.queryRow(ctx, tx, insertRouterSwapQuery,
+		event.Path[0],
+		event.Path[len(event.Path)-1],
+		strings.Join(event.Path, ","),
+		event.AmountIn,
+		event.AmountOut,
+		len(event.Pairs),
+		nullableLedger(event.LedgerSequence),
+		nullableString(event.TxHash),
+		event.OperationIndex,
+		event.EventIndex,
+		swappedAt.Value,
+	).Scan(&swapID); err != nil {
+		return fmt.Errorf("failed to insert router swap: %v", err)
+	}
+
+	// Hops through pairs not stored yet keep a null pair_ref until the pair's
+	// new_pair event reconciles them.
+	for i, pair := range event.Pairs {
+		in, out := event.hopAmounts(i)
+		if _, err := s.stmts.exec(ctx, tx, insertRouterHopQuery,
+			swapID, i, pair, pair, event.Path[i], event.Path[i+1], in, out,
+		); err != nil {
+			return fmt.Errorf("failed to insert router hop %d: %v", i, err)
+		}
+	}
+
+	if err := s.commitEvent(ctx, tx, true); err != nil {
+		return fmt.Errorf("failed to commit router swap: %v", err)
+	}
+	logger.Debug("Recorded router swap", "event_type", "router_swap", "from", event.Path[0],
+		"to", event.Path[len(event.Path)-1], "pairs", len(event.Pairs), "ledger", event.LedgerSequence)
+	s.recordOutcome(ctx, "router_swap", outcomeInserted, event.Pairs[0])
+	return nil
+}
+
+// reconcileRouterHops links hops recorded before their pair was stored.
+func (s *SaveSoroswapPairsToSQLite) reconcileRouterHops(ctx context.Context, tx *sql.Tx, pair string) error {
+	result, err := s.stmts.exec(ctx, tx, reconcileRouterHopsQuery, pair)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile router hops for %s: %v", pair, err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("Linked earlier router hops to pair", "hops", n, "pair", pair)
+	}
+	return nil
+}
+
+// RouterVolume is the amount routed through a pair, per token, over a time
+// range. Amounts are decimal strings since they overflow int64.
+type RouterVolume struct {
+	PairAddress string `json:"pair_address"`
+	Hops        int64  `json:"hops"`
+	// AmountIn and AmountOut are keyed by token address. Hops whose amounts
+	// the router event did not carry are counted but not summed.
+	AmountIn  map[string]string `json:"amount_in"`
+	AmountOut map[string]string `json:"amount_out"`
+}
+
+// GetPairRouterVolume sums router-initiated swaps through pair between from
+// (inclusive) and to (exclusive). A zero from or to leaves that end open.
+func (s *SaveSoroswapPairsToSQLite) GetPairRouterVolume(ctx context.Context, pair string, from, to time.Time) (RouterVolume, error) {
+	pair, err := normalizeAddress(pair)
+	if err != nil {
+		return RouterVolume{}, err
+	}
+	if to.IsZero() {
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(routerVolumeQuery), pair, from.UTC(), to.UTC())
+	if err != nil {
+		return RouterVolume{}, fmt.Errorf("failed to query router volume: %v", err)
+	}
+	defer rows.Close()
+
+	in := map[string]*big.Int{}
+	out := map[string]*big.Int{}
+	vol := RouterVolume{PairAddress: pair}
+	for rows.Next() {
+		var tokenIn, tokenOut string
+		var amountIn, amountOut sql.NullString
+		if err := rows.Scan(&tokenIn, &amountIn, &tokenOut, &amountOut); err != nil {
+			return RouterVolume{}, err
+		}
+		vol.Hops++
+		if err := addAmount(in, tokenIn, amountIn); err != nil {
+			return RouterVolume{}, err
+		}
+		if err := addAmount(out, tokenOut, amountOut); err != nil {
+			return RouterVolume{}, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return RouterVolume{}, err
+	}
+
+	vol.AmountIn = make(map[string]string, len(in))
+	for t, v := range in {
+		vol.AmountIn[t] = v.String()
+	}
+	vol.AmountOut = make(map[string]string, len(out))
+	for t, v := range out {
+		vol.AmountOut[t] = v.String()
+	}
+	return vol, nil
+}
+
+func addAmount(totals map[string]*big.Int, token string, amount sql.NullString) error {
+	if !amount.Valid {
+		return nil
+	}
+	v, err := parseAmount(amount.String)
+	if err != nil {
+		return err
+	}
+	if totals[token] == nil {
+		totals[token] = new(big.Int)
+	}
+	totals[token].Add(totals[token], v)
+	return nil
+}
+
+// pruneRouterSwaps deletes routed swaps older than cutoff with their hops,
+// hops first since they reference the swap.
+func pruneRouterSwaps(ctx context.Context, tx *sql.Tx, b backend, cutoff time.Time, chunk int) (int64, error) {
+	const oldest = `SELECT id FROM router_swaps WHERE swapped_at < ? ORDER BY id LIMIT ?`
+	if _, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM router_swap_hops WHERE swap_id IN ("+oldest+")"), cutoff, chunk); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM router_swaps WHERE id IN ("+oldest+")"), cutoff, chunk)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// rollbackRouterSwaps deletes the routed swaps above ledger with their
+// hops.
+func rollbackRouterSwaps(ctx context.Context, tx *sql.Tx, b backend, ledger int64) (int64, error) {
+	const above = `SELECT id FROM router_swaps WHERE ledger_sequence > ?`
+	if _, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM router_swap_hops WHERE swap_id IN ("+above+")"), ledger); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, b.Rebind("DELETE FROM router_swaps WHERE ledger_sequence > ?"), ledger)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RouterLiquidityEvent is liquidity added to or removed from a pair through
+// the Soroswap router (router_add_liquidity and router_remove_liquidity).
+// Unlike the pair's own deposit and withdraw events it names the tokens in
+// the order the user gave them, and the address credited.
+type RouterLiquidityEvent struct {
+	Type        string `json:"type"`
+	PairAddress string `json:"pair_address"`
+	TokenA      string `json:"token_a"`
+	TokenB      string `json:"token_b"`
+	AmountA     Amount `json:"amount_a"`
+	AmountB     Amount `json:"amount_b"`
+	// Liquidity is the LP shares minted or burned.
+	Liquidity Amount `json:"liquidity"`
+	// To receives the LP shares when adding and the tokens when removing.
+	To     string `json:"to"`
+	TxHash string `json:"tx_hash"`
+	// OperationIndex is the operation within the transaction.
+	OperationIndex int64     `json:"operation_index"`
+	Timestamp      time.Time `json:"timestamp"`
+	// EventIndex tells apart several router events in one transaction.
+	EventIndex int64 `json:"event_index"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const insertRouterLiquidityQuery = `
+        INSERT INTO router_liquidity (
+            action, pair_address, token_a, token_b, amount_a, amount_b, liquidity,
+            recipient, ledger_sequence, tx_hash, operation_index, event_index, occurred_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, pair_address, event_index) DO NOTHING
+    `
+
+func init() {
+	registerHandlerQuery(insertRouterLiquidityQuery)
+	registerRetentionTable(retentionTable{Name: "router_liquidity", Table: "router_liquidity", Column: "occurred_at"})
+	registerLedgerTable(ledgerTable{Table: "router_liquidity"})
+	registerPairTable(pairTable{
+		Name:   "router_liquidity",
+		Count:  "SELECT COUNT(*) FROM router_liquidity WHERE pair_address = ?",
+		Delete: deleteByID("router_liquidity"),
+	})
+}
+
+// action returns "add" or "remove".
+func (e *RouterLiquidityEvent) action() string {
+	return strings.TrimSuffix(strings.TrimPrefix(e.Type, "router_"), "_liquidity")
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// amounts. Amounts are canonicalized beforehand.
+func (e *RouterLiquidityEvent) normalize() error {
+	if err := normalizeAddresses(&e.PairAddress, &e.TokenA, &e.TokenB, &e.To); err != nil {
+		return err
+	}
+	if e.TokenA == "" || e.TokenB == "" {
+		return fmt.Errorf("token_a and token_b are required")
+	}
+	if e.AmountA == "" || e.AmountB == "" || e.Liquidity == "" {
+		return fmt.Errorf("amount_a, amount_b and liquidity are required")
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleRouterLiquidity(ctx context.Context, event RouterLiquidityEvent) error {
+	if event.PairAddress == "" {
+		return fmt.Errorf("invalid router liquidity event data: missing pair_address")
+	}
+	occurredAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.PairAddress, err)
+	}
+	return s.storePairEvent(ctx, event.Type, event.PairAddress,
+		func(st PairStore) (bool, error) { return st.RecordRouterLiquidity(ctx, event, occurredAt.Value) }, nil)
+}
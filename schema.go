@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// schemaStatements creates the tables and indexes of the baseline schema.
+// They are written with the portable type markers understood by
+// backend.DDL. Later changes are new steps in migrations.
+var schemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS soroswap_pairs (
+            pair_address TEXT NOT NULL PRIMARY KEY,
+            token_0 TEXT,
+            token_1 TEXT,
+            reserve_0 TEXT NOT NULL DEFAULT '0',
+            reserve_1 TEXT NOT NULL DEFAULT '0',
+            created_at {{timestamp}} NOT NULL,
+            last_sync_at {{timestamp}},
+            last_sync_ledger INTEGER,
+            
+            -- Add constraints to prevent empty strings
+            CHECK (length(pair_address) > 0),
+            CHECK (length(token_0) > 0),
+            CHECK (length(token_1) > 0)
+        )`,
+
+	// Add an index for faster token lookups
+	`CREATE INDEX IF NOT EXISTS idx_tokens ON soroswap_pairs(token_0, token_1)`,
+
+	// Reserve timeline, appended on every sync when reserve_history is enabled
+	`CREATE TABLE IF NOT EXISTS pair_reserve_history (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL,
+            reserve_0 TEXT NOT NULL,
+            reserve_1 TEXT NOT NULL,
+            ledger_sequence INTEGER NOT NULL,
+            synced_at {{timestamp}} NOT NULL
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_history_pair_ledger
+            ON pair_reserve_history(pair_address, ledger_sequence, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_history_synced_at ON pair_reserve_history(synced_at)`,
+
+	// Byte-for-byte archive of received payloads, replayed by Reprocess
+	`CREATE TABLE IF NOT EXISTS raw_events (
+            id {{serial_pk}},
+            event_type TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            payload {{blob}} NOT NULL,
+            received_at {{timestamp}} NOT NULL
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_raw_events_ledger ON raw_events(ledger_sequence, id)`,
+	`CREATE TABLE IF NOT EXISTS reprocess_checkpoints (
+            job TEXT NOT NULL PRIMARY KEY,
+            last_ledger INTEGER NOT NULL,
+            last_id INTEGER NOT NULL,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+
+	// Triggered liquidity alerts; one per rule, pair and ledger
+	`CREATE TABLE IF NOT EXISTS alerts (
+            id {{serial_pk}},
+            rule_id TEXT NOT NULL,
+            rule_type TEXT NOT NULL,
+            pair_address TEXT NOT NULL,
+            token TEXT NOT NULL,
+            before_value TEXT NOT NULL,
+            after_value TEXT NOT NULL,
+            ledger_sequence INTEGER NOT NULL,
+            triggered_at {{timestamp}} NOT NULL,
+            UNIQUE (rule_id, pair_address, ledger_sequence)
+        )`,
+
+	`CREATE INDEX IF NOT EXISTS idx_alerts_pair ON alerts(pair_address)`,
+
+	// Audit trail of DeletePair calls, dry runs included
+	`CREATE TABLE IF NOT EXISTS pair_deletions (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL,
+            requested_by TEXT NOT NULL,
+            reason TEXT NOT NULL,
+            dry_run BOOLEAN NOT NULL,
+            row_counts TEXT NOT NULL,
+            deleted_at {{timestamp}} NOT NULL
+        )`,
+
+	// Events that failed processing, kept for inspection and retry
+	`CREATE TABLE IF NOT EXISTS dead_letter_events (
+            id {{serial_pk}},
+            event_type TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            payload {{blob}} NOT NULL,
+            error TEXT NOT NULL,
+            failed_at {{timestamp}} NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 1,
+            resolved_at {{timestamp}}
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_dead_letter_events_unresolved ON dead_letter_events(resolved_at, id)`,
+
+	// Lifetime per-type event counters, flushed in batches
+	`CREATE TABLE IF NOT EXISTS consumer_counters (
+            event_type TEXT NOT NULL PRIMARY KEY,
+            processed BIGINT NOT NULL DEFAULT 0,
+            failed BIGINT NOT NULL DEFAULT 0,
+            skipped_stale BIGINT NOT NULL DEFAULT 0,
+            dead_lettered BIGINT NOT NULL DEFAULT 0,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+
+	// Per-run summary of what a dry run would have written
+	`CREATE TABLE IF NOT EXISTS dry_run_report (
+            run_id TEXT NOT NULL,
+            event_type TEXT NOT NULL,
+            outcome TEXT NOT NULL,
+            count INTEGER NOT NULL,
+            last_pair_address TEXT,
+            last_detail TEXT,
+            updated_at {{timestamp}} NOT NULL,
+            PRIMARY KEY (run_id, event_type, outcome)
+        )`,
+}
+
+// schemaColumn is a column added to an existing table after its first
+// release. Neither backend has a portable ADD COLUMN IF NOT EXISTS, so
+// columns are only added when missing.
+type schemaColumn struct {
+	table      string
+	column     string
+	definition string
+}
+
+var schemaColumns = []schemaColumn{
+	// Timestamp validation: the original value of a flagged timestamp that
+	// was replaced by the ingest time.
+	{"soroswap_pairs", "created_at_original", "{{timestamp}}"},
+	{"soroswap_pairs", "last_sync_at_original", "{{timestamp}}"},
+	{"soroswap_pairs", "timestamp_suspect", "BOOLEAN NOT NULL DEFAULT FALSE"},
+
+	// Ledger the pair was created in, when known.
+	{"soroswap_pairs", "created_at_ledger", "INTEGER"},
+
+	// Canonical token order: token_a < token_b regardless of how the
+	// factory ordered token_0/token_1, and whether they were swapped.
+	{"soroswap_pairs", "token_a", "TEXT"},
+	{"soroswap_pairs", "token_b", "TEXT"},
+	{"soroswap_pairs", "tokens_flipped", "BOOLEAN NOT NULL DEFAULT FALSE"},
+
+	// Rows created from a sync for an unknown pair, completed by the pair's
+	// new_pair event.
+	{"soroswap_pairs", "placeholder", "BOOLEAN NOT NULL DEFAULT FALSE"},
+}
+
+// schemaNullable lists columns that used to be NOT NULL, relaxed on
+// databases created by older versions before the schema statements run.
+var schemaNullable = []struct {
+	table   string
+	columns []string
+}{
+	// Placeholder pairs have no tokens until their new_pair event.
+	{"soroswap_pairs", []string{"token_0", "token_1"}},
+}
+
+// schemaBackfills run after schemaColumns to populate added columns for
+// rows written by older versions, and to create indexes over them. They must
+// be idempotent.
+var schemaBackfills = []string{
+	`UPDATE soroswap_pairs SET
+            token_a = CASE WHEN token_0 <= token_1 THEN token_0 ELSE token_1 END,
+            token_b = CASE WHEN token_0 <= token_1 THEN token_1 ELSE token_0 END,
+            tokens_flipped = (token_0 > token_1)
+        WHERE token_a IS NULL AND token_0 IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_canonical_tokens ON soroswap_pairs(token_a, token_b)`,
+	// Pairs by either token; token_a lookups use idx_canonical_tokens
+	`CREATE INDEX IF NOT EXISTS idx_token_b ON soroswap_pairs(token_b)`,
+
+	// Stale pair scans
+	`CREATE INDEX IF NOT EXISTS idx_last_sync_at ON soroswap_pairs(last_sync_at)`,
+
+	// Swaps executed against a pair. Rows without a tx hash are never
+	// considered duplicates, since NULLs are distinct in the unique key.
+	`CREATE TABLE IF NOT EXISTS soroswap_swaps (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL,
+            trader TEXT NOT NULL,
+            amount_0_in TEXT NOT NULL,
+            amount_1_in TEXT NOT NULL,
+            amount_0_out TEXT NOT NULL,
+            amount_1_out TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            swapped_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, pair_address, event_index)
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_swaps_pair_ledger ON soroswap_swaps(pair_address, ledger_sequence)`,
+
+	// Liquidity added to a pair
+	`CREATE TABLE IF NOT EXISTS soroswap_deposits (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL REFERENCES soroswap_pairs(pair_address),
+            provider TEXT NOT NULL,
+            amount_0 TEXT NOT NULL,
+            amount_1 TEXT NOT NULL,
+            liquidity TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            deposited_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, pair_address, event_index)
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_deposits_pair_ledger ON soroswap_deposits(pair_address, ledger_sequence)`,
+
+	// Liquidity removed from a pair
+	`CREATE TABLE IF NOT EXISTS soroswap_withdrawals (
+            id {{serial_pk}},
+            pair_address TEXT NOT NULL REFERENCES soroswap_pairs(pair_address),
+            recipient TEXT NOT NULL,
+            amount_0 TEXT NOT NULL,
+            amount_1 TEXT NOT NULL,
+            liquidity TEXT NOT NULL,
+            ledger_sequence INTEGER,
+            tx_hash TEXT,
+            event_index INTEGER NOT NULL DEFAULT 0,
+            withdrawn_at {{timestamp}} NOT NULL,
+            UNIQUE (tx_hash, pair_address, event_index)
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_withdrawals_pair_ledger ON soroswap_withdrawals(pair_address, ledger_sequence)`,
+
+	// OHLCV candles per pair and resolution. Prices are token_1 per token_0
+	// from sync reserves; volumes are summed swap amounts per token.
+	`CREATE TABLE IF NOT EXISTS pair_candles (
+            pair_address TEXT NOT NULL,
+            resolution TEXT NOT NULL,
+            bucket_start {{timestamp}} NOT NULL,
+            open DOUBLE PRECISION,
+            high DOUBLE PRECISION,
+            low DOUBLE PRECISION,
+            close DOUBLE PRECISION,
+            open_ledger INTEGER,
+            close_ledger INTEGER,
+            volume_0 TEXT NOT NULL DEFAULT '0',
+            volume_1 TEXT NOT NULL DEFAULT '0',
+            swap_count INTEGER NOT NULL DEFAULT 0,
+            PRIMARY KEY (pair_address, resolution, bucket_start)
+        )`,
+
+	// Swap volume per pair and hour, kept for the longest rolling window,
+	// and the rolling 24h/7d totals computed from it
+	`CREATE TABLE IF NOT EXISTS pair_volume_hourly (
+            pair_address TEXT NOT NULL,
+            hour_start {{timestamp}} NOT NULL,
+            volume_0 TEXT NOT NULL,
+            volume_1 TEXT NOT NULL,
+            swap_count INTEGER NOT NULL,
+            PRIMARY KEY (pair_address, hour_start)
+        )`,
+	`CREATE TABLE IF NOT EXISTS pair_volume_stats (
+            pair_address TEXT NOT NULL PRIMARY KEY,
+            volume_24h_0 TEXT NOT NULL,
+            volume_24h_1 TEXT NOT NULL,
+            swaps_24h INTEGER NOT NULL,
+            volume_7d_0 TEXT NOT NULL,
+            volume_7d_1 TEXT NOT NULL,
+            swaps_7d INTEGER NOT NULL,
+            updated_at {{timestamp}} NOT NULL
+        )`,
+
+	// Token metadata resolved over Soroban RPC. A failed lookup leaves a row
+	// with last_error set, retried after token_retry_after.
+	`CREATE TABLE IF NOT EXISTS tokens (
+            address TEXT NOT NULL PRIMARY KEY,
+            symbol TEXT,
+            name TEXT,
+            decimals INTEGER,
+            fetched_at {{timestamp}} NOT NULL,
+            last_error TEXT
+        )`,
+
+	// Router-initiated swaps and the pairs each one traversed. pair_ref stays
+	// null until the pair itself is stored.
+	`CREATE TABLE IF NOT EXISTS router_swaps (
+            id {{serial_pk}},
+            token_in TEXT NOT NULL,
+            token_out TEXT NOT NULL,
+            path TEXT NOT NULL,
+            amount_in TEXT NOT NULL,
+            amount_out TEXT NOT NULL,
+            hop_count INTEGER NOT NULL,
+            ledger_sequence INTEGER,
+            swapped_at {{timestamp}} NOT NULL
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_router_swaps_time ON router_swaps(swapped_at)`,
+	`CREATE TABLE IF NOT EXISTS router_swap_hops (
+            swap_id INTEGER NOT NULL REFERENCES router_swaps(id),
+            hop_index INTEGER NOT NULL,
+            pair_address TEXT NOT NULL,
+            pair_ref TEXT REFERENCES soroswap_pairs(pair_address),
+            token_in TEXT NOT NULL,
+            token_out TEXT NOT NULL,
+            amount_in TEXT,
+            amount_out TEXT,
+            PRIMARY KEY (swap_id, hop_index)
+        )`,
+	`CREATE INDEX IF NOT EXISTS idx_router_hops_pair ON router_swap_hops(pair_address)`,
+}
+
+// createBaselineSchema creates any missing tables, indexes and columns of
+// the schema as it was before versioned migrations. It is migration 1.
+func createBaselineSchema(ctx context.Context, db *sql.DB, b backend) error {
+	for _, n := range schemaNullable {
+		if err := b.DropNotNull(ctx, db, n.table, n.columns...); err != nil {
+			return fmt.Errorf("failed to relax %s: %v", n.table, err)
+		}
+	}
+
+	for _, stmt := range schemaStatements {
+		if _, err := db.ExecContext(ctx, b.DDL(stmt)); err != nil {
+			return fmt.Errorf("failed to create schema: %v", err)
+		}
+	}
+
+	for _, c := range schemaColumns {
+		exists, err := b.ColumnExists(ctx, db, c.table, c.column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s.%s: %v", c.table, c.column, err)
+		}
+		if exists {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)
+		if _, err := db.ExecContext(ctx, b.DDL(stmt)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", c.table, c.column, err)
+		}
+	}
+
+	for _, stmt := range schemaBackfills {
+		if _, err := db.ExecContext(ctx, b.DDL(stmt)); err != nil {
+			return fmt.Errorf("failed to migrate schema: %v", err)
+		}
+	}
+	return nil
+}
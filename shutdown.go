@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown is returned by Process once Close has started. The
+// message was not processed, so the pipeline may redeliver it after a
+// restart.
+var ErrShuttingDown = errors.New("consumer is shutting down")
+
+// defaultShutdownTimeout bounds Close's drain when shutdown_timeout is not
+// set.
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownState lets Close refuse new messages and wait for those in
+// flight.
+type shutdownState struct {
+	timeout time.Duration
+
+	mu       sync.Mutex
+	closing  bool
+	inflight sync.WaitGroup
+	pending  atomic.Int64
+	// abort is cancelled when the drain times out, cancelling the
+	// messages still in flight.
+	abort       context.Context
+	cancelAbort context.CancelFunc
+}
+
+// abortGrace is how long a timed out drain waits for the cancelled
+// messages to roll back.
+const abortGrace = time.Second
+
+// commitGrace bounds committing the open batch once shutdown_timeout is
+// used up. Its events were acknowledged when they joined the batch, so the
+// drain does not give up on them with the rest.
+const commitGrace = 10 * time.Second
+
+func parseShutdownTimeout(cfg *Config) (time.Duration, error) {
+	if cfg.ShutdownTimeout <= 0 {
+		return 0, fmt.Errorf("config shutdown_timeout must be positive, got %s", cfg.ShutdownTimeout)
+	}
+	return cfg.ShutdownTimeout, nil
+}
+
+// enter admits a message, reporting false once shutdown has begun. The
+// returned context is also cancelled if the drain times out; leave must be
+// called when the message is done.
+func (st *shutdownState) enter(ctx context.Context) (_ context.Context, leave func(), ok bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closing {
+		return ctx, nil, false
+	}
+	if st.abort == nil {
+		st.abort, st.cancelAbort = context.WithCancel(context.Background())
+	}
+	st.inflight.Add(1)
+	st.pending.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(st.abort, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		st.pending.Add(-1)
+		st.inflight.Done()
+	}, true
+}
+
+// aborted reports whether the drain timed out and cancelled the messages
+// in flight. Their failures are not the events' fault.
+func (st *shutdownState) aborted() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.abort != nil && st.abort.Err() != nil
+}
+
+// close refuses further messages and waits for those in flight, until ctx
+// is done. Messages still in flight then are cancelled, and given
+// abortGrace to roll back.
+func (st *shutdownState) close(ctx context.Context) error {
+	st.mu.Lock()
+	st.closing = true
+	st.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		st.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	pending := st.pending.Load()
+	st.mu.Lock()
+	if st.cancelAbort != nil {
+		st.cancelAbort()
+	}
+	st.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(abortGrace):
+	}
+	return fmt.Errorf("%d messages still in flight after %s were cancelled", pending, st.timeout)
+}
+
+// drain brings the database up to date before Close closes it: messages in
+// flight finish, the open batch is committed and forwarded, and the
+// counters and checkpoint are persisted. SQLite's WAL is then checkpointed
+// into the database file, so the last processed ledger is on disk in the
+// main file. Messages still in flight at shutdown_timeout are cancelled,
+// but the open batch is committed all the same, within commitGrace, since
+// Process already acknowledged its events.
+//
+// Once drained, the write lock stays held so nothing writes between the
+// drain and closing the database; Close releases it afterwards with the
+// returned func, which is nil when the lock was not taken.
+func (s *SaveSoroswapPairsToSQLite) drain() (unlock func(), err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdown.timeout)
+	defer cancel()
+	start := time.Now()
+
+	closeErr := s.shutdown.close(ctx)
+	graced := false
+	grace := func() {
+		ctx, cancel = context.WithTimeout(context.Background(), commitGrace)
+		graced = true
+	}
+	if ctx.Err() != nil {
+		grace()
+		defer cancel()
+	}
+	unlock, err = s.lockWrites(ctx)
+	if err != nil && ctx.Err() != nil && !graced {
+		// The timeout ran out waiting for the lock.
+		grace()
+		defer cancel()
+		unlock, err = s.lockWrites(ctx)
+	}
+	if err != nil {
+		return nil, errors.Join(closeErr, fmt.Errorf("failed to commit the open batch: %v", err))
+	}
+	if s.counters != nil {
+		if err := s.flushCounters(ctx); err != nil {
+			return unlock, errors.Join(closeErr, err)
+		}
+	}
+	if _, ok := s.backend.(*sqliteBackend); ok && !s.dryRun {
+		if err := s.checkpointOnClose(ctx); err != nil {
+			return unlock, errors.Join(closeErr, err)
+		}
+	}
+	if closeErr != nil {
+		return unlock, closeErr
+	}
+	logger.Info("Drained pending writes", "duration", time.Since(start).Round(time.Millisecond),
+		"last_ledger", s.checkpoint.get().LastLedger)
+	return unlock, nil
+}
+
+// checkpointOnClose copies the WAL into the database file and truncates it,
+// shipping it first when WAL shipping is on.
+func (s *SaveSoroswapPairsToSQLite) checkpointOnClose(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	res, err := s.checkpointWAL(ctx, conn, "TRUNCATE")
+	if err != nil {
+		return err
+	}
+	if res.Busy {
+		logger.Warn("WAL checkpoint on close could not finish while readers were active",
+			"checkpointed", res.Checkpointed, "frames", res.Frames)
+	}
+	return nil
+}
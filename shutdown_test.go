@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainTimeoutCommitsOpenBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// stall keeps the drain busy past shutdown_timeout, returning what
+		// ends it.
+		stall   func(t *testing.T, s *SaveSoroswapPairsToSQLite) func()
+		wantErr string
+	}{
+		{
+			name: "message in flight",
+			stall: func(t *testing.T, s *SaveSoroswapPairsToSQLite) func() {
+				_, leave, ok := s.shutdown.enter(context.Background())
+				if !ok {
+					t.Fatal("enter refused a message before Close")
+				}
+				return leave
+			},
+			wantErr: "1 messages still in flight",
+		},
+		{
+			name: "write lock held",
+			stall: func(t *testing.T, s *SaveSoroswapPairsToSQLite) func() {
+				unlock, err := s.lockExclusive(context.Background())
+				if err != nil {
+					t.Fatalf("lockExclusive: %v", err)
+				}
+				// Released after the timeout, within commitGrace.
+				time.AfterFunc(200*time.Millisecond, unlock)
+				return func() {}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pairs.sqlite")
+			s := openTestConsumer(t, map[string]interface{}{
+				"db_path":          path,
+				"batch_size":       100,
+				"shutdown_timeout": "50ms",
+			})
+			process(t, s, newPairEvent(testPair, 10), syncEvent(testPair, "100", "5", 20))
+			if s.batch.tx == nil {
+				t.Fatal("batch committed before Close")
+			}
+			done := tt.stall(t, s)
+			err := s.Close()
+			done()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Close = %v, want an error containing %q", err, tt.wantErr)
+			}
+
+			// The acknowledged events of the batch were committed.
+			s = newTestConsumer(t, map[string]interface{}{"db_path": path})
+			if p := getPair(t, s, testPair); p.Reserve0 != "100" {
+				t.Errorf("reserve_0 = %s, want 100", p.Reserve0)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// sorobanRPC is a minimal Soroban RPC client that reads contract values by
+// simulating a call. Only the few XDR types needed to build the call and
+// decode simple results are implemented.
+type sorobanRPC struct {
+	url    string
+	client *http.Client
+	// source is the raw ed25519 key of the simulated transaction's source
+	// account. Simulation neither signs nor submits anything.
+	source []byte
+}
+
+func newSorobanRPC(url, sourceAccount string, timeout time.Duration) (*sorobanRPC, error) {
+	version, key, err := decodeStrkey(sourceAccount)
+	if err != nil || version != strkeyVersionAccount {
+		return nil, fmt.Errorf("invalid source account %q", sourceAccount)
+	}
+	return &sorobanRPC{url: url, client: &http.Client{Timeout: timeout}, source: key}, nil
+}
+
+// call simulates invoking a contract function with the given XDR encoded
+// SCVal arguments and returns the decoded result.
+func (r *sorobanRPC) call(ctx context.Context, contract, function string, args ...[]byte) (interface{}, error) {
+	version, contractID, err := decodeStrkey(contract)
+	if err != nil || version != strkeyVersionContract {
+		return nil, fmt.Errorf("invalid contract address %q", contract)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "simulateTransaction",
+		"params": map[string]string{
+			"transaction": base64.StdEncoding.EncodeToString(invokeEnvelopeXDR(r.source, contractID, function, args)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("soroban rpc: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("soroban rpc: HTTP %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Result struct {
+			Error   string `json:"error"`
+			Results []struct {
+				XDR string `json:"xdr"`
+			} `json:"results"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("soroban rpc: decoding response: %w", err)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("soroban rpc: %s", out.Error.Message)
+	}
+	if out.Result.Error != "" {
+		return nil, fmt.Errorf("soroban rpc: %s() failed: %s", function, out.Result.Error)
+	}
+	if len(out.Result.Results) == 0 {
+		return nil, fmt.Errorf("soroban rpc: %s() returned no result", function)
+	}
+	raw, err := base64.StdEncoding.DecodeString(out.Result.Results[0].XDR)
+	if err != nil {
+		return nil, fmt.Errorf("soroban rpc: %v", err)
+	}
+	v, _, err := decodeScVal(raw)
+	return v, err
+}
+
+// XDR discriminants used below, from the Stellar protocol definitions.
+const (
+	xdrEnvelopeTypeTx        = 2
+	xdrKeyTypeEd25519        = 0
+	xdrPrecondNone           = 0
+	xdrMemoNone              = 0
+	xdrOpInvokeHostFunction  = 24
+	xdrHostFunctionInvoke    = 0
+	xdrScAddressTypeContract = 1
+	xdrScvBool               = 0
+	xdrScvVoid               = 1
+	xdrScvU32                = 3
+	xdrScvU64                = 5
+	xdrScvI128               = 10
+	xdrScvString             = 14
+	xdrScvSymbol             = 15
+	xdrScvVec                = 16
+	xdrScvMap                = 17
+	xdrScvAddress            = 18
+	xdrSimulationFee         = 100
+)
+
+// xdrWriter appends XDR encoded values.
+type xdrWriter struct{ bytes.Buffer }
+
+func (w *xdrWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *xdrWriter) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	w.Write(b[:])
+}
+
+func (w *xdrWriter) fixed(b []byte) {
+	w.Write(b)
+	w.Write(make([]byte, (4-len(b)%4)%4))
+}
+
+func (w *xdrWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.fixed([]byte(s))
+}
+
+// scvSymbol encodes a symbol SCVal.
+func scvSymbol(s string) []byte {
+	var w xdrWriter
+	w.uint32(xdrScvSymbol)
+	w.string(s)
+	return w.Bytes()
+}
+
+// scvContract encodes the address SCVal of a contract.
+func scvContract(contractID []byte) []byte {
+	var w xdrWriter
+	w.uint32(xdrScvAddress)
+	w.uint32(xdrScAddressTypeContract)
+	w.fixed(contractID)
+	return w.Bytes()
+}
+
+// scvVec encodes a vector SCVal of already encoded items. Contract enum
+// variants are vectors of the variant's symbol and its values.
+func scvVec(items ...[]byte) []byte {
+	var w xdrWriter
+	w.uint32(xdrScvVec)
+	w.uint32(1) // present
+	w.uint32(uint32(len(items)))
+	for _, item := range items {
+		w.Write(item)
+	}
+	return w.Bytes()
+}
+
+// invokeEnvelopeXDR builds an unsigned transaction envelope with a single
+// InvokeHostFunction operation calling function on contractID.
+func invokeEnvelopeXDR(source, contractID []byte, function string, args [][]byte) []byte {
+	var w xdrWriter
+	w.uint32(xdrEnvelopeTypeTx)
+	// Transaction
+	w.uint32(xdrKeyTypeEd25519)
+	w.fixed(source)
+	w.uint32(xdrSimulationFee)
+	w.int64(0) // sequence number, unused by simulation
+	w.uint32(xdrPrecondNone)
+	w.uint32(xdrMemoNone)
+	w.uint32(1) // one operation
+	w.uint32(0) // no operation source account
+	w.uint32(xdrOpInvokeHostFunction)
+	w.uint32(xdrHostFunctionInvoke)
+	w.uint32(xdrScAddressTypeContract)
+	w.fixed(contractID)
+	w.string(function)
+	w.uint32(uint32(len(args)))
+	for _, arg := range args {
+		w.Write(arg)
+	}
+	w.uint32(0) // no authorization entries
+	w.uint32(0) // transaction ext v0
+	// Signatures
+	w.uint32(0)
+	return w.Bytes()
+}
+
+// decodeScVal decodes the SCVal types contract reads use and returns the
+// bytes following it: void (nil), bool, u32, u64, i128 (*big.Int), string
+// and symbol, vectors ([]interface{}) and maps with string or symbol keys
+// (map[string]interface{}).
+func decodeScVal(b []byte) (interface{}, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("scval: truncated")
+	}
+	kind, b := binary.BigEndian.Uint32(b), b[4:]
+	switch kind {
+	case xdrScvVoid:
+		return nil, b, nil
+	case xdrScvBool:
+		if len(b) < 4 {
+			return nil, nil, errors.New("scval: truncated bool")
+		}
+		return binary.BigEndian.Uint32(b) != 0, b[4:], nil
+	case xdrScvU32:
+		if len(b) < 4 {
+			return nil, nil, errors.New("scval: truncated u32")
+		}
+		return binary.BigEndian.Uint32(b), b[4:], nil
+	case xdrScvU64:
+		if len(b) < 8 {
+			return nil, nil, errors.New("scval: truncated u64")
+		}
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	case xdrScvI128:
+		if len(b) < 16 {
+			return nil, nil, errors.New("scval: truncated i128")
+		}
+		hi := big.NewInt(int64(binary.BigEndian.Uint64(b)))
+		lo := new(big.Int).SetUint64(binary.BigEndian.Uint64(b[8:]))
+		return hi.Lsh(hi, 64).Add(hi, lo), b[16:], nil
+	case xdrScvString, xdrScvSymbol:
+		if len(b) < 4 {
+			return nil, nil, errors.New("scval: truncated string")
+		}
+		n, b := binary.BigEndian.Uint32(b), b[4:]
+		padded := n + (4-n%4)%4
+		if uint32(len(b)) < padded {
+			return nil, nil, errors.New("scval: truncated string")
+		}
+		return string(b[:n]), b[padded:], nil
+	case xdrScvVec, xdrScvMap:
+		if len(b) < 4 {
+			return nil, nil, errors.New("scval: truncated collection")
+		}
+		present, b := binary.BigEndian.Uint32(b), b[4:]
+		if present == 0 {
+			return nil, b, nil
+		}
+		if len(b) < 4 {
+			return nil, nil, errors.New("scval: truncated collection")
+		}
+		n, b := binary.BigEndian.Uint32(b), b[4:]
+		if kind == xdrScvVec {
+			vec := make([]interface{}, 0, n)
+			for i := uint32(0); i < n; i++ {
+				var v interface{}
+				var err error
+				if v, b, err = decodeScVal(b); err != nil {
+					return nil, nil, err
+				}
+				vec = append(vec, v)
+			}
+			return vec, b, nil
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint32(0); i < n; i++ {
+			var k, v interface{}
+			var err error
+			if k, b, err = decodeScVal(b); err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("scval: unsupported map key %T", k)
+			}
+			if v, b, err = decodeScVal(b); err != nil {
+				return nil, nil, err
+			}
+			m[key] = v
+		}
+		return m, b, nil
+	default:
+		return nil, nil, fmt.Errorf("scval: unsupported type %d", kind)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// sqliteKey is the SQLCipher key of an encrypted database. The connector
+// reads it for every new connection, so Rekey can switch the key of the
+// connections opened after it.
+type sqliteKey struct {
+	mu  sync.RWMutex
+	key string
+}
+
+func (k *sqliteKey) get() string {
+	if k == nil {
+		return ""
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.key
+}
+
+func (k *sqliteKey) set(key string) {
+	k.mu.Lock()
+	k.key = key
+	k.mu.Unlock()
+}
+
+// parseSQLiteKey reads sqlite_key, the SQLCipher key to open the database
+// with. Keys are best passed as ${NAME} references to the environment.
+func parseSQLiteKey(cfg *Config) *sqliteKey {
+	return &sqliteKey{key: cfg.SQLiteKey}
+}
+
+// keyPragma returns the PRAGMA that sets (name "key") or changes (name
+// "rekey") a connection's SQLCipher key. Errors must not include it.
+func keyPragma(name, key string) string {
+	return fmt.Sprintf("PRAGMA %s = '%s'", name, strings.ReplaceAll(key, "'", "''"))
+}
+
+// checkSQLCipher checks that the SQLite library the driver is built
+// against is SQLCipher. Plain SQLite ignores PRAGMA key and would leave
+// the database unencrypted.
+func checkSQLCipher(ctx context.Context, db *sql.DB) error {
+	var version string
+	err := db.QueryRowContext(ctx, "PRAGMA cipher_version").Scan(&version)
+	if err == sql.ErrNoRows || (err == nil && version == "") {
+		return errors.New("config sqlite_key requires SQLCipher; build with -tags libsqlite3 and link against libsqlcipher")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read SQLCipher version: %v", err)
+	}
+	logger.Info("Opened encrypted SQLite database", "cipher_version", version)
+	return nil
+}
+
+// Rekey re-encrypts the database with newKey. Writes wait while the file
+// is rewritten; connections opened with the old key are closed, and new
+// ones use newKey. sqlite_key must be changed to newKey before the next
+// start.
+func (s *SaveSoroswapPairsToSQLite) Rekey(ctx context.Context, newKey string) error {
+	sb, ok := s.backend.(*sqliteBackend)
+	if !ok || sb.key.get() == "" {
+		return errors.New("rekey requires an encrypted SQLite database (sqlite_key)")
+	}
+	if newKey == "" {
+		return errors.New("new key must not be empty")
+	}
+	if s.dryRun {
+		return errors.New("rekey is not available in dry-run mode")
+	}
+
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	// Pages still in the write-ahead log are re-encrypted too once they
+	// are in the main file.
+	if _, err := s.checkpointWAL(ctx, conn, "TRUNCATE"); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to checkpoint before rekey: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, keyPragma("rekey", newKey)); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to rekey database: %v", err)
+	}
+	sb.key.set(newKey)
+	// Dropping the idle connections closes those opened with the old key.
+	s.db.SetMaxIdleConns(0)
+	s.readDB.SetMaxIdleConns(0)
+	conn.Close()
+	s.db.SetMaxIdleConns(sb.pool.maxIdle)
+	s.readDB.SetMaxIdleConns(sb.readPool.maxIdle)
+	logger.Info("Rekeyed encrypted SQLite database")
+	return nil
+}
+
+// rekeyHandler serves POST /rekey on the admin address. The new key is
+// read from the JSON body, {"key": "..."}, so it stays out of access logs.
+func (s *SaveSoroswapPairsToSQLite) rekeyHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /rekey", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			writeAPIError(w, fmt.Errorf("%w: invalid body: %v", errBadRequest, err))
+			return
+		}
+		if req.Key == "" {
+			writeAPIError(w, fmt.Errorf("%w: key is required", errBadRequest))
+			return
+		}
+		err := s.Rekey(r.Context(), req.Key)
+		writeAPIResult(w, map[string]bool{"rekeyed": err == nil}, err)
+	})
+	return mux
+}
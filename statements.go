@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	insertPairQuery = `
+        INSERT INTO soroswap_pairs (
+            pair_address, token_0, token_1, created_at,
+            created_at_original, timestamp_suspect, created_at_ledger,
+            created_tx_hash, created_operation_index, created_event_index,
+            token_a, token_b, tokens_flipped,
+            reserve_0, reserve_1, reserve_0_scaled, reserve_1_scaled, price_0_1, price_1_0,
+            last_sync_at, last_sync_at_original, last_sync_ledger,
+            last_sync_tx_hash, last_sync_operation_index, last_sync_event_index, network
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address) DO UPDATE SET
+            token_0 = excluded.token_0,
+            token_1 = excluded.token_1,
+            created_at = excluded.created_at,
+            created_at_original = excluded.created_at_original,
+            timestamp_suspect = (excluded.timestamp_suspect OR soroswap_pairs.last_sync_at_original IS NOT NULL),
+            created_at_ledger = excluded.created_at_ledger,
+            created_tx_hash = excluded.created_tx_hash,
+            created_operation_index = excluded.created_operation_index,
+            created_event_index = excluded.created_event_index,
+            token_a = excluded.token_a,
+            token_b = excluded.token_b,
+            tokens_flipped = excluded.tokens_flipped,
+            placeholder = FALSE
+        WHERE soroswap_pairs.placeholder
+    `
+
+	// insertPlaceholderQuery stores a pair first seen in a sync, with the
+	// sync's reserves and no tokens.
+	insertPlaceholderQuery = `
+        INSERT INTO soroswap_pairs (
+            pair_address, created_at, created_at_original, timestamp_suspect,
+            reserve_0, reserve_1, reserve_0_scaled, reserve_1_scaled, price_0_1, price_1_0,
+            last_sync_at, last_sync_at_original, last_sync_ledger,
+            last_sync_tx_hash, last_sync_operation_index, last_sync_event_index, network, placeholder
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, TRUE)
+        ON CONFLICT (pair_address) DO NOTHING
+    `
+
+	pairExistsQuery = `SELECT EXISTS (
+		SELECT 1 FROM soroswap_pairs WHERE pair_address = ?
+	)`
+
+	pairStateQuery = `
+        SELECT COALESCE(token_0, ''), COALESCE(token_1, ''), reserve_0, reserve_1, last_sync_ledger
+        FROM soroswap_pairs WHERE pair_address = ?
+    `
+
+	updateReservesQuery = `
+        UPDATE soroswap_pairs 
+        SET reserve_0 = ?,
+            reserve_1 = ?,
+            reserve_0_scaled = ?,
+            reserve_1_scaled = ?,
+            price_0_1 = ?,
+            price_1_0 = ?,
+            last_sync_at = ?,
+            last_sync_at_original = ?,
+            timestamp_suspect = (? OR created_at_original IS NOT NULL),
+            last_sync_ledger = ?,
+            last_sync_tx_hash = ?,
+            last_sync_operation_index = ?,
+            last_sync_event_index = ?
+        WHERE pair_address = ?
+          AND (? = 0 OR last_sync_ledger IS NULL OR last_sync_ledger <= ?)
+    `
+
+	insertHistoryQuery = `
+        INSERT INTO pair_reserve_history (
+            pair_address, reserve_0, reserve_1, ledger_sequence,
+            tx_hash, operation_index, event_index, synced_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+	// historyPointExistsQuery finds a point already stored by an earlier
+	// ingest of the same sync, when backfilling.
+	historyPointExistsQuery = `SELECT EXISTS (
+		SELECT 1 FROM pair_reserve_history
+		WHERE pair_address = ? AND ledger_sequence = ? AND reserve_0 = ? AND reserve_1 = ?
+	)`
+)
+
+func init() {
+	registerHandlerQuery(historyPointExistsQuery)
+}
+
+// handlerQueries are the other queries run for every event, registered by
+// the files that own them. They are prepared with the named statements and
+// run through statements.exec, queryRow and query.
+var handlerQueries []string
+
+func registerHandlerQuery(queries ...string) {
+	handlerQueries = append(handlerQueries, queries...)
+}
+
+// statements holds the statements used by the event handlers, prepared once
+// at Initialize and bound to each transaction with tx.StmtContext.
+// database/sql transparently re-prepares a statement on any new connection
+// it is used on, so they survive reconnects without extra bookkeeping.
+type statements struct {
+	insertPair        *sql.Stmt
+	pairExists        *sql.Stmt
+	pairState         *sql.Stmt
+	updateReserves    *sql.Stmt
+	insertHistory     *sql.Stmt
+	insertPlaceholder *sql.Stmt
+
+	db      *sql.DB
+	backend backend
+	// metrics, if set, records the latency of every handler statement.
+	metrics *latencyMetrics
+	// byQuery holds every prepared statement by query.
+	byQuery map[string]*sql.Stmt
+	all     []*sql.Stmt
+}
+
+// prepareStatements prepares every handler statement against db, except
+// those skip reports, which run unprepared.
+func prepareStatements(ctx context.Context, db *sql.DB, b backend, skip func(query string) bool) (*statements, error) {
+	st := &statements{db: db, backend: b, byQuery: make(map[string]*sql.Stmt)}
+	prepare := func(query string) (*sql.Stmt, error) {
+		stmt, err := db.PrepareContext(ctx, b.Rebind(query))
+		if err != nil {
+			st.Close()
+			return nil, fmt.Errorf("failed to prepare statement: %v", err)
+		}
+		st.all = append(st.all, stmt)
+		return stmt, nil
+	}
+	for _, p := range []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&st.insertPair, insertPairQuery},
+		{&st.pairExists, pairExistsQuery},
+		{&st.pairState, pairStateQuery},
+		{&st.updateReserves, updateReservesQuery},
+		{&st.insertHistory, insertHistoryQuery},
+		{&st.insertPlaceholder, insertPlaceholderQuery},
+	} {
+		if skip(p.query) {
+			continue
+		}
+		stmt, err := prepare(p.query)
+		if err != nil {
+			return nil, err
+		}
+		*p.dst = stmt
+		st.byQuery[p.query] = stmt
+	}
+	for _, query := range handlerQueries {
+		if st.byQuery[query] != nil || skip(query) {
+			continue
+		}
+		stmt, err := prepare(query)
+		if err != nil {
+			return nil, err
+		}
+		st.byQuery[query] = stmt
+	}
+	return st, nil
+}
+
+// Close releases all prepared statements.
+func (st *statements) Close() error {
+	var firstErr error
+	for _, stmt := range st.all {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	st.all = nil
+	return firstErr
+}
+
+// exec runs query in tx, or outside a transaction when tx is nil, with its
+// prepared statement. Queries that were not registered still run, prepared
+// by the driver each time.
+func (st *statements) exec(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	defer st.observe(time.Now())
+	if stmt := st.bind(ctx, tx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	if tx == nil {
+		return st.db.ExecContext(ctx, st.backend.Rebind(query), args...)
+	}
+	return tx.ExecContext(ctx, st.backend.Rebind(query), args...)
+}
+
+// queryRow is exec for queries returning at most one row.
+func (st *statements) queryRow(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	defer st.observe(time.Now())
+	if stmt := st.bind(ctx, tx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	if tx == nil {
+		return st.db.QueryRowContext(ctx, st.backend.Rebind(query), args...)
+	}
+	return tx.QueryRowContext(ctx, st.backend.Rebind(query), args...)
+}
+
+// query is exec for queries returning rows.
+func (st *statements) query(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	defer st.observe(time.Now())
+	if stmt := st.bind(ctx, tx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	if tx == nil {
+		return st.db.QueryContext(ctx, st.backend.Rebind(query), args...)
+	}
+	return tx.QueryContext(ctx, st.backend.Rebind(query), args...)
+}
+
+// observe records a statement started at start.
+func (st *statements) observe(start time.Time) {
+	st.metrics.observeDB("statement", time.Since(start))
+}
+
+// bind returns the prepared statement for query, bound to tx when set, or
+// nil if query is not prepared.
+func (st *statements) bind(ctx context.Context, tx *sql.Tx, query string) *sql.Stmt {
+	stmt := st.byQuery[query]
+	if stmt == nil || tx == nil {
+		return stmt
+	}
+	return tx.StmtContext(ctx, stmt)
+}
+
+// pairState is a stored pair as seen by handlers before they change it.
+type pairState struct {
+	Token0         string
+	Token1         string
+	Reserve0       string
+	Reserve1       string
+	LastSyncLedger sql.NullInt64
+}
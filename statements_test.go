@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+func TestStatementsPrepared(t *testing.T) {
+	s := newTestConsumer(t, nil)
+	for _, query := range append([]string{insertPairQuery, pairExistsQuery, pairStateQuery, updateReservesQuery,
+		insertHistoryQuery, insertPlaceholderQuery}, handlerQueries...) {
+		if s.stmts.byQuery[query] == nil {
+			t.Errorf("query not prepared: %.60s", query)
+		}
+	}
+}
+
+func TestStatementsUnknownPair(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]interface{}
+		wantErr     bool
+		wantOutcome string
+		wantPairs   int
+	}{
+		{name: "lenient", wantOutcome: outcomeUnknownPair},
+		{name: "strict", config: map[string]interface{}{"strict_mode": true}, wantErr: true, wantOutcome: outcomeUnknownPair},
+		{name: "placeholder", config: map[string]interface{}{"create_missing_pairs": true}, wantOutcome: outcomePlaceholder, wantPairs: 1},
+		{name: "pending", config: map[string]interface{}{"pending_syncs": true}, wantOutcome: outcomePending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConsumer(t, tt.config)
+			err := s.Process(context.Background(), syncEvent(testPair, "100", "5", 20).message(t))
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("Process error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errUnknownPair) {
+				t.Errorf("Process error = %v, want %v", err, errUnknownPair)
+			}
+			if got := s.Stats().Outcomes[tt.wantOutcome]; got != 1 {
+				t.Errorf("%s outcomes = %d, want 1", tt.wantOutcome, got)
+			}
+			if n := countRows(t, s, "soroswap_pairs"); n != tt.wantPairs {
+				t.Errorf("pairs = %d, want %d", n, tt.wantPairs)
+			}
+		})
+	}
+}
+
+func TestStatementsContextCanceled(t *testing.T) {
+	s := newTestConsumer(t, nil)
+	process(t, s, newPairEvent(testPair, 10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Process(ctx, syncEvent(testPair, "100", "5", 20).message(t)); err == nil {
+		t.Fatal("Process with a canceled context succeeded")
+	}
+	if p := getPair(t, s, testPair); p.LastSyncLedger != nil {
+		t.Errorf("canceled sync was applied at ledger %d", *p.LastSyncLedger)
+	}
+
+	// The prepared statements are still usable afterwards.
+	process(t, s, syncEvent(testPair, "200", "5", 21))
+	if p := getPair(t, s, testPair); p.Reserve0 != "200" {
+		t.Errorf("reserve_0 = %s, want 200", p.Reserve0)
+	}
+}
+
+// BenchmarkProcessSync measures syncs of a handful of pairs on an in-memory
+// database, the path a backfill spends its time in, with the handler
+// statements prepared at Initialize and, for comparison, prepared by the
+// driver on every use.
+func BenchmarkProcessSync(b *testing.B) {
+	for _, prepared := range []bool{true, false} {
+		name := "prepared"
+		if !prepared {
+			name = "unprepared"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newTestConsumer(b, nil)
+			if !prepared {
+				// Queries without a prepared statement run through tx.ExecContext.
+				s.stmts.byQuery = map[string]*sql.Stmt{}
+			}
+			const pairs = 8
+			for i := 0; i < pairs; i++ {
+				process(b, s, newPairEvent(testContract(byte(100+i)), 1))
+			}
+			// Ledgers keep rising so every sync is applied.
+			msgs := make([]pluginapi.Message, b.N)
+			for i := range msgs {
+				msgs[i] = syncEvent(testContract(byte(100+i%pairs)), fmt.Sprint(1000+i), "500", int64(10+i)).message(b)
+			}
+			ctx := context.Background()
+			b.ResetTimer()
+			for _, msg := range msgs {
+				if err := s.Process(ctx, msg); err != nil {
+					b.Fatalf("Process: %v", err)
+				}
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+)
+
+// Event outcomes tracked in Stats and, in dry-run mode, in dry_run_report.
+const (
+	outcomeInserted     = "inserted"
+	outcomeDuplicate    = "duplicate"
+	outcomeUpdated      = "updated"
+	outcomeUnknownPair  = "unknown_pair"
+	outcomeSkippedStale = "skipped_stale"
+	outcomePlaceholder  = "placeholder_created"
+	outcomePending      = "pending"
+	outcomeFiltered     = "filtered"
+	outcomeInvalid      = "validation_failure"
+)
+
+// Stats is a snapshot of the consumer's counters since Initialize, plus
+// lifetime totals.
+type Stats struct {
+	DryRun bool `json:"dry_run"`
+	// Processed and Failed count events by type.
+	Processed map[string]int64 `json:"processed"`
+	Failed    map[string]int64 `json:"failed"`
+	// Outcomes counts what handlers did (or, in dry-run mode, would have
+	// done) by outcome.
+	Outcomes map[string]int64 `json:"outcomes"`
+	// QueueDepth is the number of events currently admitted or waiting for
+	// admission; Throttled and Rejected count rate-limited waits and
+	// messages refused by overflow_policy: reject.
+	QueueDepth int64 `json:"queue_depth"`
+	Throttled  int64 `json:"throttled"`
+	Rejected   int64 `json:"rejected"`
+	// Slowed counts admissions held back because commits were over
+	// max_commit_latency, and CommitLatencySeconds is the moving average
+	// of commit latency it is compared with.
+	Slowed               int64   `json:"slowed"`
+	CommitLatencySeconds float64 `json:"commit_latency_seconds"`
+	// BusyRetries counts events retried after hitting a locked database.
+	BusyRetries int64 `json:"busy_retries"`
+	// Lifetime holds per-type totals across restarts, including this run.
+	Lifetime map[string]EventCounters `json:"lifetime"`
+	// QueryPlans is the latest plan check of the canonical read queries,
+	// showing which indexes they actually use.
+	QueryPlans []QueryPlanCheck `json:"query_plans"`
+}
+
+// statsCollector accumulates the counters behind Stats.
+type statsCollector struct {
+	mu        sync.Mutex
+	processed map[string]int64
+	failed    map[string]int64
+	outcomes  map[string]int64
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		processed: make(map[string]int64),
+		failed:    make(map[string]int64),
+		outcomes:  make(map[string]int64),
+	}
+}
+
+// recordEvent counts a processed event, as failed when err is non-nil.
+func (c *statsCollector) recordEvent(eventType string, err error) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.failed[eventType]++
+	} else {
+		c.processed[eventType]++
+	}
+}
+
+func (c *statsCollector) recordOutcome(outcome string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outcomes[outcome]++
+}
+
+func (c *statsCollector) snapshot() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Processed: copyCounts(c.processed),
+		Failed:    copyCounts(c.failed),
+		Outcomes:  copyCounts(c.outcomes),
+	}
+}
+
+func copyCounts(m map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// Stats returns the consumer's counters since Initialize.
+func (s *SaveSoroswapPairsToSQLite) Stats() Stats {
+	st := s.stats.snapshot()
+	st.DryRun = s.dryRun
+	if s.counters != nil {
+		st.Lifetime = s.counters.lifetime()
+	}
+	st.QueryPlans = s.queryPlans.get()
+	if s.flow != nil {
+		st.QueueDepth = s.flow.depth.Load()
+		st.Throttled = s.flow.throttled.Load()
+		st.Rejected = s.flow.rejected.Load()
+		st.Slowed = s.flow.slowed.Load()
+		avg, _ := s.flow.commitLag()
+		st.CommitLatencySeconds = avg.Seconds()
+	}
+	if s.busy != nil {
+		st.BusyRetries = s.busy.retried.Load()
+	}
+	return st
+}
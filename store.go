@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PairStore writes the primary data: pairs, their reserves and the per-pair
+// activity log. Handlers get one bound to their transaction, so everything
+// an event writes still commits or rolls back together; the derived tables
+// (history, candles, volume, alerts) are written on the same transaction by
+// their own code. Replacing SaveSoroswapPairsToSQLite.newStore swaps the
+// implementation, e.g. for a fake in tests.
+type PairStore interface {
+	// InsertPair stores a new pair, or completes its placeholder, reporting
+	// false if it already exists.
+	InsertPair(ctx context.Context, p PairRecord) (bool, error)
+	// InsertPlaceholder stores a pair known only from a sync, with the
+	// sync's reserves and no tokens.
+	InsertPlaceholder(ctx context.Context, u ReserveUpdate) error
+	// PairExists reports whether the pair is stored.
+	PairExists(ctx context.Context, pair string) (bool, error)
+	// LoadPair returns the stored pair, or nil for unknown pairs.
+	LoadPair(ctx context.Context, pair string) (*pairState, error)
+	// UpdateReserves sets a stored pair's reserves, reporting false when
+	// they are already from a later ledger.
+	UpdateReserves(ctx context.Context, u ReserveUpdate) (bool, error)
+	// RecordSwap, RecordDeposit and RecordWithdrawal append to the activity
+	// log, reporting false for a duplicate delivery.
+	RecordSwap(ctx context.Context, e SwapEvent, at time.Time) (bool, error)
+	RecordDeposit(ctx context.Context, e DepositEvent, at time.Time) (bool, error)
+	RecordWithdrawal(ctx context.Context, e WithdrawEvent, at time.Time) (bool, error)
+	// RecordProtocolFee appends a protocol fee mint, reporting false for a
+	// duplicate delivery.
+	RecordProtocolFee(ctx context.Context, e ProtocolFeeEvent, at time.Time) (bool, error)
+	// RecordRouterLiquidity appends liquidity added or removed through the
+	// router, reporting false for a duplicate delivery.
+	RecordRouterLiquidity(ctx context.Context, e RouterLiquidityEvent, at time.Time) (bool, error)
+	// RecordLPTransfer appends a transfer of LP shares, reporting false for
+	// a duplicate delivery.
+	RecordLPTransfer(ctx context.Context, e LPTransferEvent, at time.Time) (bool, error)
+}
+
+// PairRecord is a new pair to store.
+type PairRecord struct {
+	PairAddress     string
+	Token0          string
+	Token1          string
+	CreatedAt       validatedTimestamp
+	CreatedAtLedger int64
+	// Created is the new_pair event.
+	Created EventRef
+	// Reserves holds the initial reserves, nil when the event had none.
+	Reserves *ReserveUpdate
+}
+
+// ReserveUpdate is a pair's reserves as of a sync.
+type ReserveUpdate struct {
+	PairAddress string
+	Reserve0    string
+	Reserve1    string
+	SyncedAt    validatedTimestamp
+	Ledger      int64
+	// Scaled0 and Scaled1 are the reserves in whole tokens, see
+	// scaleReserve; nil until the token's decimals are known.
+	Scaled0 *string
+	Scaled1 *string
+	// Price01 and Price10 are the prices the reserves imply, see
+	// reservePrices.
+	Price01 *float64
+	Price10 *float64
+	// Event is the sync the reserves came from.
+	Event EventRef
+}
+
+// EventRef locates the contract event a row came from on chain, for
+// linking to explorers. TxHash is empty when the processor did not send
+// it.
+type EventRef struct {
+	TxHash         string
+	OperationIndex int64
+	EventIndex     int64
+}
+
+// sqlStore is the PairStore of the SQL backends, bound to a transaction.
+type sqlStore struct {
+	tx    *sql.Tx
+	stmts *statements
+	// network tags inserted pairs, "" for none
+	network string
+	// partitions routes swaps to their month's partition
+	partitions *monthPartitions
+}
+
+// newSQLStore returns the default PairStore for tx.
+func (s *SaveSoroswapPairsToSQLite) newSQLStore(tx *sql.Tx) PairStore {
+	return &sqlStore{tx: tx, stmts: s.stmts, network: s.network, partitions: s.partitions}
+}
+
+// store returns the PairStore for a handler transaction.
+func (s *SaveSoroswapPairsToSQLite) store(tx *sql.Tx) PairStore {
+	if s.newStore != nil {
+		return s.newStore(tx)
+	}
+	return s.newSQLStore(tx)
+}
+
+func (st *sqlStore) InsertPair(ctx context.Context, p PairRecord) (bool, error) {
+	// Initial reserves count as the pair's first sync, so it does not look
+	// empty until the next one.
+	reserve0, reserve1 := "0", "0"
+	var syncedAt, syncedAtOriginal, syncLedger, scaled0, scaled1, price01, price10 interface{}
+	var sync EventRef
+	if r := p.Reserves; r != nil {
+		reserve0, reserve1 = r.Reserve0, r.Reserve1
+		syncedAt, syncedAtOriginal = r.SyncedAt.Value, r.SyncedAt.Original
+		syncLedger = nullableLedger(r.Ledger)
+		scaled0, scaled1 = nullableDecimal(r.Scaled0), nullableDecimal(r.Scaled1)
+		price01, price10 = nullablePrice(r.Price01), nullablePrice(r.Price10)
+		sync = r.Event
+	}
+	tokenA, tokenB, flipped := canonicalTokens(p.Token0, p.Token1)
+
+	result, err := st.stmts.exec(ctx, st.tx, insertPairQuery,
+		p.PairAddress,
+		p.Token0,
+		p.Token1,
+		p.CreatedAt.Value,
+		p.CreatedAt.Original,
+		p.CreatedAt.Suspect(),
+		nullableLedger(p.CreatedAtLedger),
+		nullableString(p.Created.TxHash),
+		p.Created.OperationIndex,
+		p.Created.EventIndex,
+		tokenA,
+		tokenB,
+		flipped,
+		reserve0,
+		reserve1,
+		scaled0,
+		scaled1,
+		price01,
+		price10,
+		syncedAt,
+		syncedAtOriginal,
+		syncLedger,
+		nullableString(sync.TxHash),
+		sync.OperationIndex,
+		sync.EventIndex,
+		nullableString(st.network),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert pair: %v", err)
+	}
+	return inserted(result)
+}
+
+// InsertPlaceholder uses the sync time as the creation time until the
+// pair's new_pair event replaces it.
+func (st *sqlStore) InsertPlaceholder(ctx context.Context, u ReserveUpdate) error {
+	if _, err := st.stmts.exec(ctx, st.tx, insertPlaceholderQuery,
+		u.PairAddress,
+		u.SyncedAt.Value,
+		u.SyncedAt.Original,
+		u.SyncedAt.Suspect(),
+		u.Reserve0,
+		u.Reserve1,
+		nullableDecimal(u.Scaled0),
+		nullableDecimal(u.Scaled1),
+		nullablePrice(u.Price01),
+		nullablePrice(u.Price10),
+		u.SyncedAt.Value,
+		u.SyncedAt.Original,
+		nullableLedger(u.Ledger),
+		nullableString(u.Event.TxHash),
+		u.Event.OperationIndex,
+		u.Event.EventIndex,
+		nullableString(st.network),
+	); err != nil {
+		return fmt.Errorf("failed to insert placeholder pair: %v", err)
+	}
+	return nil
+}
+
+func (st *sqlStore) PairExists(ctx context.Context, pair string) (bool, error) {
+	var exists bool
+	if err := st.stmts.queryRow(ctx, st.tx, pairExistsQuery, pair).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check pair %s: %v", pair, err)
+	}
+	return exists, nil
+}
+
+func (st *sqlStore) LoadPair(ctx context.Context, pair string) (*pairState, error) {
+	var ps pairState
+	err := st.stmts.queryRow(ctx, st.tx, pairStateQuery, pair).Scan(
+		&ps.Token0, &ps.Token1, &ps.Reserve0, &ps.Reserve1, &ps.LastSyncLedger)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pair %s: %v", pair, err)
+	}
+	return &ps, nil
+}
+
+// UpdateReserves checks the ledger in the UPDATE itself, so a late sync
+// cannot overwrite newer reserves even with several writers. Syncs without
+// a ledger cannot be ordered and always apply.
+func (st *sqlStore) UpdateReserves(ctx context.Context, u ReserveUpdate) (bool, error) {
+	result, err := st.stmts.exec(ctx, st.tx, updateReservesQuery,
+		u.Reserve0,
+		u.Reserve1,
+		nullableDecimal(u.Scaled0),
+		nullableDecimal(u.Scaled1),
+		nullablePrice(u.Price01),
+		nullablePrice(u.Price10),
+		u.SyncedAt.Value,
+		u.SyncedAt.Original,
+		u.SyncedAt.Suspect(),
+		u.Ledger,
+		nullableString(u.Event.TxHash),
+		u.Event.OperationIndex,
+		u.Event.EventIndex,
+		u.PairAddress,
+		u.Ledger,
+		u.Ledger,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update pair reserves: %v", err)
+	}
+	return inserted(result)
+}
+
+func (st *sqlStore) RecordSwap(ctx context.Context, e SwapEvent, at time.Time) (bool, error) {
+	query, err := st.partitions.route(ctx, st.tx, "soroswap_swaps", insertSwapQuery, at)
+	if err != nil {
+		return false, err
+	}
+	return st.record(ctx, "swap", query,
+		e.ContractID,
+		e.Trader,
+		e.Amount0In,
+		e.Amount1In,
+		e.Amount0Out,
+		e.Amount1Out,
+		nullableLedger(e.LedgerSequence),
+		nullableString(e.TxHash),
+		e.OperationIndex,
+		e.EventIndex,
+		at,
+	)
+}
+
+func (st *sqlStore) RecordDeposit(ctx context.Context, e DepositEvent, at time.Time) (bool, error) {
+	return st.record(ctx, "deposit", insertDepositQuery,
+		e.ContractID,
+		e.Provider,
+		e.Amount0,
+		e.Amount1,
+		e.Liquidity,
+		nullableLedger(e.LedgerSequence),
+		nullableString(e.TxHash),
+		e.OperationIndex,
+		e.EventIndex,
+		at,
+	)
+}
+
+func (st *sqlStore) RecordWithdrawal(ctx context.Context, e WithdrawEvent, at time.Time) (bool, error) {
+	return st.record(ctx, "withdraw", insertWithdrawQuery,
+		e.ContractID,
+		e.Recipient,
+		e.Amount0,
+		e.Amount1,
+		e.Liquidity,
+		nullableLedger(e.LedgerSequence),
+		nullableString(e.TxHash),
+		e.OperationIndex,
+		e.EventIndex,
+		at,
+	)
+}
+
+func (st *sqlStore) RecordProtocolFee(ctx context.Context, e ProtocolFeeEvent, at time.Time) (bool, error) {
+	return st.record(ctx, "protocol fee", insertProtocolFeeQuery,
+		e.ContractID,
+		e.FeeTo,
+		e.Liquidity,
+		nullableLedger(e.LedgerSequence),
+		nullableString(e.TxHash),
+		e.OperationIndex,
+		e.EventIndex,
+		at,
+	)
+}
+
+func (st *sqlStore) RecordRouterLiquidity(ctx context.Context, e RouterLiquidityEvent, at time.Time) (bool, error) {
+	return st.record(ctx, "router liquidity", insertRouterLiquidityQuery,
+		e.action(),
+		e.PairAddress,
+		e.TokenA,
+		e.TokenB,
+		e.AmountA,
+		e.AmountB,
+		e.Liquidity,
+		nullableString(e.To),
+		nullableLedger(e.LedgerSequence),
+		nullableString(e.TxHash),
+		e.OperationIndex,
+		e.EventIndex,
+		at,
+	)
+}
+
+func (st *sqlStore) RecordLPTransfer(ctx context.Context, e LPTransferEvent, at time.Time) (bool, error) {
+	return st.record(ctx, "LP transfer", insertLPTransferQuery,
+		e.ContractID,
+		e.From,
+		e.To,
+		e.Amount,
+		nullableLedger(e.LedgerSequence),
+		nullableString(e.TxHash),
+		e.OperationIndex,
+		e.EventIndex,
+		at,
+	)
+}
+
+// record runs an activity insert, which skips duplicates with ON CONFLICT.
+func (st *sqlStore) record(ctx context.Context, kind, query string, args ...interface{}) (bool, error) {
+	result, err := st.stmts.exec(ctx, st.tx, query, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert %s event: %v", kind, err)
+	}
+	return inserted(result)
+}
+
+// inserted reports whether an insert that skips conflicts added a row, or
+// a guarded update changed one.
+func inserted(result sql.Result) (bool, error) {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	return n > 0, nil
+}
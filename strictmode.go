@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/withObsrvr/pluginapi"
+)
+
+// Events this consumer cannot place. With strict_mode they fail like any
+// other error, halting a pipeline that stops on errors; otherwise they are
+// dead-lettered and skipped.
+var (
+	errUnknownEventType = errors.New("unknown event type")
+	errUnknownPair      = errors.New("unknown pair")
+)
+
+// skipLenient turns an unknown event type or pair into a dead letter and a
+// nil error when strict_mode is off, returning any other err as is. Dry
+// runs and replays skip without the dead letter.
+func (s *SaveSoroswapPairsToSQLite) skipLenient(ctx context.Context, eventType string, msg pluginapi.Message, err error) error {
+	if err == nil || s.strictMode || !(errors.Is(err, errUnknownEventType) || errors.Is(err, errUnknownPair)) {
+		return err
+	}
+	if !s.dryRun && replayTables(ctx) == nil {
+		s.deadLetter(ctx, eventType, msg, err)
+	}
+	logger.Debug("Skipped event", "event_type", eventType, "reason", err)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Stellar strkey version bytes for the address kinds that appear in Soroswap
+// events.
+const (
+	strkeyVersionAccount  byte = 6 << 3  // G...
+	strkeyVersionContract byte = 2 << 3  // C...
+	strkeyVersionMuxed    byte = 12 << 3 // M...
+)
+
+var strkeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// strkeyPayloadLen maps a version byte to its expected payload length.
+var strkeyPayloadLen = map[byte]int{
+	strkeyVersionAccount:  32,
+	strkeyVersionContract: 32,
+	strkeyVersionMuxed:    40,
+}
+
+// decodeStrkey decodes a strkey into its version byte and payload,
+// verifying the checksum.
+func decodeStrkey(s string) (byte, []byte, error) {
+	raw, err := strkeyEncoding.DecodeString(s)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid strkey encoding: %v", err)
+	}
+	if len(raw) < 3 {
+		return 0, nil, fmt.Errorf("strkey too short")
+	}
+	version, payload, sum := raw[0], raw[1:len(raw)-2], raw[len(raw)-2:]
+	want, ok := strkeyPayloadLen[version]
+	if !ok {
+		return 0, nil, fmt.Errorf("unsupported strkey version byte %d", version)
+	}
+	if len(payload) != want {
+		return 0, nil, fmt.Errorf("invalid strkey payload length %d", len(payload))
+	}
+	if binary.LittleEndian.Uint16(sum) != crc16XModem(raw[:len(raw)-2]) {
+		return 0, nil, fmt.Errorf("invalid strkey checksum")
+	}
+	return version, payload, nil
+}
+
+// encodeStrkey encodes payload with the given version byte.
+func encodeStrkey(version byte, payload []byte) string {
+	raw := make([]byte, 0, len(payload)+3)
+	raw = append(raw, version)
+	raw = append(raw, payload...)
+	raw = binary.LittleEndian.AppendUint16(raw, crc16XModem(raw))
+	return strkeyEncoding.EncodeToString(raw)
+}
+
+func crc16XModem(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// normalizeAddress returns the canonical strkey form of a contract or
+// account address: whitespace trimmed, uppercased and round-tripped through
+// decode/encode so only one representation is ever stored or queried.
+func normalizeAddress(addr string) (string, error) {
+	s := strings.ToUpper(strings.TrimSpace(addr))
+	if s == "" {
+		return "", nil
+	}
+	version, payload, err := decodeStrkey(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	return encodeStrkey(version, payload), nil
+}
+
+// normalizeAddresses canonicalizes each address field in place.
+func normalizeAddresses(fields ...*string) error {
+	for _, f := range fields {
+		n, err := normalizeAddress(*f)
+		if err != nil {
+			return err
+		}
+		*f = n
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SwapEvent is a swap executed directly against a pair contract.
+type SwapEvent struct {
+	Type       string `json:"type"`
+	ContractID string `json:"contract_id"`
+	// Trader is the address receiving the output (the pair event's "to").
+	Trader     string    `json:"trader"`
+	Amount0In  Amount    `json:"amount_0_in"`
+	Amount1In  Amount    `json:"amount_1_in"`
+	Amount0Out Amount    `json:"amount_0_out"`
+	Amount1Out Amount    `json:"amount_1_out"`
+	TxHash     string    `json:"tx_hash"`
+	EventIndex int64     `json:"event_index"`
+	Timestamp  time.Time `json:"timestamp"`
+	// OperationIndex is the operation within the transaction.
+	OperationIndex int64 `json:"operation_index"`
+	// LedgerSequence falls back to the message metadata when absent.
+	LedgerSequence int64 `json:"ledger_sequence"`
+}
+
+const insertSwapQuery = `
+        INSERT INTO soroswap_swaps (
+            pair_address, trader, amount_0_in, amount_1_in, amount_0_out, amount_1_out,
+            ledger_sequence, tx_hash, operation_index, event_index, swapped_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tx_hash, pair_address, event_index) DO NOTHING
+    `
+
+func init() {
+	registerHandlerQuery(insertSwapQuery)
+	registerRetentionTable(retentionTable{Name: "swaps", Table: "soroswap_swaps", Column: "swapped_at"})
+	registerLedgerTable(ledgerTable{Table: "soroswap_swaps"})
+	registerPartitionedTable(partitionedTable{Table: "soroswap_swaps", Column: "swapped_at"})
+	registerCanonicalQuery(canonicalQuery{
+		Name:  "swaps_by_pair",
+		Query: "SELECT id FROM soroswap_swaps WHERE pair_address = ? AND ledger_sequence >= ? ORDER BY ledger_sequence",
+		Args:  []interface{}{"", 0},
+	})
+	registerPairTable(pairTable{
+		Name:   "soroswap_swaps",
+		Count:  "SELECT COUNT(*) FROM soroswap_swaps WHERE pair_address = ?",
+		Delete: deleteByID("soroswap_swaps"),
+	})
+}
+
+// normalize canonicalizes the event's address fields and checks the
+// amounts. Amounts are canonicalized beforehand.
+func (e *SwapEvent) normalize() error {
+	if err := normalizeAddresses(&e.ContractID, &e.Trader); err != nil {
+		return err
+	}
+	for _, a := range []Amount{e.Amount0In, e.Amount1In, e.Amount0Out, e.Amount1Out} {
+		if a == "" {
+			return fmt.Errorf("amount_0_in, amount_1_in, amount_0_out and amount_1_out are required")
+		}
+	}
+	return nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) handleSwap(ctx context.Context, event SwapEvent) error {
+	if event.ContractID == "" {
+		return fmt.Errorf("invalid swap event data: missing contract_id")
+	}
+	swappedAt, err := s.timestamps.check(event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("pair %s: %w", event.ContractID, err)
+	}
+
+	v0, _ := parseAmount(string(event.Amount0In))
+	v0out, _ := parseAmount(string(event.Amount0Out))
+	v1, _ := parseAmount(string(event.Amount1In))
+	v1out, _ := parseAmount(string(event.Amount1Out))
+	volume0, volume1 := v0.Add(v0, v0out), v1.Add(v1, v1out)
+
+	// derived updates the tables built from swaps, the ones selected by
+	// replay when rebuilding.
+	derived := func(tx *sql.Tx, replay map[string]bool) error {
+		if (replay == nil && len(s.candleResolutions) > 0) || replay["pair_candles"] {
+			if err := s.updateCandles(ctx, tx, event.ContractID, candleUpdate{
+				At:      swappedAt.Value,
+				Ledger:  event.LedgerSequence,
+				Volume0: volume0,
+				Volume1: volume1,
+			}); err != nil {
+				return err
+			}
+		}
+		if replay == nil || replay["pair_fees"] {
+			in0, _ := parseAmount(string(event.Amount0In))
+			in1, _ := parseAmount(string(event.Amount1In))
+			if err := s.addSwapFees(ctx, tx, event.ContractID, event.LedgerSequence, in0, in1); err != nil {
+				return err
+			}
+		}
+		if err := s.updateRollups(ctx, tx, event.ContractID, rollupUpdate{
+			At:      swappedAt.Value,
+			Ledger:  event.LedgerSequence,
+			Volume0: volume0,
+			Volume1: volume1,
+			Trader:  event.Trader,
+		}, replay); err != nil {
+			return err
+		}
+		if (replay == nil && s.volumeStats) || replay["pair_volume_hourly"] {
+			return s.addSwapVolume(ctx, tx, event.ContractID, swappedAt.Value, volume0, volume1)
+		}
+		return nil
+	}
+
+	if replay := replayTables(ctx); replay != nil {
+		return s.replayEvent(ctx, replayEventKey("swap", event.TxHash, event.ContractID, event.EventIndex),
+			event.ContractID, func(tx *sql.Tx) error { return derived(tx, replay) })
+	}
+
+	return s.storePairEvent(ctx, "swap", event.ContractID,
+		func(st PairStore) (bool, error) { return st.RecordSwap(ctx, event, swappedAt.Value) },
+		func(tx *sql.Tx) error { return derived(tx, nil) },
+	)
+}
+
+const (
+	defaultSwapLimit = 100
+	maxSwapLimit     = 1000
+)
+
+// Swap is a stored swap as returned by GetPairSwaps.
+type Swap struct {
+	PairAddress string `json:"pair_address"`
+	Trader      string `json:"trader"`
+	Amount0In   string `json:"amount_0_in"`
+	Amount1In   string `json:"amount_1_in"`
+	Amount0Out  string `json:"amount_0_out"`
+	Amount1Out  string `json:"amount_1_out"`
+	Ledger      *int64 `json:"ledger,omitempty"`
+	TxHash      string `json:"tx_hash,omitempty"`
+	// OperationIndex and EventIndex locate the event in the transaction.
+	OperationIndex int64     `json:"operation_index"`
+	EventIndex     int64     `json:"event_index"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// SwapOptions filters and pages a GetPairSwaps result. Zero values leave
+// the corresponding filter off.
+type SwapOptions struct {
+	// FromLedger and ToLedger bound the ledger range, inclusive.
+	FromLedger int64
+	ToLedger   int64
+	// Limit caps the number of swaps per page (default 100, max 1000).
+	Limit int
+	// Cursor continues from a previous page's NextCursor.
+	Cursor string
+}
+
+// SwapPage is one page of a pair's swaps.
+type SwapPage struct {
+	Swaps []Swap `json:"swaps"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// GetPairSwaps returns a pair's swaps ordered by ledger ascending, swaps
+// without a ledger first. It returns ErrPairNotFound for unknown pairs.
+func (s *SaveSoroswapPairsToSQLite) GetPairSwaps(ctx context.Context, pair string, opts SwapOptions) (*SwapPage, error) {
+	addr, err := normalizeAddress(pair)
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	if err := s.stmts.pairExists.QueryRowContext(ctx, addr).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check pair existence: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPairNotFound, addr)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSwapLimit
+	}
+	if limit > maxSwapLimit {
+		limit = maxSwapLimit
+	}
+	var afterLedger, afterID int64
+	if opts.Cursor != "" {
+		if afterLedger, afterID, err = decodeLedgerCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	where := []string{"pair_address = ?", "(COALESCE(ledger_sequence, 0), id) > (?, ?)"}
+	args := []interface{}{addr, afterLedger, afterID}
+	if opts.FromLedger > 0 {
+		where = append(where, "ledger_sequence >= ?")
+		args = append(args, opts.FromLedger)
+	}
+	if opts.ToLedger > 0 {
+		where = append(where, "ledger_sequence <= ?")
+		args = append(args, opts.ToLedger)
+	}
+	// One extra row tells whether another page follows.
+	args = append(args, limit+1)
+
+	rows, err := s.readDB.QueryContext(ctx, s.backend.Rebind(`
+        SELECT id, pair_address, trader, amount_0_in, amount_1_in, amount_0_out, amount_1_out,
+            ledger_sequence, COALESCE(tx_hash, ''), operation_index, event_index, swapped_at
+        FROM soroswap_swaps WHERE `+strings.Join(where, " AND ")+`
+        ORDER BY COALESCE(ledger_sequence, 0), id LIMIT ?`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query swaps: %v", err)
+	}
+	defer rows.Close()
+
+	page := &SwapPage{Swaps: []Swap{}}
+	var lastLedger, lastID int64
+	for rows.Next() {
+		if len(page.Swaps) == limit {
+			page.NextCursor = encodeLedgerCursor(lastLedger, lastID)
+			break
+		}
+		var sw Swap
+		var ledger sql.NullInt64
+		if err := rows.Scan(&lastID, &sw.PairAddress, &sw.Trader,
+			&sw.Amount0In, &sw.Amount1In, &sw.Amount0Out, &sw.Amount1Out,
+			&ledger, &sw.TxHash, &sw.OperationIndex, &sw.EventIndex, &sw.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan swap: %v", err)
+		}
+		lastLedger = ledger.Int64
+		if ledger.Valid {
+			sw.Ledger = &ledger.Int64
+		}
+		page.Swaps = append(page.Swaps, sw)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read swaps: %v", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tableNames qualifies the plugin's tables, indexes and views with the
+// configured table_prefix, so several pipelines can share one database.
+// Queries keep naming the unprefixed tables; backends rewrite them in
+// Rebind and DDL.
+type tableNames struct {
+	prefix string
+	names  *regexp.Regexp
+}
+
+var (
+	validTablePrefix = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	schemaObjectName = regexp.MustCompile(`(?i)\bCREATE\s+(?:UNIQUE\s+)?(?:TABLE|INDEX|VIEW)\s+(?:IF\s+NOT\s+EXISTS\s+)?(\w+)`)
+)
+
+func parseTableNames(cfg *Config) (tableNames, error) {
+	prefix := cfg.TablePrefix
+	if prefix == "" {
+		return tableNames{}, nil
+	}
+	// Postgres folds unquoted names to lower case, so upper case letters
+	// would make catalog lookups miss the tables.
+	if !validTablePrefix.MatchString(prefix) {
+		return tableNames{}, fmt.Errorf("config table_prefix: %q must be lower case letters, digits and underscores", prefix)
+	}
+
+	var names []string
+	for _, name := range schemaObjectNames() {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	return tableNames{
+		prefix: prefix,
+		names:  regexp.MustCompile(`\b(` + strings.Join(names, "|") + `)\b`),
+	}, nil
+}
+
+// schemaObjectNames returns every table, index and view the plugin
+// creates, read from the schema statements.
+func schemaObjectNames() []string {
+	stmts := []string{createMigrationsTableQuery}
+	stmts = append(stmts, schemaStatements...)
+	stmts = append(stmts, schemaBackfills...)
+	for _, m := range migrations {
+		stmts = append(stmts, m.statements...)
+	}
+
+	seen := make(map[string]bool)
+	names := append([]string(nil), managedViews...)
+	for _, stmt := range stmts {
+		for _, m := range schemaObjectName.FindAllStringSubmatch(stmt, -1) {
+			name := strings.ToLower(m[1])
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Table returns the name of one of the plugin's tables in the database.
+func (t tableNames) Table(name string) string { return t.prefix + name }
+
+// qualify prefixes every table, index and view named in query. Prefixed
+// names are not matched again, so qualifying twice is harmless.
+func (t tableNames) qualify(query string) string {
+	if t.prefix == "" {
+		return query
+	}
+	return t.names.ReplaceAllString(query, t.prefix+"${1}")
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampPolicy decides what happens to an event whose timestamp fails a
+// validation check.
+type timestampPolicy string
+
+const (
+	// timestampFlag stores the ingest time instead and marks the row suspect.
+	timestampFlag timestampPolicy = "flag"
+	// timestampReject fails the event.
+	timestampReject timestampPolicy = "reject"
+)
+
+// defaultMaxFutureSkew bounds how far ahead of the local clock an event
+// timestamp may be. Ledgers close every few seconds, so anything further
+// out is a bad clock or a bad unit rather than skew.
+const defaultMaxFutureSkew = 5 * time.Minute
+
+func parseTimestampPolicy(key, s string) (timestampPolicy, error) {
+	switch p := timestampPolicy(s); p {
+	case timestampFlag, timestampReject:
+		return p, nil
+	default:
+		return "", fmt.Errorf("config %s: unknown policy %q (want flag or reject)", key, s)
+	}
+}
+
+// timestampValidator guards against zero, too old and future event
+// timestamps leaking into created_at/last_sync_at.
+type timestampValidator struct {
+	minTime    time.Time
+	maxSkew    time.Duration
+	zeroPolicy timestampPolicy
+	minPolicy  timestampPolicy
+	skewPolicy timestampPolicy
+	now        func() time.Time
+}
+
+func newTimestampValidator(cfg *Config) (*timestampValidator, error) {
+	v := &timestampValidator{now: time.Now, minTime: cfg.MinEventTime, maxSkew: cfg.MaxFutureSkew}
+	var err error
+	if v.zeroPolicy, err = parseTimestampPolicy("zero_timestamp_policy", cfg.ZeroTimestampPolicy); err != nil {
+		return nil, err
+	}
+	if v.minPolicy, err = parseTimestampPolicy("min_event_time_policy", cfg.MinEventTimePolicy); err != nil {
+		return nil, err
+	}
+	if v.skewPolicy, err = parseTimestampPolicy("future_skew_policy", cfg.FutureSkewPolicy); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// validatedTimestamp is the timestamp to store for an event. When the event
+// timestamp was flagged, Value holds the ingest time and Original the value
+// the event carried.
+type validatedTimestamp struct {
+	Value    time.Time
+	Original *time.Time
+}
+
+// Suspect reports whether the event timestamp was replaced.
+func (t validatedTimestamp) Suspect() bool {
+	return t.Original != nil
+}
+
+// check validates ts, returning the value to store or an error when a
+// failing check is configured to reject.
+func (v *timestampValidator) check(ts time.Time) (validatedTimestamp, error) {
+	now := v.now()
+
+	var policy timestampPolicy
+	var reason string
+	switch {
+	case ts.IsZero() || ts.Unix() == 0:
+		policy, reason = v.zeroPolicy, "timestamp is zero"
+	case !v.minTime.IsZero() && ts.Before(v.minTime):
+		policy, reason = v.minPolicy, fmt.Sprintf("timestamp %s is before min_event_time %s",
+			ts.Format(time.RFC3339), v.minTime.Format(time.RFC3339))
+	case v.maxSkew > 0 && ts.After(now.Add(v.maxSkew)):
+		policy, reason = v.skewPolicy, fmt.Sprintf("timestamp %s is more than %s in the future",
+			ts.Format(time.RFC3339), v.maxSkew)
+	default:
+		// Stored timestamps are compared as text by SQLite, so keep them
+		// all in UTC.
+		return validatedTimestamp{Value: ts.UTC()}, nil
+	}
+
+	if policy == timestampReject {
+		return validatedTimestamp{}, fmt.Errorf("invalid event timestamp: %s", reason)
+	}
+	logger.Warn("Invalid event timestamp, storing ingest time instead", "reason", reason)
+	original := ts
+	return validatedTimestamp{Value: now.UTC(), Original: &original}, nil
+}
+
+// timestampLayouts are the string forms accepted for event timestamps, in
+// order. Layouts without a zone are taken as UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// parseEventTime reads an event's timestamp: an RFC3339 string (or one of
+// timestampLayouts), or Unix seconds as a number or numeric string. An
+// absent, null, empty or zero timestamp falls back to the ledger close
+// time in the ledger_close_time message metadata, and is the zero time
+// when that is missing too. canonical reports whether raw already is the
+// RFC3339 string the event structs decode, so the payload needs no
+// rewrite.
+func parseEventTime(raw json.RawMessage, metadata map[string]interface{}) (t time.Time, canonical bool, err error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return time.Time{}, false, err
+		}
+		if t, err = parseTimestampString(s); err != nil {
+			return time.Time{}, false, err
+		}
+		_, rfcErr := time.Parse(time.RFC3339, s)
+		canonical = rfcErr == nil
+	default:
+		if t, err = unixSeconds(string(raw)); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	if !t.IsZero() && t.Unix() != 0 {
+		return t.UTC(), canonical, nil
+	}
+	if closed, ok := metadataTime(metadata, "ledger_close_time"); ok {
+		return closed.UTC(), false, nil
+	}
+	return time.Time{}, canonical, nil
+}
+
+func parseTimestampString(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if s[0] >= '0' && s[0] <= '9' && !strings.ContainsAny(s, "-:") {
+		return unixSeconds(s)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp %q (want RFC3339 or Unix seconds)", s)
+}
+
+// Unix timestamps from unixMillisFrom on, which as seconds would be past
+// the year 5000, are taken as milliseconds, the unit JavaScript and many
+// indexers emit. From unixMillisTo on they are neither and are rejected.
+const (
+	unixMillisFrom = 1e11
+	unixMillisTo   = 1e14
+)
+
+// unixSeconds parses Unix seconds, with an optional fraction, or Unix
+// milliseconds.
+func unixSeconds(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return unixInteger(n)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return time.Time{}, fmt.Errorf("unsupported timestamp %s (want RFC3339 or Unix seconds)", s)
+	}
+	switch a := math.Abs(f); {
+	case a >= unixMillisTo:
+		return time.Time{}, fmt.Errorf("timestamp %s is out of range for Unix seconds or milliseconds", s)
+	case a >= unixMillisFrom:
+		return time.UnixMicro(int64(math.Round(f * 1e3))), nil
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
+// unixInteger converts whole Unix seconds or milliseconds.
+func unixInteger(n int64) (time.Time, error) {
+	switch {
+	case n >= unixMillisTo || n <= -unixMillisTo:
+		return time.Time{}, fmt.Errorf("timestamp %d is out of range for Unix seconds or milliseconds", n)
+	case n >= unixMillisFrom || n <= -unixMillisFrom:
+		return time.UnixMilli(n), nil
+	}
+	return time.Unix(n, 0), nil
+}
+
+// withTimestamp returns the event payload with its timestamp replaced by
+// t in RFC3339, or removed when t is zero.
+func withTimestamp(payload []byte, t time.Time) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	if t.IsZero() {
+		delete(fields, "timestamp")
+	} else {
+		encoded, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		fields["timestamp"] = encoded
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimestampValidatorCheck(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		ts   time.Time
+		// failing reports whether ts fails a check.
+		failing bool
+	}{
+		{"zero value", time.Time{}, true},
+		{"unix zero", time.Unix(0, 0), true},
+		{"before min_event_time", time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), true},
+		{"far future", now.Add(24 * time.Hour), true},
+		{"within skew", now.Add(time.Minute), false},
+		{"normal", now.Add(-time.Hour), false},
+		{"normal in another zone", now.In(time.FixedZone("UTC+2", 2*3600)), false},
+	}
+	for _, policy := range []timestampPolicy{timestampFlag, timestampReject} {
+		v, err := newTimestampValidator(decodeTestConfig(t, map[string]interface{}{
+			"min_event_time":        "2024-01-01T00:00:00Z",
+			"max_future_skew":       "5m",
+			"zero_timestamp_policy": string(policy),
+			"min_event_time_policy": string(policy),
+			"future_skew_policy":    string(policy),
+		}))
+		if err != nil {
+			t.Fatalf("newTimestampValidator: %v", err)
+		}
+		v.now = func() time.Time { return now }
+		for _, tt := range tests {
+			t.Run(string(policy)+"/"+tt.name, func(t *testing.T) {
+				got, err := v.check(tt.ts)
+				switch {
+				case !tt.failing:
+					if err != nil || got.Suspect() || !got.Value.Equal(tt.ts) || got.Value.Location() != time.UTC {
+						t.Errorf("check = %v, %v; want %v in UTC", got, err, tt.ts)
+					}
+				case policy == timestampReject:
+					if err == nil {
+						t.Errorf("check = %v, want an error", got)
+					}
+				default:
+					if err != nil || !got.Suspect() || !got.Value.Equal(now) || !got.Original.Equal(tt.ts) {
+						t.Errorf("check = %v, %v; want now flagged with original %v", got, err, tt.ts)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTimestampPolicyStored(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp interface{}
+		// policy "" leaves the policies and max_future_skew at their
+		// defaults.
+		policy  timestampPolicy
+		wantErr bool
+		// wantSuspect reports whether the sync is stored flagged.
+		wantSuspect bool
+	}{
+		{"zero flagged", "1970-01-01T00:00:00Z", timestampFlag, false, true},
+		{"zero rejected", 0, timestampReject, true, false},
+		{"far future flagged", "2100-01-01T00:00:00Z", timestampFlag, false, true},
+		{"far future rejected", "2100-01-01T00:00:00Z", timestampReject, true, false},
+		{"normal flag policy", ledgerTime(20).Format(time.RFC3339), timestampFlag, false, false},
+		{"normal reject policy", ledgerTime(20).Unix(), timestampReject, false, false},
+		{"zero rejected by default", "1970-01-01T00:00:00Z", "", true, false},
+		{"far future flagged by default", "2100-01-01T00:00:00Z", "", false, true},
+		{"milliseconds", ledgerTime(20).UnixMilli(), "", false, false},
+		{"milliseconds string", strconv.FormatInt(ledgerTime(20).UnixMilli(), 10), "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			if tt.policy != "" {
+				config = map[string]interface{}{
+					"max_future_skew":       "5m",
+					"zero_timestamp_policy": string(tt.policy),
+					"future_skew_policy":    string(tt.policy),
+				}
+			}
+			s := newTestConsumer(t, config)
+			process(t, s, newPairEvent(testPair, 10))
+			err := s.Process(context.Background(), syncEvent(testPair, "100", "5", 20).with(event{"timestamp": tt.timestamp}).message(t))
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("Process error = %v, want error %v", err, tt.wantErr)
+			}
+
+			p := getPair(t, s, testPair)
+			if tt.wantErr {
+				if p.LastSyncLedger != nil {
+					t.Errorf("rejected sync was applied at ledger %d", *p.LastSyncLedger)
+				}
+				return
+			}
+			var suspect bool
+			var original *string
+			if err := s.db.QueryRow(s.backend.Rebind(`SELECT timestamp_suspect, last_sync_at_original
+				FROM soroswap_pairs WHERE pair_address = ?`), testPair).Scan(&suspect, &original); err != nil {
+				t.Fatalf("query pair: %v", err)
+			}
+			if suspect != tt.wantSuspect || (original != nil) != tt.wantSuspect {
+				t.Errorf("timestamp_suspect = %v, last_sync_at_original = %v; want suspect %v", suspect, original, tt.wantSuspect)
+			}
+			switch {
+			case p.LastSyncAt == nil:
+				t.Error("last_sync_at not set")
+			case tt.wantSuspect && time.Since(*p.LastSyncAt) > time.Minute:
+				t.Errorf("flagged last_sync_at = %v, want the ingest time", *p.LastSyncAt)
+			case !tt.wantSuspect && !p.LastSyncAt.Equal(ledgerTime(20)):
+				t.Errorf("last_sync_at = %v, want %v", *p.LastSyncAt, ledgerTime(20))
+			}
+		})
+	}
+}
+
+func TestUnixSeconds(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "1735689600", want: time.Unix(1735689600, 0)},
+		{in: "1735689600.25", want: time.Unix(1735689600, 250e6)},
+		{in: "99999999999", want: time.Unix(99999999999, 0)},
+		{in: "1735689600123", want: time.UnixMilli(1735689600123)},
+		{in: "1735689600123.5", want: time.UnixMicro(1735689600123500)},
+		{in: "1.7356896e12", want: time.UnixMilli(1735689600000)},
+		{in: "1735689600123456", wantErr: true},
+		{in: "1e20", wantErr: true},
+		{in: "NaN", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := unixSeconds(tt.in)
+			switch {
+			case tt.wantErr && err == nil:
+				t.Errorf("unixSeconds(%s) = %v, want an error", tt.in, got)
+			case !tt.wantErr && (err != nil || !got.Equal(tt.want)):
+				t.Errorf("unixSeconds(%s) = %v, %v; want %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
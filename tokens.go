@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTokenNotFound is returned by GetToken for tokens not looked up yet.
+var ErrTokenNotFound = errors.New("token not found")
+
+// zeroAccount is the all-zero ed25519 account, used as the source of
+// simulated calls when token_rpc_source_account is not set.
+const zeroAccount = "GAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAWHF"
+
+const (
+	// pendingTokensQuery finds pair tokens never looked up, or whose last
+	// lookup failed before the retry cutoff.
+	pendingTokensQuery = `
+        SELECT address FROM (
+            SELECT token_0 AS address FROM soroswap_pairs
+            UNION
+            SELECT token_1 FROM soroswap_pairs
+        ) pt
+        WHERE NOT EXISTS (
+            SELECT 1 FROM tokens t WHERE t.address = pt.address
+            AND (t.last_error IS NULL OR t.fetched_at > ?)
+        )
+        ORDER BY address
+        LIMIT ?
+    `
+
+	upsertTokenQuery = `
+        INSERT INTO tokens (address, symbol, name, decimals, fetched_at, last_error)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (address) DO UPDATE SET
+            symbol = COALESCE(excluded.symbol, tokens.symbol),
+            name = COALESCE(excluded.name, tokens.name),
+            decimals = COALESCE(excluded.decimals, tokens.decimals),
+            fetched_at = excluded.fetched_at,
+            last_error = excluded.last_error
+    `
+
+	getTokenQuery = `
+        SELECT address, COALESCE(symbol, ''), COALESCE(name, ''), decimals, fetched_at, COALESCE(last_error, '')
+        FROM tokens WHERE address = ?
+    `
+
+	getTokenPriceQuery = `
+        SELECT price_usd, oracle_price_usd, oracle_timestamp, oracle_divergence_pct, oracle_divergent
+        FROM token_usd_prices WHERE token = ?
+    `
+)
+
+// Token is the metadata resolved for a token contract. Decimals is nil
+// until a lookup succeeds; LastError holds the latest failed lookup's error.
+type Token struct {
+	Address   string    `json:"address"`
+	Symbol    string    `json:"symbol"`
+	Name      string    `json:"name"`
+	Decimals  *int64    `json:"decimals,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	LastError string    `json:"last_error,omitempty"`
+	// PriceUSD is the token's USD price when USD pricing has derived one.
+	PriceUSD *float64 `json:"price_usd,omitempty"`
+	// Oracle is the Reflector oracle's price, when checked.
+	Oracle *OraclePrice `json:"oracle,omitempty"`
+}
+
+// tokenEnrichment configures the optional token metadata lookups.
+type tokenEnrichment struct {
+	rpc        *sorobanRPC
+	interval   time.Duration
+	retryAfter time.Duration
+	batch      int
+}
+
+func parseTokenEnrichment(cfg *Config) (tokenEnrichment, error) {
+	te := tokenEnrichment{
+		interval:   cfg.TokenEnrichInterval,
+		retryAfter: cfg.TokenRetryAfter,
+		batch:      cfg.TokenEnrichBatch,
+	}
+	if cfg.TokenRPCURL == "" {
+		return te, nil
+	}
+	if te.batch <= 0 {
+		return te, fmt.Errorf("config token_enrich_batch must be positive, got %d", te.batch)
+	}
+	var err error
+	if te.rpc, err = newSorobanRPC(cfg.TokenRPCURL, cfg.TokenRPCSourceAccount, cfg.TokenRPCTimeout); err != nil {
+		return te, fmt.Errorf("config token_rpc_source_account: %v", err)
+	}
+	return te, nil
+}
+
+// enrichTokens looks up metadata for a batch of tokens missing from the
+// tokens table. Lookups run without holding writes; each result, success
+// or failure, is stored so a token is queried once and failures are only
+// retried after token_retry_after.
+func (s *SaveSoroswapPairsToSQLite) enrichTokens(ctx context.Context) error {
+	if s.dryRun {
+		return nil
+	}
+	cutoff := time.Now().UTC().Add(-s.tokens.retryAfter)
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind(pendingTokensQuery), cutoff, s.tokens.batch)
+	if err != nil {
+		return fmt.Errorf("failed to query tokens to enrich: %v", err)
+	}
+	var pending []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, address)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var resolved int
+	for _, address := range pending {
+		t, lookupErr := s.lookupToken(ctx, address)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var lastError sql.NullString
+		if lookupErr != nil {
+			lastError = sql.NullString{String: lookupErr.Error(), Valid: true}
+		} else {
+			resolved++
+		}
+		if err := s.storeToken(ctx, t, lastError); err != nil {
+			return err
+		}
+		if t.Decimals != nil {
+			if err := s.refreshPrices(ctx, address); err != nil {
+				return err
+			}
+		}
+	}
+	if len(pending) > 0 {
+		logger.Info("Token enrichment", "resolved", resolved, "tokens", len(pending))
+	}
+	return nil
+}
+
+// lookupToken calls the token's symbol, name and decimals functions. The
+// fields resolved before an error are returned along with it.
+func (s *SaveSoroswapPairsToSQLite) lookupToken(ctx context.Context, address string) (Token, error) {
+	t := Token{Address: address}
+	for _, function := range []string{"symbol", "name", "decimals"} {
+		v, err := s.tokens.rpc.call(ctx, address, function)
+		if err != nil {
+			return t, err
+		}
+		switch value := v.(type) {
+		case string:
+			if function == "symbol" {
+				t.Symbol = value
+			} else if function == "name" {
+				t.Name = value
+			} else {
+				return t, fmt.Errorf("decimals() returned a string")
+			}
+		case uint32:
+			if function != "decimals" {
+				return t, fmt.Errorf("%s() returned a number", function)
+			}
+			d := int64(value)
+			t.Decimals = &d
+		}
+	}
+	return t, nil
+}
+
+func (s *SaveSoroswapPairsToSQLite) storeToken(ctx context.Context, t Token, lastError sql.NullString) error {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var decimals sql.NullInt64
+	if t.Decimals != nil {
+		decimals = sql.NullInt64{Int64: *t.Decimals, Valid: true}
+	}
+	if _, err := s.db.ExecContext(ctx, s.backend.Rebind(upsertTokenQuery),
+		t.Address, nullableString(t.Symbol), nullableString(t.Name), decimals, time.Now().UTC(), lastError,
+	); err != nil {
+		return fmt.Errorf("failed to store token %s: %v", t.Address, err)
+	}
+	return nil
+}
+
+// GetToken returns the stored metadata of a token.
+func (s *SaveSoroswapPairsToSQLite) GetToken(ctx context.Context, address string) (Token, error) {
+	var t Token
+	address, err := normalizeAddress(address)
+	if err != nil {
+		return t, err
+	}
+	var decimals sql.NullInt64
+	err = s.readDB.QueryRowContext(ctx, s.backend.Rebind(getTokenQuery), address).Scan(
+		&t.Address, &t.Symbol, &t.Name, &decimals, &t.FetchedAt, &t.LastError)
+	if err == sql.ErrNoRows {
+		return t, ErrTokenNotFound
+	}
+	if err != nil {
+		return t, fmt.Errorf("failed to read token %s: %v", address, err)
+	}
+	if decimals.Valid {
+		t.Decimals = &decimals.Int64
+	}
+	var price float64
+	var oraclePrice, oraclePct sql.NullFloat64
+	var oracleAt sql.NullTime
+	var divergent bool
+	err = s.readDB.QueryRowContext(ctx, s.backend.Rebind(getTokenPriceQuery), address).Scan(
+		&price, &oraclePrice, &oracleAt, &oraclePct, &divergent)
+	if err != nil && err != sql.ErrNoRows {
+		return t, fmt.Errorf("failed to read USD price of %s: %v", address, err)
+	}
+	if err == nil {
+		t.PriceUSD = &price
+		t.Oracle = scanOraclePrice(oraclePrice, oracleAt, oraclePct, divergent)
+	}
+	return t, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/withObsrvr/flow-consumer-save-soroswappairs-to-sqlite"
+
+// tracing holds the plugin's own tracer provider. It is not installed
+// globally, so the host's tracing setup is left alone.
+type tracing struct {
+	// provider is nil when tracing is off.
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var noopTracing = &tracing{
+	tracer:     noop.NewTracerProvider().Tracer(tracerName),
+	propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+}
+
+// parseTracing reads the tracing_* settings. tracing_exporter is otlp_grpc
+// or otlp_http; unset turns tracing off. The exporters also honour the
+// standard OTEL_EXPORTER_OTLP_* environment variables.
+func parseTracing(cfg *Config, version string) (*tracing, error) {
+	exporter := cfg.TracingExporter
+	if exporter == "" {
+		return noopTracing, nil
+	}
+	endpoint, insecure, headers := cfg.TracingEndpoint, cfg.TracingInsecure, cfg.TracingHeaders
+	ratio := cfg.TracingSampleRatio
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("config tracing_sample_ratio must be between 0 and 1, got %g", ratio)
+	}
+
+	// Creating an exporter does not connect; spans are sent in the
+	// background once there are some.
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "otlp_grpc":
+		var opts []otlptracegrpc.Option
+		if strings.Contains(endpoint, "://") {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(endpoint))
+		} else if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		if insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(headers))
+		}
+		spanExporter, err = otlptracegrpc.New(context.Background(), opts...)
+	case "otlp_http":
+		var opts []otlptracehttp.Option
+		if strings.Contains(endpoint, "://") {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		} else if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(headers))
+		}
+		spanExporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("config tracing_exporter: expected otlp_grpc or otlp_http, got %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s exporter: %v", exporter, err)
+	}
+	return newTracing(spanExporter, ratio, cfg.TracingServiceName, version), nil
+}
+
+// newTracing batches spans to exporter. Traces started upstream keep their
+// sampling decision; new ones are sampled at ratio.
+func newTracing(exporter sdktrace.SpanExporter, ratio float64, service, version string) *tracing {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", service),
+			attribute.String("service.version", version),
+		)),
+	)
+	return &tracing{
+		provider:   provider,
+		tracer:     provider.Tracer(tracerName, trace.WithInstrumentationVersion(version)),
+		propagator: noopTracing.propagator,
+	}
+}
+
+// startSpan starts a span, a no-op one when tracing is off.
+func (s *SaveSoroswapPairsToSQLite) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	t := s.tracing
+	if t == nil {
+		t = noopTracing
+	}
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed when err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceContext continues a trace whose W3C traceparent, tracestate and
+// baggage were passed in the message metadata.
+func (s *SaveSoroswapPairsToSQLite) traceContext(ctx context.Context, metadata map[string]interface{}) context.Context {
+	t := s.tracing
+	if t == nil {
+		t = noopTracing
+	}
+	return t.propagator.Extract(ctx, metadataCarrier(metadata))
+}
+
+// metadataCarrier reads trace context from message metadata.
+type metadataCarrier map[string]interface{}
+
+func (c metadataCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// Set is unused; the carrier is only extracted from.
+func (c metadataCarrier) Set(key, value string) {}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// pairSpanAttributes describes the event a handler span covers.
+func pairSpanAttributes(pair string, ledger int64) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("soroswap.pair_address", pair)}
+	if ledger > 0 {
+		attrs = append(attrs, attribute.Int64("soroswap.ledger_sequence", ledger))
+	}
+	return attrs
+}
+
+// shutdownTracing sends the spans still buffered, waiting a few seconds at
+// most.
+func (s *SaveSoroswapPairsToSQLite) shutdownTracing() error {
+	if s.tracing == nil || s.tracing.provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.tracing.provider.Shutdown(ctx)
+}
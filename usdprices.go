@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// usdPricing derives USD prices of tokens from the pairs they trade in.
+// Stablecoins are worth one dollar; reference tokens are priced through a
+// pair with a stablecoin, and every other token through a pair with a
+// stablecoin or a reference token.
+type usdPricing struct {
+	stablecoins map[string]bool
+	references  map[string]bool
+}
+
+func parseUSDPricing(cfg *Config) (usdPricing, error) {
+	var up usdPricing
+	stablecoins, err := parseAddresses("usd_stablecoins", cfg.USDStablecoins)
+	if err != nil {
+		return up, err
+	}
+	references, err := parseAddresses("usd_reference_tokens", cfg.USDReferenceTokens)
+	if err != nil {
+		return up, err
+	}
+	if len(stablecoins) == 0 && len(references) > 0 {
+		return up, fmt.Errorf("config usd_reference_tokens requires usd_stablecoins to price them")
+	}
+	up.stablecoins, up.references = stablecoins, references
+	for token := range references {
+		if stablecoins[token] {
+			return up, fmt.Errorf("config usd_reference_tokens: %s is already a stablecoin", token)
+		}
+	}
+	return up, nil
+}
+
+// parseAddresses normalizes the addresses listed under key into a set.
+func parseAddresses(key string, list []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(list))
+	for _, raw := range list {
+		addr, err := normalizeAddress(raw)
+		if err != nil || addr == "" {
+			return nil, fmt.Errorf("config %s: invalid address %q", key, raw)
+		}
+		set[addr] = true
+	}
+	return set, nil
+}
+
+func (up usdPricing) enabled() bool {
+	return len(up.stablecoins) > 0
+}
+
+const (
+	loadUSDPriceQuery = `SELECT price_usd FROM token_usd_prices WHERE token = ?`
+
+	upsertUSDPriceQuery = `
+        INSERT INTO token_usd_prices (token, price_usd, source_pair, ledger_sequence, updated_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (token) DO UPDATE SET
+            price_usd = excluded.price_usd,
+            source_pair = excluded.source_pair,
+            ledger_sequence = COALESCE(excluded.ledger_sequence, token_usd_prices.ledger_sequence),
+            updated_at = excluded.updated_at
+    `
+
+	setPairTVLQuery = `UPDATE soroswap_pairs SET tvl_usd = ? WHERE pair_address = ?`
+)
+
+func init() {
+	registerHandlerQuery(loadUSDPriceQuery, upsertUSDPriceQuery, setPairTVLQuery, pairsByTokenQuery)
+	// Prices set above a rolled back ledger go; the next sync reprices.
+	registerLedgerTable(ledgerTable{Table: "token_usd_prices"})
+}
+
+// updateUSDPrices reprices both tokens of a pair after a sync changed its
+// reserves, then the pair's TVL.
+func (s *SaveSoroswapPairsToSQLite) updateUSDPrices(ctx context.Context, tx *sql.Tx, pair, token0, token1 string, ledger int64) error {
+	if token0 == "" || token1 == "" {
+		return nil
+	}
+	tokens := []string{token0, token1}
+	// Reference tokens go first, as the other token may be priced in them.
+	if s.usd.references[token1] {
+		tokens = []string{token1, token0}
+	}
+	for _, token := range tokens {
+		price, source, err := s.resolveUSDPrice(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+		if price == nil {
+			continue
+		}
+		if _, err := s.stmts.exec(ctx, tx, upsertUSDPriceQuery,
+			token, *price, nullableString(source), nullableLedger(ledger), time.Now().UTC(),
+		); err != nil {
+			return fmt.Errorf("failed to store USD price of %s: %v", token, err)
+		}
+	}
+	return s.updatePairTVL(ctx, tx, pair, token0, token1)
+}
+
+// resolveUSDPrice prices a whole token in USD through the deepest pair
+// with an already priced counter token, nil when there is none. source is
+// the pair used, empty for stablecoins.
+func (s *SaveSoroswapPairsToSQLite) resolveUSDPrice(ctx context.Context, tx *sql.Tx, token string) (price *float64, source string, err error) {
+	if s.usd.stablecoins[token] {
+		one := 1.0
+		return &one, "", nil
+	}
+	decimals, err := s.tokenDecimals(ctx, tx, token)
+	if err != nil || decimals == nil {
+		return nil, "", err
+	}
+
+	rows, err := s.stmts.query(ctx, tx, pairsByTokenQuery, token, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query pairs of %s: %v", token, err)
+	}
+	type candidate struct {
+		pair, counter           string
+		reserve, counterReserve string
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		var token0, token1 sql.NullString
+		var reserve0, reserve1 string
+		if err := rows.Scan(&c.pair, &token0, &token1, &reserve0, &reserve1); err != nil {
+			rows.Close()
+			return nil, "", fmt.Errorf("failed to scan pair of %s: %v", token, err)
+		}
+		c.counter, c.reserve, c.counterReserve = token1.String, reserve0, reserve1
+		if token1.String == token {
+			c.counter, c.reserve, c.counterReserve = token0.String, reserve1, reserve0
+		}
+		// Reference tokens are only priced against stablecoins, so their
+		// prices cannot depend on each other.
+		if s.usd.stablecoins[c.counter] || (s.usd.references[c.counter] && !s.usd.references[token]) {
+			candidates = append(candidates, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read pairs of %s: %v", token, err)
+	}
+
+	var best, bestDepth *big.Float
+	for _, c := range candidates {
+		counterPrice, err := s.usdPrice(ctx, tx, c.counter)
+		if err != nil {
+			return nil, "", err
+		}
+		counterDecimals, err := s.tokenDecimals(ctx, tx, c.counter)
+		if err != nil {
+			return nil, "", err
+		}
+		amount := wholeTokens(c.reserve, decimals)
+		counterAmount := wholeTokens(c.counterReserve, counterDecimals)
+		if counterPrice == nil || amount == nil || counterAmount == nil || amount.Sign() == 0 {
+			continue
+		}
+		// depth is the USD value of the counter side of the pair.
+		depth := new(big.Float).Mul(counterAmount, big.NewFloat(*counterPrice))
+		if bestDepth == nil || depth.Cmp(bestDepth) > 0 {
+			best = new(big.Float).Quo(depth, amount)
+			bestDepth, source = depth, c.pair
+		}
+	}
+	if best == nil {
+		return nil, "", nil
+	}
+	p, _ := best.Float64()
+	return &p, source, nil
+}
+
+// usdPrice returns a token's stored USD price, nil when it has none.
+func (s *SaveSoroswapPairsToSQLite) usdPrice(ctx context.Context, tx *sql.Tx, token string) (*float64, error) {
+	if s.usd.stablecoins[token] {
+		one := 1.0
+		return &one, nil
+	}
+	var price float64
+	err := s.stmts.queryRow(ctx, tx, loadUSDPriceQuery, token).Scan(&price)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read USD price of %s: %v", token, err)
+	}
+	return &price, nil
+}
+
+// wholeTokens converts a raw reserve to whole tokens, nil when the
+// decimals are unknown or the reserve unparsable.
+func wholeTokens(reserve string, decimals *int64) *big.Float {
+	if decimals == nil {
+		return nil
+	}
+	r, ok := new(big.Int).SetString(reserve, 10)
+	if !ok {
+		return nil
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(*decimals), nil)
+	return new(big.Float).Quo(new(big.Float).SetInt(r), new(big.Float).SetInt(scale))
+}
+
+// updatePairTVL sets a pair's total value locked in USD from its reserves.
+// With only one side priced, the pool's even split counts it twice; with
+// neither, the TVL is NULL.
+func (s *SaveSoroswapPairsToSQLite) updatePairTVL(ctx context.Context, tx *sql.Tx, pair, token0, token1 string) error {
+	var reserve0, reserve1 string
+	if err := s.stmts.queryRow(ctx, tx, pairStateQuery, pair).Scan(
+		new(string), new(string), &reserve0, &reserve1, new(sql.NullInt64)); err != nil {
+		return fmt.Errorf("failed to load reserves of %s: %v", pair, err)
+	}
+	var sides []*big.Float
+	for _, side := range []struct{ token, reserve string }{{token0, reserve0}, {token1, reserve1}} {
+		price, err := s.usdPrice(ctx, tx, side.token)
+		if err != nil {
+			return err
+		}
+		decimals, err := s.tokenDecimals(ctx, tx, side.token)
+		if err != nil {
+			return err
+		}
+		if amount := wholeTokens(side.reserve, decimals); price != nil && amount != nil {
+			sides = append(sides, amount.Mul(amount, big.NewFloat(*price)))
+		}
+	}
+	var tvl interface{}
+	switch len(sides) {
+	case 1:
+		v, _ := sides[0].Mul(sides[0], big.NewFloat(2)).Float64()
+		tvl = v
+	case 2:
+		v, _ := sides[0].Add(sides[0], sides[1]).Float64()
+		tvl = v
+	}
+	if _, err := s.stmts.exec(ctx, tx, setPairTVLQuery, tvl, pair); err != nil {
+		return fmt.Errorf("failed to store TVL of %s: %v", pair, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// managedViews are the views owned by the plugin. They are dropped and
+// recreated on every Initialize so their definitions follow the plugin
+// version, and dropped when create_views is turned off.
+var managedViews = []string{
+	"v_pairs_human", "v_pair_activity", "v_top_pairs", "v_pairs_per_token",
+	"v_recent_syncs", "v_stale_pairs",
+}
+
+// defaultStaleLedgers is how far behind the newest sync a pair's last one
+// may be before v_stale_pairs lists it: about a day of 5 second ledgers.
+const defaultStaleLedgers = 17280
+
+// viewColumn is one selectable column of v_pairs_human.
+type viewColumn struct {
+	name string
+	expr func(b backend, tokens bool) string
+}
+
+// scaledReserve divides a text reserve by 10^decimals of its token. Decimals
+// come from the optional tokens table; without it the raw value is returned
+// as a number.
+func scaledReserve(reserve, token string) func(backend, bool) string {
+	return func(b backend, tokens bool) string {
+		value := fmt.Sprintf("CAST(p.%s AS DOUBLE PRECISION)", reserve)
+		if !tokens {
+			return value
+		}
+		return fmt.Sprintf("%s / COALESCE(CAST('1' || substr('%s', 1, %s.decimals) AS DOUBLE PRECISION), 1)",
+			value, strings.Repeat("0", 38), token)
+	}
+}
+
+func tokenSymbol(token string) func(backend, bool) string {
+	return func(b backend, tokens bool) string {
+		if !tokens {
+			return "CAST(NULL AS TEXT)"
+		}
+		return token + ".symbol"
+	}
+}
+
+func plainColumn(column string) func(backend, bool) string {
+	return func(backend, bool) string { return "p." + column }
+}
+
+func isoColumn(column string) func(backend, bool) string {
+	return func(b backend, _ bool) string { return b.ISOTimestamp("p." + column) }
+}
+
+var pairsHumanColumns = []viewColumn{
+	{"pair_address", plainColumn("pair_address")},
+	{"token_0", plainColumn("token_0")},
+	{"token_1", plainColumn("token_1")},
+	{"token_0_symbol", tokenSymbol("t0")},
+	{"token_1_symbol", tokenSymbol("t1")},
+	{"reserve_0", scaledReserve("reserve_0", "t0")},
+	{"reserve_1", scaledReserve("reserve_1", "t1")},
+	{"reserve_0_raw", plainColumn("reserve_0")},
+	{"reserve_1_raw", plainColumn("reserve_1")},
+	{"price_0_1", plainColumn("price_0_1")},
+	{"price_1_0", plainColumn("price_1_0")},
+	{"tvl_usd", plainColumn("tvl_usd")},
+	{"total_supply", plainColumn("total_supply")},
+	{"created_at", isoColumn("created_at")},
+	{"created_at_ledger", plainColumn("created_at_ledger")},
+	{"last_sync_at", isoColumn("last_sync_at")},
+	{"last_sync_ledger", plainColumn("last_sync_ledger")},
+	{"active", plainColumn("active")},
+}
+
+// viewConfig selects whether views are maintained and which v_pairs_human
+// columns they expose.
+type viewConfig struct {
+	enabled bool
+	columns []viewColumn
+	// staleLedgers is the v_stale_pairs threshold.
+	staleLedgers int
+}
+
+func parseViewConfig(cfg *Config) (viewConfig, error) {
+	vc := viewConfig{enabled: cfg.CreateViews, staleLedgers: cfg.StalePairLedgers}
+	if vc.staleLedgers <= 0 {
+		return vc, fmt.Errorf("config stale_pair_ledgers must be positive, got %d", vc.staleLedgers)
+	}
+	names := cfg.ViewColumns
+	if len(names) == 0 {
+		vc.columns = pairsHumanColumns
+		return vc, nil
+	}
+	for _, name := range names {
+		found := false
+		for _, c := range pairsHumanColumns {
+			if c.name == name {
+				vc.columns = append(vc.columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return vc, fmt.Errorf("config view_columns: unknown column %q", name)
+		}
+	}
+	return vc, nil
+}
+
+// rebuildViews drops the managed views and, when enabled, recreates them in
+// one transaction, so readers see either the old or the new definitions.
+// Views hold no data, so rebuilding them never waits on or blocks pair
+// writes beyond the short DDL transaction at startup.
+func rebuildViews(ctx context.Context, db *sql.DB, b backend, vc viewConfig) error {
+	tokens := true
+	for _, column := range []string{"address", "symbol", "decimals"} {
+		ok, err := b.ColumnExists(ctx, db, "tokens", column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect tokens table: %v", err)
+		}
+		tokens = tokens && ok
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	for _, view := range managedViews {
+		if _, err := tx.ExecContext(ctx, b.DDL("DROP VIEW IF EXISTS "+view)); err != nil {
+			return fmt.Errorf("failed to drop view %s: %v", view, err)
+		}
+	}
+	if vc.enabled {
+		for _, stmt := range viewStatements(b, vc, tokens) {
+			if _, err := tx.ExecContext(ctx, b.DDL(stmt)); err != nil {
+				return fmt.Errorf("failed to create view: %v\nStatement: %s", err, stmt)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func viewStatements(b backend, vc viewConfig, tokens bool) []string {
+	selects := make([]string, len(vc.columns))
+	for i, c := range vc.columns {
+		selects[i] = c.expr(b, tokens) + " AS " + c.name
+	}
+	from := "soroswap_pairs p"
+	if tokens {
+		from += `
+            LEFT JOIN tokens t0 ON t0.address = p.token_0
+            LEFT JOIN tokens t1 ON t1.address = p.token_1`
+	}
+	pairsHuman := fmt.Sprintf(`CREATE VIEW v_pairs_human AS
+        SELECT %s
+        FROM %s`, strings.Join(selects, ",\n            "), from)
+
+	// Daily activity per pair, from the swaps, which are always stored
+	// unlike the reserve history and the stats rollups.
+	pairActivity := fmt.Sprintf(`CREATE VIEW v_pair_activity AS
+        SELECT pair_address,
+            substr(%s, 1, 10) AS day,
+            COUNT(*) AS swaps,
+            COUNT(DISTINCT trader) AS traders,
+            SUM(CAST(amount_0_in AS DOUBLE PRECISION) + CAST(amount_0_out AS DOUBLE PRECISION)) AS volume_0,
+            SUM(CAST(amount_1_in AS DOUBLE PRECISION) + CAST(amount_1_out AS DOUBLE PRECISION)) AS volume_1,
+            MIN(ledger_sequence) AS first_ledger,
+            MAX(ledger_sequence) AS last_ledger
+        FROM soroswap_swaps
+        GROUP BY pair_address, substr(%[1]s, 1, 10)`, b.ISOTimestamp("swapped_at"))
+
+	// Pairs ranked by USD value locked, then by the product of their raw
+	// reserves for pairs USD pricing cannot value.
+	topPairs := `CREATE VIEW v_top_pairs AS
+        SELECT pair_address, token_0, token_1, reserve_0, reserve_1, tvl_usd, active,
+            ROW_NUMBER() OVER (ORDER BY tvl_usd IS NULL, tvl_usd DESC,
+                CAST(reserve_0 AS DOUBLE PRECISION) * CAST(reserve_1 AS DOUBLE PRECISION) DESC,
+                pair_address) AS liquidity_rank
+        FROM soroswap_pairs
+        WHERE NOT placeholder`
+
+	symbol, join, group := "CAST(NULL AS TEXT)", "", "pt.token"
+	if tokens {
+		symbol, join, group = "t.symbol", "LEFT JOIN tokens t ON t.address = pt.token", "pt.token, t.symbol"
+	}
+	pairsPerToken := fmt.Sprintf(`CREATE VIEW v_pairs_per_token AS
+        SELECT pt.token, %s AS symbol,
+            COUNT(*) AS pair_count,
+            SUM(CASE WHEN pt.active THEN 1 ELSE 0 END) AS active_pairs
+        FROM (
+            SELECT token_0 AS token, active FROM soroswap_pairs WHERE token_0 IS NOT NULL AND token_0 <> ''
+            UNION ALL
+            SELECT token_1, active FROM soroswap_pairs WHERE token_1 IS NOT NULL AND token_1 <> ''
+        ) pt
+        %s
+        GROUP BY %s`, symbol, join, group)
+
+	// Staleness is measured in ledgers behind the newest sync of any pair,
+	// so the views need no clock and agree with the data on every backend.
+	synced := `
+        SELECT p.pair_address, p.token_0, p.token_1, p.last_sync_ledger,
+            %s AS last_sync_at,
+            tip.ledger - COALESCE(p.last_sync_ledger, p.created_at_ledger) AS ledgers_behind%s
+        FROM soroswap_pairs p
+        CROSS JOIN (
+            SELECT MAX(COALESCE(last_sync_ledger, created_at_ledger)) AS ledger FROM soroswap_pairs
+        ) tip`
+	recentSyncs := "CREATE VIEW v_recent_syncs AS" + fmt.Sprintf(synced, b.ISOTimestamp("p.last_sync_at"),
+		",\n            ROW_NUMBER() OVER (ORDER BY p.last_sync_ledger DESC, p.pair_address) AS sync_rank") + `
+        WHERE p.last_sync_ledger IS NOT NULL`
+	stalePairs := "CREATE VIEW v_stale_pairs AS" + fmt.Sprintf(synced, b.ISOTimestamp("p.last_sync_at"), "") +
+		fmt.Sprintf(`
+        WHERE tip.ledger - COALESCE(p.last_sync_ledger, p.created_at_ledger) > %d`, vc.staleLedgers)
+
+	return []string{pairsHuman, pairActivity, topPairs, pairsPerToken, recentSyncs, stalePairs}
+}
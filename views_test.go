@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// queryStrings returns the rows of query, each value formatted with
+// fmt.Sprint.
+func queryStrings(t *testing.T, s *SaveSoroswapPairsToSQLite, query string, args ...interface{}) [][]string {
+	t.Helper()
+	rows, err := s.db.Query(s.backend.Rebind(query), args...)
+	if err != nil {
+		t.Fatalf("query %q: %v", query, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatalf("scan %q: %v", query, err)
+		}
+		row := make([]string, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = fmt.Sprint(v)
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestViews(t *testing.T) {
+	// Views are created by default.
+	s := newTestConsumer(t, map[string]interface{}{"stale_pair_ledgers": 5})
+	process(t, s,
+		newPairEvent(testPair, 10),
+		newPairEvent(testPair2, 10),
+		syncEvent(testPair, "100", "50", 20),
+		swapEvent(testPair, "aa01", 21),
+		swapEvent(testPair, "aa02", 22),
+	)
+
+	tests := []struct {
+		name  string
+		query string
+		want  [][]string
+	}{
+		{
+			name:  "pairs human",
+			query: "SELECT pair_address, reserve_0, reserve_1_raw, last_sync_at, last_sync_ledger FROM v_pairs_human WHERE last_sync_ledger IS NOT NULL",
+			want:  [][]string{{testPair, "100", "50", "2025-01-01T00:01:40Z", "20"}},
+		},
+		{
+			name:  "pair activity",
+			query: "SELECT pair_address, day, swaps, traders, volume_0, volume_1, first_ledger, last_ledger FROM v_pair_activity",
+			want:  [][]string{{testPair, "2025-01-01", "2", "1", "20", "18", "21", "22"}},
+		},
+		{
+			name:  "top pairs",
+			query: "SELECT pair_address, liquidity_rank FROM v_top_pairs ORDER BY liquidity_rank",
+			want:  [][]string{{testPair, "1"}, {testPair2, "2"}},
+		},
+		{
+			name:  "pairs per token",
+			query: "SELECT token, pair_count FROM v_pairs_per_token ORDER BY token",
+			want:  [][]string{{testTokenA, "2"}, {testTokenB, "2"}},
+		},
+		{
+			name:  "recent syncs",
+			query: "SELECT pair_address, ledgers_behind, sync_rank FROM v_recent_syncs",
+			want:  [][]string{{testPair, "0", "1"}},
+		},
+		{
+			name:  "stale pairs",
+			query: "SELECT pair_address, ledgers_behind FROM v_stale_pairs",
+			want:  [][]string{{testPair2, "10"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryStrings(t, s, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestViewsDropped(t *testing.T) {
+	s := newTestConsumer(t, nil)
+	ctx := context.Background()
+	vc, err := parseViewConfig(decodeTestConfig(t, map[string]interface{}{"create_views": false}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rebuildViews(ctx, s.db, s.backend, vc); err != nil {
+		t.Fatalf("rebuildViews: %v", err)
+	}
+	for _, view := range managedViews {
+		if _, err := s.db.Exec("SELECT * FROM " + s.backend.Table(view)); err == nil {
+			t.Errorf("view %s still exists", view)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Rolling volume windows maintained in pair_volume_stats.
+const (
+	volumeWindowShort = 24 * time.Hour
+	volumeWindowLong  = 7 * 24 * time.Hour
+)
+
+const (
+	loadVolumeHourQuery = `
+        SELECT volume_0, volume_1, swap_count FROM pair_volume_hourly
+        WHERE pair_address = ? AND hour_start = ?
+    `
+
+	upsertVolumeHourQuery = `
+        INSERT INTO pair_volume_hourly (pair_address, hour_start, volume_0, volume_1, swap_count)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address, hour_start) DO UPDATE SET
+            volume_0 = excluded.volume_0,
+            volume_1 = excluded.volume_1,
+            swap_count = excluded.swap_count
+    `
+
+	volumeHoursQuery = `
+        SELECT hour_start, volume_0, volume_1, swap_count FROM pair_volume_hourly
+        WHERE pair_address = ? AND hour_start >= ?
+    `
+
+	upsertVolumeStatsQuery = `
+        INSERT INTO pair_volume_stats (
+            pair_address, volume_24h_0, volume_24h_1, swaps_24h,
+            volume_7d_0, volume_7d_1, swaps_7d, updated_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (pair_address) DO UPDATE SET
+            volume_24h_0 = excluded.volume_24h_0,
+            volume_24h_1 = excluded.volume_24h_1,
+            swaps_24h = excluded.swaps_24h,
+            volume_7d_0 = excluded.volume_7d_0,
+            volume_7d_1 = excluded.volume_7d_1,
+            swaps_7d = excluded.swaps_7d,
+            updated_at = excluded.updated_at
+    `
+)
+
+func init() {
+	registerHandlerQuery(loadVolumeHourQuery, upsertVolumeHourQuery, volumeHoursQuery, upsertVolumeStatsQuery)
+	registerPairTable(pairTable{
+		Name:   "pair_volume_hourly",
+		Count:  "SELECT COUNT(*) FROM pair_volume_hourly WHERE pair_address = ?",
+		Delete: deleteByKey("pair_volume_hourly", "hour_start"),
+	})
+	registerPairTable(pairTable{
+		Name:   "pair_volume_stats",
+		Count:  "SELECT COUNT(*) FROM pair_volume_stats WHERE pair_address = ?",
+		Delete: deleteByKey("pair_volume_stats", "pair_address"),
+	})
+}
+
+// addSwapVolume adds a swap to its pair's hourly bucket and refreshes the
+// pair's rolling stats from the buckets, so reads never scan the swap log.
+func (s *SaveSoroswapPairsToSQLite) addSwapVolume(ctx context.Context, tx *sql.Tx, pair string, at time.Time, volume0, volume1 *big.Int) error {
+	now := time.Now().UTC()
+	hour := at.UTC().Truncate(time.Hour)
+	if hour.Before(now.Add(-volumeWindowLong).Truncate(time.Hour)) {
+		// Too old for any window, e.g. during a backfill.
+		return nil
+	}
+	v0, v1 := "0", "0"
+	var swaps int64
+	err := s.stmts.queryRow(ctx, tx, loadVolumeHourQuery, pair, hour).Scan(&v0, &v1, &swaps)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load volume for %s: %v", pair, err)
+	}
+	if _, err := s.stmts.exec(ctx, tx, upsertVolumeHourQuery,
+		pair, hour, addAmounts(v0, volume0), addAmounts(v1, volume1), swaps+1,
+	); err != nil {
+		return fmt.Errorf("failed to update volume for %s: %v", pair, err)
+	}
+	return s.refreshPairVolume(ctx, tx, pair, now)
+}
+
+// refreshPairVolume recomputes a pair's 24h and 7d volume from its hourly
+// buckets as of now. The current hour's bucket counts in full.
+func (s *SaveSoroswapPairsToSQLite) refreshPairVolume(ctx context.Context, tx *sql.Tx, pair string, now time.Time) error {
+	shortFrom := now.Add(-volumeWindowShort).Truncate(time.Hour)
+	longFrom := now.Add(-volumeWindowLong).Truncate(time.Hour)
+
+	rows, err := s.stmts.query(ctx, tx, volumeHoursQuery, pair, longFrom)
+	if err != nil {
+		return fmt.Errorf("failed to read volume for %s: %v", pair, err)
+	}
+	short0, short1, long0, long1 := new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	var shortSwaps, longSwaps int64
+	for rows.Next() {
+		var hour time.Time
+		var v0, v1 string
+		var swaps int64
+		if err := rows.Scan(&hour, &v0, &v1, &swaps); err != nil {
+			rows.Close()
+			return err
+		}
+		a0, _ := new(big.Int).SetString(v0, 10)
+		a1, _ := new(big.Int).SetString(v1, 10)
+		if a0 == nil || a1 == nil {
+			continue
+		}
+		long0.Add(long0, a0)
+		long1.Add(long1, a1)
+		longSwaps += swaps
+		if !hour.Before(shortFrom) {
+			short0.Add(short0, a0)
+			short1.Add(short1, a1)
+			shortSwaps += swaps
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := s.stmts.exec(ctx, tx, upsertVolumeStatsQuery,
+		pair, short0.String(), short1.String(), shortSwaps,
+		long0.String(), long1.String(), longSwaps, now,
+	); err != nil {
+		return fmt.Errorf("failed to update volume stats for %s: %v", pair, err)
+	}
+	return nil
+}
+
+// refreshVolumeStats rolls every pair's windows forward so volume from
+// hours that have left them drops out even without new swaps, and removes
+// buckets older than the longest window.
+func (s *SaveSoroswapPairsToSQLite) refreshVolumeStats(ctx context.Context) error {
+	if s.dryRun {
+		return nil
+	}
+	now := time.Now().UTC()
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind("SELECT pair_address FROM pair_volume_stats"))
+	if err != nil {
+		return fmt.Errorf("failed to list volume stats: %v", err)
+	}
+	var pairs []string
+	for rows.Next() {
+		var pair string
+		if err := rows.Scan(&pair); err != nil {
+			rows.Close()
+			return err
+		}
+		pairs = append(pairs, pair)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		if err := s.refreshVolumeChunk(ctx, func(tx *sql.Tx) error {
+			return s.refreshPairVolume(ctx, tx, pair, now)
+		}); err != nil {
+			return err
+		}
+	}
+	cutoff := now.Add(-volumeWindowLong).Truncate(time.Hour)
+	if err := s.refreshVolumeChunk(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.backend.Rebind(
+			"DELETE FROM pair_volume_hourly WHERE hour_start < ?"), cutoff)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to prune volume buckets: %v", err)
+	}
+	logger.Debug("Refreshed rolling volume", "pairs", len(pairs))
+	return nil
+}
+
+// refreshVolumeChunk runs fn in its own transaction between event writes.
+func (s *SaveSoroswapPairsToSQLite) refreshVolumeChunk(ctx context.Context, fn func(*sql.Tx) error) error {
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.inTx(ctx, fn)
+}
+
+// PairVolume is a pair's rolling swap volume. Amounts are decimal strings.
+type PairVolume struct {
+	PairAddress string    `json:"pair_address"`
+	Volume24h0  string    `json:"volume_24h_0"`
+	Volume24h1  string    `json:"volume_24h_1"`
+	Swaps24h    int64     `json:"swaps_24h"`
+	Volume7d0   string    `json:"volume_7d_0"`
+	Volume7d1   string    `json:"volume_7d_1"`
+	Swaps7d     int64     `json:"swaps_7d"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GetPairVolume returns a pair's rolling 24h and 7d volume, all zero for a
+// stored pair without swaps.
+func (s *SaveSoroswapPairsToSQLite) GetPairVolume(ctx context.Context, pair string) (PairVolume, error) {
+	pair, err := normalizeAddress(pair)
+	if err != nil {
+		return PairVolume{}, err
+	}
+	v := PairVolume{PairAddress: pair}
+	err = s.readDB.QueryRowContext(ctx, s.backend.Rebind(`
+        SELECT volume_24h_0, volume_24h_1, swaps_24h, volume_7d_0, volume_7d_1, swaps_7d, updated_at
+        FROM pair_volume_stats WHERE pair_address = ?`), pair).Scan(
+		&v.Volume24h0, &v.Volume24h1, &v.Swaps24h, &v.Volume7d0, &v.Volume7d1, &v.Swaps7d, &v.UpdatedAt)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := s.stmts.pairExists.QueryRowContext(ctx, pair).Scan(&exists); err != nil {
+			return v, fmt.Errorf("failed to check pair %s: %v", pair, err)
+		}
+		if !exists {
+			return v, ErrPairNotFound
+		}
+		v.Volume24h0, v.Volume24h1, v.Volume7d0, v.Volume7d1 = "0", "0", "0", "0"
+		return v, nil
+	}
+	if err != nil {
+		return v, fmt.Errorf("failed to read volume for %s: %v", pair, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook event names, sent in the X-Soroswap-Event header.
+const (
+	webhookPairCreated = "pair.created"
+	webhookAlert       = "alert"
+)
+
+const (
+	insertWebhookDeliveryQuery = `
+        INSERT INTO webhook_deliveries (event, pair_address, body, created_at, next_attempt_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+
+	dueWebhookDeliveriesQuery = `
+        SELECT id, event, body, attempts FROM webhook_deliveries
+        WHERE delivered_at IS NULL AND attempts < ? AND next_attempt_at <= ?
+        ORDER BY id LIMIT ?
+    `
+
+	nextWebhookAttemptQuery = `
+        SELECT next_attempt_at FROM webhook_deliveries
+        WHERE delivered_at IS NULL AND attempts < ?
+        ORDER BY next_attempt_at LIMIT 1
+    `
+
+	webhookDeliveredQuery = `
+        UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = NULL, delivered_at = ?
+        WHERE id = ?
+    `
+
+	webhookFailedQuery = `
+        UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+        WHERE id = ?
+    `
+)
+
+// webhookBatch is the most deliveries sent per pass of the worker.
+const webhookBatch = 100
+
+// webhookIdlePoll is how often the worker looks for deliveries without
+// being woken, which picks up rows committed by other processes.
+const webhookIdlePoll = time.Minute
+
+func init() {
+	registerHandlerQuery(insertWebhookDeliveryQuery)
+	// Only delivered rows age out.
+	registerRetentionTable(retentionTable{Name: "webhook_deliveries", Table: "webhook_deliveries", Column: "delivered_at"})
+	registerPairTable(pairTable{
+		Name:   "webhook_deliveries",
+		Count:  "SELECT COUNT(*) FROM webhook_deliveries WHERE pair_address = ?",
+		Delete: deleteByID("webhook_deliveries"),
+	})
+}
+
+// webhookSink posts notifications to the configured webhook. Notifications
+// are queued in webhook_deliveries inside the transaction that caused them
+// and sent by a worker once it commits, so they survive restarts and are
+// never sent for rolled back writes. Delivery is at least once.
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	// maxAttempts is how often a delivery is tried before giving up.
+	maxAttempts int
+	// backoff is the wait after the first failed attempt; it doubles with
+	// every further one up to maxBackoff.
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// queued is set when a delivery was queued since the last commit.
+	queued atomic.Bool
+	wake   chan struct{}
+
+	delivered atomic.Int64
+	retried   atomic.Int64
+	failed    atomic.Int64
+}
+
+func parseWebhook(cfg *Config) (*webhookSink, error) {
+	target := cfg.WebhookURL
+	if target == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("config webhook_url: %q is not an http(s) URL", target)
+	}
+	wh := &webhookSink{
+		url:         target,
+		wake:        make(chan struct{}, 1),
+		headers:     cfg.WebhookHeaders,
+		client:      &http.Client{Timeout: cfg.WebhookTimeout},
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     cfg.WebhookRetryBackoff,
+		maxBackoff:  cfg.WebhookMaxBackoff,
+	}
+	if wh.maxAttempts <= 0 {
+		return nil, fmt.Errorf("config webhook_max_attempts must be positive, got %d", wh.maxAttempts)
+	}
+	if wh.backoff <= 0 || wh.maxBackoff < wh.backoff {
+		return nil, fmt.Errorf("config webhook_retry_backoff must be positive and at most webhook_max_backoff")
+	}
+	return wh, nil
+}
+
+// retryAfter returns the wait after the given number of failed attempts.
+func (wh *webhookSink) retryAfter(attempts int) time.Duration {
+	d := wh.backoff
+	for i := 1; i < attempts && d < wh.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > wh.maxBackoff {
+		d = wh.maxBackoff
+	}
+	return d
+}
+
+// notify wakes the worker after a commit that queued deliveries.
+func (wh *webhookSink) notify() {
+	if wh == nil || !wh.queued.Swap(false) {
+		return
+	}
+	select {
+	case wh.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueWebhook queues v as the JSON body of a webhook delivery inside tx.
+// Backfills and replays re-ingest old events and notify nobody.
+func (s *SaveSoroswapPairsToSQLite) queueWebhook(ctx context.Context, tx *sql.Tx, event, pair string, v interface{}) error {
+	if s.webhook == nil || s.backfill || replayTables(ctx) != nil {
+		return nil
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s webhook: %v", event, err)
+	}
+	now := time.Now().UTC()
+	if _, err := s.stmts.exec(ctx, tx, insertWebhookDeliveryQuery, event, nullableString(pair), string(body), now, now); err != nil {
+		return fmt.Errorf("failed to queue %s webhook: %v", event, err)
+	}
+	s.webhook.queued.Store(true)
+	return nil
+}
+
+// queuePairWebhook queues the pair.created notification of a newly
+// inserted pair, with the pair as the read APIs return it.
+func (s *SaveSoroswapPairsToSQLite) queuePairWebhook(ctx context.Context, tx *sql.Tx, address string) error {
+	if s.webhook == nil {
+		return nil
+	}
+	p, err := scanPair(s.stmts.queryRow(ctx, tx, selectPairQuery, address))
+	if err != nil {
+		return fmt.Errorf("failed to read pair %s for webhook: %v", address, err)
+	}
+	return s.queueWebhook(ctx, tx, webhookPairCreated, address, p)
+}
+
+// startWebhook runs the delivery worker until Close.
+func (s *SaveSoroswapPairsToSQLite) startWebhook() {
+	s.bgWG.Add(1)
+	go func() {
+		defer s.bgWG.Done()
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-s.bgCtx.Done():
+				return
+			case <-s.webhook.wake:
+			case <-timer.C:
+			}
+			wait, err := s.deliverWebhooks(s.bgCtx)
+			if err != nil {
+				if s.bgCtx.Err() != nil {
+					return
+				}
+				logger.Error("Webhook delivery failed", "error", err)
+				wait = s.webhook.backoff
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+		}
+	}()
+}
+
+type webhookDelivery struct {
+	id       int64
+	event    string
+	body     string
+	attempts int
+	err      error
+}
+
+// deliverWebhooks sends the deliveries that are due and records the
+// results, returning how long the worker may wait before the next pass.
+func (s *SaveSoroswapPairsToSQLite) deliverWebhooks(ctx context.Context) (time.Duration, error) {
+	wh := s.webhook
+	rows, err := s.db.QueryContext(ctx, s.backend.Rebind(dueWebhookDeliveriesQuery),
+		wh.maxAttempts, time.Now().UTC(), webhookBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read webhook deliveries: %v", err)
+	}
+	var due []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.id, &d.event, &d.body, &d.attempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read webhook deliveries: %v", err)
+		}
+		due = append(due, d)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("failed to read webhook deliveries: %v", err)
+	}
+
+	// Posts run without the write lock; only the results are written.
+	for i := range due {
+		due[i].err = wh.post(ctx, due[i])
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+	if len(due) > 0 {
+		if err := s.recordWebhookResults(ctx, due); err != nil {
+			return 0, err
+		}
+	}
+	if len(due) == webhookBatch {
+		return 0, nil
+	}
+
+	var next time.Time
+	err = s.db.QueryRowContext(ctx, s.backend.Rebind(nextWebhookAttemptQuery), wh.maxAttempts).Scan(&next)
+	if err == sql.ErrNoRows {
+		return webhookIdlePoll, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read webhook deliveries: %v", err)
+	}
+	wait := time.Until(next)
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > webhookIdlePoll {
+		wait = webhookIdlePoll
+	}
+	return wait, nil
+}
+
+// recordWebhookResults marks sent deliveries delivered and schedules the
+// retry of failed ones.
+func (s *SaveSoroswapPairsToSQLite) recordWebhookResults(ctx context.Context, results []webhookDelivery) error {
+	wh := s.webhook
+	unlock, err := s.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	now := time.Now().UTC()
+	for _, d := range results {
+		if d.err == nil {
+			_, err = tx.ExecContext(ctx, s.backend.Rebind(webhookDeliveredQuery), now, d.id)
+		} else {
+			attempts := d.attempts + 1
+			_, err = tx.ExecContext(ctx, s.backend.Rebind(webhookFailedQuery),
+				d.err.Error(), now.Add(wh.retryAfter(attempts)), d.id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record webhook delivery %d: %v", d.id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record webhook deliveries: %v", err)
+	}
+
+	for _, d := range results {
+		switch attempts := d.attempts + 1; {
+		case d.err == nil:
+			wh.delivered.Add(1)
+		case attempts >= wh.maxAttempts:
+			wh.failed.Add(1)
+			logger.Error("Giving up on webhook delivery", "event", d.event, "id", d.id, "attempts", attempts, "error", d.err)
+		default:
+			wh.retried.Add(1)
+			logger.Warn("Webhook delivery failed, retrying", "id", d.id, "event", d.event, "retry_in", wh.retryAfter(attempts), "error", d.err)
+		}
+	}
+	return nil
+}
+
+// post sends one delivery. Any 2xx response counts as delivered.
+func (wh *webhookSink) post(ctx context.Context, d webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader([]byte(d.body)))
+	if err != nil {
+		return err
+	}
+	for k, v := range wh.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Soroswap-Event", d.event)
+	req.Header.Set("X-Soroswap-Delivery", strconv.FormatInt(d.id, 10))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}